@@ -0,0 +1,96 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// applyRequest asks the configuration loop to apply the latest configurations now,
+// the results of the runs are sent on the results channel
+type applyRequest struct {
+	results chan []configurationResult
+}
+
+// applyResponse is the JSON response of POST /apply
+type applyResponse struct {
+	Results []applyResult `json:"results"`
+}
+
+type applyResult struct {
+	ConfigFile string `json:"config_file"`
+	Hash       string `json:"hash"`
+	Applied    bool   `json:"applied"`
+	Error      string `json:"error,omitempty"`
+}
+
+// adminServer serves the admin API of the configure command, the requests have to
+// authenticate with the admin token as "Authorization: Bearer <token>"
+func adminServer(address, token string, requests chan<- applyRequest) *http.Server {
+	engine := gin.New()
+	engine.Use(gin.Logger(), gin.ErrorLogger())
+	engine.POST("/apply", adminAuth(token), applyHandler(requests))
+	return &http.Server{Addr: address, Handler: engine}
+}
+
+func adminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// applyHandler triggers an immediate configuration run of the latest configurations and responds
+// with their results, 200 if all of them applied and 500 otherwise
+func applyHandler(requests chan<- applyRequest) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request := applyRequest{results: make(chan []configurationResult, 1)}
+
+		select {
+		case requests <- request:
+		case <-c.Request.Context().Done():
+			return
+		}
+
+		var results []configurationResult
+		select {
+		case results = <-request.results:
+		case <-c.Request.Context().Done():
+			return
+		}
+
+		response := applyResponse{Results: []applyResult{}}
+		status := http.StatusOK
+		for _, result := range results {
+			item := applyResult{ConfigFile: result.configFile, Hash: result.hash, Applied: result.applied}
+			if result.err != nil {
+				item.Error = result.err.Error()
+			}
+			if !result.applied {
+				status = http.StatusInternalServerError
+			}
+			response.Results = append(response.Results, item)
+		}
+		c.JSON(status, response)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestAdminApply(t *testing.T) {
+	v := &mockVault{}
+
+	requests := make(chan applyRequest)
+	previous := configureConfig.applyRequests
+	configureConfig.applyRequests = requests
+	defer func() { configureConfig.applyRequests = previous }()
+
+	configurations := make(chan *viper.Viper, 1)
+	config := viper.New()
+	config.SetConfigFile("vault-config.yml")
+	config.Set("policies", []interface{}{map[string]interface{}{"name": "allow_secrets"}})
+	configurations <- config
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		applyConfigurations(ctx, v, configurations, false)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	server := httptest.NewServer(adminServer("", "admin-token", requests).Handler)
+	defer server.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for v.configureCalls() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the configuration wasn't applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	post := func(token string) *http.Response {
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/apply", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	response := post("wrong-token")
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an invalid token to be rejected, got %d", response.StatusCode)
+	}
+	if calls := v.configureCalls(); calls != 1 {
+		t.Fatalf("a rejected request shouldn't apply, got %d runs", calls)
+	}
+
+	response = post("admin-token")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected the apply to succeed, got %d", response.StatusCode)
+	}
+
+	var result applyResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 1 || result.Results[0].ConfigFile != "vault-config.yml" || !result.Results[0].Applied {
+		t.Fatalf("unexpected apply results: %+v", result)
+	}
+
+	// the unchanged configuration is applied again on request
+	if calls := v.configureCalls(); calls != 2 {
+		t.Fatalf("expected the configuration to be applied again, got %d runs", calls)
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// changelogChange is a Vault resource touched during a configuration run, the values
+// are redacted, only the hashes of the state before and after the change are recorded,
+// and with --change-log the diff of the written fields with the sensitive values redacted
+type changelogChange struct {
+	Timestamp time.Time                     `json:"timestamp"`
+	Path      string                        `json:"path"`
+	Action    string                        `json:"action"`
+	Before    string                        `json:"before,omitempty"`
+	After     string                        `json:"after,omitempty"`
+	Diff      map[string]changelogFieldDiff `json:"diff,omitempty"`
+}
+
+// changelogFieldDiff is the value of a written field before and after the change
+type changelogFieldDiff struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// changelogEntry is one line of the changelog file, written at the end of every configuration run
+type changelogEntry struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	RequestID  string            `json:"request_id"`
+	Operator   string            `json:"operator"`
+	ConfigFile string            `json:"config_file"`
+	ConfigHash string            `json:"config_hash"`
+	Changes    []changelogChange `json:"changes"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// changelog records the mutating Vault API calls (as an http.RoundTripper) of the configuration
+// runs and appends a JSONL entry per run to the changelog file, and to the optional sinks of
+// --change-log: out (stdout) and the Kubernetes Events of recordEvent
+type changelog struct {
+	sync.Mutex
+	file        string
+	operator    string
+	next        http.RoundTripper
+	diff        bool
+	out         io.Writer
+	recordEvent func(entry changelogEntry) error
+	recording   bool
+	requestID   string
+	changes     []changelogChange
+}
+
+func newChangelog(file, operator string, next http.RoundTripper) *changelog {
+	if operator == "" {
+		operator, _ = os.Hostname()
+	}
+	return &changelog{file: file, operator: operator, next: next}
+}
+
+// begin starts recording the changes of a configuration run
+func (c *changelog) begin() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.recording = true
+	c.requestID = randomID(16)
+	c.changes = nil
+}
+
+// end stops recording and appends the entry of the configuration run to the changelog file
+func (c *changelog) end(configFile, configHash string, runErr error) error {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	entry := changelogEntry{
+		Timestamp:  time.Now().UTC(),
+		RequestID:  c.requestID,
+		Operator:   c.operator,
+		ConfigFile: configFile,
+		ConfigHash: configHash,
+		Changes:    c.changes,
+	}
+	c.recording = false
+	c.changes = nil
+	c.Unlock()
+
+	if entry.Changes == nil {
+		entry.Changes = []changelogChange{}
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding changelog entry: %s", err.Error())
+	}
+
+	if c.out != nil {
+		_, err = c.out.Write(append(line, '\n'))
+		if err != nil {
+			return fmt.Errorf("error writing changelog entry: %s", err.Error())
+		}
+	}
+
+	// only the runs which changed something or failed are worth an Event
+	if c.recordEvent != nil && (len(entry.Changes) > 0 || entry.Error != "") {
+		err = c.recordEvent(entry)
+		if err != nil {
+			return fmt.Errorf("error recording changelog event: %s", err.Error())
+		}
+	}
+
+	if c.file == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(c.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening changelog file: %s", err.Error())
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("error writing changelog file: %s", err.Error())
+	}
+	return nil
+}
+
+func (c *changelog) isRecording() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.recording
+}
+
+func (c *changelog) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !c.isRecording() || !isMutating(req.Method) {
+		return c.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	before, beforeData := c.currentState(req)
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 400 {
+		return resp, err
+	}
+
+	// the resources written again with their current values (e.g. the policies and the roles on
+	// every run) didn't change
+	if req.Method != http.MethodDelete && before != "" && changelogUnchanged(beforeData, body) {
+		return resp, nil
+	}
+
+	change := changelogChange{
+		Timestamp: time.Now().UTC(),
+		Path:      strings.TrimPrefix(req.URL.Path, "/v1/"),
+		Before:    before,
+	}
+	switch {
+	case req.Method == http.MethodDelete:
+		change.Action = "delete"
+	case before == "":
+		change.Action = "create"
+		change.After = hashChangelogValue(body)
+	default:
+		change.Action = "update"
+		change.After = hashChangelogValue(body)
+	}
+	if c.diff {
+		change.Diff = changelogDiff(change.Path, beforeData, body)
+	}
+
+	c.Lock()
+	if c.recording {
+		c.changes = append(c.changes, change)
+	}
+	c.Unlock()
+
+	return resp, nil
+}
+
+// currentState reads the resource before it is changed with the same credentials, it returns
+// the hash and the data, or an empty hash if the resource doesn't exist or can't be read
+func (c *changelog) currentState(req *http.Request) (string, map[string]interface{}) {
+	read, err := http.NewRequest(http.MethodGet, req.URL.String(), nil)
+	if err != nil {
+		return "", nil
+	}
+	read = read.WithContext(req.Context())
+	for name, values := range req.Header {
+		if name != "Content-Type" && name != "Content-Length" {
+			read.Header[name] = values
+		}
+	}
+
+	resp, err := c.next.RoundTrip(read)
+	if err != nil {
+		return "", nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	var secret struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	if err != nil || len(secret.Data) == 0 || string(secret.Data) == "null" {
+		return "", nil
+	}
+
+	var data map[string]interface{}
+	json.Unmarshal(secret.Data, &data)
+	return hashChangelogValue(secret.Data), data
+}
+
+// changelogDiff returns the written fields whose value differs from the current one, the values
+// of the sensitive fields are redacted, and so are all the values of the paths which aren't
+// the configuration of Vault itself (e.g. the startup secrets, or the connection URL of a database)
+func changelogDiff(path string, before map[string]interface{}, body []byte) map[string]changelogFieldDiff {
+	var after map[string]interface{}
+	if err := json.Unmarshal(body, &after); err != nil {
+		return nil
+	}
+
+	redactAll := !changelogConfigPath(path)
+	diff := map[string]changelogFieldDiff{}
+	for field, value := range after {
+		previous, found := before[field]
+		if found && hashChangelogJSON(previous) == hashChangelogJSON(value) {
+			continue
+		}
+		sensitive := redactAll || sanitizeSensitiveKey(field)
+		fieldDiff := changelogFieldDiff{After: sanitizeValue(value, sensitive, nil)}
+		if found {
+			fieldDiff.Before = sanitizeValue(previous, sensitive, nil)
+		}
+		diff[field] = fieldDiff
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// changelogUnchanged tells if every written field has its current value already
+func changelogUnchanged(before map[string]interface{}, body []byte) bool {
+	var after map[string]interface{}
+	if before == nil || json.Unmarshal(body, &after) != nil {
+		return false
+	}
+	for field, value := range after {
+		previous, found := before[field]
+		if !found || hashChangelogJSON(previous) != hashChangelogJSON(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// changelogConfigPath tells if the path is a configuration of Vault (policies, mounts, auth
+// methods, identities) whose non-sensitive values can be recorded
+func changelogConfigPath(path string) bool {
+	for _, prefix := range []string{"sys/", "auth/", "identity/"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashChangelogJSON(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return hashChangelogValue(encoded)
+}
+
+// changelogEventRecorder returns the recorder of a Kubernetes Event on the pod of the configurer
+// for every configuration run, its message lists the changes without their values
+func changelogEventRecorder(client func() (kubernetes.Interface, error), namespace, pod string) func(entry changelogEntry) error {
+	return func(entry changelogEntry) error {
+		cl, err := client()
+		if err != nil {
+			return fmt.Errorf("error creating kubernetes client: %s", err.Error())
+		}
+
+		eventType, reason := corev1.EventTypeNormal, "VaultConfigured"
+		message := fmt.Sprintf("%d changes applied from %s (%s)", len(entry.Changes), entry.ConfigFile, entry.ConfigHash)
+		if entry.Error != "" {
+			eventType, reason = corev1.EventTypeWarning, "VaultConfigurationFailed"
+			message = fmt.Sprintf("%d changes applied from %s (%s) before the error: %s", len(entry.Changes), entry.ConfigFile, entry.ConfigHash, entry.Error)
+		}
+		for _, change := range entry.Changes {
+			message += fmt.Sprintf(", %s %s", change.Action, change.Path)
+		}
+		// the message of an Event is limited to 1kB
+		if len(message) > 1024 {
+			message = message[:1021] + "..."
+		}
+
+		timestamp := metav1.NewTime(entry.Timestamp)
+		_, err = cl.CoreV1().Events(namespace).Create(&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: pod + ".",
+				Namespace:    namespace,
+			},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Namespace:  namespace,
+				Name:       pod,
+			},
+			Type:           eventType,
+			Reason:         reason,
+			Message:        message,
+			Source:         corev1.EventSource{Component: "bank-vaults"},
+			FirstTimestamp: timestamp,
+			LastTimestamp:  timestamp,
+			Count:          1,
+		})
+		return err
+	}
+}
+
+// hashChangelogValue hashes the canonical JSON form of the value, so the key order doesn't matter
+func hashChangelogValue(value []byte) string {
+	var decoded interface{}
+	if err := json.Unmarshal(value, &decoded); err == nil {
+		value, _ = json.Marshal(decoded)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(value))
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+		return true
+	}
+	return false
+}
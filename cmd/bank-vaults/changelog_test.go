@@ -0,0 +1,206 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestChangelogAppendsEntryPerRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/policies/acl/existing":
+			w.Write([]byte(`{"data":{"name":"existing","policy":"path \"secret/*\" { capabilities = [\"list\"] }"}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/policies/acl/unchanged":
+			w.Write([]byte(`{"data":{"name":"unchanged","policy":"path \"secret/*\" { capabilities = [\"read\"] }"}}`))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/sys/policies/acl/"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		default:
+			w.Write([]byte(`{"data":{}}`))
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "changelog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	changelogFile := filepath.Join(dir, "changelog.jsonl")
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = server.URL
+	configureConfig.changelog = newChangelog(changelogFile, "ci-operator", clientConfig.HttpClient.Transport)
+	defer func() { configureConfig.changelog = nil }()
+	clientConfig.HttpClient.Transport = configureConfig.changelog
+	cl, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vault.New(memory.New(map[string][]byte{"vault-root": []byte("root")}), cl, vault.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	config.SetConfigFile("vault-config.yml")
+	err = config.ReadConfig(bytes.NewBufferString(`
+policies:
+  - name: existing
+    rules: path "secret/*" { capabilities = ["read"] }
+  - name: created
+    rules: path "secret/*" { capabilities = ["read"] }
+  - name: unchanged
+    rules: path "secret/*" { capabilities = ["read"] }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		configurations := make(chan *viper.Viper, 1)
+		configurations <- config
+		close(configurations)
+		applyConfigurations(context.Background(), v, configurations, true)
+	}
+
+	data, err := ioutil.ReadFile(changelogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a changelog entry per run, got %d", len(lines))
+	}
+
+	var entry changelogEntry
+	err = json.Unmarshal([]byte(lines[1]), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Operator != "ci-operator" || entry.RequestID == "" || entry.ConfigFile != "vault-config.yml" || entry.ConfigHash == "" {
+		t.Fatalf("unexpected changelog entry: %+v", entry)
+	}
+	if len(entry.Changes) != 2 {
+		t.Fatalf("expected the changed policies only in the changelog, got: %+v", entry.Changes)
+	}
+
+	existing, created := entry.Changes[0], entry.Changes[1]
+	if existing.Path != "sys/policies/acl/existing" || existing.Action != "update" || existing.Before == "" || existing.After == "" || existing.Before == existing.After {
+		t.Fatalf("unexpected change of the existing policy: %+v", existing)
+	}
+	if created.Path != "sys/policies/acl/created" || created.Action != "create" || created.Before != "" || created.After == "" {
+		t.Fatalf("unexpected change of the created policy: %+v", created)
+	}
+	if strings.Contains(string(data), "capabilities") {
+		t.Fatal("the changelog shouldn't contain the values")
+	}
+}
+
+func TestChangelogUnchanged(t *testing.T) {
+	before := map[string]interface{}{"policy": "path \"secret/*\" {}", "name": "app"}
+	if !changelogUnchanged(before, []byte(`{"policy":"path \"secret/*\" {}"}`)) {
+		t.Error("expected a write of the current value to be unchanged")
+	}
+	if changelogUnchanged(before, []byte(`{"policy":"path \"secret/*\" {}","ttl":"1h"}`)) {
+		t.Error("expected a write of a new field to be a change")
+	}
+	if changelogUnchanged(nil, []byte(`{}`)) {
+		t.Error("expected a write without a current state to be a change")
+	}
+}
+
+func TestChangelogDiff(t *testing.T) {
+	before := map[string]interface{}{"url": "ldaps://old.example.com", "bindpass": "old", "userattr": "uid"}
+	body := []byte(`{"url":"ldaps://ldap.example.com","bindpass":"new","userattr":"uid","binddn":"cn=vault"}`)
+
+	diff := changelogDiff("auth/ldap/config", before, body)
+	if len(diff) != 3 {
+		t.Fatalf("expected the changed and added fields only, got %+v", diff)
+	}
+	if diff["url"].Before != "ldaps://old.example.com" || diff["url"].After != "ldaps://ldap.example.com" {
+		t.Errorf("unexpected diff of the url: %+v", diff["url"])
+	}
+	if diff["bindpass"].Before != sanitizeRedacted || diff["bindpass"].After != sanitizeRedacted {
+		t.Errorf("expected the bind password to be redacted, got %+v", diff["bindpass"])
+	}
+	if diff["binddn"].Before != nil || diff["binddn"].After != "cn=vault" {
+		t.Errorf("unexpected diff of the added field: %+v", diff["binddn"])
+	}
+
+	// the values of the secrets are never recorded
+	diff = changelogDiff("secret/data/app", nil, []byte(`{"data":{"apiKey":"abc"}}`))
+	if encoded, _ := json.Marshal(diff); strings.Contains(string(encoded), "abc") || diff["data"].After == nil {
+		t.Errorf("expected the secret data to be redacted, got %s", encoded)
+	}
+}
+
+func TestChangelogSinks(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	out := bytes.NewBuffer(nil)
+
+	c := newChangelog("", "ci-operator", nil)
+	c.diff = true
+	c.out = out
+	c.recordEvent = changelogEventRecorder(func() (kubernetes.Interface, error) { return client, nil }, "vault", "vault-configurer-0")
+
+	// a run without changes isn't worth an Event
+	c.begin()
+	if err := c.end("vault-config.yml", "sha256:abc", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.begin()
+	c.changes = []changelogChange{{Path: "sys/policies/acl/app", Action: "create"}}
+	if err := c.end("vault-config.yml", "sha256:abc", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 {
+		t.Fatalf("expected a JSON entry per run on stdout, got %q", out.String())
+	}
+
+	events, err := client.CoreV1().Events("vault").List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected an Event of the run with changes, got %d", len(events.Items))
+	}
+	event := events.Items[0]
+	if event.InvolvedObject.Name != "vault-configurer-0" || event.Reason != "VaultConfigured" || !strings.Contains(event.Message, "create sys/policies/acl/app") {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
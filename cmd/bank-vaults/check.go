@@ -0,0 +1,113 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var checkConfigCmd = &cobra.Command{
+	Use:   "check-config",
+	Short: "Checks that the secret engines and auth methods of the configuration are working",
+	Long: `This command checks every secret engine and auth method declared in the configuration
+files: it has to be enabled with the declared type and its tune endpoint has to answer.
+The report is printed as a table and the command exits with 1 if anything is not healthy.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+
+		vaultConfigFiles := appConfig.GetStringSlice(cfgVaultConfigFile)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		healthy, err := checkConfig(v, vaultConfigFiles, os.Stdout)
+		if err != nil {
+			logrus.Fatalf("error checking the configuration: %s", err.Error())
+		}
+		if !healthy {
+			exitCode = 1
+		}
+	},
+}
+
+// checkConfig prints the state of the mounts of all the configuration files as a table,
+// it returns false if any of them is not enabled or not healthy
+func checkConfig(v vault.Vault, vaultConfigFiles []string, out io.Writer) (bool, error) {
+	var checks []vault.MountCheck
+	for _, vaultConfigFile := range vaultConfigFiles {
+		config, err := readConfiguration(vaultConfigFile)
+		if err != nil {
+			return false, err
+		}
+		fileChecks, err := v.CheckMounts(config)
+		if err != nil {
+			return false, fmt.Errorf("error checking the mounts of %s: %s", vaultConfigFile, err.Error())
+		}
+		checks = append(checks, fileChecks...)
+	}
+
+	healthy := true
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tPATH\tTYPE\tENABLED\tHEALTHY\tMESSAGE")
+	for _, check := range checks {
+		if !check.Healthy {
+			healthy = false
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", check.Kind, check.Path, check.Type, yesNo(check.Enabled), yesNo(check.Healthy), check.Message)
+	}
+	return healthy, w.Flush()
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+func init() {
+	checkConfigCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+
+	rootCmd.AddCommand(checkConfigCmd)
+}
@@ -0,0 +1,77 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	writeConfigFile(t, configFile, "secrets:\n  - path: secret\n    type: kv\n  - path: pki\n    type: pki\nauth:\n  - type: kubernetes\n")
+
+	v := &mockVault{mountChecks: []vault.MountCheck{
+		{Kind: vault.MountKindSecret, Path: "secret", Type: "kv", Enabled: true, Healthy: true},
+		{Kind: vault.MountKindSecret, Path: "pki", Type: "pki", Message: "not enabled"},
+		{Kind: vault.MountKindAuth, Path: "kubernetes", Type: "kubernetes", Enabled: true, Healthy: true},
+	}}
+
+	out := bytes.NewBuffer(nil)
+	healthy, err := checkConfig(v, []string{configFile}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if healthy {
+		t.Fatal("expected the configuration to be reported unhealthy because of the missing mount")
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 rows, got:\n%s", out.String())
+	}
+	expected := [][]string{
+		{"KIND", "PATH", "TYPE", "ENABLED", "HEALTHY", "MESSAGE"},
+		{"secret", "secret", "kv", "yes", "yes"},
+		{"secret", "pki", "pki", "no", "no", "not", "enabled"},
+		{"auth", "kubernetes", "kubernetes", "yes", "yes"},
+	}
+	for i, line := range lines {
+		if fields := strings.Fields(line); strings.Join(fields, " ") != strings.Join(expected[i], " ") {
+			t.Fatalf("unexpected row %d: %q", i, line)
+		}
+	}
+
+	v.mountChecks = v.mountChecks[:1]
+	healthy, err = checkConfig(v, []string{configFile}, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !healthy {
+		t.Fatal("expected the configuration to be reported healthy")
+	}
+}
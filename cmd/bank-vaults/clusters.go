@@ -0,0 +1,201 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// vaultCluster is a Vault cluster of --vault-clusters, configured with the same configuration
+// as the others, it has its own kv store holding its root token and unseal keys. The token is
+// read from a file or an environment variable, so it doesn't show up in the process list.
+type vaultCluster struct {
+	name     string
+	address  string
+	token    string
+	role     string
+	kvConfig string
+}
+
+// clusterResult is the outcome of configuring a Vault cluster of --vault-clusters
+type clusterResult struct {
+	cluster string
+	results []configurationResult
+	err     error
+}
+
+// parseVaultClusters parses the name=...,address=...,token-file=...|token-env=...|role=...,kv-config=...
+// entries of the clusters, the address and the kv store config file are required, the name
+// defaults to the address
+func parseVaultClusters(entries []string) ([]vaultCluster, error) {
+	var clusters []vaultCluster
+	names := map[string]bool{}
+	for _, entry := range entries {
+		var cluster vaultCluster
+		for _, field := range strings.Split(entry, ",") {
+			parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("error parsing vault cluster '%s': expected key=value fields", entry)
+			}
+			switch parts[0] {
+			case "name":
+				cluster.name = parts[1]
+			case "address":
+				cluster.address = parts[1]
+			case "token":
+				return nil, fmt.Errorf("error parsing vault cluster '%s': the token can't be set on the command line, use token-file or token-env", entry)
+			case "token-file":
+				token, err := ioutil.ReadFile(parts[1])
+				if err != nil {
+					return nil, fmt.Errorf("error reading the token of vault cluster '%s': %s", entry, err.Error())
+				}
+				cluster.token = strings.TrimSpace(string(token))
+				if cluster.token == "" {
+					return nil, fmt.Errorf("error parsing vault cluster '%s': the token file %s is empty", entry, parts[1])
+				}
+			case "token-env":
+				cluster.token = os.Getenv(parts[1])
+				if cluster.token == "" {
+					return nil, fmt.Errorf("error parsing vault cluster '%s': the token environment variable %s is not set", entry, parts[1])
+				}
+			case "role":
+				cluster.role = parts[1]
+			case "kv-config":
+				cluster.kvConfig = parts[1]
+			default:
+				return nil, fmt.Errorf("error parsing vault cluster '%s': unknown field %s", entry, parts[0])
+			}
+		}
+
+		if cluster.address == "" {
+			return nil, fmt.Errorf("error parsing vault cluster '%s': the address has to be set", entry)
+		}
+		if cluster.name == "" {
+			cluster.name = cluster.address
+		}
+		if cluster.kvConfig == "" {
+			return nil, fmt.Errorf("the kv-config of vault cluster %s has to be set, the clusters can't share a kv store", cluster.name)
+		}
+		if cluster.token != "" && cluster.role != "" {
+			return nil, fmt.Errorf("only one of the token and the role of vault cluster %s can be set", cluster.name)
+		}
+		if names[cluster.name] {
+			return nil, fmt.Errorf("vault cluster %s is listed more than once", cluster.name)
+		}
+		names[cluster.name] = true
+
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+// newClusterVault creates the client and the vault helper of the cluster, with the token or
+// the role of the cluster if set, otherwise with the root token of its kv store
+func newClusterVault(cluster vaultCluster, store kv.Service, clientConfig *api.Config, vaultConfig vault.Config) (vault.Vault, *api.Client, error) {
+	cl, err := api.NewClient(clientConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to vault: %s", err.Error())
+	}
+	err = cl.SetAddress(cluster.address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error setting vault address: %s", err.Error())
+	}
+
+	vaultConfig.Token = cluster.token
+	if cluster.role != "" {
+		vaultConfig.AuthRole = cluster.role
+		if vaultConfig.AuthMethod == "" {
+			vaultConfig.AuthMethod = vault.AuthMethodKubernetes
+		}
+	}
+
+	v, err := vault.New(store, cl, vaultConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating vault helper: %s", err.Error())
+	}
+	return v, cl, nil
+}
+
+// clusterConnection is the vault helper of a Vault cluster of --vault-clusters, with the
+// configurations rendered for it and the kv store of its config snapshots (nil if they aren't
+// persisted), nothing of a cluster is kept in configureConfig
+type clusterConnection struct {
+	vault         vault.Vault
+	configs       []*viper.Viper
+	snapshotStore kv.Service
+}
+
+// configureClusters applies the configurations once to every cluster, one after the other,
+// connect creates the vault helper of a cluster and parses the configurations for it.
+// A cluster which can't be connected or configured doesn't stop the others.
+func configureClusters(ctx context.Context, clusters []vaultCluster, connect func(vaultCluster) (clusterConnection, error)) []clusterResult {
+	var results []clusterResult
+	for _, cluster := range clusters {
+		if ctx.Err() != nil {
+			break
+		}
+
+		logrus.Infof("configuring vault cluster %s (%s)...", cluster.name, cluster.address)
+		connection, err := connect(cluster)
+		if err != nil {
+			logrus.Errorf("error connecting to vault cluster %s: %s", cluster.name, err.Error())
+			results = append(results, clusterResult{cluster: cluster.name, err: err})
+			continue
+		}
+
+		configurations := make(chan *viper.Viper, len(connection.configs))
+		for _, config := range connection.configs {
+			configurations <- config
+		}
+		close(configurations)
+
+		results = append(results, clusterResult{
+			cluster: cluster.name,
+			results: applyConfigurationsTo(ctx, connection.vault, connection.snapshotStore, configurations, true),
+		})
+	}
+
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			logrus.Errorf("vault cluster %s: not configured: %s", result.cluster, result.err.Error())
+		case configurationsFailed(result.results):
+			logrus.Errorf("vault cluster %s: configuration failed", result.cluster)
+		default:
+			logrus.Infof("vault cluster %s: configured", result.cluster)
+		}
+	}
+	return results
+}
+
+// clustersFailed returns true if any of the clusters couldn't be connected or configured
+func clustersFailed(results []clusterResult) bool {
+	for _, result := range results {
+		if result.err != nil || configurationsFailed(result.results) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/spf13/viper"
+)
+
+func TestParseVaultClusters(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s.eu\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_VAULT_CLUSTER_TOKEN", "s.ap")
+	defer os.Unsetenv("TEST_VAULT_CLUSTER_TOKEN")
+
+	clusters, err := parseVaultClusters([]string{
+		"name=eu,address=https://vault-eu:8200,token-file=" + tokenFile + ",kv-config=eu.yaml",
+		"address=https://vault-us:8200,role=configurer,kv-config=us.yaml",
+		"name=ap,address=https://vault-ap:8200,token-env=TEST_VAULT_CLUSTER_TOKEN,kv-config=ap.yaml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []vaultCluster{
+		{name: "eu", address: "https://vault-eu:8200", token: "s.eu", kvConfig: "eu.yaml"},
+		{name: "https://vault-us:8200", address: "https://vault-us:8200", role: "configurer", kvConfig: "us.yaml"},
+		{name: "ap", address: "https://vault-ap:8200", token: "s.ap", kvConfig: "ap.yaml"},
+	}
+	if fmt.Sprint(clusters) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected clusters: %v", clusters)
+	}
+
+	for _, entries := range [][]string{
+		{"name=eu,kv-config=eu.yaml"},
+		{"address=https://vault-eu:8200"},
+		{"address=https://vault-eu:8200,kv-config=eu.yaml,token-file=" + tokenFile + ",role=configurer"},
+		// the token isn't accepted on the command line
+		{"address=https://vault-eu:8200,kv-config=eu.yaml,token=s.eu"},
+		{"address=https://vault-eu:8200,kv-config=eu.yaml,token-file=" + filepath.Join(t.TempDir(), "missing")},
+		{"address=https://vault-eu:8200,kv-config=eu.yaml,token-env=TEST_VAULT_CLUSTER_TOKEN_UNSET"},
+		{"address=https://vault-eu:8200,kv-config=eu.yaml,region=eu"},
+		{"name=eu,address=https://vault-eu:8200,kv-config=eu.yaml", "name=eu,address=https://vault-us:8200,kv-config=us.yaml"},
+	} {
+		if _, err := parseVaultClusters(entries); err == nil {
+			t.Fatalf("expected an error for %v", entries)
+		}
+	}
+}
+
+func TestConfigureClusters(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+
+	clusters := []vaultCluster{
+		{name: "eu", address: "https://vault-eu:8200", kvConfig: "eu.yaml"},
+		{name: "us", address: "https://vault-us:8200", kvConfig: "us.yaml"},
+	}
+	vaults := map[string]*mockVault{
+		"eu": {active: true, configureErr: fmt.Errorf("permission denied")},
+		"us": {active: true},
+	}
+	stores := map[string]kv.Service{}
+
+	results := configureClusters(context.Background(), clusters, func(cluster vaultCluster) (clusterConnection, error) {
+		stores[cluster.name] = memory.New(nil)
+		return clusterConnection{vault: vaults[cluster.name], configs: []*viper.Viper{viper.New()}}, nil
+	})
+
+	if len(results) != 2 || results[0].cluster != "eu" || results[1].cluster != "us" {
+		t.Fatalf("unexpected cluster results: %v", results)
+	}
+	if !configurationsFailed(results[0].results) || configurationsFailed(results[1].results) {
+		t.Fatalf("expected only the eu cluster to fail: %v", results)
+	}
+	if !clustersFailed(results) {
+		t.Fatal("expected the clusters to fail")
+	}
+
+	// a failing cluster doesn't stop configuring the next one
+	for name, v := range vaults {
+		if len(v.configured) != 1 {
+			t.Fatalf("expected cluster %s to be configured once, got %d", name, len(v.configured))
+		}
+	}
+	if stores["eu"] == stores["us"] {
+		t.Fatal("expected the clusters to have their own kv stores")
+	}
+}
+
+func TestConfigureClustersConnectError(t *testing.T) {
+	clusters := []vaultCluster{
+		{name: "eu", address: "https://vault-eu:8200", kvConfig: "eu.yaml"},
+		{name: "us", address: "https://vault-us:8200", kvConfig: "us.yaml"},
+	}
+	us := &mockVault{active: true}
+
+	results := configureClusters(context.Background(), clusters, func(cluster vaultCluster) (clusterConnection, error) {
+		if cluster.name == "eu" {
+			return clusterConnection{}, fmt.Errorf("error reading kv store config file eu.yaml")
+		}
+		return clusterConnection{vault: us, configs: []*viper.Viper{viper.New()}}, nil
+	})
+
+	if len(results) != 2 || results[0].err == nil || results[1].err != nil {
+		t.Fatalf("unexpected cluster results: %v", results)
+	}
+	if len(us.configured) != 1 {
+		t.Fatal("expected the us cluster to be configured")
+	}
+	if !clustersFailed(results) {
+		t.Fatal("expected the clusters to fail")
+	}
+}
+
+func TestConfigRendererPerCluster(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "vault-config.yml")
+	content := "policies:\n  - name: ${ .vault.edition }\n    rules: path \"secret/*\" { capabilities = [\"read\"] }\n"
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the clusters render the templates with their own edition, the global template data is kept
+	configureConfig.templateData = configTemplateData(nil, "oss")
+	defer func() { configureConfig.templateData = nil }()
+
+	for _, edition := range []string{"enterprise", "oss"} {
+		renderer := configRenderer{templateData: configTemplateData(nil, edition)}
+		config, err := renderer.readConfiguration(configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies := []map[string]interface{}{}
+		if err := config.UnmarshalKey("policies", &policies); err != nil {
+			t.Fatal(err)
+		}
+		if len(policies) != 1 || policies[0]["name"] != edition {
+			t.Errorf("expected the policy of the %s edition, got %v", edition, policies)
+		}
+	}
+	if fmt.Sprint(configureConfig.templateData) != fmt.Sprint(configTemplateData(nil, "oss")) {
+		t.Errorf("expected the global template data to be kept, got %v", configureConfig.templateData)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	keyMatch      = "match"
+	keyMismatch   = "mismatch"
+	keyMissingSrc = "missing in src"
+	keyMissingDst = "missing in dst"
+	keyMissing    = "missing"
+)
+
+type keyComparison struct {
+	key    string
+	status string
+}
+
+var compareStoreCmd = &cobra.Command{
+	Use:   "compare-store src dst",
+	Short: "Compares the keys stored in two kv backends",
+	Long: `This command reads the root token and the unseal keys from two kv backends
+and reports for every key whether it matches, differs, or is missing.
+
+The src and dst arguments are YAML/JSON files holding the flags of the backends
+(e.g. mode, aws-s3-bucket, ...), unset values fall back to the command line flags.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := kvStoreForConfigFile(args[0])
+		if err != nil {
+			logrus.Fatalf("error creating src kv store: %s", err.Error())
+		}
+
+		dst, err := kvStoreForConfigFile(args[1])
+		if err != nil {
+			logrus.Fatalf("error creating dst kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		comparisons, err := compareStores(src, dst, vault.StoredKeys(vaultConfig))
+		if err != nil {
+			logrus.Fatalf("error comparing kv stores: %s", err.Error())
+		}
+
+		equal := true
+		for _, comparison := range comparisons {
+			fmt.Printf("%s: %s\n", comparison.key, comparison.status)
+			if comparison.status != keyMatch {
+				equal = false
+			}
+		}
+
+		if !equal {
+			exitCode = 1
+		}
+	},
+}
+
+// kvStoreForConfigFile creates a kv store from a backend config file, the unset values
+// are taken from the application config
+func kvStoreForConfigFile(configFile string) (kv.Service, error) {
+	cfg := viper.New()
+	for _, key := range appConfig.AllKeys() {
+		cfg.SetDefault(key, appConfig.Get(key))
+	}
+
+	cfg.SetConfigFile(configFile)
+	err := cfg.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error reading kv store config file %s: %s", configFile, err.Error())
+	}
+
+	return kvStoreForConfig(cfg)
+}
+
+// compareStores compares the given keys in the two kv stores
+func compareStores(src, dst kv.Service, keys []string) ([]keyComparison, error) {
+	var comparisons []keyComparison
+
+	for _, key := range keys {
+		srcValue, srcFound, err := getIfFound(src, key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s' from src: %s", key, err.Error())
+		}
+		dstValue, dstFound, err := getIfFound(dst, key)
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s' from dst: %s", key, err.Error())
+		}
+
+		status := keyMatch
+		switch {
+		case !srcFound && !dstFound:
+			status = keyMissing
+		case !srcFound:
+			status = keyMissingSrc
+		case !dstFound:
+			status = keyMissingDst
+		case !bytes.Equal(srcValue, dstValue):
+			status = keyMismatch
+		}
+
+		comparisons = append(comparisons, keyComparison{key: key, status: status})
+	}
+
+	return comparisons, nil
+}
+
+func getIfFound(store kv.Service, key string) ([]byte, bool, error) {
+	value, err := store.Get(key)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func init() {
+	rootCmd.AddCommand(compareStoreCmd)
+}
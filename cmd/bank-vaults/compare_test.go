@@ -0,0 +1,46 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+)
+
+func TestCompareStores(t *testing.T) {
+	src := memory.New(map[string][]byte{
+		"vault-root":     []byte("root"),
+		"vault-unseal-0": []byte("key0"),
+		"vault-unseal-1": []byte("key1"),
+	})
+	dst := memory.New(map[string][]byte{
+		"vault-root":     []byte("root"),
+		"vault-unseal-0": []byte("other"),
+		"vault-unseal-2": []byte("key2"),
+	})
+
+	comparisons, err := compareStores(src, dst, []string{"vault-root", "vault-unseal-0", "vault-unseal-1", "vault-unseal-2", "vault-unseal-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{keyMatch, keyMismatch, keyMissingDst, keyMissingSrc, keyMissing}
+	for i, comparison := range comparisons {
+		if comparison.status != expected[i] {
+			t.Errorf("expected %s for %s, got %s", expected[i], comparison.key, comparison.status)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Masterminds/sprig"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The template functions of the config files resolving secrets
+const (
+	templateFuncSecret = "secret"
+	templateFuncVault  = "vault"
+)
+
+// configSecrets resolves the secret (a key of a Kubernetes Secret) and vault (a key of a
+// Vault secret) functions of the config templates, and remembers the values every config file
+// was last rendered with, so it can be re-rendered once they changed
+type configSecrets struct {
+	// the vault references are rendered once Vault is unsealed, until the context is cancelled
+	ctx        context.Context
+	vault      vault.Vault
+	kubernetes func() (kubernetes.Interface, error)
+	// how long the vault references wait for Vault to be unsealed, 0 waits until the context is cancelled
+	sealedTimeout time.Duration
+
+	mu         sync.Mutex
+	kubeClient kubernetes.Interface
+	// the hashes of the referenced values by config file, keyed by the function and the reference
+	references map[string]map[string]string
+}
+
+func newConfigSecrets(ctx context.Context, v vault.Vault) *configSecrets {
+	return &configSecrets{
+		ctx:        ctx,
+		vault:      v,
+		kubernetes: kubernetesClient,
+		references: map[string]map[string]string{},
+	}
+}
+
+// templateFuncs returns the sprig, secret and vault functions of the config templates, the
+// hashes of the referenced values are collected in references. Without a configSecrets (e.g.
+// in a dry run, which doesn't connect to Vault) the functions render a placeholder instead,
+// and the references are collected without a hash.
+func (s *configSecrets) templateFuncs(references map[string]string) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	for _, function := range []string{templateFuncSecret, templateFuncVault} {
+		function := function
+		funcs[function] = func(reference string) (interface{}, error) {
+			if s == nil {
+				references[function+" "+reference] = ""
+				return fmt.Sprintf("<%s %s>", function, reference), nil
+			}
+			value, err := s.resolve(function, reference)
+			if err != nil {
+				return nil, err
+			}
+			references[function+" "+reference] = valueHash(value)
+			return value, nil
+		}
+	}
+	return funcs
+}
+
+// rendered remembers the references of the last rendering of the config file
+func (s *configSecrets) rendered(configFile string, references map[string]string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(references) == 0 {
+		delete(s.references, configFile)
+		return
+	}
+	s.references[configFile] = references
+}
+
+// resolve returns the value of a secret reference: namespace/name/key for the secret function,
+// path#key (or path for all the data) for the vault function
+func (s *configSecrets) resolve(function, reference string) (interface{}, error) {
+	switch function {
+	case templateFuncSecret:
+		parts := strings.SplitN(reference, "/", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("secret '%s' is not in namespace/name/key form", reference)
+		}
+		client, err := s.kubernetesClient()
+		if err != nil {
+			return nil, err
+		}
+		secret, err := client.CoreV1().Secrets(parts[0]).Get(parts[1], metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error reading secret %s/%s: %s", parts[0], parts[1], err.Error())
+		}
+		value, ok := secret.Data[parts[2]]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in secret %s/%s", parts[2], parts[0], parts[1])
+		}
+		return string(value), nil
+	case templateFuncVault:
+		if err := s.waitUnsealed(); err != nil {
+			return nil, err
+		}
+		split := strings.SplitN(reference, "#", 2)
+		data, err := s.vault.ReadSecret(split[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(split) == 1 {
+			return data, nil
+		}
+		value, ok := data[split[1]]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in secret %s", split[1], split[0])
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown template function %s", function)
+}
+
+// waitUnsealed waits until Vault is unsealed, the secrets of a sealed Vault can't be read (e.g.
+// the config files are rendered before the unseal of a starting Vault)
+func (s *configSecrets) waitUnsealed() error {
+	start := time.Now()
+	for failures := 1; ; failures++ {
+		sealed, err := s.vault.Sealed()
+		if err == nil && !sealed {
+			return nil
+		}
+		if s.sealedTimeout > 0 && time.Since(start) >= s.sealedTimeout {
+			return fmt.Errorf("vault is still sealed or unreachable after %s, the vault references can't be rendered", s.sealedTimeout)
+		}
+
+		retryAfter := vaultReferenceRetryInterval(failures)
+		if err != nil {
+			logrus.Errorf("error checking if vault is sealed: %s, rendering the vault references in %s...", err.Error(), retryAfter)
+		} else if failures == 1 {
+			logrus.Infof("vault is sealed, the vault references are rendered once it is unsealed")
+		}
+		if !waitUnlessShutdown(s.ctx, retryAfter) {
+			return s.ctx.Err()
+		}
+	}
+}
+
+// vaultReferenceRetryInterval is how long the vault references wait before checking the seal again
+func vaultReferenceRetryInterval(failures int) time.Duration {
+	return retryInterval(unsealConfig.unsealRetryInitial, unsealConfig.unsealPeriod, failures, rand.Float64())
+}
+
+func (s *configSecrets) kubernetesClient() (kubernetes.Interface, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.kubeClient == nil {
+		client, err := s.kubernetes()
+		if err != nil {
+			return nil, fmt.Errorf("error creating kubernetes client: %s", err.Error())
+		}
+		s.kubeClient = client
+	}
+	return s.kubeClient, nil
+}
+
+// changed returns the config files whose referenced secrets changed since they were rendered,
+// the secrets which can't be read are logged and treated as unchanged
+func (s *configSecrets) changed() []string {
+	s.mu.Lock()
+	references := make(map[string]map[string]string, len(s.references))
+	for configFile, fileReferences := range s.references {
+		references[configFile] = fileReferences
+	}
+	s.mu.Unlock()
+
+	var changed []string
+	for configFile, fileReferences := range references {
+		for key, hash := range fileReferences {
+			split := strings.SplitN(key, " ", 2)
+			value, err := s.resolve(split[0], split[1])
+			if err != nil {
+				logrus.Errorf("error checking the %s of %s for changes: %s", key, configFile, err.Error())
+				continue
+			}
+			if valueHash(value) != hash {
+				logrus.Infof("the %s of %s changed", key, configFile)
+				changed = append(changed, configFile)
+				break
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// watchConfigSecrets re-renders the config files onto the channel whose referenced secrets
+// changed, they are checked at the poll interval until the context is cancelled
+func watchConfigSecrets(ctx context.Context, s *configSecrets, pollInterval time.Duration, configurations chan *viper.Viper) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, configFile := range s.changed() {
+				config, err := readConfiguration(configFile)
+				if err != nil {
+					logrus.Errorf("error reading %s: %s", configFile, err.Error())
+					continue
+				}
+				select {
+				case configurations <- config:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// vaultReferencePaths returns the paths of the Vault secrets referenced by the config template,
+// the template is rendered with placeholders, without reading the secrets
+func vaultReferencePaths(vaultConfigFile string) ([]string, error) {
+	templateName := filepath.Base(vaultConfigFile)
+	references := map[string]string{}
+
+	var secrets *configSecrets
+	configTemplate, err := template.New(templateName).
+		Funcs(secrets.templateFuncs(references)).
+		Delims("${", "}").
+		ParseFiles(vaultConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing vault config template: %s", err.Error())
+	}
+	err = configTemplate.ExecuteTemplate(ioutil.Discard, templateName, configureConfig.templateData)
+	if err != nil {
+		return nil, fmt.Errorf("error executing vault config template: %s", err.Error())
+	}
+
+	var paths []string
+	for key := range references {
+		split := strings.SplitN(key, " ", 2)
+		if split[0] == templateFuncVault {
+			paths = append(paths, strings.SplitN(split[1], "#", 2)[0])
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// valueHash returns the hash of a referenced value, the secrets aren't kept in memory
+func valueHash(value interface{}) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%v", value))))
+}
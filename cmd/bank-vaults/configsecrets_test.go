@@ -0,0 +1,203 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/spf13/viper"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const secretsConfig = `auth:
+  - type: ldap
+    config:
+      url: ldaps://ldap.example.com
+      bindpass: ${ secret "vault/ldap/bindpass" | quote }
+  - type: oidc
+    path: oidc
+    config:
+      oidc_client_id: vault
+      oidc_client_secret: ${ vault "secret/data/oidc#client_secret" | quote }
+`
+
+func writeSecretsConfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "vault-config.yml")
+	if err := ioutil.WriteFile(configFile, []byte(secretsConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configFile, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigSecrets(t *testing.T) {
+	configFile, cleanup := writeSecretsConfig(t)
+	defer cleanup()
+
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ldap", Namespace: "vault"},
+		Data:       map[string][]byte{"bindpass": []byte("ldap-s3cr3t")},
+	})
+	mock := &mockVault{secrets: map[string]map[string]interface{}{
+		"secret/data/oidc": {"client_secret": "oidc-s3cr3t"},
+	}}
+	secrets := newConfigSecrets(context.Background(), mock)
+	secrets.kubernetes = func() (kubernetes.Interface, error) { return client, nil }
+
+	configureConfig.secrets = secrets
+	defer func() { configureConfig.secrets = nil }()
+
+	config, err := readConfiguration(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := config.Get("auth").([]interface{})
+	if bindpass := auth[0].(map[interface{}]interface{})["config"].(map[interface{}]interface{})["bindpass"]; bindpass != "ldap-s3cr3t" {
+		t.Errorf("expected the bind password of the Kubernetes Secret, got %v", bindpass)
+	}
+	if clientSecret := auth[1].(map[interface{}]interface{})["config"].(map[interface{}]interface{})["oidc_client_secret"]; clientSecret != "oidc-s3cr3t" {
+		t.Errorf("expected the client secret of the Vault secret, got %v", clientSecret)
+	}
+
+	if changed := secrets.changed(); len(changed) != 0 {
+		t.Fatalf("expected no changed config files, got %v", changed)
+	}
+
+	// the changed Kubernetes Secret re-renders the config file
+	_, err = client.CoreV1().Secrets("vault").Update(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ldap", Namespace: "vault"},
+		Data:       map[string][]byte{"bindpass": []byte("rotated")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configurations := make(chan *viper.Viper)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchConfigSecrets(ctx, secrets, 10*time.Millisecond, configurations)
+
+	select {
+	case config = <-configurations:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the config file to be re-rendered")
+	}
+	auth = config.Get("auth").([]interface{})
+	if bindpass := auth[0].(map[interface{}]interface{})["config"].(map[interface{}]interface{})["bindpass"]; bindpass != "rotated" {
+		t.Errorf("expected the rotated bind password, got %v", bindpass)
+	}
+	cancel()
+
+	if changed := secrets.changed(); len(changed) != 0 {
+		t.Errorf("expected the re-rendered config file to be up to date, got %v", changed)
+	}
+}
+
+func TestConfigSecretsPlaceholders(t *testing.T) {
+	configFile, cleanup := writeSecretsConfig(t)
+	defer cleanup()
+
+	// a dry run doesn't read the secrets
+	config, err := readConfiguration(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := config.Get("auth").([]interface{})
+	if bindpass := auth[0].(map[interface{}]interface{})["config"].(map[interface{}]interface{})["bindpass"]; bindpass != `<secret vault/ldap/bindpass>` {
+		t.Errorf("expected a placeholder of the bind password, got %v", bindpass)
+	}
+}
+
+func TestConfigSecretsWaitUnsealed(t *testing.T) {
+	configFile, cleanup := writeSecretsConfig(t)
+	defer cleanup()
+
+	unsealPeriod := unsealConfig.unsealPeriod
+	unsealConfig.unsealPeriod = time.Millisecond
+	defer func() { unsealConfig.unsealPeriod = unsealPeriod }()
+
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ldap", Namespace: "vault"},
+		Data:       map[string][]byte{"bindpass": []byte("ldap-s3cr3t")},
+	})
+	mock := &mockVault{sealed: true, secrets: map[string]map[string]interface{}{
+		"secret/data/oidc": {"client_secret": "oidc-s3cr3t"},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	secrets := newConfigSecrets(ctx, mock)
+	secrets.kubernetes = func() (kubernetes.Interface, error) { return client, nil }
+
+	configureConfig.secrets = secrets
+	defer func() { configureConfig.secrets = nil }()
+
+	// the vault references are rendered once Vault is unsealed
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		mock.Lock()
+		mock.sealed = false
+		mock.Unlock()
+	}()
+	config, err := readConfiguration(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := config.Get("auth").([]interface{})
+	if clientSecret := auth[1].(map[interface{}]interface{})["config"].(map[interface{}]interface{})["oidc_client_secret"]; clientSecret != "oidc-s3cr3t" {
+		t.Errorf("expected the client secret of the unsealed Vault, got %v", clientSecret)
+	}
+
+	// a single run gives up after the sealed timeout
+	mock.Lock()
+	mock.sealed = true
+	mock.Unlock()
+	secrets.sealedTimeout = 10 * time.Millisecond
+	if _, err := readConfiguration(configFile); err == nil || !strings.Contains(err.Error(), "still sealed") {
+		t.Fatalf("expected the rendering to time out while vault is sealed, got: %v", err)
+	}
+
+	// and the shutdown stops waiting
+	secrets.sealedTimeout = 0
+	cancel()
+	if _, err := readConfiguration(configFile); err == nil {
+		t.Fatal("expected the rendering to stop on shutdown")
+	}
+}
+
+func TestRequiredPolicyRulesVaultReferences(t *testing.T) {
+	configFile, cleanup := writeSecretsConfig(t)
+	defer cleanup()
+
+	rules, err := requiredPolicyRules([]string{configFile}, vault.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rules["secret/data/oidc"]["read"] {
+		t.Errorf("expected the policy to read the referenced vault secret, got: %v", rules["secret/data/oidc"])
+	}
+}
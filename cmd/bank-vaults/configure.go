@@ -16,20 +16,137 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
-	"github.com/Masterminds/sprig"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
 	"github.com/fsnotify/fsnotify"
 	"github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
 )
 
 const cfgVaultConfigFile = "vault-config-file"
+const cfgVaultConfigValues = "vault-config-values"
+const cfgMountDescriptionTemplate = "mount-description-template"
+const cfgManagedMetadata = "managed-metadata"
+const cfgPruneSecrets = "prune-secrets"
+const cfgPruneAuth = "prune-auth"
+const cfgActiveOnly = "active-only"
+const cfgAllowDefaultPolicyEdit = "allow-default-policy-edit"
+const cfgAtomicPolicies = "atomic-policies"
+const cfgValidatePolicyTemplates = "validate-policy-templates"
+const cfgFailOnDeprecated = "fail-on-deprecated"
+const cfgStatusConfigMap = "status-configmap"
+const cfgStatusCRD = "status-crd"
+const cfgConfigLiteral = "config-literal"
+const cfgRetryFailedAfter = "retry-failed-after"
+const cfgReconcileInterval = "reconcile-interval"
+const cfgMaxIterations = "max-iterations"
+const cfgWatchUntil = "watch-until"
+const cfgValidateInNamespace = "validate-in-namespace"
+const cfgRevokeLeasesOnDisable = "revoke-leases-on-disable"
+const cfgConfigureRateLimit = "configure-rate-limit"
+const cfgOtelEndpoint = "otel-endpoint"
+const cfgChangelogFile = "changelog-file"
+const cfgConsistentReads = "consistent-reads"
+const cfgAdminAddress = "admin-address"
+const cfgAdminToken = "admin-token"
+const cfgForwardInconsistent = "forward-inconsistent"
+const cfgChangelogOperator = "changelog-operator"
+const cfgChangeLog = "change-log"
+const cfgChangeLogEvents = "change-log-events"
+const cfgShutdownGracePeriod = "shutdown-grace-period"
+const cfgMetricsAddress = "metrics-address"
+const cfgDryRun = "dry-run"
+const cfgDetectChanges = "detect-changes"
+const cfgConfirmIdentityPurge = "confirm-identity-purge"
+const cfgConfigureRetries = "configure-retries"
+const cfgSealedTimeout = "sealed-timeout"
+const cfgPersistConfigSnapshot = "persist-config-snapshot"
+const cfgConfigDebounce = "config-debounce"
+const cfgExplain = "explain"
+const cfgVaultClusters = "vault-clusters"
+const cfgVerifyAccess = "verify-access"
+const cfgEarlyAudit = "early-audit"
+const cfgContinueOnSectionFailure = "continue-on-section-failure"
+const cfgWatchSeal = "watch-seal"
+const cfgSectionBreakerThreshold = "section-breaker-threshold"
+const cfgSectionBreakerCooldown = "section-breaker-cooldown"
+const cfgVaultConfigGit = "vault-config-git"
+const cfgGitRef = "git-ref"
+const cfgGitPath = "git-path"
+const cfgGitPollInterval = "git-poll-interval"
+const cfgGitToken = "git-token"
+const cfgGitSSHKey = "git-ssh-key"
+const cfgAutoRevokeRootToken = "auto-revoke-root-token"
+const cfgConfigurerTokenPeriod = "configurer-token-period"
+const cfgConfigureConcurrency = "configure-concurrency"
+const cfgWriteRetries = "write-retries"
+const cfgWriteRetryInterval = "write-retry-interval"
+const cfgLeaderElection = "leader-election"
+const cfgLeaseName = "lease-name"
+const cfgLeaseNamespace = "lease-namespace"
+const cfgLeaseDuration = "lease-duration"
+const cfgLeaseRenewDeadline = "lease-renew-deadline"
+const cfgLeaseRetryPeriod = "lease-retry-period"
+const cfgConfigSecretsPollInterval = "config-secrets-poll-interval"
+
+type configureCfg struct {
+	activeOnly        bool
+	retryFailedAfter  time.Duration
+	reconcileInterval time.Duration
+	maxIterations     int
+	watchUntil        time.Duration
+	configureRetries  int
+	sealedTimeout     time.Duration
+	configDebounce    time.Duration
+	changelog         *changelog
+	// the kv store of the config snapshots, nil if they are not persisted
+	snapshotStore kv.Service
+	// the data of the config templates, nil without a values file
+	templateData interface{}
+	// the on-demand runs requested through the admin API, nil if it is disabled
+	applyRequests chan applyRequest
+	// the sealed to unsealed transitions of Vault, nil if the seal state isn't watched
+	unsealTransitions chan struct{}
+	// the secrets referenced by the config templates, nil if they aren't resolved (e.g. in a dry run)
+	secrets *configSecrets
+	// how often the referenced secrets are checked for changes in watch mode, 0 disables it
+	secretsPollInterval time.Duration
+	// the health and readiness checks served with the metrics, nil if they aren't served
+	health *healthChecker
+	// the directory the config file labels of the metrics are relative to, e.g. the clone of
+	// --vault-config-git, empty if they aren't
+	configRoot string
+}
+
+// configurationResult is the outcome of the last configuration run of a config file
+type configurationResult struct {
+	configFile string
+	hash       string
+	applied    bool
+	err        error
+}
+
+var configureConfig configureCfg
 
 var configureCmd = &cobra.Command{
 	Use:   "configure",
@@ -40,11 +157,191 @@ var configureCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		appConfig.BindPFlag(cfgOnce, cmd.PersistentFlags().Lookup(cfgOnce))
 		appConfig.BindPFlag(cfgUnsealPeriod, cmd.PersistentFlags().Lookup(cfgUnsealPeriod))
+		appConfig.BindPFlag(cfgUnsealRetryInitial, cmd.PersistentFlags().Lookup(cfgUnsealRetryInitial))
 		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgVaultConfigValues, cmd.PersistentFlags().Lookup(cfgVaultConfigValues))
+		appConfig.BindPFlag(cfgMountDescriptionTemplate, cmd.PersistentFlags().Lookup(cfgMountDescriptionTemplate))
+		appConfig.BindPFlag(cfgManagedMetadata, cmd.PersistentFlags().Lookup(cfgManagedMetadata))
+		appConfig.BindPFlag(cfgPruneSecrets, cmd.PersistentFlags().Lookup(cfgPruneSecrets))
+		appConfig.BindPFlag(cfgPruneAuth, cmd.PersistentFlags().Lookup(cfgPruneAuth))
+		appConfig.BindPFlag(cfgActiveOnly, cmd.PersistentFlags().Lookup(cfgActiveOnly))
+		appConfig.BindPFlag(cfgAllowDefaultPolicyEdit, cmd.PersistentFlags().Lookup(cfgAllowDefaultPolicyEdit))
+		appConfig.BindPFlag(cfgAtomicPolicies, cmd.PersistentFlags().Lookup(cfgAtomicPolicies))
+		appConfig.BindPFlag(cfgValidatePolicyTemplates, cmd.PersistentFlags().Lookup(cfgValidatePolicyTemplates))
+		appConfig.BindPFlag(cfgFailOnDeprecated, cmd.PersistentFlags().Lookup(cfgFailOnDeprecated))
+		appConfig.BindPFlag(cfgStatusConfigMap, cmd.PersistentFlags().Lookup(cfgStatusConfigMap))
+		appConfig.BindPFlag(cfgStatusCRD, cmd.PersistentFlags().Lookup(cfgStatusCRD))
+		appConfig.BindPFlag(cfgRetryFailedAfter, cmd.PersistentFlags().Lookup(cfgRetryFailedAfter))
+		appConfig.BindPFlag(cfgReconcileInterval, cmd.PersistentFlags().Lookup(cfgReconcileInterval))
+		appConfig.BindPFlag(cfgWatchSeal, cmd.PersistentFlags().Lookup(cfgWatchSeal))
+		appConfig.BindPFlag(cfgMaxIterations, cmd.PersistentFlags().Lookup(cfgMaxIterations))
+		appConfig.BindPFlag(cfgWatchUntil, cmd.PersistentFlags().Lookup(cfgWatchUntil))
+		appConfig.BindPFlag(cfgValidateInNamespace, cmd.PersistentFlags().Lookup(cfgValidateInNamespace))
+		appConfig.BindPFlag(cfgRevokeLeasesOnDisable, cmd.PersistentFlags().Lookup(cfgRevokeLeasesOnDisable))
+		appConfig.BindPFlag(cfgAutoRevokeRootToken, cmd.PersistentFlags().Lookup(cfgAutoRevokeRootToken))
+		appConfig.BindPFlag(cfgConfigurerTokenPeriod, cmd.PersistentFlags().Lookup(cfgConfigurerTokenPeriod))
+		appConfig.BindPFlag(cfgConfigureRateLimit, cmd.PersistentFlags().Lookup(cfgConfigureRateLimit))
+		appConfig.BindPFlag(cfgSectionBreakerThreshold, cmd.PersistentFlags().Lookup(cfgSectionBreakerThreshold))
+		appConfig.BindPFlag(cfgSectionBreakerCooldown, cmd.PersistentFlags().Lookup(cfgSectionBreakerCooldown))
+		appConfig.BindPFlag(cfgConfigureConcurrency, cmd.PersistentFlags().Lookup(cfgConfigureConcurrency))
+		appConfig.BindPFlag(cfgWriteRetries, cmd.PersistentFlags().Lookup(cfgWriteRetries))
+		appConfig.BindPFlag(cfgWriteRetryInterval, cmd.PersistentFlags().Lookup(cfgWriteRetryInterval))
+		appConfig.BindPFlag(cfgLeaderElection, cmd.PersistentFlags().Lookup(cfgLeaderElection))
+		appConfig.BindPFlag(cfgLeaseName, cmd.PersistentFlags().Lookup(cfgLeaseName))
+		appConfig.BindPFlag(cfgLeaseNamespace, cmd.PersistentFlags().Lookup(cfgLeaseNamespace))
+		appConfig.BindPFlag(cfgLeaseDuration, cmd.PersistentFlags().Lookup(cfgLeaseDuration))
+		appConfig.BindPFlag(cfgLeaseRenewDeadline, cmd.PersistentFlags().Lookup(cfgLeaseRenewDeadline))
+		appConfig.BindPFlag(cfgLeaseRetryPeriod, cmd.PersistentFlags().Lookup(cfgLeaseRetryPeriod))
+		appConfig.BindPFlag(cfgConfigSecretsPollInterval, cmd.PersistentFlags().Lookup(cfgConfigSecretsPollInterval))
+		appConfig.BindPFlag(cfgOtelEndpoint, cmd.PersistentFlags().Lookup(cfgOtelEndpoint))
+		appConfig.BindPFlag(cfgChangelogFile, cmd.PersistentFlags().Lookup(cfgChangelogFile))
+		appConfig.BindPFlag(cfgConsistentReads, cmd.PersistentFlags().Lookup(cfgConsistentReads))
+		appConfig.BindPFlag(cfgAdminAddress, cmd.PersistentFlags().Lookup(cfgAdminAddress))
+		appConfig.BindPFlag(cfgAdminToken, cmd.PersistentFlags().Lookup(cfgAdminToken))
+		appConfig.BindPFlag(cfgForwardInconsistent, cmd.PersistentFlags().Lookup(cfgForwardInconsistent))
+		appConfig.BindPFlag(cfgChangelogOperator, cmd.PersistentFlags().Lookup(cfgChangelogOperator))
+		appConfig.BindPFlag(cfgChangeLog, cmd.PersistentFlags().Lookup(cfgChangeLog))
+		appConfig.BindPFlag(cfgChangeLogEvents, cmd.PersistentFlags().Lookup(cfgChangeLogEvents))
+		appConfig.BindPFlag(cfgShutdownGracePeriod, cmd.PersistentFlags().Lookup(cfgShutdownGracePeriod))
+		bindMetricsAddress(cmd.PersistentFlags())
+		appConfig.BindPFlag(cfgDryRun, cmd.PersistentFlags().Lookup(cfgDryRun))
+		appConfig.BindPFlag(cfgDetectChanges, cmd.PersistentFlags().Lookup(cfgDetectChanges))
+		appConfig.BindPFlag(cfgConfirmIdentityPurge, cmd.PersistentFlags().Lookup(cfgConfirmIdentityPurge))
+		appConfig.BindPFlag(cfgConfigureRetries, cmd.PersistentFlags().Lookup(cfgConfigureRetries))
+		appConfig.BindPFlag(cfgSealedTimeout, cmd.PersistentFlags().Lookup(cfgSealedTimeout))
+		appConfig.BindPFlag(cfgPersistConfigSnapshot, cmd.PersistentFlags().Lookup(cfgPersistConfigSnapshot))
+		appConfig.BindPFlag(cfgConfigDebounce, cmd.PersistentFlags().Lookup(cfgConfigDebounce))
+		appConfig.BindPFlag(cfgExplain, cmd.PersistentFlags().Lookup(cfgExplain))
+		appConfig.BindPFlag(cfgVerifyAccess, cmd.PersistentFlags().Lookup(cfgVerifyAccess))
+		appConfig.BindPFlag(cfgEarlyAudit, cmd.PersistentFlags().Lookup(cfgEarlyAudit))
+		appConfig.BindPFlag(cfgContinueOnSectionFailure, cmd.PersistentFlags().Lookup(cfgContinueOnSectionFailure))
+		appConfig.BindPFlag(cfgVaultConfigGit, cmd.PersistentFlags().Lookup(cfgVaultConfigGit))
+		appConfig.BindPFlag(cfgGitRef, cmd.PersistentFlags().Lookup(cfgGitRef))
+		appConfig.BindPFlag(cfgGitPath, cmd.PersistentFlags().Lookup(cfgGitPath))
+		appConfig.BindPFlag(cfgGitPollInterval, cmd.PersistentFlags().Lookup(cfgGitPollInterval))
+		appConfig.BindPFlag(cfgGitToken, cmd.PersistentFlags().Lookup(cfgGitToken))
+		appConfig.BindPFlag(cfgGitSSHKey, cmd.PersistentFlags().Lookup(cfgGitSSHKey))
 
 		runOnce := appConfig.GetBool(cfgOnce)
 		unsealConfig.unsealPeriod = appConfig.GetDuration(cfgUnsealPeriod)
+		unsealConfig.unsealRetryInitial = appConfig.GetDuration(cfgUnsealRetryInitial)
+		configureConfig.activeOnly = appConfig.GetBool(cfgActiveOnly)
+		configureConfig.retryFailedAfter = appConfig.GetDuration(cfgRetryFailedAfter)
+		configureConfig.reconcileInterval = appConfig.GetDuration(cfgReconcileInterval)
+		configureConfig.maxIterations = appConfig.GetInt(cfgMaxIterations)
+		configureConfig.watchUntil = appConfig.GetDuration(cfgWatchUntil)
+		configureConfig.configureRetries = appConfig.GetInt(cfgConfigureRetries)
+		// the transient errors are retried by one layer only, either the writes or the runs, the
+		// writes aren't retried with --configure-retries unless --write-retries is set too
+		if configureConfig.configureRetries > 0 && appConfig.GetInt(cfgWriteRetries) > 0 {
+			if cmd.PersistentFlags().Changed(cfgWriteRetries) {
+				logrus.Fatalf("--%s can't be used together with --%s, set --%s=0 to retry the runs instead of the writes", cfgConfigureRetries, cfgWriteRetries, cfgWriteRetries)
+			}
+			appConfig.Set(cfgWriteRetries, 0)
+		}
+		configureConfig.sealedTimeout = appConfig.GetDuration(cfgSealedTimeout)
+		configureConfig.configDebounce = appConfig.GetDuration(cfgConfigDebounce)
+		configureConfig.secretsPollInterval = appConfig.GetDuration(cfgConfigSecretsPollInterval)
 		vaultConfigFiles := appConfig.GetStringSlice(cfgVaultConfigFile)
+		statusConfigMap := appConfig.GetString(cfgStatusConfigMap)
+		statusCRDValue := appConfig.GetString(cfgStatusCRD)
+		validateNamespace := appConfig.GetString(cfgValidateInNamespace)
+		var templateValues map[string]interface{}
+		if valuesFile := appConfig.GetString(cfgVaultConfigValues); valuesFile != "" {
+			values, err := readConfigValues(valuesFile)
+			if err != nil {
+				logrus.Fatal(err.Error())
+			}
+			templateValues = values
+		}
+		configureConfig.templateData = configTemplateData(templateValues, "")
+		// not bound to viper, it would split the literals at commas and whitespace
+		configLiterals, _ := cmd.PersistentFlags().GetStringArray(cfgConfigLiteral)
+		clusterEntries, _ := cmd.PersistentFlags().GetStringArray(cfgVaultClusters)
+
+		// the config files of a Git repository are applied from a shallow clone instead of the --vault-config-file ones
+		var gitSource *gitConfigSource
+		if gitURL := appConfig.GetString(cfgVaultConfigGit); gitURL != "" {
+			if len(configLiterals) > 0 {
+				logrus.Fatalf("--%s can't be used together with --%s", cfgVaultConfigGit, cfgConfigLiteral)
+			}
+			gitSource = &gitConfigSource{
+				url:    gitURL,
+				ref:    appConfig.GetString(cfgGitRef),
+				path:   appConfig.GetString(cfgGitPath),
+				token:  appConfig.GetString(cfgGitToken),
+				sshKey: appConfig.GetString(cfgGitSSHKey),
+			}
+			// the clone is removed on the exits of logrus too, the deferred calls are skipped by them
+			logrus.RegisterExitHandler(gitSource.remove)
+			defer gitSource.remove()
+			err := gitSource.clone()
+			if err != nil {
+				logrus.Fatal(err.Error())
+			}
+			vaultConfigFiles, err = gitSource.configFiles()
+			if err != nil {
+				logrus.Fatal(err.Error())
+			}
+			configureConfig.configRoot = gitSource.dir
+		}
+
+		detectChangesOnly := appConfig.GetBool(cfgDetectChanges)
+		if detectChangesOnly && !appConfig.GetBool(cfgDryRun) {
+			logrus.Fatalf("--%s can only be used together with --%s", cfgDetectChanges, cfgDryRun)
+		}
+
+		if appConfig.GetBool(cfgDryRun) {
+			if !dryRunConfigurations(vaultConfigFiles, os.Stdout) {
+				exitCode = 1
+				return
+			}
+			// the changes are detected against Vault, once the config files are known to be valid
+			if !detectChangesOnly {
+				return
+			}
+		}
+
+		if appConfig.GetBool(cfgExplain) {
+			if !explainConfigurations(vaultConfigFiles, os.Stdout) {
+				exitCode = 1
+			}
+			return
+		}
+
+		// inline configurations are applied only once instead of the config files,
+		// and the validation is a one-off as well
+		if len(configLiterals) > 0 || validateNamespace != "" {
+			runOnce = true
+		}
+
+		if statusConfigMap != "" && !runOnce {
+			logrus.Fatalf("--%s can only be used together with --%s", cfgStatusConfigMap, cfgOnce)
+		}
+		if statusCRDValue != "" && !runOnce {
+			logrus.Fatalf("--%s can only be used together with --%s", cfgStatusCRD, cfgOnce)
+		}
+
+		clusters, err := parseVaultClusters(clusterEntries)
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+		if len(clusters) > 0 {
+			if !runOnce {
+				logrus.Fatalf("--%s can only be used together with --%s", cfgVaultClusters, cfgOnce)
+			}
+			if statusConfigMap != "" || statusCRDValue != "" || validateNamespace != "" {
+				logrus.Fatalf("--%s can't be used together with --%s, --%s or --%s", cfgVaultClusters, cfgStatusConfigMap, cfgStatusCRD, cfgValidateInNamespace)
+			}
+		}
+
+		var crd statusCRD
+		if statusCRDValue != "" {
+			crd, err = parseStatusCRD(statusCRDValue)
+			if err != nil {
+				logrus.Fatal(err.Error())
+			}
+		}
 
 		store, err := kvStoreForConfig(appConfig)
 
@@ -52,7 +349,50 @@ var configureCmd = &cobra.Command{
 			logrus.Fatalf("error creating kv store: %s", err.Error())
 		}
 
-		cl, err := api.NewClient(nil)
+		if appConfig.GetBool(cfgPersistConfigSnapshot) {
+			configureConfig.snapshotStore = store
+		}
+
+		clientConfig := api.DefaultConfig()
+		// the requests denied with the token of --auth-method are retried with a new login, the
+		// transports above it see them once
+		loginRetry := vault.NewLoginRetryTransport(clientConfig.HttpClient.Transport)
+		clientConfig.HttpClient.Transport = loginRetry
+
+		if appConfig.GetBool(cfgConsistentReads) || appConfig.GetBool(cfgForwardInconsistent) {
+			clientConfig.HttpClient.Transport = newConsistencyTransport(appConfig.GetBool(cfgForwardInconsistent), clientConfig.HttpClient.Transport)
+		}
+
+		var configureTracer *tracer
+		if otelEndpoint := appConfig.GetString(cfgOtelEndpoint); otelEndpoint != "" {
+			exporter, err := newOTLPExporter(otelEndpoint)
+			if err != nil {
+				logrus.Fatalf("error creating trace exporter: %s", err.Error())
+			}
+			configureTracer = newTracer(exporter)
+			clientConfig.HttpClient.Transport = &tracingTransport{tracer: configureTracer, next: clientConfig.HttpClient.Transport}
+		}
+
+		if changelogFile := appConfig.GetString(cfgChangelogFile); changelogFile != "" || appConfig.GetBool(cfgChangeLog) {
+			configureConfig.changelog = newChangelog(changelogFile, appConfig.GetString(cfgChangelogOperator), clientConfig.HttpClient.Transport)
+			clientConfig.HttpClient.Transport = configureConfig.changelog
+
+			// the audit trail of --change-log: the diffs of the changes as JSON on stdout
+			if appConfig.GetBool(cfgChangeLog) {
+				configureConfig.changelog.diff = true
+				configureConfig.changelog.out = os.Stdout
+
+				if appConfig.GetBool(cfgChangeLogEvents) {
+					pod, err := leaderElectionIdentity()
+					if err != nil {
+						logrus.Fatalf("error getting the pod of the change log events: %s", err.Error())
+					}
+					configureConfig.changelog.recordEvent = changelogEventRecorder(kubernetesClient, podNamespace(), pod)
+				}
+			}
+		}
+
+		cl, err := api.NewClient(clientConfig)
 
 		if err != nil {
 			logrus.Fatalf("error connecting to vault: %s", err.Error())
@@ -64,130 +404,1213 @@ var configureCmd = &cobra.Command{
 			logrus.Fatalf("error building vault config: %s", err.Error())
 		}
 
+		if configureTracer != nil {
+			vaultConfig.Tracer = configureTracer
+		}
+		vaultConfig.LoginRetryTransport = loginRetry
+
+		// the token replacing the root token has to apply every config file, not only the first one,
+		// the secrets of the templates aren't resolved yet, they don't change the required paths
+		if vaultConfig.AutoRevokeRootToken && len(configLiterals) == 0 {
+			vaultConfig.ConfigurerPolicy, err = requiredPolicyRules(vaultConfigFiles, vaultConfig)
+			if err != nil {
+				logrus.Fatalf("error generating the %s policy: %s", vault.ConfigurerPolicyName, err.Error())
+			}
+		}
+
+		// SIGINT and SIGTERM stop the watch mode, the run in flight may finish within the grace period
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			select {
+			case sig := <-signals:
+				logrus.Infof("received %s, shutting down...", sig)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// the waits of the configuration runs stop on shutdown too
+		vaultConfig.Context = ctx
+
 		v, err := vault.New(store, cl, vaultConfig)
 
 		if err != nil {
 			logrus.Fatalf("error creating vault helper: %s", err.Error())
 		}
 
-		configurations := make(chan *viper.Viper, len(vaultConfigFiles))
+		if detectChangesOnly {
+			configureConfig.secrets = newConfigSecrets(ctx, v)
+			exitCode = detectChanges(v, vaultConfigFiles, os.Stdout)
+			return
+		}
 
-		for _, vaultConfigFile := range vaultConfigFiles {
-			configurations <- parseConfiguration(vaultConfigFile)
+		configureConfig.secrets = newConfigSecrets(ctx, v)
+		if runOnce {
+			configureConfig.secrets.sealedTimeout = configureConfig.sealedTimeout
 		}
 
-		if !runOnce {
-			go watchConfigurations(vaultConfigFiles, configurations)
-		} else {
-			close(configurations)
+		if metricsAddress := appConfig.GetString(cfgMetricsAddress); metricsAddress != "" {
+			// the standby replicas aren't ready, they don't configure Vault until they are elected,
+			// the configuration runs of multiple clusters aren't probed
+			if len(clusters) == 0 {
+				configureConfig.health = newHealthChecker(store, v, true)
+				if len(configLiterals) == 0 {
+					configureConfig.health.expectConfigFiles(vaultConfigFiles)
+				}
+			}
+			server := metricsServer(metricsAddress, configureConfig.health)
+			go func() {
+				logrus.Infof("configure metrics enabled: %s%s", metricsAddress, defaultMetricsPath)
+				if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logrus.Errorf("error serving metrics: %s", err.Error())
+				}
+			}()
+			defer func() {
+				shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancelShutdown()
+				server.Shutdown(shutdownCtx)
+			}()
+		}
+
+		// the standby replicas only serve the metrics until they are elected
+		if appConfig.GetBool(cfgLeaderElection) {
+			if runOnce || len(clusters) > 0 {
+				logrus.Errorf("--%s can only be used in watch mode", cfgLeaderElection)
+				exitCode = 1
+				return
+			}
+			client, err := kubernetesClient()
+			if err != nil {
+				logrus.Errorf("error creating kubernetes client: %s", err.Error())
+				exitCode = 1
+				return
+			}
+			identity, err := leaderElectionIdentity()
+			if err != nil {
+				logrus.Error(err.Error())
+				exitCode = 1
+				return
+			}
+			namespace := appConfig.GetString(cfgLeaseNamespace)
+			if namespace == "" {
+				namespace = podNamespace()
+			}
+			lock := &leaseLock{
+				namespace: namespace,
+				name:      appConfig.GetString(cfgLeaseName),
+				identity:  identity,
+				client:    client.CoordinationV1beta1(),
+			}
+			elected, err := waitForLeadership(ctx, lock, leaderElectionCfg{
+				leaseDuration: appConfig.GetDuration(cfgLeaseDuration),
+				renewDeadline: appConfig.GetDuration(cfgLeaseRenewDeadline),
+				retryPeriod:   appConfig.GetDuration(cfgLeaseRetryPeriod),
+			})
+			if err != nil {
+				logrus.Error(err.Error())
+				exitCode = 1
+				return
+			}
+			if !elected {
+				return
+			}
 		}
 
-		for config := range configurations {
+		if watchSealPeriod := appConfig.GetDuration(cfgWatchSeal); watchSealPeriod > 0 {
+			if runOnce || len(clusters) > 0 {
+				logrus.Errorf("--%s can only be used in watch mode", cfgWatchSeal)
+				exitCode = 1
+				return
+			}
+			configureConfig.unsealTransitions = make(chan struct{})
+			go watchSeal(ctx, v, watchSealPeriod, configureConfig.unsealTransitions)
+		}
 
-			logrus.Infoln("config file has changed:", config.ConfigFileUsed())
+		if adminAddress := appConfig.GetString(cfgAdminAddress); adminAddress != "" {
+			if runOnce || len(clusters) > 0 {
+				logrus.Errorf("--%s can only be used in watch mode", cfgAdminAddress)
+				exitCode = 1
+				return
+			}
+			adminToken := appConfig.GetString(cfgAdminToken)
+			if adminToken == "" {
+				logrus.Errorf("--%s requires --%s", cfgAdminAddress, cfgAdminToken)
+				exitCode = 1
+				return
+			}
+			configureConfig.applyRequests = make(chan applyRequest)
+			server := adminServer(adminAddress, adminToken, configureConfig.applyRequests)
+			go func() {
+				logrus.Infof("configure admin API enabled: %s", adminAddress)
+				if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logrus.Errorf("error serving admin API: %s", err.Error())
+				}
+			}()
+			defer func() {
+				shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancelShutdown()
+				server.Shutdown(shutdownCtx)
+			}()
+		}
 
-			func() {
-				for {
-					logrus.Infof("checking if vault is sealed...")
-					sealed, err := v.Sealed()
+		// the same configurations are applied to every cluster with their own client and kv store,
+		// the templates are rendered for each of them (e.g. with their edition)
+		if len(clusters) > 0 {
+			var results []clusterResult
+			finished := runUntilShutdown(ctx, appConfig.GetDuration(cfgShutdownGracePeriod), func() {
+				results = configureClusters(ctx, clusters, func(cluster vaultCluster) (clusterConnection, error) {
+					var connection clusterConnection
+					clusterStore, err := kvStoreForConfigFile(cluster.kvConfig)
 					if err != nil {
-						logrus.Errorf("error checking if vault is sealed: %s, waiting %s before trying again...", err.Error(), unsealConfig.unsealPeriod)
-						time.Sleep(unsealConfig.unsealPeriod)
-						continue
+						return connection, err
+					}
+					if appConfig.GetBool(cfgPersistConfigSnapshot) {
+						connection.snapshotStore = clusterStore
 					}
 
-					// If vault is sealed, we stop here and wait another unsealPeriod
-					if sealed {
-						logrus.Infof("vault is sealed, waiting %s before trying again...", unsealConfig.unsealPeriod)
-						time.Sleep(unsealConfig.unsealPeriod)
-						continue
+					clusterVault, clusterClient, err := newClusterVault(cluster, clusterStore, clientConfig, vaultConfig)
+					if err != nil {
+						return connection, err
 					}
+					connection.vault = clusterVault
 
-					logrus.Infof("vault is unsealed, configuring...")
+					edition, ok := detectVaultEdition(ctx, clusterClient)
+					if !ok {
+						return connection, ctx.Err()
+					}
+					renderer := configRenderer{
+						templateData: configTemplateData(templateValues, edition),
+						secrets:      newConfigSecrets(ctx, clusterVault),
+					}
+					renderer.secrets.sealedTimeout = configureConfig.sealedTimeout
+
+					if len(configLiterals) > 0 {
+						config, err := renderer.parseConfigurationLiterals(configLiterals)
+						if err != nil {
+							return connection, err
+						}
+						connection.configs = []*viper.Viper{config}
+						return connection, nil
+					}
+					for _, vaultConfigFile := range vaultConfigFiles {
+						config, err := renderer.readConfiguration(vaultConfigFile)
+						if err != nil {
+							return connection, err
+						}
+						connection.configs = append(connection.configs, config)
+					}
+					return connection, nil
+				})
+			})
+			if !finished {
+				logrus.Warnf("the configuration in flight didn't finish within the shutdown grace period")
+				return
+			}
+			if clustersFailed(results) {
+				exitCode = 1
+			}
+			return
+		}
 
-					if err = v.Configure(config); err != nil {
-						logrus.Errorf("error configuring vault: %s", err.Error())
+		// the Vault edition is detected once, before the config templates are rendered
+		edition, ok := detectVaultEdition(ctx, cl)
+		if !ok {
+			return
+		}
+		configureConfig.templateData = configTemplateData(templateValues, edition)
+
+		configurations := make(chan *viper.Viper, len(vaultConfigFiles))
+
+		if len(configLiterals) > 0 {
+			config, err := parseConfigurationLiterals(configLiterals)
+			if err != nil {
+				logrus.Error(err.Error())
+				exitCode = 1
+				return
+			}
+			configurations <- config
+		} else {
+			// the vault references of the config templates wait for Vault to be unsealed
+			for _, vaultConfigFile := range vaultConfigFiles {
+				config, err := readConfiguration(vaultConfigFile)
+				if err != nil {
+					if ctx.Err() != nil {
 						return
 					}
+					logrus.Error(err.Error())
+					exitCode = 1
+					return
+				}
+				configurations <- config
+			}
+		}
 
-					logrus.Infof("successfully configured vault")
+		if !runOnce {
+			var watcher *fsnotify.Watcher
+			if gitSource == nil {
+				watcher, err = newConfigWatcher(vaultConfigFiles)
+				if err != nil {
+					logrus.Error(err.Error())
+					exitCode = 1
 					return
 				}
+			}
+			go func() {
+				if gitSource != nil {
+					watchGitConfigurations(ctx, gitSource, appConfig.GetDuration(cfgGitPollInterval), configurations)
+				} else {
+					watchConfigurations(ctx, watcher, vaultConfigFiles, configurations)
+				}
+				close(configurations)
 			}()
+		} else {
+			close(configurations)
+		}
+
+		if validateNamespace != "" {
+			if !validateConfigurations(v, configurations, validateNamespace) {
+				exitCode = 1
+			}
+			return
+		}
+
+		var results []configurationResult
+		finished := runUntilShutdown(ctx, appConfig.GetDuration(cfgShutdownGracePeriod), func() {
+			results = applyConfigurations(ctx, v, configurations, runOnce)
+		})
+		if !finished {
+			logrus.Warnf("the configuration in flight didn't finish within the shutdown grace period")
+			return
+		}
+
+		if statusConfigMap != "" {
+			client, err := kubernetesClient()
+			if err != nil {
+				logrus.Errorf("error creating kubernetes client: %s", err.Error())
+				exitCode = 1
+				return
+			}
+
+			err = writeStatusConfigMap(client, statusConfigMap, results, time.Now())
+			if err != nil {
+				logrus.Errorf("error writing status configmap: %s", err.Error())
+				exitCode = 1
+				return
+			}
+		}
+
+		if statusCRDValue != "" {
+			client, err := kubernetesDynamicClient()
+			if err != nil {
+				logrus.Errorf("error creating kubernetes client: %s", err.Error())
+				exitCode = 1
+				return
+			}
+
+			err = patchStatusCRD(client, crd, results, time.Now())
+			if err != nil {
+				logrus.Errorf("error patching status crd: %s", err.Error())
+				exitCode = 1
+				return
+			}
+		}
+
+		// a single run (e.g. in a Job) has to tell if the configuration didn't apply,
+		// and so does a bounded watch loop about its last runs
+		bounded := configureConfig.maxIterations > 0 || configureConfig.watchUntil > 0
+		if (runOnce || bounded) && configurationsFailed(results) {
+			exitCode = 1
 		}
 	},
 }
 
-func watchConfigurations(vaultConfigFiles []string, configurations chan *viper.Viper) {
+// runUntilShutdown runs fn and waits for it to return, once the context is cancelled it waits
+// at most the grace period, it returns false if fn didn't return in time
+func runUntilShutdown(ctx context.Context, gracePeriod time.Duration, fn func()) bool {
+	finished := make(chan struct{})
+	go func() {
+		fn()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-finished:
+		return true
+	case <-time.After(gracePeriod):
+		return false
+	}
+}
+
+// configurationsFailed returns true if any of the configurations failed to apply,
+// skipping the configuration on a standby node is not a failure
+func configurationsFailed(results []configurationResult) bool {
+	for _, result := range results {
+		if result.err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// configurationRetry is a scheduled re-apply of the failed sections of a configuration
+type configurationRetry struct {
+	config   *viper.Viper
+	hash     string
+	sections []string
+}
+
+// applyConfigurations configures Vault with every configuration arriving on the channel,
+// semantically unchanged configurations (e.g. only comments or whitespace changed) are skipped.
+// In watch mode the failed sections are retried after the retry cooldown if it is set, and the
+// latest configurations are re-applied at the reconcile interval if it is set.
+// It returns the result of the last run of every config file, when the channel is closed or the context is cancelled.
+func applyConfigurations(ctx context.Context, v vault.Vault, configurations <-chan *viper.Viper, runOnce bool) []configurationResult {
+	return applyConfigurationsTo(ctx, v, configureConfig.snapshotStore, configurations, runOnce)
+}
+
+// applyConfigurationsTo is applyConfigurations persisting the config snapshots in the kv store
+// if it isn't nil
+func applyConfigurationsTo(ctx context.Context, v vault.Vault, snapshotStore kv.Service, configurations <-chan *viper.Viper, runOnce bool) []configurationResult {
+	applied := map[string]string{}
+	latest := map[string]string{}
+	results := map[string]configurationResult{}
+	retries := make(chan configurationRetry)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	var configFiles []string
+	latestConfigs := map[string]*viper.Viper{}
+
+	var reconciles <-chan time.Time
+	if !runOnce && configureConfig.reconcileInterval > 0 {
+		ticker := time.NewTicker(configureConfig.reconcileInterval)
+		defer ticker.Stop()
+		reconciles = ticker.C
+	}
+
+	var deadline <-chan time.Time
+	if !runOnce && configureConfig.watchUntil > 0 {
+		timer := time.NewTimer(configureConfig.watchUntil)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	iterations := 0
+	iterationsExhausted := func() bool {
+		return configureConfig.maxIterations > 0 && iterations >= configureConfig.maxIterations
+	}
+
+	apply := func(config *viper.Viper, hash string, sections []string) {
+		if iterationsExhausted() {
+			return
+		}
+		iterations++
+
+		configFile := config.ConfigFileUsed()
+
+		configureConfig.changelog.begin()
+		ok, err := configureVault(ctx, v, config, sections, runOnce)
+		if changelogErr := configureConfig.changelog.end(configFile, hash, err); changelogErr != nil {
+			logrus.Errorf("error writing changelog: %s", changelogErr.Error())
+		}
+		if ok {
+			applied[configFile] = hash
+
+			// inline configurations have no config file to snapshot
+			if snapshotStore != nil && configFile != "" {
+				if err := persistConfigSnapshot(snapshotStore, configFile, hash, time.Now()); err != nil {
+					logrus.Errorf("error persisting config snapshot: %s", err.Error())
+				}
+			}
+		}
+
+		if _, seen := results[configFile]; !seen {
+			configFiles = append(configFiles, configFile)
+		}
+		results[configFile] = configurationResult{configFile: configFile, hash: hash, applied: ok, err: err}
+		configureConfig.health.recordConfigure(configFile, err)
+
+		if configErr, partial := err.(*vault.ConfigurationError); partial && !runOnce && configureConfig.retryFailedAfter > 0 {
+			logrus.Infof("retrying the failed and skipped sections %v of %s in %s", configErr.UnappliedSections(), configFile, configureConfig.retryFailedAfter)
+			retry := configurationRetry{config: config, hash: hash, sections: configErr.UnappliedSections()}
+			time.AfterFunc(configureConfig.retryFailedAfter, func() {
+				select {
+				case retries <- retry:
+				case <-stopped:
+				}
+			})
+		}
+	}
+
+	for done := false; !done; {
+		// the run in flight is finished, but nothing else is started after the shutdown
+		if ctx.Err() != nil {
+			break
+		}
+		if iterationsExhausted() {
+			logrus.Infof("stopping after %d configuration runs", iterations)
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			done = true
+
+		case config, open := <-configurations:
+			if !open {
+				done = true
+				break
+			}
+
+			configFile := config.ConfigFileUsed()
+			hash := configurationHash(config)
+			latest[configFile] = hash
+			latestConfigs[configFile] = config
+
+			if applied[configFile] == hash {
+				logrus.Infoln("config file has changed, but its content is the same as the applied one:", configFile)
+				continue
+			}
+
+			logrus.Infoln("config file has changed:", configFile)
+
+			apply(config, hash, nil)
+
+		case retry := <-retries:
+			configFile := retry.config.ConfigFileUsed()
+
+			// the configuration has changed since, that run has its own retries
+			if latest[configFile] != retry.hash {
+				continue
+			}
+
+			logrus.Infof("retrying the failed sections %v of %s", retry.sections, configFile)
+
+			apply(retry.config, retry.hash, retry.sections)
+
+		case <-reconciles:
+			for _, configFile := range configFiles {
+				logrus.Infoln("reconciling vault with config file:", configFile)
+
+				apply(latestConfigs[configFile], latest[configFile], nil)
+			}
+
+		case <-deadline:
+			// a final run with the latest configurations, then the watch loop exits
+			for _, configFile := range configFiles {
+				logrus.Infoln("watch deadline reached, applying config file a final time:", configFile)
+
+				apply(latestConfigs[configFile], latest[configFile], nil)
+			}
+			done = true
+
+		case <-configureConfig.unsealTransitions:
+			for _, configFile := range configFiles {
+				logrus.Infoln("vault got unsealed, applying config file:", configFile)
+
+				apply(latestConfigs[configFile], latest[configFile], nil)
+			}
+
+		case request := <-configureConfig.applyRequests:
+			applyResults := make([]configurationResult, 0, len(configFiles))
+			for _, configFile := range configFiles {
+				logrus.Infoln("applying config file on request of the admin API:", configFile)
+
+				apply(latestConfigs[configFile], latest[configFile], nil)
+				applyResults = append(applyResults, results[configFile])
+			}
+			request.results <- applyResults
+		}
+	}
+
+	ordered := make([]configurationResult, 0, len(configFiles))
+	for _, configFile := range configFiles {
+		ordered = append(ordered, results[configFile])
+	}
+	return ordered
+}
+
+// watchSeal polls the seal state of Vault and signals its sealed to unsealed transitions, e.g.
+// after Vault got restarted and unsealed again. Vault being unreachable counts as sealed, so a
+// restart is noticed even if Vault is unsealed (e.g. auto-unsealed) before the next poll.
+func watchSeal(ctx context.Context, v vault.Vault, period time.Duration, unsealTransitions chan<- struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	wasSealed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sealed, err := v.Sealed()
+		if err != nil {
+			logrus.Debugf("error checking if vault is sealed: %s", err.Error())
+			sealed = true
+		}
+
+		if wasSealed && !sealed {
+			select {
+			case unsealTransitions <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		wasSealed = sealed
+	}
+}
+
+// validateConfigurations applies every configuration in the temporary validation namespace
+// and reports the results, it returns false if any of them is invalid
+func validateConfigurations(v vault.Vault, configurations <-chan *viper.Viper, namespace string) bool {
+	valid := true
+
+	for config := range configurations {
+		configFile := config.ConfigFileUsed()
+
+		err := v.ValidateInNamespace(config, namespace)
+		if err == nil {
+			logrus.Infof("configuration %s is valid", configFile)
+			continue
+		}
+
+		valid = false
+		if configErr, ok := err.(*vault.ConfigurationError); ok {
+			for _, section := range configErr.FailedSections {
+				logrus.Errorf("configuration %s is invalid, section %s failed: %s", configFile, section, configErr.Errors[section].Error())
+			}
+		} else {
+			logrus.Errorf("error validating configuration %s: %s", configFile, err.Error())
+		}
+	}
+
+	return valid
+}
+
+// configurationHash returns the hash of the parsed configuration settings,
+// fmt prints maps with sorted keys so this is stable
+func configurationHash(config *viper.Viper) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%#v", config.AllSettings()))))
+}
+
+// configureVault waits until Vault is unsealed (and active if requested) and applies the given sections
+// of the configuration (all if nil), it returns true if they got applied and the configuration error if any.
+// Waiting for Vault is given up when the context is cancelled, or in a single run after the sealed timeout.
+func configureVault(ctx context.Context, v vault.Vault, config *viper.Viper, sections []string, runOnce bool) (bool, error) {
+	start := time.Now()
+	attempts := 0
+	for failures := 1; ; failures++ {
+		retryAfter := retryInterval(unsealConfig.unsealRetryInitial, unsealConfig.unsealPeriod, failures, rand.Float64())
+
+		if runOnce && configureConfig.sealedTimeout > 0 && failures > 1 && time.Since(start) >= configureConfig.sealedTimeout {
+			return false, fmt.Errorf("vault is still sealed or unreachable after %s", configureConfig.sealedTimeout)
+		}
+
+		logrus.Infof("checking if vault is sealed...")
+		sealed, err := v.Sealed()
+		if err != nil {
+			logrus.Errorf("error checking if vault is sealed: %s, waiting %s before trying again...", err.Error(), retryAfter)
+			if !waitUnlessShutdown(ctx, retryAfter) {
+				return false, ctx.Err()
+			}
+			continue
+		}
+
+		vaultSealed.Set(bToF(sealed))
+
+		// If vault is sealed, we stop here and wait before trying again
+		if sealed {
+			logrus.Infof("vault is sealed, waiting %s before trying again...", retryAfter)
+			if !waitUnlessShutdown(ctx, retryAfter) {
+				return false, ctx.Err()
+			}
+			continue
+		}
+
+		// Only the active node should be configured if requested, the others are standing by
+		if configureConfig.activeOnly {
+			active, err := v.Active()
+			if err != nil {
+				logrus.Errorf("error checking if vault is active: %s, waiting %s before trying again...", err.Error(), retryAfter)
+				if !waitUnlessShutdown(ctx, retryAfter) {
+					return false, ctx.Err()
+				}
+				continue
+			}
+
+			if !active {
+				if runOnce {
+					logrus.Infof("vault is in standby mode, skipping configuration")
+					return false, nil
+				}
+				logrus.Infof("vault is in standby mode, standing by %s before trying again...", retryAfter)
+				if !waitUnlessShutdown(ctx, retryAfter) {
+					return false, ctx.Err()
+				}
+				continue
+			}
+		}
+
+		logrus.Infof("vault is unsealed, configuring...")
+
+		start := time.Now()
+		err = v.ConfigureSections(config, sections)
+		configureDuration.Observe(time.Since(start).Seconds())
+		configureTotal.Inc()
+		if err != nil {
+			configureErrors.Inc()
+			configureFileErrors.WithLabelValues(configFileLabel(config, configureConfig.configRoot)).Inc()
+			attempts++
+			// in watch mode transient errors (e.g. a leader election) are retried, as the
+			// configuration would be applied again only on the next change otherwise
+			// the sections whose circuit breaker is open would be skipped again
+			retriable := true
+			if configErr, partial := err.(*vault.ConfigurationError); partial {
+				retriable = len(configErr.RetriableSections()) > 0
+			}
+			if !runOnce && retriable && attempts <= configureConfig.configureRetries {
+				// only the failed and the skipped sections are retried if some of them succeeded
+				if configErr, partial := err.(*vault.ConfigurationError); partial {
+					sections = append(configErr.RetriableSections(), configErr.SkippedSections...)
+				}
+				logrus.Errorf("error configuring vault: %s, retrying in %s (%d/%d)...", err.Error(), retryAfter, attempts, configureConfig.configureRetries)
+				if !waitUnlessShutdown(ctx, retryAfter) {
+					return false, ctx.Err()
+				}
+				continue
+			}
+			if attempts > 1 {
+				logrus.Errorf("error configuring vault, giving up after %d attempts: %s", attempts, err.Error())
+			} else {
+				logrus.Errorf("error configuring vault: %s", err.Error())
+			}
+			return false, err
+		}
+
+		logrus.Infof("successfully configured vault")
+		recordConfigureSuccess(time.Now())
+		return true, nil
+	}
+}
+
+// retryInterval returns how long to wait after the given number of consecutive failures (starting from 1):
+// the interval starts from initial and doubles up to max, minus a jitter of up to 20% (random is in [0, 1))
+// so that many instances don't retry at once. Without an initial interval (or if it is not below max)
+// it is always max.
+func retryInterval(initial, max time.Duration, failures int, random float64) time.Duration {
+	if initial <= 0 || initial >= max {
+		return max
+	}
+
+	interval := initial
+	for i := 1; i < failures && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		interval = max
+	}
+
+	return interval - time.Duration(float64(interval)*0.2*random)
+}
+
+// waitUnlessShutdown waits for the given duration, it returns false if the context got cancelled meanwhile
+func waitUnlessShutdown(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// watchConfigurations parses the config files changed according to the watcher onto the channel
+// until the context is cancelled, the watcher is closed then
+func watchConfigurations(ctx context.Context, watcher *fsnotify.Watcher, vaultConfigFiles []string, configurations chan *viper.Viper) {
+	defer watcher.Close()
+
+	// the config files are re-rendered when the secrets referenced by their templates change too
+	if configureConfig.secrets != nil && configureConfig.secretsPollInterval > 0 {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchConfigSecrets(ctx, configureConfig.secrets, configureConfig.secretsPollInterval, configurations)
+		}()
+		defer wg.Wait()
+	}
+
+	watchConfigEvents(ctx, watcher, vaultConfigFiles, configureConfig.configDebounce, configurations)
+}
+
+// newConfigWatcher watches the directories of all the config files with one watcher,
+// we have to watch the entire directory to pick up renames/atomic saves in a cross-platform way
+func newConfigWatcher(vaultConfigFiles []string) (*fsnotify.Watcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, err
 	}
-	defer watcher.Close()
 
+	watched := map[string]bool{}
 	for _, vaultConfigFile := range vaultConfigFiles {
-		// we have to watch the entire directory to pick up renames/atomic saves in a cross-platform way
-		configFile := filepath.Clean(vaultConfigFile)
-		configDir, _ := filepath.Split(configFile)
+		configDir := filepath.Dir(filepath.Clean(vaultConfigFile))
+		if watched[configDir] {
+			continue
+		}
+		err = watcher.Add(configDir)
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("error watching config directory %s: %s", configDir, err.Error())
+		}
+		watched[configDir] = true
+	}
 
-		done := make(chan bool)
-		go func() {
-			for {
-				select {
-				case event := <-watcher.Events:
-					// we only care about the config file or the ConfigMap directory (if in Kubernetes)
-					if filepath.Clean(event.Name) == configFile || filepath.Base(event.Name) == "..data" {
-						if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-							configurations <- parseConfiguration(configFile)
+	return watcher, nil
+}
+
+// watchConfigEvents parses the config files affected by the events of the watcher
+// until the context is cancelled or the watcher is closed. The events of a config file are
+// debounced: it is parsed once its events have been quiet for the debounce window, so a burst
+// of events (e.g. the ..data swap of a ConfigMap) triggers one reconfigure per changed file.
+func watchConfigEvents(ctx context.Context, watcher *fsnotify.Watcher, vaultConfigFiles []string, debounce time.Duration, configurations chan *viper.Viper) {
+	// the time each changed config file is parsed at
+	pending := map[string]time.Time{}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	schedule := func() {
+		var next time.Time
+		for _, at := range pending {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+
+	send := func(configFile string) bool {
+		config, err := readConfiguration(configFile)
+		if err != nil {
+			logrus.Errorf("error reading %s: %s", configFile, err.Error())
+			return true
+		}
+		select {
+		case configurations <- config:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&fsnotify.Write != fsnotify.Write && event.Op&fsnotify.Create != fsnotify.Create {
+				continue
+			}
+			for _, vaultConfigFile := range vaultConfigFiles {
+				configFile := filepath.Clean(vaultConfigFile)
+				// we only care about the config file or its ConfigMap directory (if in Kubernetes)
+				if filepath.Clean(event.Name) == configFile ||
+					(filepath.Base(event.Name) == "..data" && filepath.Dir(filepath.Clean(event.Name)) == filepath.Dir(configFile)) {
+					if debounce <= 0 {
+						if !send(configFile) {
+							return
 						}
+						continue
 					}
-				case err := <-watcher.Errors:
-					logrus.Error(err)
+					pending[configFile] = time.Now().Add(debounce)
 				}
 			}
-		}()
-
-		watcher.Add(configDir)
-		<-done
+			timer.Stop()
+			schedule()
+		case <-timer.C:
+			now := time.Now()
+			for _, vaultConfigFile := range vaultConfigFiles {
+				configFile := filepath.Clean(vaultConfigFile)
+				if at, ok := pending[configFile]; ok && !at.After(now) {
+					delete(pending, configFile)
+					if !send(configFile) {
+						return
+					}
+				}
+			}
+			schedule()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.Error(err)
+		}
 	}
 }
 
 func parseConfiguration(vaultConfigFile string) *viper.Viper {
+	config, err := readConfiguration(vaultConfigFile)
+	if err != nil {
+		logrus.Fatal(err.Error())
+	}
+	return config
+}
+
+// configRenderer renders the config templates with the template data (e.g. the Vault edition)
+// and resolves their secret references, the Vault clusters of --vault-clusters have their own
+type configRenderer struct {
+	templateData interface{}
+	secrets      *configSecrets
+}
+
+// defaultRenderer renders the config templates for the Vault of VAULT_ADDR
+func defaultRenderer() configRenderer {
+	return configRenderer{templateData: configureConfig.templateData, secrets: configureConfig.secrets}
+}
+
+// readConfiguration templates, reads and validates the schema of the config file
+func readConfiguration(vaultConfigFile string) (*viper.Viper, error) {
+	return defaultRenderer().readConfiguration(vaultConfigFile)
+}
+
+func (r configRenderer) readConfiguration(vaultConfigFile string) (*viper.Viper, error) {
+	config, rendered, err := r.readUncheckedConfiguration(vaultConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sections ignore the unknown keys, so the typos of the section names and of the keys of
+	// the list items are reported before anything is applied
+	err = vault.ValidateConfigSchema(rendered)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// readUncheckedConfiguration templates and reads the config file, it returns the rendered file too
+func (r configRenderer) readUncheckedConfiguration(vaultConfigFile string) (*viper.Viper, []byte, error) {
 
 	config := viper.New()
 
+	buffer, err := r.renderConfiguration(vaultConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config.SetConfigFile(vaultConfigFile)
+
+	rendered := buffer.Bytes()
+
+	err = config.ReadConfig(buffer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading vault config file: %s", err.Error())
+	}
+
+	return config, rendered, nil
+}
+
+// renderConfiguration executes the config file as a template, the secrets it references are
+// remembered, so it can be re-rendered once they change
+func (r configRenderer) renderConfiguration(vaultConfigFile string) (*bytes.Buffer, error) {
 	templateName := filepath.Base(vaultConfigFile)
+	references := map[string]string{}
 
 	configTemplate, err := template.New(templateName).
-		Funcs(sprig.TxtFuncMap()).
+		Funcs(r.secrets.templateFuncs(references)).
 		Delims("${", "}").
 		ParseFiles(vaultConfigFile)
 
 	if err != nil {
-		logrus.Fatalf("error parsing vault config template: %s", err.Error())
+		return nil, fmt.Errorf("error parsing vault config template: %s", err.Error())
 	}
 
 	buffer := bytes.NewBuffer(nil)
 
-	err = configTemplate.ExecuteTemplate(buffer, templateName, nil)
+	err = configTemplate.ExecuteTemplate(buffer, templateName, r.templateData)
 	if err != nil {
-		logrus.Fatalf("error executing vault config template: %s", err.Error())
+		return nil, fmt.Errorf("error executing vault config template: %s", err.Error())
 	}
 
-	config.SetConfigFile(vaultConfigFile)
+	r.secrets.rendered(vaultConfigFile, references)
 
-	err = config.ReadConfig(buffer)
+	return buffer, nil
+}
+
+// explainConfigurations prints what applying every config file would do without connecting
+// to Vault, it returns false if any of them can't be read
+func explainConfigurations(vaultConfigFiles []string, out io.Writer) bool {
+	ok := true
+	for _, vaultConfigFile := range vaultConfigFiles {
+		config, err := readConfiguration(vaultConfigFile)
+		if err != nil {
+			ok = false
+			fmt.Fprintf(out, "%s: %s\n", vaultConfigFile, err.Error())
+			continue
+		}
+
+		fmt.Fprintf(out, "%s:\n", vaultConfigFile)
+		sentences := vault.Explain(config)
+		if len(sentences) == 0 {
+			fmt.Fprintln(out, "  Nothing is configured.")
+		}
+		for _, sentence := range sentences {
+			fmt.Fprintf(out, "  %s\n", sentence)
+		}
+	}
+	return ok
+}
+
+// configTemplateData returns the data of the config templates: the values file as .Values and
+// the detected Vault edition as .vault.edition, it is nil if neither of them is known
+func configTemplateData(values map[string]interface{}, edition string) interface{} {
+	if values == nil && edition == "" {
+		return nil
+	}
+	data := map[string]interface{}{}
+	if values != nil {
+		data["Values"] = values
+	}
+	if edition != "" {
+		data["vault"] = map[string]interface{}{"edition": edition}
+	}
+	return data
+}
+
+// detectVaultEdition waits until the edition of Vault can be detected, it returns false on shutdown
+func detectVaultEdition(ctx context.Context, cl *api.Client) (string, bool) {
+	for failures := 1; ; failures++ {
+		edition, err := vault.Edition(cl)
+		if err == nil {
+			logrus.Infof("detected vault edition: %s", edition)
+			return edition, true
+		}
+
+		retryAfter := retryInterval(unsealConfig.unsealRetryInitial, unsealConfig.unsealPeriod, failures, rand.Float64())
+		logrus.Errorf("error detecting the vault edition: %s, waiting %s before trying again...", err.Error(), retryAfter)
+		if !waitUnlessShutdown(ctx, retryAfter) {
+			return "", false
+		}
+	}
+}
+
+// readConfigValues reads the YAML/JSON values file of the config templates, the keys keep
+// their case (unlike with viper), so they can be referenced as ${ .Values.someKey }
+func readConfigValues(valuesFile string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(valuesFile)
 	if err != nil {
-		logrus.Fatalf("error reading vault config file: %s", err.Error())
+		return nil, fmt.Errorf("error reading vault config values file: %s", err.Error())
 	}
 
-	return config
+	values := map[interface{}]interface{}{}
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing vault config values file: %s", err.Error())
+	}
+
+	return cast.ToStringMap(stringKeys(values)), nil
+}
+
+// stringKeys converts the map[interface{}]interface{} maps of the YAML decoder to map[string]interface{}
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[cast.ToString(k)] = stringKeys(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(value))
+		for i, v := range value {
+			s[i] = stringKeys(v)
+		}
+		return s
+	default:
+		return value
+	}
+}
+
+// dryRunConfigurations reads and validates the structure of every config file without connecting
+// to Vault, it prints a report per file and returns false if any of them is invalid
+func dryRunConfigurations(vaultConfigFiles []string, out io.Writer) bool {
+	valid := true
+	for _, vaultConfigFile := range vaultConfigFiles {
+		var problems []string
+		config, rendered, err := defaultRenderer().readUncheckedConfiguration(vaultConfigFile)
+		if err != nil {
+			problems = []string{err.Error()}
+		} else {
+			problems = dryRunProblems(config, rendered)
+		}
+
+		if len(problems) == 0 {
+			fmt.Fprintf(out, "%s: OK\n", vaultConfigFile)
+			continue
+		}
+
+		valid = false
+		fmt.Fprintf(out, "%s: INVALID\n", vaultConfigFile)
+		for _, problem := range problems {
+			fmt.Fprintf(out, "  - %s\n", problem)
+		}
+	}
+	return valid
+}
+
+// dryRunProblems returns the schema problems with their lines first, then the structural problems
+// which aren't reported by the schema already (e.g. an unknown top-level key)
+func dryRunProblems(config *viper.Viper, rendered []byte) []string {
+	var problems []string
+	if schemaErr, ok := vault.ValidateConfigSchema(rendered).(*vault.SchemaError); ok {
+		problems = schemaErr.Problems
+	}
+
+	schemaProblems := problems
+	for _, problem := range vault.ValidateConfig(config) {
+		reported := false
+		for _, schemaProblem := range schemaProblems {
+			if strings.HasSuffix(schemaProblem, ": "+problem) {
+				reported = true
+				break
+			}
+		}
+		if !reported {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// parseConfigurationLiterals templates the inline YAML/JSON configurations
+// and merges them in order into one configuration
+func parseConfigurationLiterals(configLiterals []string) (*viper.Viper, error) {
+	return defaultRenderer().parseConfigurationLiterals(configLiterals)
+}
+
+func (r configRenderer) parseConfigurationLiterals(configLiterals []string) (*viper.Viper, error) {
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+
+	for i, configLiteral := range configLiterals {
+		templateName := fmt.Sprintf("%s-%d", cfgConfigLiteral, i)
+
+		configTemplate, err := template.New(templateName).
+			Funcs(r.secrets.templateFuncs(map[string]string{})).
+			Delims("${", "}").
+			Parse(configLiteral)
+
+		if err != nil {
+			return nil, fmt.Errorf("error parsing vault config literal template: %s", err.Error())
+		}
+
+		buffer := bytes.NewBuffer(nil)
+
+		err = configTemplate.Execute(buffer, r.templateData)
+		if err != nil {
+			return nil, fmt.Errorf("error executing vault config literal template: %s", err.Error())
+		}
+
+		err = vault.ValidateConfigSchema(buffer.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("error validating vault config literal: %s", err.Error())
+		}
+
+		err = config.MergeConfig(buffer)
+		if err != nil {
+			return nil, fmt.Errorf("error reading vault config literal: %s", err.Error())
+		}
+	}
+
+	return config, nil
 }
 
 func init() {
 	configureCmd.PersistentFlags().Bool(cfgOnce, false, "Run configure only once")
-	configureCmd.PersistentFlags().Duration(cfgUnsealPeriod, time.Second*30, "How often to attempt to unseal the Vault instance")
+	configureCmd.PersistentFlags().Duration(cfgUnsealPeriod, time.Second*30, "How often to attempt to unseal the Vault instance, the maximum interval of the retries while waiting for Vault")
+	configureCmd.PersistentFlags().Duration(cfgUnsealRetryInitial, time.Second, "The initial interval of the retries while waiting for Vault, doubled after every failure up to the unseal period (0 retries at the unseal period)")
 	configureCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+	configureCmd.PersistentFlags().String(cfgVaultConfigValues, "", "YAML/JSON values file passed to the config templates, its keys can be referenced as ${ .Values.key }")
+	configureCmd.PersistentFlags().String(cfgMountDescriptionTemplate, vault.DefaultMountDescriptionTemplate, "Template of the managed mount descriptions (.Description, .Path, .Type, .Marker, .Metadata), the managed marker is always appended if missing")
+	configureCmd.PersistentFlags().StringSlice(cfgManagedMetadata, nil, "Metadata stamped on the managed resources for inventory tooling as key=value (e.g. source=github.com/org/vault-config), in the mount descriptions, the metadata of the entities and groups and the custom_metadata of the KV version 2 startup secrets")
+	configureCmd.PersistentFlags().Bool(cfgActiveOnly, false, "Configure only the active Vault node, standby nodes are skipped")
+	configureCmd.PersistentFlags().Bool(cfgAllowDefaultPolicyEdit, false, "Allow overwriting the built-in default policy from the configuration")
+	configureCmd.PersistentFlags().Bool(cfgAtomicPolicies, false, "Stage the ACL policies under temporary names first and swap them in all-or-nothing, rolling back on failure")
+	configureCmd.PersistentFlags().Bool(cfgValidatePolicyTemplates, false, "Validate the ACL templating directives (e.g. {{identity.entity.metadata.team}}) of the policies before writing them, Vault accepts malformed ones silently")
+	configureCmd.PersistentFlags().Bool(cfgFailOnDeprecated, false, "Fail the configuration run if it uses deprecated auth methods or secret engines (e.g. KV version 1), known or reported by Vault")
+	configureCmd.PersistentFlags().Int(cfgConfigureRetries, 0, "How many times a failed configuration is retried in watch mode (with the backoff of waiting for Vault) before giving up on it until the next change, the writes aren't retried by --"+cfgWriteRetries+" then")
+	configureCmd.PersistentFlags().Duration(cfgSealedTimeout, 0, "With --once give up (and exit non-zero) if Vault is still sealed or unreachable after this long (0 waits forever)")
+	configureCmd.PersistentFlags().Bool(cfgPersistConfigSnapshot, false, "Write the raw template and the hash of every successfully applied config file to the kv backend, see restore-config-snapshot")
+	configureCmd.PersistentFlags().Duration(cfgRetryFailedAfter, 0, "Retry the failed sections of a configuration after this cooldown in watch mode (0 disables retries)")
+	configureCmd.PersistentFlags().String(cfgValidateInNamespace, "", "Validate the configurations once by applying them in this temporary (Enterprise) namespace, which must not exist and is deleted afterwards, instead of configuring Vault")
+	configureCmd.PersistentFlags().Duration(cfgConfigDebounce, 500*time.Millisecond, "Parse a changed config file once its file events have been quiet for this long in watch mode, to coalesce bursts of events (0 parses on every event)")
+	configureCmd.PersistentFlags().Duration(cfgWatchSeal, 0, "Poll the seal state of Vault at this interval in watch mode and re-apply the configurations right after Vault got unsealed again, e.g. after a restart (0 disables watching)")
+	configureCmd.PersistentFlags().Duration(cfgReconcileInterval, 0, "Re-apply the configurations at this interval in watch mode, even if they haven't changed, to heal out-of-band changes (0 disables reconciling)")
+	configureCmd.PersistentFlags().Int(cfgMaxIterations, 0, "Stop watching after this many configuration runs (changes, retries and reconciles) and exit (0 watches forever)")
+	configureCmd.PersistentFlags().Duration(cfgWatchUntil, 0, "Watch for configuration changes until this deadline, then apply the configurations a final time and exit, e.g. in a Job with a time budget (0 watches forever)")
+	configureCmd.PersistentFlags().StringArray(cfgVaultClusters, nil, "Apply the configurations once to this Vault cluster instead of VAULT_ADDR (name=...,address=...,token-file=...|token-env=...|role=...,kv-config=..., the token is read from the file or the environment variable), repeated for each cluster, they are configured one after the other, each with its own kv store config file")
+	configureCmd.PersistentFlags().StringArray(cfgConfigLiteral, nil, "Inline YAML/JSON Vault configuration applied once instead of the config files, multiple literals are merged in order")
+	configureCmd.PersistentFlags().String(cfgStatusConfigMap, "", "Write the result of a --once run into this ConfigMap (namespace/name)")
+	configureCmd.PersistentFlags().String(cfgStatusCRD, "", "Patch the status subresource of this custom resource (group/version/kind/namespace/name) with the result of a --once run")
+	configureCmd.PersistentFlags().Bool(cfgPruneSecrets, false, "Unmount the secret engines managed by bank-vaults which are not present in any of the config files anymore")
+	configureCmd.PersistentFlags().Bool(cfgPruneAuth, false, "Disable the auth methods managed by bank-vaults which are not present in any of the config files anymore, and delete their identity aliases")
+	configureCmd.PersistentFlags().Bool(cfgConfirmIdentityPurge, false, "Delete the entities and groups listed in the identityPurge section, without it they are only logged")
+	configureCmd.PersistentFlags().Bool(cfgEarlyAudit, false, "Apply the audit devices before the startup secrets are written, so the writes are audited, instead of after all other sections")
+	configureCmd.PersistentFlags().Bool(cfgContinueOnSectionFailure, false, "Apply the config sections after a failed one nevertheless, by default they are skipped (and retried with it), since they may depend on it")
+	configureCmd.PersistentFlags().Bool(cfgVerifyAccess, false, "Read the paths of the verifyAccess section with short-lived tokens of their policies after the startup secrets are written, failing the run if access is denied")
+	configureCmd.PersistentFlags().Bool(cfgRevokeLeasesOnDisable, false, "Revoke the leases of a secret engine or auth method (sys/leases/revoke-prefix) before it is disabled by the pruning")
+	configureCmd.PersistentFlags().Bool(cfgAutoRevokeRootToken, false, "Revoke the root token after the first successful configuration, the next runs use a periodic token of the "+vault.ConfigurerPolicyName+" policy (generated from the configuration) stored in the kv backend, see regenerate-root")
+	configureCmd.PersistentFlags().Duration(cfgConfigurerTokenPeriod, vault.DefaultConfigurerTokenPeriod, "The period of the token replacing the root token, it is renewed by every configuration run, so it should be longer than --"+cfgReconcileInterval)
+	configureCmd.PersistentFlags().String(cfgOtelEndpoint, "", "Export an OpenTelemetry trace of every configuration run to this OTLP/HTTP endpoint (e.g. http://otel-collector:4318)")
+	configureCmd.PersistentFlags().String(cfgChangelogFile, "", "Append a JSONL entry per configuration run to this file, listing the touched Vault resources with the hashes of their state before and after")
+	configureCmd.PersistentFlags().Bool(cfgConsistentReads, false, "Send the replication state (X-Vault-Index) of the last write with the subsequent requests, so performance standbys only serve them once they caught up")
+	configureCmd.PersistentFlags().Bool(cfgForwardInconsistent, false, "Forward the requests a performance standby can't serve consistently to the active node (implies --"+cfgConsistentReads+")")
+	configureCmd.PersistentFlags().String(cfgAdminAddress, "", "Serve the admin API on this address in watch mode, POST /apply applies the configurations immediately and returns the results, disabled if empty")
+	configureCmd.PersistentFlags().String(cfgAdminToken, "", "The bearer token the requests of the admin API have to authenticate with (can be set as BANK_VAULTS_ADMIN_TOKEN)")
+	configureCmd.PersistentFlags().String(cfgChangelogOperator, "", "The operator recorded in the changelog entries (defaults to the hostname)")
+	configureCmd.PersistentFlags().Bool(cfgChangeLog, false, "Write a JSON changelog entry per configuration run to stdout (and to --"+cfgChangelogFile+" if set), with the timestamp and the diff of the written fields of every change, the sensitive values redacted")
+	configureCmd.PersistentFlags().Bool(cfgChangeLogEvents, false, "With --"+cfgChangeLog+", record a Kubernetes Event on the pod of the configurer (POD_NAME in POD_NAMESPACE) for every configuration run which changed something or failed")
+	addMetricsAddressFlags(configureCmd.PersistentFlags(), "", "Serve the Prometheus metrics of the configuration runs, and the /healthz (the kv backend is reachable) and /readyz (Vault is unsealed and the last configuration of every config file succeeded) probes on this address (e.g. :9091, alias --metrics-listen-address), disabled if empty")
+	configureCmd.PersistentFlags().Duration(cfgShutdownGracePeriod, 30*time.Second, "How long the configuration in flight may take to finish after SIGINT or SIGTERM in watch mode")
+	configureCmd.PersistentFlags().Bool(cfgDryRun, false, "Only read and validate the structure of the config files and print a report, without connecting to Vault (unless --"+cfgDetectChanges+" is set), exit non-zero if any of them is invalid")
+	configureCmd.PersistentFlags().Bool(cfgDetectChanges, false, fmt.Sprintf("With --%s, compare the valid config files with Vault too and print the plan of the policies, secret engines, auth methods and audit devices which would be created, updated or tuned (the other sections are listed as not compared), exit 0 without changes, %d with changes and %d if only the sections which aren't compared could change", cfgDryRun, exitCodeChanges, exitCodeUnknown))
+	configureCmd.PersistentFlags().Bool(cfgExplain, false, "Only print in plain English what applying the config files would do, per section, without connecting to Vault")
+	configureCmd.PersistentFlags().Float64(cfgConfigureRateLimit, 0, "Maximum number of Vault API calls per second while configuring (0 means unlimited)")
+	configureCmd.PersistentFlags().Int(cfgSectionBreakerThreshold, 0, "Skip a config section for --"+cfgSectionBreakerCooldown+" after this many consecutive failures, so it doesn't use up the retries of the other sections (0 disables the circuit breakers)")
+	configureCmd.PersistentFlags().Duration(cfgSectionBreakerCooldown, 5*time.Minute, "How long a config section is skipped once its circuit breaker opened, then it is tried again")
+	configureCmd.PersistentFlags().Int(cfgConfigureConcurrency, 1, "How many policies and auth method roles are written concurrently, the failed ones don't stop the others")
+	configureCmd.PersistentFlags().Int(cfgWriteRetries, 3, "How many times the idempotent writes (policies and auth method roles) failing with a server or connection error are retried, with exponential backoff (the Vault client doesn't retry them itself), not retried with --"+cfgConfigureRetries+" unless it is set")
+	configureCmd.PersistentFlags().Duration(cfgWriteRetryInterval, vault.DefaultWriteRetryInterval, "The wait before the first retry of a failed idempotent write, doubled after every retry")
+	configureCmd.PersistentFlags().Bool(cfgLeaderElection, false, "Elect a leader through a Kubernetes Lease in watch mode, only the leader configures Vault while the other replicas stand by to take over")
+	configureCmd.PersistentFlags().String(cfgLeaseName, "bank-vaults-configurer", "The name of the Lease of --"+cfgLeaderElection)
+	configureCmd.PersistentFlags().String(cfgLeaseNamespace, "", "The namespace of the Lease of --"+cfgLeaderElection+" (defaults to the namespace of the pod)")
+	configureCmd.PersistentFlags().Duration(cfgLeaseDuration, 15*time.Second, "How long the standby replicas wait before taking over the Lease of a leader which stopped renewing it")
+	configureCmd.PersistentFlags().Duration(cfgLeaseRenewDeadline, 10*time.Second, "How long the leader retries renewing the Lease before it gives up the leadership and exits")
+	configureCmd.PersistentFlags().Duration(cfgLeaseRetryPeriod, 2*time.Second, "How often the Lease is tried to be acquired or renewed")
+	configureCmd.PersistentFlags().Duration(cfgConfigSecretsPollInterval, time.Minute, "How often the Kubernetes Secrets and Vault secrets referenced by the secret and vault functions of the config templates are checked for changes in watch mode, the config files are re-rendered when they changed (0 disables it)")
+	configureCmd.PersistentFlags().String(cfgVaultConfigGit, "", "Apply the YAML/JSON config files in --"+cfgGitPath+" of a shallow clone of this Git repository instead of --"+cfgVaultConfigFile+", and re-apply them on the new commits of --"+cfgGitRef+" in watch mode (requires git 2.31 or newer, and ssh for SSH repositories)")
+	configureCmd.PersistentFlags().String(cfgGitRef, "master", "The branch (or tag) of --"+cfgVaultConfigGit+" to apply")
+	configureCmd.PersistentFlags().String(cfgGitPath, ".", "The directory (or the file) of the config files in --"+cfgVaultConfigGit)
+	configureCmd.PersistentFlags().Duration(cfgGitPollInterval, time.Minute, "How often --"+cfgGitRef+" is polled for new commits in watch mode")
+	configureCmd.PersistentFlags().String(cfgGitToken, "", "The token of a private HTTPS --"+cfgVaultConfigGit+" repository (can be set as BANK_VAULTS_GIT_TOKEN)")
+	configureCmd.PersistentFlags().String(cfgGitSSHKey, "", "The private key file of a private SSH --"+cfgVaultConfigGit+" repository")
 
 	rootCmd.AddCommand(configureCmd)
 }
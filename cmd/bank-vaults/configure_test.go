@@ -0,0 +1,1077 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+)
+
+// mockVault is a vault.Vault which records the Configure calls,
+// the sections in failSections fail that many times
+type mockVault struct {
+	sync.Mutex
+	sealed       bool
+	active       bool
+	configureErr error
+	failSections map[string]int
+	drift        vault.Drift
+	plan         []vault.PlannedChange
+	mountChecks  []vault.MountCheck
+	configured   []*viper.Viper
+	sections     [][]string
+
+	// how long a configuration run takes
+	configureDelay time.Duration
+	// how many times it has been checked if Vault is sealed
+	sealedChecks int
+	// how many times the database root credentials have been rotated
+	rootRotations int
+	// the error of unsealing, a successful unseal unseals the mock
+	unsealErr error
+	// the data of the secrets returned by ReadSecret by path
+	secrets map[string]map[string]interface{}
+	// the servers of the raft cluster and the ones removed from it
+	raftPeers    []vault.RaftPeer
+	removedPeers []string
+}
+
+var _ vault.Vault = &mockVault{}
+
+func (m *mockVault) Init() error { return nil }
+
+func (m *mockVault) Sealed() (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.sealedChecks++
+	return m.sealed, nil
+}
+
+func (m *mockVault) Active() (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.active, nil
+}
+
+func (m *mockVault) Unseal() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.unsealErr != nil {
+		return m.unsealErr
+	}
+	m.sealed = false
+	return nil
+}
+
+func (m *mockVault) Leader() (bool, error) { return m.Active() }
+
+func (m *mockVault) Configure(config *viper.Viper) error {
+	return m.ConfigureSections(config, nil)
+}
+
+func (m *mockVault) ConfigureSections(config *viper.Viper, sections []string) error {
+	time.Sleep(m.configureDelay)
+
+	m.Lock()
+	defer m.Unlock()
+	m.configured = append(m.configured, config)
+	m.sections = append(m.sections, sections)
+
+	if m.configureErr != nil {
+		return m.configureErr
+	}
+
+	if sections == nil {
+		sections = []string{"auth", "policies", "secrets"}
+	}
+	configErr := &vault.ConfigurationError{Errors: map[string]error{}}
+	for _, section := range sections {
+		if m.failSections[section] > 0 {
+			m.failSections[section]--
+			configErr.FailedSections = append(configErr.FailedSections, section)
+			configErr.Errors[section] = fmt.Errorf("error configuring %s", section)
+		}
+	}
+	if len(configErr.FailedSections) > 0 {
+		return configErr
+	}
+	return nil
+}
+
+func (m *mockVault) StepDownActive(string) error { return nil }
+
+func (m *mockVault) RewrapSealWrap(time.Duration) error { return nil }
+
+func (m *mockVault) MigrateSeal(string) error { return nil }
+
+func (m *mockVault) RotateDatabaseStaticRoles(*viper.Viper) ([]vault.StaticRoleRotation, error) {
+	return nil, nil
+}
+
+func (m *mockVault) RotateDatabaseRoots(*viper.Viper, []string) ([]vault.RootRotation, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.rootRotations++
+	return nil, nil
+}
+
+func (m *mockVault) DetectDrift(*viper.Viper) (vault.Drift, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.drift, nil
+}
+
+func (m *mockVault) Plan(*viper.Viper) ([]vault.PlannedChange, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.plan, nil
+}
+
+func (m *mockVault) CheckMounts(*viper.Viper) ([]vault.MountCheck, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.mountChecks, nil
+}
+
+func (m *mockVault) ValidateInNamespace(config *viper.Viper, namespace string) error {
+	return m.ConfigureSections(config, nil)
+}
+
+func (m *mockVault) RegenerateRoot(bool) (string, error) { return "", nil }
+
+func (m *mockVault) ReadSecret(path string) (map[string]interface{}, error) {
+	m.Lock()
+	defer m.Unlock()
+	data, ok := m.secrets[path]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", path)
+	}
+	return data, nil
+}
+
+func (m *mockVault) RaftJoin(string) error { return nil }
+
+func (m *mockVault) RaftPeers() ([]vault.RaftPeer, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.raftPeers, nil
+}
+
+func (m *mockVault) RaftRemovePeer(nodeID string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.removedPeers = append(m.removedPeers, nodeID)
+	return nil
+}
+
+func (m *mockVault) RaftSnapshot(w io.Writer) error {
+	_, err := w.Write([]byte("snapshot"))
+	return err
+}
+
+func (m *mockVault) configureCalls() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.configured)
+}
+
+func TestConfigureVaultActiveOnly(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.activeOnly = true
+	defer func() { configureConfig.activeOnly = false }()
+
+	standby := &mockVault{active: false}
+	configureVault(context.Background(), standby, viper.New(), nil, true)
+	if standby.configureCalls() != 0 {
+		t.Fatal("standby node shouldn't be configured")
+	}
+
+	active := &mockVault{active: true}
+	configureVault(context.Background(), active, viper.New(), nil, true)
+	if active.configureCalls() != 1 {
+		t.Fatal("active node should be configured")
+	}
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyConfigurationsSkipsUnchanged(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	configurations := make(chan *viper.Viper, 3)
+
+	writeConfigFile(t, configFile, "policies:\n  - name: allow_secrets\n    rules: path \"secret/*\" {}\n")
+	configurations <- parseConfiguration(configFile)
+
+	writeConfigFile(t, configFile, "# only a comment and whitespace changed\npolicies:\n\n  -   name: allow_secrets\n      rules: path \"secret/*\" {}\n")
+	configurations <- parseConfiguration(configFile)
+
+	writeConfigFile(t, configFile, "policies:\n  - name: deny_secrets\n    rules: path \"secret/*\" {}\n")
+	configurations <- parseConfiguration(configFile)
+
+	close(configurations)
+
+	v := &mockVault{active: true}
+	applyConfigurations(context.Background(), v, configurations, false)
+
+	if v.configureCalls() != 2 {
+		t.Fatalf("expected 2 configure calls (whitespace change skipped), got %d", v.configureCalls())
+	}
+}
+
+func TestConfigLiteral(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+
+	args := []string{
+		"--" + cfgConfigLiteral, `policies: [{name: allow_secrets, rules: 'path "secret/*" { capabilities = ["read"] }'}]`,
+		"--" + cfgConfigLiteral, "auth:\n  - type: ${ \"approle\" }\n",
+	}
+	flags := configureCmd.PersistentFlags()
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	configLiterals, err := flags.GetStringArray(cfgConfigLiteral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := parseConfigurationLiterals(configLiterals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- config
+	close(configurations)
+
+	v := &mockVault{active: true}
+	applyConfigurations(context.Background(), v, configurations, true)
+
+	if v.configureCalls() != 1 {
+		t.Fatalf("expected 1 configure call, got %d", v.configureCalls())
+	}
+
+	config = v.configured[0]
+	policies := []map[string]interface{}{}
+	if err := config.UnmarshalKey("policies", &policies); err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0]["name"] != "allow_secrets" || policies[0]["rules"] != `path "secret/*" { capabilities = ["read"] }` {
+		t.Fatalf("unexpected inline policies: %v", policies)
+	}
+
+	auth := []map[string]interface{}{}
+	if err := config.UnmarshalKey("auth", &auth); err != nil {
+		t.Fatal(err)
+	}
+	if len(auth) != 1 || auth[0]["type"] != "approle" {
+		t.Fatalf("expected the templated literals to be merged: %v", auth)
+	}
+
+	if _, err := parseConfigurationLiterals([]string{"auth: ${ unknown }"}); err == nil {
+		t.Fatal("expected an error for an invalid literal template")
+	}
+}
+
+func TestApplyConfigurationsRetriesFailedSections(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.retryFailedAfter = 10 * time.Millisecond
+	defer func() { configureConfig.retryFailedAfter = 0 }()
+
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+
+	v := &mockVault{active: true, failSections: map[string]int{"auth": 1}}
+
+	done := make(chan []configurationResult)
+	go func() { done <- applyConfigurations(context.Background(), v, configurations, false) }()
+
+	for deadline := time.Now().Add(5 * time.Second); v.configureCalls() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed sections to be retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// wait for another cooldown to make sure nothing else is retried
+	time.Sleep(30 * time.Millisecond)
+	close(configurations)
+	results := <-done
+
+	v.Lock()
+	defer v.Unlock()
+	if len(v.sections) != 2 {
+		t.Fatalf("expected 2 configure calls, got %d", len(v.sections))
+	}
+	if v.sections[0] != nil {
+		t.Fatalf("expected the first run to apply every section, got %v", v.sections[0])
+	}
+	if len(v.sections[1]) != 1 || v.sections[1][0] != "auth" {
+		t.Fatalf("expected only the failed auth section to be retried, got %v", v.sections[1])
+	}
+	if len(results) != 1 || !results[0].applied || results[0].err != nil {
+		t.Fatalf("expected the retry to succeed: %+v", results)
+	}
+}
+
+func TestApplyConfigurationsReconcilesOnInterval(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.reconcileInterval = 10 * time.Millisecond
+	defer func() { configureConfig.reconcileInterval = 0 }()
+
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+
+	v := &mockVault{active: true}
+
+	done := make(chan []configurationResult)
+	go func() { done <- applyConfigurations(context.Background(), v, configurations, false) }()
+
+	// the config file doesn't change, the reconciles are fired by the timer
+	for deadline := time.Now().Add(5 * time.Second); v.configureCalls() < 3; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the unchanged configuration to be reconciled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(configurations)
+	<-done
+
+	v.Lock()
+	defer v.Unlock()
+	for i, sections := range v.sections {
+		if sections != nil {
+			t.Fatalf("expected run %d to apply every section, got %v", i, sections)
+		}
+	}
+}
+
+func TestApplyConfigurationsMaxIterations(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.reconcileInterval = time.Millisecond
+	configureConfig.maxIterations = 3
+	defer func() {
+		configureConfig.reconcileInterval = 0
+		configureConfig.maxIterations = 0
+	}()
+
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+
+	v := &mockVault{active: true}
+
+	// the configurations channel is left open, the loop exits on its own
+	done := make(chan []configurationResult)
+	go func() { done <- applyConfigurations(context.Background(), v, configurations, false) }()
+
+	select {
+	case results := <-done:
+		if len(results) != 1 || !results[0].applied {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the watch loop to stop after the maximum number of iterations")
+	}
+
+	if calls := v.configureCalls(); calls != 3 {
+		t.Fatalf("expected 3 configuration runs, got %d", calls)
+	}
+}
+
+func TestApplyConfigurationsWatchUntil(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.watchUntil = 50 * time.Millisecond
+	defer func() { configureConfig.watchUntil = 0 }()
+
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+
+	v := &mockVault{active: true}
+
+	// the configurations channel is left open, the loop exits at the deadline
+	start := time.Now()
+	done := make(chan []configurationResult)
+	go func() { done <- applyConfigurations(context.Background(), v, configurations, false) }()
+
+	select {
+	case results := <-done:
+		if len(results) != 1 || !results[0].applied || configurationsFailed(results) {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the watch loop to stop at the deadline")
+	}
+
+	if elapsed := time.Since(start); elapsed < configureConfig.watchUntil {
+		t.Fatalf("expected the watch loop to run until the deadline, it stopped after %s", elapsed)
+	}
+	if calls := v.configureCalls(); calls != 2 {
+		t.Fatalf("expected the initial and the final configuration runs, got %d", calls)
+	}
+}
+
+func TestApplyConfigurationsOnUnseal(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.unsealTransitions = make(chan struct{})
+	defer func() { configureConfig.unsealTransitions = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+
+	v := &mockVault{active: true}
+
+	done := make(chan []configurationResult)
+	go func() { done <- applyConfigurations(ctx, v, configurations, false) }()
+	go watchSeal(ctx, v, time.Millisecond, configureConfig.unsealTransitions)
+
+	waitFor := func(what string, condition func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !condition() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitFor("the first configuration run", func() bool { return v.configureCalls() == 1 })
+
+	// vault restarts sealed, the configuration isn't applied until it is unsealed again
+	v.Lock()
+	v.sealed = true
+	checks := v.sealedChecks
+	v.Unlock()
+	waitFor("the sealed state to be observed", func() bool {
+		v.Lock()
+		defer v.Unlock()
+		return v.sealedChecks > checks+1
+	})
+	if calls := v.configureCalls(); calls != 1 {
+		t.Fatalf("expected no configuration run while vault is sealed, got %d", calls)
+	}
+
+	v.Lock()
+	v.sealed = false
+	v.Unlock()
+	waitFor("the configuration run after the unseal", func() bool { return v.configureCalls() == 2 })
+
+	// staying unsealed doesn't trigger more runs
+	time.Sleep(20 * time.Millisecond)
+	if calls := v.configureCalls(); calls != 2 {
+		t.Fatalf("expected a single configuration run after the unseal, got %d", calls)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestValidateConfigurations(t *testing.T) {
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+	close(configurations)
+
+	v := &mockVault{failSections: map[string]int{"policies": 1}}
+	if validateConfigurations(v, configurations, "scratch") {
+		t.Fatal("expected the configuration with a failing section to be invalid")
+	}
+
+	configurations = make(chan *viper.Viper, 1)
+	configurations <- viper.New()
+	close(configurations)
+
+	if !validateConfigurations(v, configurations, "scratch") {
+		t.Fatal("expected the configuration to be valid")
+	}
+	if v.configureCalls() != 2 {
+		t.Fatalf("expected every configuration to be validated, got %d", v.configureCalls())
+	}
+}
+
+func TestWatchConfigurationsWatchesEveryConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// in separate directories, as the directories are watched
+	var configFiles []string
+	for _, name := range []string{"first", "second"} {
+		configDir := filepath.Join(dir, name)
+		if err := os.Mkdir(configDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		configFile := filepath.Join(configDir, "vault-config.yml")
+		if err := ioutil.WriteFile(configFile, []byte("policies: []\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		configFiles = append(configFiles, configFile)
+	}
+
+	watcher, err := newConfigWatcher(configFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	configurations := make(chan *viper.Viper, 10)
+	go watchConfigEvents(context.Background(), watcher, configFiles, 0, configurations)
+
+	err = ioutil.WriteFile(configFiles[1], []byte("policies:\n  - name: changed\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case config := <-configurations:
+		if config.ConfigFileUsed() != configFiles[1] {
+			t.Fatalf("expected the second config file to be parsed, got %s", config.ConfigFileUsed())
+		}
+		if policies := config.Get("policies"); fmt.Sprint(policies) != "[map[name:changed]]" {
+			t.Fatalf("expected the changed configuration, got %v", policies)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change of the second config file wasn't picked up")
+	}
+}
+
+func TestApplyConfigurationsShutdown(t *testing.T) {
+	v := &mockVault{configureDelay: 100 * time.Millisecond}
+
+	configurations := make(chan *viper.Viper, 2)
+	for i := 0; i < 2; i++ {
+		config := viper.New()
+		config.SetConfigFile(fmt.Sprintf("vault-config-%d.yml", i))
+		config.Set("policies", []interface{}{map[string]interface{}{"name": fmt.Sprint(i)}})
+		configurations <- config
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var results []configurationResult
+	finished := make(chan bool)
+	go func() {
+		finished <- runUntilShutdown(ctx, time.Second, func() {
+			results = applyConfigurations(ctx, v, configurations, false)
+		})
+	}()
+
+	// cancelled while the first configuration is in flight
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	select {
+	case ok := <-finished:
+		if !ok {
+			t.Fatal("the configuration in flight should finish within the grace period")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the loop didn't return after the shutdown")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the loop should return within the grace period, took %s", elapsed)
+	}
+
+	if calls := v.configureCalls(); calls != 1 {
+		t.Fatalf("expected only the run in flight to finish, got %d runs", calls)
+	}
+	if len(results) != 1 || !results[0].applied {
+		t.Fatalf("expected the run in flight to be applied, got %+v", results)
+	}
+}
+
+func TestRunUntilShutdownGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	start := time.Now()
+	if runUntilShutdown(ctx, 50*time.Millisecond, func() { <-release }) {
+		t.Fatal("a run exceeding the grace period shouldn't be reported as finished")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up after the grace period, took %s", elapsed)
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	var intervals []time.Duration
+	for failures := 1; failures <= 7; failures++ {
+		intervals = append(intervals, retryInterval(time.Second, 30*time.Second, failures, 0))
+	}
+	expected := []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second,
+	}
+	if fmt.Sprint(intervals) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected retry intervals: %v", intervals)
+	}
+
+	// the jitter only shortens the interval, by at most 20%
+	if interval := retryInterval(time.Second, 30*time.Second, 3, 0.5); interval != 3600*time.Millisecond {
+		t.Fatalf("unexpected jittered interval: %s", interval)
+	}
+	if interval := retryInterval(time.Second, 30*time.Second, 100, 0.999); interval < 24*time.Second || interval > 30*time.Second {
+		t.Fatalf("the jittered interval should stay between 80%% and 100%% of the cap: %s", interval)
+	}
+
+	// without an initial interval the retries are at the unseal period
+	if interval := retryInterval(0, 30*time.Second, 1, 0.5); interval != 30*time.Second {
+		t.Fatalf("expected the unseal period without an initial interval, got %s", interval)
+	}
+}
+
+func TestDryRunConfigurations(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"valid.yml": `
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+secrets:
+  - type: kv
+    path: secret
+`,
+		"broken-template.yml": `
+secrets:
+  - type: ${ .Missing
+`,
+		"unknown-key.yml": `
+secret:
+  - type: kv
+auth:
+  - path: kubernetes
+`,
+	}
+	var configFiles []string
+	for _, name := range []string{"valid.yml", "broken-template.yml", "unknown-key.yml"} {
+		configFile := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(configFile, []byte(files[name]), 0600); err != nil {
+			t.Fatal(err)
+		}
+		configFiles = append(configFiles, configFile)
+	}
+
+	var out bytes.Buffer
+	if dryRunConfigurations(configFiles, &out) {
+		t.Fatal("expected the dry run to fail with invalid config files")
+	}
+
+	report := out.String()
+	for _, expected := range []string{
+		configFiles[0] + ": OK",
+		configFiles[1] + ": INVALID",
+		"error parsing vault config template",
+		configFiles[2] + ": INVALID",
+		"line 2: unknown top-level key 'secret'",
+		"auth[0] has to set type",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected %q in the report:\n%s", expected, report)
+		}
+	}
+	if strings.Count(report, "unknown top-level key 'secret'") != 1 {
+		t.Errorf("expected the unknown top-level key to be reported once:\n%s", report)
+	}
+
+	out.Reset()
+	if !dryRunConfigurations(configFiles[:1], &out) {
+		t.Errorf("expected the dry run to pass with a valid config file:\n%s", out.String())
+	}
+}
+
+func TestParseConfigurationWithValues(t *testing.T) {
+	dir := t.TempDir()
+	valuesFile := filepath.Join(dir, "values.yml")
+	err := ioutil.WriteFile(valuesFile, []byte("region: eu-west-1\nkmsKey:\n  alias: vault-unseal\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "vault-config.yml")
+	err = ioutil.WriteFile(configFile, []byte(`
+secrets:
+  - type: kv
+    path: ${ .Values.region }/secret
+    description: ${ .Values.kmsKey.alias } in ${ env "TEST_VAULT_CONFIG_ENV" }
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_VAULT_CONFIG_ENV", "production")
+	defer os.Unsetenv("TEST_VAULT_CONFIG_ENV")
+
+	values, err := readConfigValues(valuesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configureConfig.templateData = map[string]interface{}{"Values": values}
+	defer func() { configureConfig.templateData = nil }()
+
+	config := parseConfiguration(configFile)
+	secrets := []map[string]interface{}{}
+	if err := config.UnmarshalKey("secrets", &secrets); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || secrets[0]["path"] != "eu-west-1/secret" || secrets[0]["description"] != "vault-unseal in production" {
+		t.Fatalf("expected the values and the env in the rendered config: %v", secrets)
+	}
+}
+
+func TestParseConfigurationWithEdition(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "vault-config.yml")
+	err := ioutil.WriteFile(configFile, []byte(`
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+${ if eq .vault.edition "enterprise" }
+  - name: allow_namespaces
+    type: egp
+    enforcement_level: soft-mandatory
+    paths: ["*"]
+    rules: main = rule { true }
+${ end }
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { configureConfig.templateData = nil }()
+
+	for edition, expected := range map[string]int{vault.EditionCE: 1, vault.EditionEnterprise: 2} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			version := "1.15.0"
+			if edition == vault.EditionEnterprise {
+				version += "+ent"
+			}
+			fmt.Fprintf(w, `{"initialized": true, "sealed": false, "version": %q}`, version)
+		}))
+
+		clientConfig := api.DefaultConfig()
+		clientConfig.Address = server.URL
+		cl, err := api.NewClient(clientConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		detected, ok := detectVaultEdition(context.Background(), cl)
+		server.Close()
+		if !ok || detected != edition {
+			t.Fatalf("expected the %s edition to be detected, got %s", edition, detected)
+		}
+
+		configureConfig.templateData = configTemplateData(nil, detected)
+		config := parseConfiguration(configFile)
+		policies := []map[string]interface{}{}
+		if err := config.UnmarshalKey("policies", &policies); err != nil {
+			t.Fatal(err)
+		}
+		if len(policies) != expected {
+			t.Errorf("expected %d policies with the %s edition, got %v", expected, edition, policies)
+		}
+	}
+}
+
+func TestConfigureVaultRetriesFailures(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.configureRetries = 3
+	defer func() { configureConfig.configureRetries = 0 }()
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	v := &mockVault{active: true, failSections: map[string]int{"secrets": 2}}
+	ok, err := configureVault(context.Background(), v, viper.New(), nil, false)
+	if !ok || err != nil {
+		t.Fatalf("expected the configuration to succeed on retry: %v", err)
+	}
+	if v.configureCalls() != 3 {
+		t.Fatalf("expected 3 configure calls, got %d", v.configureCalls())
+	}
+	if v.sealedChecks != 3 {
+		t.Errorf("expected vault to be checked before every attempt, got %d checks", v.sealedChecks)
+	}
+	if strings.Join(v.sections[1], ",") != "secrets" || strings.Join(v.sections[2], ",") != "secrets" {
+		t.Errorf("expected only the failed sections to be retried, got %v", v.sections)
+	}
+
+	successes := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.InfoLevel && entry.Message == "successfully configured vault" {
+			successes++
+		}
+	}
+	if successes != 1 {
+		t.Errorf("expected the success to be logged once, got %d", successes)
+	}
+
+	// the retries are used up
+	v = &mockVault{active: true, failSections: map[string]int{"secrets": 10}}
+	ok, err = configureVault(context.Background(), v, viper.New(), nil, false)
+	if ok || err == nil {
+		t.Fatal("expected the configuration to fail after the retries")
+	}
+	if v.configureCalls() != 4 {
+		t.Errorf("expected 1 configure call and 3 retries, got %d", v.configureCalls())
+	}
+
+	// a single run isn't retried
+	v = &mockVault{active: true, failSections: map[string]int{"secrets": 1}}
+	if ok, _ := configureVault(context.Background(), v, viper.New(), nil, true); ok {
+		t.Fatal("expected the single run to fail")
+	}
+	if v.configureCalls() != 1 {
+		t.Errorf("expected 1 configure call with --once, got %d", v.configureCalls())
+	}
+}
+
+func TestConfigurationsFailed(t *testing.T) {
+	results := []configurationResult{
+		{configFile: "a.yml", applied: true},
+		{configFile: "b.yml", applied: false},
+	}
+	if configurationsFailed(results) {
+		t.Error("skipping the configuration on a standby node is not a failure")
+	}
+	results = append(results, configurationResult{configFile: "c.yml", err: fmt.Errorf("error configuring vault")})
+	if !configurationsFailed(results) {
+		t.Error("expected the failed configuration to be reported")
+	}
+}
+
+func TestConfigureVaultSealedTimeout(t *testing.T) {
+	unsealConfig.unsealPeriod = time.Millisecond
+	configureConfig.sealedTimeout = 10 * time.Millisecond
+	defer func() { configureConfig.sealedTimeout = 0 }()
+
+	v := &mockVault{sealed: true}
+	ok, err := configureVault(context.Background(), v, viper.New(), nil, true)
+	if ok || err == nil {
+		t.Fatal("expected the single run to give up on the sealed vault")
+	}
+	if v.configureCalls() != 0 {
+		t.Errorf("the sealed vault should not be configured, got %d calls", v.configureCalls())
+	}
+}
+
+// TestConfigureOnceExitCode runs the configure command against a Vault failing every configuration
+// request in a subprocess, since the failed single run exits the process
+func TestConfigureOnceExitCode(t *testing.T) {
+	if os.Getenv("TEST_CONFIGURE_ONCE_ARGS") != "" {
+		rootCmd.SetArgs(strings.Split(os.Getenv("TEST_CONFIGURE_ONCE_ARGS"), " "))
+		execute()
+		os.Exit(0)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/sys/health", "/v1/sys/seal-status":
+			fmt.Fprint(w, `{"initialized": true, "sealed": false, "version": "1.15.0"}`)
+		default:
+			// not a 5xx, so the client doesn't retry
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors": ["invalid request"]}`)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "vault-root"), []byte("root"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "vault-config.yml")
+	err := ioutil.WriteFile(configFile, []byte(`
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"configure", "--once", "--mode", "file", "--file-path", dir, "--vault-config-file", configFile}
+	cmd := exec.Command(os.Args[0], "-test.run", "^TestConfigureOnceExitCode$")
+	cmd.Env = append(os.Environ(), "TEST_CONFIGURE_ONCE_ARGS="+strings.Join(args, " "), "VAULT_ADDR="+server.URL)
+	output, err := cmd.CombinedOutput()
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.Success() {
+		t.Fatalf("expected a non-zero exit code of the failed single run, got %v:\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "error configuring vault") {
+		t.Errorf("expected the configuration error in the output:\n%s", output)
+	}
+}
+
+func TestWatchConfigEventsDebounce(t *testing.T) {
+	dir := t.TempDir()
+	var configFiles []string
+	for _, name := range []string{"first.yml", "second.yml"} {
+		configFile := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(configFile, []byte("policies: []\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		configFiles = append(configFiles, configFile)
+	}
+
+	watcher, err := newConfigWatcher(configFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	configurations := make(chan *viper.Viper, 10)
+	go watchConfigEvents(ctx, watcher, configFiles, 200*time.Millisecond, configurations)
+
+	for i := 0; i < 3; i++ {
+		err := ioutil.WriteFile(configFiles[0], []byte(fmt.Sprintf("policies:\n  - name: changed-%d\n", i)), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := ioutil.WriteFile(configFiles[1], []byte("policies:\n  - name: other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second)
+
+	parsed := map[string]int{}
+	for drained := false; !drained; {
+		select {
+		case config := <-configurations:
+			parsed[config.ConfigFileUsed()]++
+			if config.ConfigFileUsed() == configFiles[0] {
+				if policies := config.Get("policies"); fmt.Sprint(policies) != "[map[name:changed-2]]" {
+					t.Errorf("expected the last change, got %v", policies)
+				}
+			}
+		default:
+			drained = true
+		}
+	}
+	if parsed[configFiles[0]] != 1 || parsed[configFiles[1]] != 1 {
+		t.Errorf("expected one configuration per changed file, got %v", parsed)
+	}
+}
+
+func TestExplainConfigurations(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "vault-config.yml")
+	err := ioutil.WriteFile(configFile, []byte(`
+auth:
+  - type: kubernetes
+    roles:
+      - name: default
+      - name: ci
+policies:
+  - name: allow_secrets
+    rules: path "secret/*" { capabilities = ["read"] }
+secrets:
+  - path: secret
+    type: kv
+    options:
+      version: 2
+    limits:
+      max_versions: 10
+  - type: pki
+audit:
+  - type: file
+    options:
+      file_path: /tmp/vault.log
+startupSecrets:
+  - type: kv
+    path: secret/data/accounts/aws
+    data:
+      data:
+        AWS_SECRET_ACCESS_KEY: s3cr3t
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if !explainConfigurations([]string{configFile}, &out) {
+		t.Fatalf("expected the config file to be explained:\n%s", out.String())
+	}
+
+	expected := configFile + `:
+  auth: Enables the kubernetes auth method at path kubernetes/ with the roles default, ci
+  policies: Writes the ACL policy allow_secrets
+  secrets: Enables KV v2 at path secret/ with max 10 versions
+  secrets: Enables the pki secret engine at path pki/
+  startupSecrets: Writes the kv secret secret/data/accounts/aws
+  audit: Enables the file audit device at path file/
+`
+	if out.String() != expected {
+		t.Errorf("expected the explanation:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestExplainEmptyIdentityPurge(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "vault-config.yml")
+	err := ioutil.WriteFile(configFile, []byte(`
+identityPurge:
+  entities: []
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if !explainConfigurations([]string{configFile}, &out) {
+		t.Fatalf("expected the config file to be explained:\n%s", out.String())
+	}
+	expected := configFile + `:
+  identityPurge: Deletes nothing, no entity or group is listed
+`
+	if out.String() != expected {
+		t.Errorf("expected the explanation:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// The headers of Vault's server side consistency, the write responses return the replication state
+// in X-Vault-Index, requests sending it back are only served by nodes which have caught up with it
+const (
+	vaultIndexHeader        = "X-Vault-Index"
+	vaultInconsistentHeader = "X-Vault-Inconsistent"
+	forwardActiveNode       = "forward-active-node"
+)
+
+// consistencyTransport requires the replication state of the last write on the subsequent requests
+// (as an http.RoundTripper), so reads served by performance standbys see the writes of the run. The
+// states are kept per Vault address. With forwardActive the requests a standby can't serve
+// consistently are forwarded to the active node instead of failing.
+type consistencyTransport struct {
+	sync.Mutex
+	next          http.RoundTripper
+	forwardActive bool
+	states        map[string]string
+}
+
+func newConsistencyTransport(forwardActive bool, next http.RoundTripper) *consistencyTransport {
+	return &consistencyTransport{next: next, forwardActive: forwardActive, states: map[string]string{}}
+}
+
+func (t *consistencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	state := t.states[req.URL.Host]
+	t.Unlock()
+
+	if state != "" && req.Header.Get(vaultIndexHeader) == "" {
+		// the request may not be modified, so it is cloned with the headers
+		req = req.Clone(req.Context())
+		req.Header.Set(vaultIndexHeader, state)
+		if t.forwardActive {
+			req.Header.Set(vaultInconsistentHeader, forwardActiveNode)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if index := resp.Header.Get(vaultIndexHeader); index != "" {
+		t.Lock()
+		t.states[req.URL.Host] = index
+		t.Unlock()
+	}
+	return resp, nil
+}
@@ -0,0 +1,74 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestConsistencyTransport(t *testing.T) {
+	var lock sync.Mutex
+	var indexes, inconsistent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		indexes = append(indexes, r.Header.Get(vaultIndexHeader))
+		inconsistent = append(inconsistent, r.Header.Get(vaultInconsistentHeader))
+		lock.Unlock()
+
+		if r.Method == http.MethodPut {
+			w.Header().Set(vaultIndexHeader, "state-after-write")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"value": "written"}}`))
+	}))
+	defer server.Close()
+
+	for _, forwardActive := range []bool{false, true} {
+		indexes, inconsistent = nil, nil
+
+		config := api.DefaultConfig()
+		config.Address = server.URL
+		config.HttpClient.Transport = newConsistencyTransport(forwardActive, config.HttpClient.Transport)
+		cl, err := api.NewClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cl.Logical().Write("secret/app", map[string]interface{}{"value": "written"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cl.Logical().Read("secret/app"); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(indexes) != 2 || indexes[0] != "" || indexes[1] != "state-after-write" {
+			t.Errorf("expected the replication state of the write on the read, got %v", indexes)
+		}
+		expected := ""
+		if forwardActive {
+			expected = forwardActiveNode
+		}
+		if inconsistent[1] != expected {
+			t.Errorf("expected %s=%q on the read, got %q", vaultInconsistentHeader, expected, inconsistent[1])
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgDriftCheckPeriod = "drift-check-period"
+
+// exitCodeChanges is the exit code of configure --dry-run --detect-changes if applying the
+// configuration files would change Vault, so e.g. a CI check can tell it from a failure
+const exitCodeChanges = 2
+
+// exitCodeUnknown is the exit code of configure --dry-run --detect-changes if no changes were
+// planned, but the configuration files have sections the plan doesn't compare with Vault
+const exitCodeUnknown = 3
+
+var auditDriftCmd = &cobra.Command{
+	Use:   "audit-drift",
+	Short: "Compares the state of Vault with the configuration files",
+	Long: `This command reports the policies, secret engines, auth methods and audit devices
+which are missing from Vault or differ from the configuration, without changing anything.
+The drift is exposed per resource type as the bank_vaults_config_drift metric, with --once
+the report is printed and the command exits with 1 if there is any drift.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgOnce, cmd.PersistentFlags().Lookup(cfgOnce))
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgDriftCheckPeriod, cmd.PersistentFlags().Lookup(cfgDriftCheckPeriod))
+		bindMetricsAddress(cmd.PersistentFlags())
+
+		runOnce := appConfig.GetBool(cfgOnce)
+		vaultConfigFiles := appConfig.GetStringSlice(cfgVaultConfigFile)
+		driftCheckPeriod := appConfig.GetDuration(cfgDriftCheckPeriod)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		if runOnce {
+			drift, err := auditDrift(v, vaultConfigFiles)
+			if err != nil {
+				logrus.Fatalf("error detecting configuration drift: %s", err.Error())
+			}
+
+			printDrift(os.Stdout, drift)
+
+			if len(drift) > 0 {
+				exitCode = 1
+			}
+			return
+		}
+
+		if metricsAddress := appConfig.GetString(cfgMetricsAddress); metricsAddress != "" {
+			go func() {
+				logrus.Infof("drift metrics enabled: %s%s", metricsAddress, defaultMetricsPath)
+				if err := metricsServer(metricsAddress, nil).ListenAndServe(); err != nil {
+					logrus.Errorf("error serving metrics: %s", err.Error())
+				}
+			}()
+		}
+
+		for {
+			drift, err := auditDrift(v, vaultConfigFiles)
+			if err != nil {
+				logrus.Errorf("error detecting configuration drift: %s", err.Error())
+			} else {
+				recordDrift(drift)
+			}
+
+			time.Sleep(driftCheckPeriod)
+		}
+	},
+}
+
+// auditDrift returns the drift of all the configuration files
+func auditDrift(v vault.Vault, vaultConfigFiles []string) (vault.Drift, error) {
+	drift := vault.Drift{}
+
+	for _, vaultConfigFile := range vaultConfigFiles {
+		fileDrift, err := v.DetectDrift(parseConfiguration(vaultConfigFile))
+		if err != nil {
+			return nil, fmt.Errorf("error detecting drift of %s: %s", vaultConfigFile, err.Error())
+		}
+
+		for resourceType, resources := range fileDrift {
+			drift[resourceType] = append(drift[resourceType], resources...)
+		}
+	}
+
+	for resourceType, resources := range drift {
+		if len(resources) > 0 {
+			logrus.Infof("%s drift: %s", resourceType, strings.Join(resources, ", "))
+		}
+	}
+
+	return drift, nil
+}
+
+func printDrift(out io.Writer, drift vault.Drift) {
+	for _, resourceType := range vault.DriftResourceTypes {
+		for _, resource := range drift[resourceType] {
+			fmt.Fprintf(out, "%s\t%s\n", resourceType, resource)
+		}
+	}
+}
+
+// detectChanges prints the plan of the changes applying the configuration files would make to
+// Vault, it returns the exit code of --detect-changes: 0 without changes, exitCodeChanges with
+// changes, exitCodeUnknown if only the sections which aren't compared could change and 1 if the
+// changes can't be planned
+func detectChanges(v vault.Vault, vaultConfigFiles []string, out io.Writer) int {
+	var changes []vault.PlannedChange
+	unknown := false
+	for _, vaultConfigFile := range vaultConfigFiles {
+		config := parseConfiguration(vaultConfigFile)
+		fileChanges, err := v.Plan(config)
+		if err != nil {
+			logrus.Errorf("error planning the changes of %s: %s", vaultConfigFile, err.Error())
+			return 1
+		}
+		changes = append(changes, fileChanges...)
+
+		if unplanned := vault.UnplannedSections(config); len(unplanned) > 0 {
+			fmt.Fprintf(out, "%s: not compared with Vault: %s\n", vaultConfigFile, strings.Join(unplanned, ", "))
+			unknown = true
+		}
+	}
+
+	if len(changes) == 0 && unknown {
+		fmt.Fprintln(out, "No changes in the compared sections, the other sections may change.")
+		return exitCodeUnknown
+	}
+
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No changes.")
+		return 0
+	}
+
+	printPlan(out, changes)
+	fmt.Fprintf(out, "%d change(s) would be applied.\n", len(changes))
+	return exitCodeChanges
+}
+
+// printPlan prints the planned changes like a diff, + for the resources to be created and ~ for
+// the ones to be updated or tuned, with the details of the changes indented
+func printPlan(out io.Writer, changes []vault.PlannedChange) {
+	for _, change := range changes {
+		symbol := "~"
+		if change.Action == vault.PlanCreate {
+			symbol = "+"
+		}
+		fmt.Fprintf(out, "%s %s %s (%s)\n", symbol, change.ResourceType, change.Resource, change.Action)
+		for _, detail := range change.Details {
+			fmt.Fprintf(out, "    %s\n", detail)
+		}
+	}
+}
+
+func init() {
+	auditDriftCmd.PersistentFlags().Bool(cfgOnce, false, "Check the drift only once and print the report")
+	auditDriftCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+	auditDriftCmd.PersistentFlags().Duration(cfgDriftCheckPeriod, time.Minute, "How often to check the configuration drift")
+	addMetricsAddressFlags(auditDriftCmd.PersistentFlags(), ":9091", "Serve the drift metrics on this address without --once (alias --metrics-listen-address), disabled if empty")
+
+	rootCmd.AddCommand(auditDriftCmd)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	writeConfigFile(t, configFile, "policies:\n  - name: allow_secrets\n    rules: path \"secret/*\" {}\n")
+
+	v := &mockVault{drift: vault.Drift{
+		vault.DriftPolicy: {"allow_secrets", "deny_secrets"},
+		vault.DriftMount:  {"secret"},
+	}}
+
+	drift, err := auditDrift(v, []string{configFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordDrift(drift)
+
+	expected := map[string]float64{
+		vault.DriftPolicy: 2,
+		vault.DriftMount:  1,
+		vault.DriftAuth:   0,
+		vault.DriftAudit:  0,
+	}
+	for resourceType, count := range expected {
+		if value := testutil.ToFloat64(configDrift.WithLabelValues(resourceType)); value != count {
+			t.Fatalf("expected %v %s drift, got %v", count, resourceType, value)
+		}
+	}
+
+	// the drift got fixed
+	recordDrift(vault.Drift{})
+	if value := testutil.ToFloat64(configDrift.WithLabelValues(vault.DriftPolicy)); value != 0 {
+		t.Fatalf("expected the policy drift to be reset, got %v", value)
+	}
+}
+
+func TestDetectChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	writeConfigFile(t, configFile, "policies:\n  - name: allow_secrets\n    rules: path \"secret/*\" {}\n")
+
+	// no-op
+	v := &mockVault{}
+	out := bytes.NewBuffer(nil)
+	if code := detectChanges(v, []string{configFile}, out); code != 0 {
+		t.Fatalf("expected exit code 0 without changes, got %d:\n%s", code, out.String())
+	}
+
+	v = &mockVault{plan: []vault.PlannedChange{
+		{ResourceType: vault.DriftPolicy, Resource: "allow_secrets", Action: vault.PlanCreate},
+		{ResourceType: vault.DriftMount, Resource: "secret", Action: vault.PlanTune, Details: []string{"max_lease_ttl: 768h0m0s -> 24h0m0s"}},
+	}}
+	out.Reset()
+	if code := detectChanges(v, []string{configFile}, out); code != exitCodeChanges {
+		t.Fatalf("expected exit code %d with changes, got %d", exitCodeChanges, code)
+	}
+	expected := "+ policy allow_secrets (create)\n~ mount secret (tune)\n    max_lease_ttl: 768h0m0s -> 24h0m0s\n2 change(s) would be applied.\n"
+	if out.String() != expected {
+		t.Fatalf("expected the plan to be printed, got:\n%s", out.String())
+	}
+
+	// the sections which aren't planned are reported
+	writeConfigFile(t, configFile, "policies: []\nstartupSecrets:\n  - type: kv\n    path: secret/data/accounts/aws\n")
+	out.Reset()
+	if code := detectChanges(&mockVault{}, []string{configFile}, out); code != exitCodeUnknown {
+		t.Fatalf("expected exit code %d with sections which aren't compared, got %d", exitCodeUnknown, code)
+	}
+	if expected := configFile + ": not compared with Vault: startupsecrets\nNo changes in the compared sections, the other sections may change.\n"; out.String() != expected {
+		t.Fatalf("expected the unplanned sections to be reported, got:\n%s", out.String())
+	}
+}
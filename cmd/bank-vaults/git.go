@@ -0,0 +1,210 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// gitConfigSource is a shallow clone of the ref of a Git repository holding the config files
+type gitConfigSource struct {
+	url string
+	ref string
+	// the directory (or the file) of the config files in the repository
+	path string
+	// the token of private HTTPS repositories, sent with basic auth as GitHub and GitLab expect it
+	token string
+	// the private key file of private SSH repositories
+	sshKey string
+	// the directory of the clone
+	dir string
+}
+
+// clone shallow-clones the ref of the repository into the temporary directory of the repository,
+// the directory is the same after a restart, so the config files keep their paths (e.g. the
+// managed names of purgeUnmanagedConfig are kept by config file)
+func (s *gitConfigSource) clone() error {
+	hash := sha256.Sum256([]byte(s.url + "#" + s.ref))
+	dir := filepath.Join(os.TempDir(), "bank-vaults-config-"+hex.EncodeToString(hash[:8]))
+	err := os.RemoveAll(dir)
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating directory of the git clone: %s", err.Error())
+	}
+	s.dir = dir
+
+	_, err = s.git("clone", "--quiet", "--depth", "1", "--single-branch", "--branch", s.ref, s.url, dir)
+	if err != nil {
+		return fmt.Errorf("error cloning %s of %s: %s", s.ref, s.url, err.Error())
+	}
+	return nil
+}
+
+// remove removes the clone, if there is one
+func (s *gitConfigSource) remove() {
+	if s.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		logrus.Errorf("error removing the git clone: %s", err.Error())
+	}
+}
+
+// pull fetches the ref and checks out its latest commit, it returns true if there is a new commit
+func (s *gitConfigSource) pull() (bool, error) {
+	_, err := s.git("fetch", "--quiet", "--depth", "1", "origin", s.ref)
+	if err != nil {
+		return false, fmt.Errorf("error fetching %s of %s: %s", s.ref, s.url, err.Error())
+	}
+
+	head, err := s.git("rev-parse", "HEAD")
+	if err != nil {
+		return false, err
+	}
+	fetched, err := s.git("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return false, err
+	}
+	if head == fetched {
+		return false, nil
+	}
+
+	_, err = s.git("reset", "--quiet", "--hard", fetched)
+	if err != nil {
+		return false, fmt.Errorf("error checking out %s of %s: %s", fetched, s.url, err.Error())
+	}
+	logrus.Infof("new commit %s on %s of %s", fetched, s.ref, s.url)
+	return true, nil
+}
+
+// configFiles returns the YAML/JSON config files in the path of the clone, in lexical order
+func (s *gitConfigSource) configFiles() ([]string, error) {
+	configPath := filepath.Join(s.dir, s.path)
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error finding %s in %s: %s", s.path, s.url, err.Error())
+	}
+	if !info.IsDir() {
+		return []string{configPath}, nil
+	}
+
+	entries, err := ioutil.ReadDir(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s in %s: %s", s.path, s.url, err.Error())
+	}
+	var configFiles []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yml", ".yaml", ".json":
+			if !entry.IsDir() {
+				configFiles = append(configFiles, filepath.Join(configPath, entry.Name()))
+			}
+		}
+	}
+	if len(configFiles) == 0 {
+		return nil, fmt.Errorf("there are no config files in %s of %s", s.path, s.url)
+	}
+	sort.Strings(configFiles)
+	return configFiles, nil
+}
+
+// git runs a git command in the clone and returns its trimmed output
+func (s *gitConfigSource) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if s.token != "" {
+		// passed in the environment instead of the arguments or the URL, so it isn't visible in ps or the logs,
+		// GIT_CONFIG_COUNT is supported since git 2.31
+		auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + s.token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth,
+		)
+	}
+	if s.sshKey != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", s.sshKey))
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", args[0], err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// watchGitConfigurations polls the ref of the repository at the interval until the context is
+// cancelled, and parses the config files in the path again on every new commit. A commit with
+// invalid config files is logged and skipped, the next commit may fix it.
+func watchGitConfigurations(ctx context.Context, source *gitConfigSource, interval time.Duration, configurations chan *viper.Viper) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := source.pull()
+		if err != nil {
+			logrus.Errorf("error polling the config repository: %s", err.Error())
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		configFiles, err := source.configFiles()
+		if err != nil {
+			logrus.Errorf("error reading the config repository: %s", err.Error())
+			continue
+		}
+		configureConfig.health.expectConfigFiles(configFiles)
+		for _, configFile := range configFiles {
+			config, err := readConfiguration(configFile)
+			if err != nil {
+				logrus.Errorf("error reading %s: %s", configFile, err.Error())
+				continue
+			}
+			select {
+			case configurations <- config:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// commitConfigFile writes the config file into the repository and commits it
+func commitConfigFile(t *testing.T, repo, name, content string) {
+	err := os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeConfigFile(t, filepath.Join(repo, name), content)
+
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "--quiet", "-m", "update " + name}} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, out)
+		}
+	}
+}
+
+func TestWatchGitConfigurationsReappliesNewCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	unsealConfig.unsealPeriod = time.Millisecond
+
+	repo, err := ioutil.TempDir("", "bank-vaults-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	if out, err := exec.Command("git", "init", "--quiet", "--initial-branch", "main", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s: %s", err, out)
+	}
+	commitConfigFile(t, repo, "vault/vault-config.yml", "policies:\n  - name: allow_secrets\n    rules: path \"secret/*\" {}\n")
+	commitConfigFile(t, repo, "README.md", "not a config file\n")
+
+	source := &gitConfigSource{url: "file://" + repo, ref: "main", path: "vault"}
+	err = source.clone()
+	defer os.RemoveAll(source.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFiles, err := source.configFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configFiles) != 1 || filepath.Base(configFiles[0]) != "vault-config.yml" {
+		t.Fatalf("expected the config file in the path of the clone, got %v", configFiles)
+	}
+
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- parseConfiguration(configFiles[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watched := make(chan struct{})
+	go func() {
+		watchGitConfigurations(ctx, source, 10*time.Millisecond, configurations)
+		close(watched)
+	}()
+
+	v := &mockVault{active: true}
+	done := make(chan []configurationResult)
+	go func() { done <- applyConfigurations(ctx, v, configurations, false) }()
+
+	for deadline := time.Now().Add(5 * time.Second); v.configureCalls() < 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the cloned configuration to be applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	commitConfigFile(t, repo, "vault/vault-config.yml", "policies:\n  - name: deny_secrets\n    rules: path \"secret/*\" {}\n")
+
+	for deadline := time.Now().Add(5 * time.Second); v.configureCalls() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new commit to be applied")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-watched
+	<-done
+
+	v.Lock()
+	defer v.Unlock()
+	if len(v.configured) != 2 {
+		t.Fatalf("expected the unchanged commits not to be re-applied, got %d runs", len(v.configured))
+	}
+	policies := v.configured[1].Get("policies").([]interface{})
+	if name := policies[0].(map[interface{}]interface{})["name"]; name != "deny_secrets" {
+		t.Fatalf("expected the configuration of the new commit, got %v", name)
+	}
+}
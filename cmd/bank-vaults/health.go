@@ -0,0 +1,143 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/gin-gonic/gin"
+)
+
+// healthChecker checks the health (the kv backend is reachable) and the readiness (Vault is
+// reachable and unsealed, and the last configuration runs succeeded) of the unseal and configure
+// commands for the liveness and readiness probes of Kubernetes
+type healthChecker struct {
+	store kv.Service
+	vault vault.Vault
+	// the configure command is only ready once every config file was applied
+	requireConfigured bool
+
+	mu sync.Mutex
+	// the config files of the applied file set, every one of them has to be configured
+	configFiles []string
+	// the error of the last configuration run by config file
+	configured map[string]error
+}
+
+func newHealthChecker(store kv.Service, v vault.Vault, requireConfigured bool) *healthChecker {
+	return &healthChecker{
+		store:             store,
+		vault:             v,
+		requireConfigured: requireConfigured,
+		configured:        map[string]error{},
+	}
+}
+
+// recordConfigure records the result of the configuration run of the config file
+func (h *healthChecker) recordConfigure(configFile string, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.configured[configFile] = err
+}
+
+// expectConfigFiles sets the config files of the applied file set (e.g. after a pull of
+// --vault-config-git), the results of the config files which aren't part of it are forgotten
+func (h *healthChecker) expectConfigFiles(configFiles []string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	configured := map[string]error{}
+	for _, configFile := range configFiles {
+		if err, ok := h.configured[configFile]; ok {
+			configured[configFile] = err
+		}
+	}
+	h.configFiles = append([]string(nil), configFiles...)
+	sort.Strings(h.configFiles)
+	h.configured = configured
+}
+
+// healthy checks that the kv backend is reachable, listing its keys doesn't need the KMS
+func (h *healthChecker) healthy() error {
+	if _, err := h.store.List(); err != nil {
+		return fmt.Errorf("kv backend is not reachable: %s", err.Error())
+	}
+	return nil
+}
+
+// ready checks that Vault is reachable and unsealed, and that the last configuration run of every
+// config file succeeded if it is required
+func (h *healthChecker) ready() error {
+	sealed, err := h.vault.Sealed()
+	if err != nil {
+		return fmt.Errorf("vault is not reachable: %s", err.Error())
+	}
+	if sealed {
+		return fmt.Errorf("vault is sealed")
+	}
+
+	if !h.requireConfigured {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, configFile := range h.configFiles {
+		if _, ok := h.configured[configFile]; !ok {
+			return fmt.Errorf("%s is not configured yet", configFile)
+		}
+	}
+	if len(h.configured) == 0 {
+		return fmt.Errorf("vault is not configured yet")
+	}
+	configFiles := make([]string, 0, len(h.configured))
+	for configFile := range h.configured {
+		configFiles = append(configFiles, configFile)
+	}
+	sort.Strings(configFiles)
+	for _, configFile := range configFiles {
+		if err := h.configured[configFile]; err != nil {
+			return fmt.Errorf("the last configuration of %s failed: %s", configFile, err.Error())
+		}
+	}
+	return nil
+}
+
+// addHealthChecks serves /healthz and /readyz next to the metrics, 200 if the check passes and
+// 503 with the error otherwise
+func addHealthChecks(engine *gin.Engine, h *healthChecker) {
+	engine.GET("/healthz", healthHandler(h.healthy))
+	engine.GET("/readyz", healthHandler(h.ready))
+}
+
+func healthHandler(check func() error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := check(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+)
+
+type unreachableKV struct {
+	*memory.Store
+}
+
+func (unreachableKV) List() ([]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHealthChecks(t *testing.T) {
+	v := &mockVault{sealed: true}
+	health := newHealthChecker(memory.New(nil), v, true)
+
+	// the probes are served by the metrics server
+
+	server := httptest.NewServer(metricsServer(":0", health).Handler)
+	defer server.Close()
+
+	status := func(path string) int {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("expected a healthy sidecar, got %d", code)
+	}
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected a sealed vault not to be ready, got %d", code)
+	}
+
+	v.Lock()
+	v.sealed = false
+	v.Unlock()
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected an unconfigured vault not to be ready, got %d", code)
+	}
+
+	health.recordConfigure("vault-config.yml", nil)
+	health.recordConfigure("vault-policies.yml", errors.New("permission denied"))
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected a failed configuration not to be ready, got %d", code)
+	}
+
+	health.recordConfigure("vault-policies.yml", nil)
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("expected a configured vault to be ready, got %d", code)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	health := newHealthChecker(unreachableKV{memory.New(nil)}, &mockVault{}, false)
+	if err := health.healthy(); err == nil {
+		t.Error("expected an unreachable kv backend to be unhealthy")
+	}
+
+	// the unseal command is ready once vault is unsealed
+	if err := health.ready(); err != nil {
+		t.Errorf("expected an unsealed vault to be ready, got %s", err.Error())
+	}
+
+	// only the config files of the applied file set have to be configured
+	health = newHealthChecker(memory.New(nil), &mockVault{}, true)
+	health.expectConfigFiles([]string{"vault-config.yml", "vault-policies.yml"})
+	health.recordConfigure("vault-config.yml", nil)
+	if err := health.ready(); err == nil {
+		t.Error("expected a config file without a configuration run not to be ready")
+	}
+	health.recordConfigure("vault-policies.yml", errors.New("permission denied"))
+	if err := health.ready(); err == nil {
+		t.Error("expected a failed configuration not to be ready")
+	}
+	health.expectConfigFiles([]string{"vault-config.yml"})
+	if err := health.ready(); err != nil {
+		t.Errorf("expected the failure of a removed config file to be forgotten, got %s", err.Error())
+	}
+
+	// the configure results aren't recorded without the health checks
+	var disabled *healthChecker
+	disabled.recordConfigure("vault-config.yml", nil)
+}
@@ -54,6 +54,12 @@ It will not unseal the Vault instance after initialising.`,
 			logrus.Fatalf("error building vault config: %s", err.Error())
 		}
 
+		vaultConfig.ShareStores, err = shareStoresForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating the kv stores of the unseal key shares: %s", err.Error())
+		}
+
 		v, err := vault.New(store, cl, vaultConfig)
 
 		if err != nil {
@@ -0,0 +1,208 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElectionCfg is the timing of the leader election, see leaderelection.LeaderElectionConfig
+type leaderElectionCfg struct {
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// leaseLock is a resourcelock.Interface storing the leader election record in the spec of a
+// coordination.k8s.io Lease, the client-go of this version only has the ConfigMap and Endpoints locks
+type leaseLock struct {
+	namespace string
+	name      string
+	identity  string
+	client    coordinationclient.LeasesGetter
+	lease     *coordinationv1beta1.Lease
+}
+
+// Get returns the election record of the Lease
+func (ll *leaseLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	var err error
+	ll.lease, err = ll.client.Leases(ll.namespace).Get(ll.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return leaseToRecord(&ll.lease.Spec), nil
+}
+
+// Create creates the Lease with the election record
+func (ll *leaseLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	var err error
+	ll.lease, err = ll.client.Leases(ll.namespace).Create(&coordinationv1beta1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ll.name,
+			Namespace: ll.namespace,
+		},
+		Spec: recordToLease(&ler),
+	})
+	return err
+}
+
+// Update writes the election record to the Lease read by Get or written by Create
+func (ll *leaseLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if ll.lease == nil {
+		return errors.New("lease not initialized, call get or create first")
+	}
+	ll.lease.Spec = recordToLease(&ler)
+	var err error
+	ll.lease, err = ll.client.Leases(ll.namespace).Update(ll.lease)
+	return err
+}
+
+// RecordEvent logs the leader election events, there is no event recorder
+func (ll *leaseLock) RecordEvent(s string) {
+	logrus.Debugf("leader election of %s: %s %s", ll.Describe(), ll.identity, s)
+}
+
+// Identity returns the identity of this replica
+func (ll *leaseLock) Identity() string {
+	return ll.identity
+}
+
+// Describe returns the namespace/name of the Lease
+func (ll *leaseLock) Describe() string {
+	return fmt.Sprintf("%s/%s", ll.namespace, ll.name)
+}
+
+func leaseToRecord(spec *coordinationv1beta1.LeaseSpec) *resourcelock.LeaderElectionRecord {
+	var record resourcelock.LeaderElectionRecord
+	if spec.HolderIdentity != nil {
+		record.HolderIdentity = *spec.HolderIdentity
+	}
+	if spec.LeaseDurationSeconds != nil {
+		record.LeaseDurationSeconds = int(*spec.LeaseDurationSeconds)
+	}
+	if spec.LeaseTransitions != nil {
+		record.LeaderTransitions = int(*spec.LeaseTransitions)
+	}
+	if spec.AcquireTime != nil {
+		record.AcquireTime = metav1.NewTime(spec.AcquireTime.Time)
+	}
+	if spec.RenewTime != nil {
+		record.RenewTime = metav1.NewTime(spec.RenewTime.Time)
+	}
+	return &record
+}
+
+func recordToLease(record *resourcelock.LeaderElectionRecord) coordinationv1beta1.LeaseSpec {
+	leaseDurationSeconds := int32(record.LeaseDurationSeconds)
+	leaseTransitions := int32(record.LeaderTransitions)
+	acquireTime := metav1.NewMicroTime(record.AcquireTime.Time)
+	renewTime := metav1.NewMicroTime(record.RenewTime.Time)
+	return coordinationv1beta1.LeaseSpec{
+		HolderIdentity:       &record.HolderIdentity,
+		LeaseDurationSeconds: &leaseDurationSeconds,
+		LeaseTransitions:     &leaseTransitions,
+		AcquireTime:          &acquireTime,
+		RenewTime:            &renewTime,
+	}
+}
+
+// podNamespace returns the namespace of the pod (e.g. of the Lease if it isn't set): POD_NAMESPACE
+// or the namespace of its service account, default outside of a pod
+func podNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if namespace, err := ioutil.ReadFile(serviceAccountNamespaceFile); err == nil {
+		return strings.TrimSpace(string(namespace))
+	}
+	return metav1.NamespaceDefault
+}
+
+// leaderElectionIdentity returns the identity of this replica in the Lease: POD_NAME or the hostname
+func leaderElectionIdentity() (string, error) {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("error getting the leader election identity: %s", err.Error())
+	}
+	return hostname, nil
+}
+
+// waitForLeadership blocks until this replica holds the lock, it returns false if the context
+// was cancelled before. The leader keeps renewing the lock in the background and exits once it
+// couldn't renew it within the renew deadline, so a standby replica can take over after the lease
+// duration without two replicas configuring Vault. The lock isn't released on shutdown, the
+// standby replicas take over once it expired.
+func waitForLeadership(ctx context.Context, lock resourcelock.Interface, config leaderElectionCfg) (bool, error) {
+	elected := make(chan struct{})
+	stopped := make(chan struct{})
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.leaseDuration,
+		RenewDeadline: config.renewDeadline,
+		RetryPeriod:   config.retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				logrus.Infof("elected as the leader of %s", lock.Describe())
+				close(elected)
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-elected:
+					if ctx.Err() == nil {
+						logrus.Fatalf("lost the leadership of %s", lock.Describe())
+					}
+				default:
+				}
+				close(stopped)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != lock.Identity() {
+					logrus.Infof("standing by, %s is the leader of %s", identity, lock.Describe())
+				}
+			},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("error creating leader elector: %s", err.Error())
+	}
+
+	go elector.Run(ctx)
+
+	select {
+	case <-elected:
+		return true, nil
+	case <-stopped:
+		return false, nil
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestLeaseLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	lock := &leaseLock{namespace: "vault", name: "bank-vaults-configurer", identity: "configurer-0", client: client.CoordinationV1beta1()}
+
+	if _, err := lock.Get(); err == nil {
+		t.Fatal("expected the lease not to exist yet")
+	}
+
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	record := resourcelock.LeaderElectionRecord{
+		HolderIdentity:       "configurer-0",
+		LeaseDurationSeconds: 15,
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+	if err := lock.Create(record); err != nil {
+		t.Fatal(err)
+	}
+
+	record.RenewTime = metav1.NewTime(now.Add(2 * time.Second))
+	record.LeaderTransitions = 1
+	if err := lock.Update(record); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := lock.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.HolderIdentity != "configurer-0" || got.LeaseDurationSeconds != 15 || got.LeaderTransitions != 1 {
+		t.Errorf("unexpected election record: %+v", got)
+	}
+	if !got.AcquireTime.Equal(&record.AcquireTime) || !got.RenewTime.Equal(&record.RenewTime) {
+		t.Errorf("expected the times of the election record to round-trip, got %+v", got)
+	}
+	if lock.Describe() != "vault/bank-vaults-configurer" {
+		t.Errorf("unexpected description: %s", lock.Describe())
+	}
+}
+
+func TestWaitForLeadership(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	config := leaderElectionCfg{leaseDuration: 2 * time.Second, renewDeadline: time.Second, retryPeriod: 100 * time.Millisecond}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	defer cancelLeader()
+	leader := &leaseLock{namespace: "vault", name: "configurer", identity: "configurer-0", client: client.CoordinationV1beta1()}
+	elected, err := waitForLeadership(leaderCtx, leader, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !elected {
+		t.Fatal("expected the first replica to be elected")
+	}
+
+	// the lease is renewed by the leader, the standby replica waits until it is cancelled
+	standbyCtx, cancelStandby := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelStandby()
+	standby := &leaseLock{namespace: "vault", name: "configurer", identity: "configurer-1", client: client.CoordinationV1beta1()}
+	elected, err = waitForLeadership(standbyCtx, standby, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elected {
+		t.Error("expected the standby replica not to be elected while the leader renews the lease")
+	}
+
+	if _, err := waitForLeadership(context.Background(), standby, leaderElectionCfg{leaseDuration: time.Second, renewDeadline: time.Second, retryPeriod: time.Second}); err == nil {
+		t.Error("expected a lease duration not longer than the renew deadline to fail")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var listStoreCmd = &cobra.Command{
+	Use:   "list-store",
+	Short: "Prints the keys stored in the kv backend",
+	Long: `This command prints the names of the keys stored in the kv backend of --mode (e.g. the
+unseal keys, the root token and their previous versions), one per line, for diagnostics such as
+a DR audit. The values are not read, so the KMS keys of the backend are not used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// the keys are listed without the caching and versioning layers
+		store, err := kvStoreForMode(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		err = listStore(store, os.Stdout)
+
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+	},
+}
+
+// listStore prints the keys of the kv store, one per line
+func listStore(store kv.Service, out io.Writer) error {
+	keys, err := store.List()
+	if err != nil {
+		return fmt.Errorf("error listing the keys of the kv store: %s", err.Error())
+	}
+	for _, key := range keys {
+		fmt.Fprintln(out, key)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(listStoreCmd)
+}
@@ -19,8 +19,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/banzaicloud/bank-vaults/pkg/kv/consul"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/vaulttransit"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -37,6 +43,8 @@ const cfgModeValueAlibabaKMSOSS = "alibaba-kms-oss"
 const cfgModeValueK8S = "k8s"
 const cfgModeValueDev = "dev"
 const cfgModeValueFile = "file"
+const cfgModeValueConsul = "consul"
+const cfgModeValueVaultTransit = "vault-transit"
 
 const cfgGoogleCloudKMSProject = "google-cloud-kms-project"
 const cfgGoogleCloudKMSLocation = "google-cloud-kms-location"
@@ -68,12 +76,56 @@ const cfgK8SSecret = "k8s-secret-name"
 
 const cfgFilePath = "file-path"
 
+const cfgPKCS11Module = "pkcs11-module"
+const cfgPKCS11Slot = "pkcs11-slot"
+const cfgPKCS11PIN = "pkcs11-pin"
+const cfgPKCS11KeyLabel = "pkcs11-key-label"
+
+const cfgConsulAddress = "consul-address"
+const cfgConsulToken = "consul-token"
+const cfgConsulPrefix = "consul-prefix"
+
+const cfgVaultTransitAddress = "vault-transit-address"
+const cfgVaultTransitToken = "vault-transit-token"
+const cfgVaultTransitMountPath = "vault-transit-mount-path"
+const cfgVaultTransitKeyName = "vault-transit-key-name"
+const cfgVaultTransitCACert = "vault-transit-ca-cert"
+const cfgVaultTransitTLSServerName = "vault-transit-tls-server-name"
+const cfgVaultTransitSkipVerify = "vault-transit-skip-verify"
+const cfgVaultTransitStorage = "vault-transit-storage"
+
+const cfgVaultTransitStorageValueS3 = "s3"
+const cfgVaultTransitStorageValueGCS = "gcs"
+const cfgVaultTransitStorageValueK8S = "k8s"
+const cfgVaultTransitStorageValueFile = "file"
+const cfgVaultTransitStorageValueConsul = "consul"
+
+const cfgKVCredentialsFile = "kv-credentials-file"
+const cfgKVCacheTTL = "kv-cache-ttl"
+const cfgKVKeepVersions = "kv-keep-versions"
+
+const cfgShareBackend = "share-backend"
+const cfgShareDecoder = "share-decoder"
+
+const cfgOperatorConfig = "operator-config"
+
+const cfgAuthMethod = "auth-method"
+const cfgAuthRole = "auth-role"
+const cfgAuthPath = "auth-path"
+const cfgAppRoleRoleID = "approle-role-id"
+const cfgAppRoleSecretIDFile = "approle-secret-id-file"
+const cfgAppRoleSecretIDWrapped = "approle-secret-id-wrapped"
+
 var rootCmd = &cobra.Command{
 	Use:   "bank-vaults",
 	Short: "Automates initialization, unsealing and configuration of Hashicorp Vault.",
 	Long:  `This is a CLI tool to help automate the setup and management of Hashicorp Vault.`,
 }
 
+// exitCode is the exit status of the command, the commands set it and return instead of exiting,
+// so their deferred cleanups (e.g. the shutdown of the servers) run before the process exits
+var exitCode int
+
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func execute() {
@@ -81,6 +133,7 @@ func execute() {
 		fmt.Println(err)
 		os.Exit(-1)
 	}
+	os.Exit(exitCode)
 }
 
 func configIntVar(key string, defaultValue int, description string) {
@@ -93,6 +146,51 @@ func configStringVar(key, defaultValue, description string) {
 	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
 }
 
+func configDurationVar(key string, defaultValue time.Duration, description string) {
+	rootCmd.PersistentFlags().Duration(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
+func configBoolVar(key string, defaultValue bool, description string) {
+	rootCmd.PersistentFlags().Bool(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
+func configStringSliceVar(key string, defaultValue []string, description string) {
+	rootCmd.PersistentFlags().StringSlice(key, defaultValue, description)
+	appConfig.BindPFlag(key, rootCmd.PersistentFlags().Lookup(key))
+}
+
+// readOperatorConfig reads the flags of bank-vaults from the operator config file (e.g. mode: k8s,
+// unseal-period: 10s), the flags set on the command line and the environment override its values
+func readOperatorConfig(cfg *viper.Viper, operatorConfig string, knownFlags []*pflag.FlagSet) error {
+	cfg.SetConfigFile(operatorConfig)
+	err := cfg.ReadInConfig()
+	if err != nil {
+		return fmt.Errorf("error reading operator config file %s: %s", operatorConfig, err.Error())
+	}
+
+	for _, key := range cfg.AllKeys() {
+		known := false
+		for _, flags := range knownFlags {
+			known = known || flags.Lookup(key) != nil
+		}
+		if !known {
+			logrus.Warnf("unknown flag %s in operator config file %s", key, operatorConfig)
+		}
+	}
+	return nil
+}
+
+// commandFlags returns the flag sets of the command and all of its subcommands
+func commandFlags(cmd *cobra.Command) []*pflag.FlagSet {
+	flags := []*pflag.FlagSet{cmd.PersistentFlags(), cmd.Flags()}
+	for _, subCmd := range cmd.Commands() {
+		flags = append(flags, commandFlags(subCmd)...)
+	}
+	return flags
+}
+
 func init() {
 	appConfig = viper.New()
 	appConfig.SetEnvPrefix("bank_vaults")
@@ -109,9 +207,11 @@ func init() {
 						'%s' => AWS S3 Object Storage using AWS KMS encryption;
 						'%s' => Azure Key Vault secret;
 						'%s' => Alibaba OSS with KMS encryption;
-						'%s' => Kubernetes Secrets;
+						'%s' => Kubernetes Secrets, optionally encrypted with a PKCS#11 HSM key;
 						'%s' => Dev (vault server -dev) mode
-						'%s' => File mode`,
+						'%s' => File mode, optionally encrypted with a PKCS#11 HSM key
+						'%s' => Consul KV, optionally encrypted with AWS KMS or Google KMS
+						'%s' => Encryption using the Transit engine of another Vault cluster, stored in --vault-transit-storage`,
 			cfgModeValueGoogleCloudKMSGCS,
 			cfgModeValueAWSKMS3,
 			cfgModeValueAzureKeyVault,
@@ -119,6 +219,8 @@ func init() {
 			cfgModeValueK8S,
 			cfgModeValueDev,
 			cfgModeValueFile,
+			cfgModeValueConsul,
+			cfgModeValueVaultTransit,
 		),
 	)
 
@@ -167,6 +269,65 @@ func init() {
 
 	// File flags
 	configStringVar(cfgFilePath, "", "The path prefix of the files where to store values in")
+
+	// PKCS#11 HSM flags
+	configStringVar(cfgPKCS11Module, "", "The PKCS#11 library of the HSM to encrypt the values of the k8s and file modes with (e.g. /usr/lib/softhsm/libsofthsm2.so), needs a bank-vaults built with -tags pkcs11")
+	configIntVar(cfgPKCS11Slot, 0, "The slot of the HSM token holding the PKCS#11 key")
+	configStringVar(cfgPKCS11PIN, "", "The user PIN of the HSM token, better set as BANK_VAULTS_PKCS11_PIN")
+	configStringVar(cfgPKCS11KeyLabel, "", "The label of the AES key on the HSM token to encrypt values with AES-GCM")
+
+	// Consul KV flags
+	configStringVar(cfgConsulAddress, "", "The address of the Consul agent to store values in (defaults to CONSUL_HTTP_ADDR or "+consul.DefaultAddress+")")
+	configStringVar(cfgConsulToken, "", "The ACL token of Consul (defaults to CONSUL_HTTP_TOKEN), better set as BANK_VAULTS_CONSUL_TOKEN")
+	configStringVar(cfgConsulPrefix, "", "The prefix to use for storing values in Consul KV (e.g. vault/)")
+
+	// Vault Transit flags
+	configStringVar(cfgVaultTransitAddress, "", "The address of the other Vault cluster to encrypt values with its Transit engine")
+	configStringVar(cfgVaultTransitToken, "", "The token of the other Vault cluster, allowed to update <mount path>/encrypt/<key> and <mount path>/decrypt/<key>, better set as BANK_VAULTS_VAULT_TRANSIT_TOKEN")
+	configStringVar(cfgVaultTransitMountPath, vaulttransit.DefaultMountPath, "The mount path of the Transit engine of the other Vault cluster")
+	configStringVar(cfgVaultTransitKeyName, "", "The name of the Transit key to encrypt values")
+	configStringVar(cfgVaultTransitCACert, "", "The PEM-encoded CA certificate file to verify the certificate of the other Vault cluster with (instead of the system CAs)")
+	configStringVar(cfgVaultTransitTLSServerName, "", "The server name to verify the certificate of the other Vault cluster with (and to send with SNI)")
+	configBoolVar(cfgVaultTransitSkipVerify, false, "Don't verify the certificate of the other Vault cluster, only for testing")
+	configStringVar(
+		cfgVaultTransitStorage,
+		cfgVaultTransitStorageValueK8S,
+		fmt.Sprintf("Where to store the values encrypted with Vault Transit: '%s' (--aws-s3-* flags), '%s' (--google-cloud-storage-* flags), '%s' (--k8s-secret-* flags), '%s' (--file-path) or '%s' (--consul-* flags)",
+			cfgVaultTransitStorageValueS3,
+			cfgVaultTransitStorageValueGCS,
+			cfgVaultTransitStorageValueK8S,
+			cfgVaultTransitStorageValueFile,
+			cfgVaultTransitStorageValueConsul,
+		),
+	)
+
+	// Credentials of the kv backend
+	configStringVar(cfgKVCredentialsFile, "", "Credentials file of the kv backend instead of the default discovery (AWS shared credentials file, Google Cloud JSON key or Azure SDK auth file)")
+	configDurationVar(cfgKVCacheTTL, 0, "Keep the values read from the kv backend in memory for this long, to reduce the backend API calls (0 disables caching)")
+	configIntVar(cfgKVKeepVersions, 0, "Keep this many previous versions of every key in the kv backend (as <key>.v<n>) when it is overwritten, so they can be restored with recover-share (0 disables versioning)")
+
+	// Distribution of the unseal key shares
+	configStringSliceVar(cfgShareBackend, nil, "Store an unseal key share in another kv backend: <share index>=<backend config file>, the backend config file holds the flags of the backend (e.g. mode, aws-s3-bucket, ...)")
+	configStringVar(cfgShareDecoder, vault.ShareDecoderRaw, "How the stored unseal key shares are decoded before they are submitted to Vault, and encoded before the init stores them (raw or base64)")
+
+	// Login to Vault
+	configStringVar(cfgAuthMethod, "", "Log in to Vault with this auth method instead of using the root token from the kv backend (kubernetes, with the service account token of the pod, or approle)")
+	configStringVar(cfgAuthRole, "", "The role to log in with the auth method")
+	configStringVar(cfgAuthPath, "", "The mount path of the auth method (defaults to the auth method)")
+	configStringVar(cfgAppRoleRoleID, "", "The role-id to log in with the approle auth method")
+	configStringVar(cfgAppRoleSecretIDFile, "", "The file holding the secret-id to log in with the approle auth method")
+	configBoolVar(cfgAppRoleSecretIDWrapped, false, "The secret-id file holds a response-wrapping token of the secret-id, which is unwrapped once at the first login")
+
+	// Flags from a file
+	configStringVar(cfgOperatorConfig, "", "YAML/JSON file holding the flags of bank-vaults by their names (e.g. mode: k8s), the flags set on the command line override it")
+
+	cobra.OnInitialize(func() {
+		if operatorConfig := appConfig.GetString(cfgOperatorConfig); operatorConfig != "" {
+			if err := readOperatorConfig(appConfig, operatorConfig, commandFlags(rootCmd)); err != nil {
+				logrus.Fatal(err.Error())
+			}
+		}
+	})
 }
 
 func main() {
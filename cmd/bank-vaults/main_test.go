@@ -0,0 +1,90 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestReadOperatorConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "operator-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	operatorConfig := filepath.Join(dir, "bank-vaults.yml")
+	err = ioutil.WriteFile(operatorConfig, []byte(`
+mode: file
+file-path: /vault/keys
+secret-shares: 7
+unseal-period: 10s
+vault-config-file:
+  - /etc/vault/config.yml
+  - /etc/vault/policies.yml
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := pflag.NewFlagSet("bank-vaults", pflag.ContinueOnError)
+	flags.String(cfgMode, cfgModeValueGoogleCloudKMSGCS, "")
+	flags.String(cfgFilePath, "", "")
+	flags.Int(cfgSecretShares, 5, "")
+	flags.Duration(cfgUnsealPeriod, 30*time.Second, "")
+	flags.StringSlice(cfgVaultConfigFile, []string{"vault-config.yml"}, "")
+
+	cfg := viper.New()
+	cfg.BindPFlags(flags)
+
+	// set on the command line, so it overrides the operator config file
+	err = flags.Parse([]string{"--" + cfgSecretShares, "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = readOperatorConfig(cfg, operatorConfig, []*pflag.FlagSet{flags})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := cfg.GetString(cfgMode); mode != cfgModeValueFile {
+		t.Fatalf("expected the mode from the operator config file, got %s", mode)
+	}
+	if filePath := cfg.GetString(cfgFilePath); filePath != "/vault/keys" {
+		t.Fatalf("expected the file path from the operator config file, got %s", filePath)
+	}
+	if unsealPeriod := cfg.GetDuration(cfgUnsealPeriod); unsealPeriod != 10*time.Second {
+		t.Fatalf("expected the unseal period from the operator config file, got %s", unsealPeriod)
+	}
+	if configFiles := cfg.GetStringSlice(cfgVaultConfigFile); len(configFiles) != 2 || configFiles[1] != "/etc/vault/policies.yml" {
+		t.Fatalf("expected the config files from the operator config file, got %v", configFiles)
+	}
+	if secretShares := cfg.GetInt(cfgSecretShares); secretShares != 9 {
+		t.Fatalf("expected the command line flag to override the operator config file, got %d", secretShares)
+	}
+
+	err = readOperatorConfig(viper.New(), filepath.Join(dir, "missing.yml"), nil)
+	if err == nil {
+		t.Fatal("expected a missing operator config file to fail")
+	}
+}
@@ -15,11 +15,20 @@
 package main
 
 import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
 )
 
 const prometheusNS = "vault"
@@ -42,6 +51,171 @@ var (
 	)
 )
 
+var (
+	kvOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "bank_vaults_kv_operation_duration_seconds",
+			Help: "Duration of the kv backend operations.",
+		},
+		[]string{"op", "backend"},
+	)
+	kvOperationErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bank_vaults_kv_operation_errors_total",
+			Help: "Number of failed kv backend operations, missing keys are not counted.",
+		},
+		[]string{"op", "backend"},
+	)
+	configDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "bank_vaults_config_drift",
+			Help: "Number of configured resources which are missing from Vault or differ from the configuration.",
+		},
+		[]string{"resource_type"},
+	)
+	configureTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bank_vaults_configure_total",
+			Help: "Number of configuration runs applied to Vault.",
+		},
+	)
+	configureErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bank_vaults_configure_errors_total",
+			Help: "Number of failed configuration runs.",
+		},
+	)
+	configureFileErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bank_vaults_configure_file_errors_total",
+			Help: "Number of failed configuration runs of the config files.",
+		},
+		[]string{"config_file"},
+	)
+	configureSinceLastSuccess = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "bank_vaults_configure_seconds_since_last_success",
+			Help: "Seconds since the last successful configuration run, or since the start if there was none yet.",
+		},
+		func() float64 {
+			return time.Since(time.Unix(0, atomic.LoadInt64(&lastConfigureSuccess))).Seconds()
+		},
+	)
+	configureDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "bank_vaults_configure_duration_seconds",
+			Help: "Duration of the configuration runs.",
+		},
+	)
+	vaultSealed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "bank_vaults_vault_sealed",
+			Help: "Is the Vault node sealed, as last checked by unseal or configure.",
+		},
+	)
+	unsealDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name: "bank_vaults_unseal_duration_seconds",
+			Help: "Duration of the unseal attempts of a sealed Vault node.",
+		},
+	)
+	unsealAttempts = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bank_vaults_unseal_attempts_total",
+			Help: "Number of unseal attempts of a sealed Vault node.",
+		},
+	)
+	unsealErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "bank_vaults_unseal_errors_total",
+			Help: "Number of failed seal checks and unseal attempts.",
+		},
+	)
+)
+
+// lastConfigureSuccess is the UnixNano time of the last successful configuration run
+var lastConfigureSuccess = time.Now().UnixNano()
+
+func recordConfigureSuccess(t time.Time) {
+	atomic.StoreInt64(&lastConfigureSuccess, t.UnixNano())
+}
+
+func init() {
+	prometheus.MustRegister(kvOperationDuration, kvOperationErrors, configDrift)
+	prometheus.MustRegister(configureTotal, configureErrors, configureFileErrors, configureSinceLastSuccess, configureDuration, vaultSealed)
+	prometheus.MustRegister(unsealAttempts, unsealDuration, unsealErrors)
+}
+
+// cfgMetricsListenAddress is the alias of --metrics-address
+const cfgMetricsListenAddress = "metrics-listen-address"
+
+// addMetricsAddressFlags adds --metrics-address and its alias --metrics-listen-address
+func addMetricsAddressFlags(flags *pflag.FlagSet, value, usage string) {
+	flags.String(cfgMetricsAddress, value, usage)
+	flags.String(cfgMetricsListenAddress, value, "Alias of --"+cfgMetricsAddress)
+}
+
+// bindMetricsAddress binds --metrics-address, or --metrics-listen-address if only the alias is set
+func bindMetricsAddress(flags *pflag.FlagSet) {
+	flag := flags.Lookup(cfgMetricsAddress)
+	if alias := flags.Lookup(cfgMetricsListenAddress); alias.Changed && !flag.Changed {
+		flag = alias
+	}
+	appConfig.BindPFlag(cfgMetricsAddress, flag)
+}
+
+// instrumentedKV records the latency and the errors of the kv.Service operations
+type instrumentedKV struct {
+	kv.Service
+	backend string
+}
+
+func newInstrumentedKV(service kv.Service, backend string) kv.Service {
+	return &instrumentedKV{Service: service, backend: backend}
+}
+
+func (i *instrumentedKV) observe(op string, start time.Time, err error) {
+	kvOperationDuration.WithLabelValues(op, i.backend).Observe(time.Since(start).Seconds())
+	if _, notFound := err.(*kv.NotFoundError); err != nil && !notFound {
+		kvOperationErrors.WithLabelValues(op, i.backend).Inc()
+	}
+}
+
+func (i *instrumentedKV) Set(key string, value []byte) error {
+	start := time.Now()
+	err := i.Service.Set(key, value)
+	i.observe("set", start, err)
+	return err
+}
+
+func (i *instrumentedKV) Get(key string) ([]byte, error) {
+	start := time.Now()
+	value, err := i.Service.Get(key)
+	i.observe("get", start, err)
+	return value, err
+}
+
+func (i *instrumentedKV) Test(key string) error {
+	start := time.Now()
+	err := i.Service.Test(key)
+	i.observe("test", start, err)
+	return err
+}
+
+func (i *instrumentedKV) Delete(key string) error {
+	start := time.Now()
+	err := kv.Delete(i.Service, key)
+	i.observe("delete", start, err)
+	return err
+}
+
+func (i *instrumentedKV) List() ([]string, error) {
+	start := time.Now()
+	keys, err := i.Service.List()
+	i.observe("list", start, err)
+	return keys, err
+}
+
 type prometheusExporter struct {
 	Vault vault.Vault
 }
@@ -84,13 +258,49 @@ func (e *prometheusExporter) Collect(ch chan<- prometheus.Metric) {
 	)
 }
 
-func (e prometheusExporter) Run() {
-	var defaultMetricsPath = "/metrics"
-	var defaultMetricsPort = ":9091"
-	logrus.Infof("vault metrics exporter enabled: %s%s", defaultMetricsPort, defaultMetricsPath)
+// Run registers the exporter and serves the registered metrics and the probes of the health
+// checker on the address
+func (e prometheusExporter) Run(address string, health *healthChecker) {
 	prometheus.MustRegister(&e)
-	server := gin.New()
-	server.Use(gin.Logger(), gin.ErrorLogger())
-	server.GET(defaultMetricsPath, gin.WrapH(promhttp.Handler()))
-	server.Run(defaultMetricsPort)
+	logrus.Infof("vault metrics exporter enabled: %s%s", address, defaultMetricsPath)
+	if err := metricsServer(address, health).ListenAndServe(); err != nil {
+		logrus.Errorf("error serving metrics: %s", err.Error())
+	}
+}
+
+// configFileLabel returns the config file label of the configuration, the path relative to the
+// root of the config files (e.g. the clone of --vault-config-git) if it is set, the
+// --config-literal configurations have no file
+func configFileLabel(config *viper.Viper, root string) string {
+	configFile := config.ConfigFileUsed()
+	if configFile == "" {
+		return cfgConfigLiteral
+	}
+	if root != "" {
+		if relative, err := filepath.Rel(root, configFile); err == nil && !strings.HasPrefix(relative, "..") {
+			return relative
+		}
+	}
+	return configFile
+}
+
+// recordDrift updates the drift gauges of every resource type
+func recordDrift(drift vault.Drift) {
+	for _, resourceType := range vault.DriftResourceTypes {
+		configDrift.WithLabelValues(resourceType).Set(float64(len(drift[resourceType])))
+	}
+}
+
+const defaultMetricsPath = "/metrics"
+
+// metricsServer returns the server of the registered metrics on the address, with the /healthz
+// and /readyz probes of the health checker if it isn't nil
+func metricsServer(address string, health *healthChecker) *http.Server {
+	engine := gin.New()
+	engine.Use(gin.Logger(), gin.ErrorLogger())
+	engine.GET(defaultMetricsPath, gin.WrapH(promhttp.Handler()))
+	if health != nil {
+		addHealthChecks(engine, health)
+	}
+	return &http.Server{Addr: address, Handler: engine}
 }
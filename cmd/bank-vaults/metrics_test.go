@@ -0,0 +1,230 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// kvMetricSamples returns the sample count of the kv operation histogram for the labels
+func kvMetricSamples(t *testing.T, op, backend string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "bank_vaults_kv_operation_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["op"] == op && labels["backend"] == backend {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestInstrumentedKV(t *testing.T) {
+	store := newInstrumentedKV(memory.New(nil), "memory")
+
+	if err := store.Set("vault-root", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("vault-root"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("vault-unseal-0"); err == nil {
+		t.Fatal("expected not found error")
+	}
+
+	if samples := kvMetricSamples(t, "get", "memory"); samples != 2 {
+		t.Fatalf("expected 2 get operations to be recorded, got %d", samples)
+	}
+	if samples := kvMetricSamples(t, "set", "memory"); samples != 1 {
+		t.Fatalf("expected 1 set operation to be recorded, got %d", samples)
+	}
+
+	if count := testutil.ToFloat64(kvOperationErrors.WithLabelValues("get", "memory")); count != 0 {
+		t.Fatalf("missing keys shouldn't be counted as errors, got %v", count)
+	}
+}
+
+func TestConfigureMetrics(t *testing.T) {
+	total := testutil.ToFloat64(configureTotal)
+	errorsTotal := testutil.ToFloat64(configureErrors)
+
+	v := &mockVault{}
+	if ok, _ := configureVault(context.Background(), v, viper.New(), nil, true); !ok {
+		t.Fatal("expected the configuration to be applied")
+	}
+
+	if since := testutil.ToFloat64(configureSinceLastSuccess); since > 60 {
+		t.Fatalf("expected the successful run to reset the time since the last success, got %v", since)
+	}
+
+	recordConfigureSuccess(time.Now().Add(-time.Hour))
+	v.configureErr = errors.New("permission denied")
+	failing := viper.New()
+	failing.SetConfigFile("vault-config.yml")
+	if ok, _ := configureVault(context.Background(), v, failing, nil, true); ok {
+		t.Fatal("expected the configuration to fail")
+	}
+	if count := testutil.ToFloat64(configureFileErrors.WithLabelValues("vault-config.yml")); count != 1 {
+		t.Fatalf("expected the failed run to be counted for its config file, got %v", count)
+	}
+	if since := testutil.ToFloat64(configureSinceLastSuccess); since < 3600 {
+		t.Fatalf("expected a failed run not to reset the time since the last success, got %v", since)
+	}
+
+	if count := testutil.ToFloat64(configureTotal) - total; count != 2 {
+		t.Fatalf("expected 2 configuration runs to be counted, got %v", count)
+	}
+	if count := testutil.ToFloat64(configureErrors) - errorsTotal; count != 1 {
+		t.Fatalf("expected 1 failed configuration run to be counted, got %v", count)
+	}
+	if sealed := testutil.ToFloat64(vaultSealed); sealed != 0 {
+		t.Fatalf("expected vault to be reported as unsealed, got %v", sealed)
+	}
+
+	// scraped from the metrics server
+	server := httptest.NewServer(metricsServer(":0", nil).Handler)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + defaultMetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []string{"bank_vaults_configure_total", "bank_vaults_configure_errors_total", "bank_vaults_vault_sealed", "bank_vaults_configure_duration_seconds_count", "bank_vaults_configure_seconds_since_last_success"} {
+		if !strings.Contains(string(body), metric) {
+			t.Fatalf("expected %s to be exposed", metric)
+		}
+	}
+}
+
+func TestUnsealMetrics(t *testing.T) {
+	errorsTotal := testutil.ToFloat64(unsealErrors)
+	attemptsTotal := testutil.ToFloat64(unsealAttempts)
+
+	server := httptest.NewServer(metricsServer(":0", nil).Handler)
+	defer server.Close()
+
+	scrape := func() string {
+		resp, err := server.Client().Get(server.URL + defaultMetricsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	v := &mockVault{sealed: true, unsealErr: errors.New("no unseal keys")}
+	if err := unsealVault(v, nil); err == nil {
+		t.Fatal("expected the unseal to fail")
+	}
+	if metrics := scrape(); !strings.Contains(metrics, "bank_vaults_vault_sealed 1") {
+		t.Fatal("expected vault to be reported as sealed")
+	}
+	if count := testutil.ToFloat64(unsealErrors) - errorsTotal; count != 1 {
+		t.Fatalf("expected 1 failed unseal to be counted, got %v", count)
+	}
+
+	v.unsealErr = nil
+	if err := unsealVault(v, nil); err != nil {
+		t.Fatal(err)
+	}
+	metrics := scrape()
+	if !strings.Contains(metrics, "bank_vaults_vault_sealed 0") {
+		t.Fatal("expected vault to be reported as unsealed")
+	}
+	if !strings.Contains(metrics, "bank_vaults_unseal_duration_seconds_count") {
+		t.Fatal("expected the unseal duration to be exposed")
+	}
+
+	if count := testutil.ToFloat64(unsealAttempts) - attemptsTotal; count != 2 {
+		t.Fatalf("expected 2 unseal attempts to be counted, got %v", count)
+	}
+
+	// the next check finds vault unsealed
+	if err := unsealVault(v, nil); err != nil || v.sealedChecks != 3 {
+		t.Fatalf("expected vault to be checked again, got %d checks: %v", v.sealedChecks, err)
+	}
+}
+
+func TestMetricsListenAddressAlias(t *testing.T) {
+	defer appConfig.Set(cfgMetricsAddress, nil)
+
+	for _, args := range [][]string{
+		{"--metrics-listen-address", ":9102"},
+		{"--metrics-address", ":9102"},
+		{"--metrics-address", ":9102", "--metrics-listen-address", ":9103"},
+	} {
+		flags := pflag.NewFlagSet("configure", pflag.ContinueOnError)
+		addMetricsAddressFlags(flags, ":9091", "")
+
+		err := flags.Parse(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bindMetricsAddress(flags)
+		if address := appConfig.GetString(cfgMetricsAddress); address != ":9102" {
+			t.Fatalf("expected %v to set --%s to :9102, got %q", args, cfgMetricsAddress, address)
+		}
+	}
+}
+
+func TestConfigFileLabel(t *testing.T) {
+	config := viper.New()
+	if label := configFileLabel(config, ""); label != cfgConfigLiteral {
+		t.Fatalf("expected the label of a config literal, got %s", label)
+	}
+
+	root := filepath.Join(os.TempDir(), "bank-vaults-config-0123456789abcdef")
+	config.SetConfigFile(filepath.Join(root, "vault", "vault-config.yml"))
+	if label := configFileLabel(config, root); label != filepath.Join("vault", "vault-config.yml") {
+		t.Fatalf("expected the path relative to the clone, got %s", label)
+	}
+	if label := configFileLabel(config, ""); label != config.ConfigFileUsed() {
+		t.Fatalf("expected the path of the config file without a root, got %s", label)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgSealType = "seal-type"
+
+var migrateSealCmd = &cobra.Command{
+	Use:   "migrate-seal",
+	Short: "Migrates the seal of the target Vault instance between Shamir and auto-unseal",
+	Long: `This command performs the migrate unseal flow (sys/unseal with migrate) on the target Vault
+instance, which has to be restarted with the new seal stanza and the old seal stanza marked as
+disabled = "true" first. With an auto-unseal --seal-type (e.g. awskms) the stored Shamir unseal keys
+are sent and stored as the recovery keys (vault-recovery-N) afterwards, with --seal-type shamir the
+stored recovery keys are sent and stored as the unseal keys (vault-unseal-N) afterwards.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgSealType, cmd.PersistentFlags().Lookup(cfgSealType))
+
+		sealType := appConfig.GetString(cfgSealType)
+		if sealType == "" {
+			logrus.Fatalf("--%s has to be set", cfgSealType)
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		if err = v.MigrateSeal(sealType); err != nil {
+			logrus.Fatalf("error migrating the seal: %s", err.Error())
+		}
+	},
+}
+
+func init() {
+	migrateSealCmd.PersistentFlags().String(cfgSealType, "", "The type of the new seal Vault was restarted with (e.g. awskms, gcpckms, azurekeyvault, transit or shamir)")
+
+	rootCmd.AddCommand(migrateSealCmd)
+}
@@ -0,0 +1,187 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/versioned"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfgSourceMode = "source-mode"
+const cfgTargetMode = "target-mode"
+const cfgSourceConfig = "source-config"
+const cfgTargetConfig = "target-config"
+const cfgDeleteSource = "delete-source"
+const cfgMigrateKeyVersions = "key-versions"
+
+var migrateKeysCmd = &cobra.Command{
+	Use:   "migrate-keys",
+	Short: "Migrates the unseal keys and the root token between two kv backends",
+	Long: `This command reads the keys bank-vaults stores (the root token, the unseal and the
+recovery key shares of --secret-shares, their previous versions up to --key-versions and the
+--extra-key keys) from the --source-mode kv backend (decrypted by its
+encryption wrapper, e.g. AWS KMS), writes them to the --target-mode kv backend (encrypted
+by its own wrapper), and reads every key back from the target to verify it decrypts to the
+same value. Only once all the keys have been verified are the source copies deleted, if
+--delete-source is set.
+
+The flags of the backends (e.g. aws-s3-bucket, ...) are taken from the command line, or
+from the YAML/JSON files of --source-config and --target-config when the two backends need
+different values of the same flag.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgSourceMode, cmd.PersistentFlags().Lookup(cfgSourceMode))
+		appConfig.BindPFlag(cfgTargetMode, cmd.PersistentFlags().Lookup(cfgTargetMode))
+		appConfig.BindPFlag(cfgSourceConfig, cmd.PersistentFlags().Lookup(cfgSourceConfig))
+		appConfig.BindPFlag(cfgTargetConfig, cmd.PersistentFlags().Lookup(cfgTargetConfig))
+		appConfig.BindPFlag(cfgDeleteSource, cmd.PersistentFlags().Lookup(cfgDeleteSource))
+		appConfig.BindPFlag(cfgMigrateKeyVersions, cmd.PersistentFlags().Lookup(cfgMigrateKeyVersions))
+		appConfig.BindPFlag(cfgRekeyExtraKey, cmd.PersistentFlags().Lookup(cfgRekeyExtraKey))
+
+		sourceMode := appConfig.GetString(cfgSourceMode)
+		targetMode := appConfig.GetString(cfgTargetMode)
+		if sourceMode == "" || targetMode == "" {
+			logrus.Fatalf("--%s and --%s have to be set", cfgSourceMode, cfgTargetMode)
+		}
+
+		src, err := kvStoreForMigration(sourceMode, appConfig.GetString(cfgSourceConfig))
+		if err != nil {
+			logrus.Fatalf("error creating source kv store: %s", err.Error())
+		}
+
+		dst, err := kvStoreForMigration(targetMode, appConfig.GetString(cfgTargetConfig))
+		if err != nil {
+			logrus.Fatalf("error creating target kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		// the other keys of the backend (e.g. the raft snapshots) are left alone
+		keys := append(migrationKeys(vaultConfig, appConfig.GetInt(cfgMigrateKeyVersions)), appConfig.GetStringSlice(cfgRekeyExtraKey)...)
+
+		migrated, err := migrateKeys(keys, src, dst, appConfig.GetBool(cfgDeleteSource))
+		if err != nil {
+			logrus.Fatalf("error migrating keys after %d migrated: %s", len(migrated), err.Error())
+		}
+
+		logrus.Infof("migrated %d keys from %s to %s", len(migrated), sourceMode, targetMode)
+	},
+}
+
+// migrationKeys returns the keys bank-vaults stores: the root token, the unseal and the recovery
+// key shares, and their previous versions up to the given version (kept by --kv-keep-versions)
+func migrationKeys(config vault.Config, versions int) []string {
+	keys := vault.StoredKeys(config)
+	for i := 0; i < config.SecretShares; i++ {
+		keys = append(keys, fmt.Sprint("vault-recovery-", i))
+	}
+
+	migrated := make([]string, 0, len(keys)*(versions+1))
+	for _, key := range keys {
+		migrated = append(migrated, key)
+		for version := 1; version <= versions; version++ {
+			migrated = append(migrated, versioned.VersionKey(key, version))
+		}
+	}
+	return migrated
+}
+
+// kvStoreForMigration creates the kv store of the mode, the other values are taken from the
+// backend config file if it is set, and from the application config otherwise
+func kvStoreForMigration(mode, configFile string) (kv.Service, error) {
+	cfg := viper.New()
+	for _, key := range appConfig.AllKeys() {
+		cfg.SetDefault(key, appConfig.Get(key))
+	}
+
+	if configFile != "" {
+		cfg.SetConfigFile(configFile)
+		err := cfg.ReadInConfig()
+		if err != nil {
+			return nil, fmt.Errorf("error reading kv store config file %s: %s", configFile, err.Error())
+		}
+	}
+	cfg.Set(cfgMode, mode)
+
+	return kvStoreForConfig(cfg)
+}
+
+// migrateKeys copies the keys from src to dst and verifies that every key reads back from dst
+// with the same value, the source copies are only deleted after all the keys were verified
+func migrateKeys(keys []string, src, dst kv.Service, deleteSource bool) ([]string, error) {
+	var migrated []string
+
+	for _, key := range keys {
+		value, found, err := getIfFound(src, key)
+		if err != nil {
+			return migrated, fmt.Errorf("error reading '%s' from source: %s", key, err.Error())
+		}
+		if !found {
+			logrus.Warnf("key '%s' is not present, skipping", key)
+			continue
+		}
+
+		err = dst.Set(key, value)
+		if err != nil {
+			return migrated, fmt.Errorf("error writing '%s' to target: %s", key, err.Error())
+		}
+
+		written, err := dst.Get(key)
+		if err != nil {
+			return migrated, fmt.Errorf("error reading back '%s' from target: %s", key, err.Error())
+		}
+		if !bytes.Equal(written, value) {
+			return migrated, fmt.Errorf("key '%s' doesn't round-trip after writing it to target", key)
+		}
+
+		logrus.Infof("migrated '%s'", key)
+		migrated = append(migrated, key)
+	}
+
+	if !deleteSource {
+		return migrated, nil
+	}
+
+	for _, key := range migrated {
+		err := kv.Delete(src, key)
+		if err != nil {
+			return migrated, fmt.Errorf("error deleting '%s' from source: %s", key, err.Error())
+		}
+		logrus.Infof("deleted '%s' from source", key)
+	}
+
+	return migrated, nil
+}
+
+func init() {
+	migrateKeysCmd.PersistentFlags().String(cfgSourceMode, "", "The kv backend mode to migrate the keys from")
+	migrateKeysCmd.PersistentFlags().String(cfgTargetMode, "", "The kv backend mode to migrate the keys to")
+	migrateKeysCmd.PersistentFlags().String(cfgSourceConfig, "", "YAML/JSON file with the flags of the source kv backend")
+	migrateKeysCmd.PersistentFlags().String(cfgTargetConfig, "", "YAML/JSON file with the flags of the target kv backend")
+	migrateKeysCmd.PersistentFlags().Bool(cfgDeleteSource, false, "Delete the keys from the source kv backend after they were verified in the target")
+	migrateKeysCmd.PersistentFlags().Int(cfgMigrateKeyVersions, 0, "How many previous versions of every key (kept by --"+cfgKVKeepVersions+") are migrated too")
+	migrateKeysCmd.PersistentFlags().StringSlice(cfgRekeyExtraKey, nil, "Other keys to migrate besides the root token and the key shares (e.g. vault-seal-key-id)")
+
+	rootCmd.AddCommand(migrateKeysCmd)
+}
@@ -0,0 +1,102 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+)
+
+// corruptingKV is a target store which doesn't read back what was written
+type corruptingKV struct {
+	*memory.Store
+}
+
+func (c *corruptingKV) Set(key string, val []byte) error {
+	return c.Store.Set(key, append([]byte("corrupted-"), val...))
+}
+
+func TestMigrateKeys(t *testing.T) {
+	src := memory.New(map[string][]byte{
+		"vault-root":     []byte("root"),
+		"vault-unseal-0": []byte("key0"),
+	})
+	dst := memory.New(nil)
+
+	keys := []string{"vault-root", "vault-unseal-0", "vault-unseal-1"}
+	migrated, err := migrateKeys(keys, src, dst, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrated) != 2 {
+		t.Fatalf("expected the 2 present keys to be migrated, got %v", migrated)
+	}
+
+	for key, value := range map[string]string{"vault-root": "root", "vault-unseal-0": "key0"} {
+		if got, err := dst.Get(key); err != nil || string(got) != value {
+			t.Errorf("expected %s to be %s in the target, got %s (%v)", key, value, got, err)
+		}
+	}
+	if len(src.Values()) != 0 {
+		t.Errorf("expected the source copies to be deleted, got %v", src.Values())
+	}
+}
+
+func TestMigrateKeysVerificationFailure(t *testing.T) {
+	src := memory.New(map[string][]byte{
+		"vault-root":     []byte("root"),
+		"vault-unseal-0": []byte("key0"),
+	})
+	dst := &corruptingKV{memory.New(nil)}
+
+	_, err := migrateKeys([]string{"vault-root", "vault-unseal-0"}, src, dst, true)
+	if err == nil {
+		t.Fatal("expected the verification of the target to fail")
+	}
+	if len(src.Values()) != 2 {
+		t.Errorf("expected the source copies to be kept, got %v", src.Values())
+	}
+}
+
+func TestMigrationKeys(t *testing.T) {
+	keys := migrationKeys(vault.Config{SecretShares: 2}, 1)
+	expected := []string{
+		"vault-root", "vault-root.v1",
+		"vault-unseal-0", "vault-unseal-0.v1",
+		"vault-unseal-1", "vault-unseal-1.v1",
+		"vault-recovery-0", "vault-recovery-0.v1",
+		"vault-recovery-1", "vault-recovery-1.v1",
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("unexpected migration keys: %v", keys)
+	}
+
+	// the other keys of the backend aren't migrated
+	src := memory.New(map[string][]byte{
+		"vault-root":     []byte("root"),
+		"vault-unseal-0": []byte("key0"),
+		"vault-raft-snapshot-20190301T123000Z.snap": []byte("snapshot"),
+	})
+	dst := memory.New(nil)
+	if _, err := migrateKeys(keys, src, dst, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.Values()) != 2 {
+		t.Fatalf("expected only the keys of bank-vaults to be migrated, got %v", dst.Values())
+	}
+}
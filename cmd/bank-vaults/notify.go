@@ -0,0 +1,114 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sealTransition is the JSON payload posted to the seal transition webhook
+type sealTransition struct {
+	Address   string `json:"address"`
+	Sealed    bool   `json:"sealed"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Timestamp string `json:"timestamp"`
+}
+
+// sealTransitionNotifier posts to a webhook when the observed seal state of Vault changes,
+// a new state is only reported once it has been observed for the debounce period, so a
+// flapping Vault doesn't flood the webhook. The initial state is not reported.
+type sealTransitionNotifier struct {
+	url      string
+	address  string
+	debounce time.Duration
+	client   *http.Client
+
+	observed bool
+	reported bool
+	pending  *time.Time
+}
+
+func newSealTransitionNotifier(url, address string, debounce time.Duration) *sealTransitionNotifier {
+	return &sealTransitionNotifier{url: url, address: address, debounce: debounce, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func sealState(sealed bool) string {
+	if sealed {
+		return "sealed"
+	}
+	return "unsealed"
+}
+
+// observe records the seal state observed at the given time, and posts the transition if there is one
+func (n *sealTransitionNotifier) observe(sealed bool, now time.Time) {
+	if !n.observed {
+		n.observed = true
+		n.reported = sealed
+		return
+	}
+
+	if sealed == n.reported {
+		n.pending = nil
+		return
+	}
+
+	if n.pending == nil {
+		n.pending = &now
+	}
+	if now.Sub(*n.pending) < n.debounce {
+		return
+	}
+
+	n.pending = nil
+	n.reported = sealed
+
+	err := n.post(sealTransition{
+		Address:   n.address,
+		Sealed:    sealed,
+		From:      sealState(!sealed),
+		To:        sealState(sealed),
+		Timestamp: now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		logrus.Errorf("error posting seal transition to webhook: %s", err.Error())
+	}
+}
+
+func (n *sealTransitionNotifier) post(transition sealTransition) error {
+	body, err := json.Marshal(transition)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	logrus.Infof("posted seal transition %s -> %s to webhook", transition.From, transition.To)
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSealTransitionNotifier(t *testing.T) {
+	var transitions []sealTransition
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var transition sealTransition
+		if err := json.NewDecoder(r.Body).Decode(&transition); err != nil {
+			t.Error(err)
+		}
+		transitions = append(transitions, transition)
+	}))
+	defer webhook.Close()
+
+	notifier := newSealTransitionNotifier(webhook.URL, "https://vault:8200", 10*time.Second)
+	start := time.Now()
+
+	// the initial and the steady state are not reported
+	notifier.observe(true, start)
+	notifier.observe(true, start.Add(30*time.Second))
+	if len(transitions) != 0 {
+		t.Fatalf("unexpected transitions on steady state: %v", transitions)
+	}
+
+	// flapping within the debounce period is not reported
+	notifier.observe(false, start.Add(31*time.Second))
+	notifier.observe(true, start.Add(35*time.Second))
+	notifier.observe(false, start.Add(36*time.Second))
+	if len(transitions) != 0 {
+		t.Fatalf("unexpected transitions while flapping: %v", transitions)
+	}
+
+	notifier.observe(false, start.Add(46*time.Second))
+	notifier.observe(false, start.Add(76*time.Second))
+	if len(transitions) != 1 {
+		t.Fatalf("expected exactly one transition, got: %v", transitions)
+	}
+	transition := transitions[0]
+	if transition.Sealed || transition.From != "sealed" || transition.To != "unsealed" || transition.Address != "https://vault:8200" {
+		t.Fatalf("unexpected transition: %+v", transition)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var generatePolicyCmd = &cobra.Command{
+	Use:   "generate-policy",
+	Short: "Prints the ACL policy bank-vaults needs to apply the configuration files",
+	Long: `This command reads the configuration files without connecting to Vault and prints the
+minimal ACL policy (paths and capabilities) a token needs to apply all of them with configure,
+so the token of bank-vaults can be scoped instead of using the root token. The pruning, purge
+and verification flags of configure add the capabilities of those operations.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgPruneSecrets, cmd.PersistentFlags().Lookup(cfgPruneSecrets))
+		appConfig.BindPFlag(cfgPruneAuth, cmd.PersistentFlags().Lookup(cfgPruneAuth))
+		appConfig.BindPFlag(cfgRevokeLeasesOnDisable, cmd.PersistentFlags().Lookup(cfgRevokeLeasesOnDisable))
+		appConfig.BindPFlag(cfgConfirmIdentityPurge, cmd.PersistentFlags().Lookup(cfgConfirmIdentityPurge))
+		appConfig.BindPFlag(cfgVerifyAccess, cmd.PersistentFlags().Lookup(cfgVerifyAccess))
+		appConfig.BindPFlag(cfgAtomicPolicies, cmd.PersistentFlags().Lookup(cfgAtomicPolicies))
+
+		options := vault.Config{
+			PruneSecrets:          appConfig.GetBool(cfgPruneSecrets),
+			PruneAuth:             appConfig.GetBool(cfgPruneAuth),
+			RevokeLeasesOnDisable: appConfig.GetBool(cfgRevokeLeasesOnDisable),
+			ConfirmIdentityPurge:  appConfig.GetBool(cfgConfirmIdentityPurge),
+			VerifyAccess:          appConfig.GetBool(cfgVerifyAccess),
+			AtomicPolicies:        appConfig.GetBool(cfgAtomicPolicies),
+		}
+
+		policy, err := generatePolicy(appConfig.GetStringSlice(cfgVaultConfigFile), options)
+
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		fmt.Print(policy)
+	},
+}
+
+// generatePolicy returns the ACL policy needed to apply all the configuration files
+func generatePolicy(vaultConfigFiles []string, options vault.Config) (string, error) {
+	rules, err := requiredPolicyRules(vaultConfigFiles, options)
+	if err != nil {
+		return "", err
+	}
+	return rules.HCL(), nil
+}
+
+// requiredPolicyRules returns the ACL rules needed to apply all the configuration files
+func requiredPolicyRules(vaultConfigFiles []string, options vault.Config) (vault.PolicyRules, error) {
+	rules := vault.PolicyRules{}
+	for _, vaultConfigFile := range vaultConfigFiles {
+		config, err := readConfiguration(vaultConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", vaultConfigFile, err.Error())
+		}
+		rules = vault.RequiredPolicy(config, options, rules)
+
+		// the secrets referenced by the vault function of the config template are read too
+		paths, err := vaultReferencePaths(vaultConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %s", vaultConfigFile, err.Error())
+		}
+		for _, path := range paths {
+			if rules[path] == nil {
+				rules[path] = map[string]bool{}
+			}
+			rules[path]["read"] = true
+		}
+	}
+	return rules, nil
+}
+
+func init() {
+	generatePolicyCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+	generatePolicyCmd.PersistentFlags().Bool(cfgPruneSecrets, false, "Include the capabilities of configure --"+cfgPruneSecrets)
+	generatePolicyCmd.PersistentFlags().Bool(cfgPruneAuth, false, "Include the capabilities of configure --"+cfgPruneAuth)
+	generatePolicyCmd.PersistentFlags().Bool(cfgRevokeLeasesOnDisable, false, "Include the capabilities of configure --"+cfgRevokeLeasesOnDisable)
+	generatePolicyCmd.PersistentFlags().Bool(cfgConfirmIdentityPurge, false, "Include the capabilities of configure --"+cfgConfirmIdentityPurge)
+	generatePolicyCmd.PersistentFlags().Bool(cfgVerifyAccess, false, "Include the capabilities of configure --"+cfgVerifyAccess)
+	generatePolicyCmd.PersistentFlags().Bool(cfgAtomicPolicies, false, "Include the capabilities of configure --"+cfgAtomicPolicies)
+
+	rootCmd.AddCommand(generatePolicyCmd)
+}
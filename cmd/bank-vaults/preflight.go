@@ -0,0 +1,73 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgPreflightKey = "preflight-key"
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Checks that the cloud identity has the permissions the kv backend needs",
+	Long: `This command probes the operations the kv backend of --mode needs with a temporary key,
+before the first unseal: e.g. for AWS the caller identity (sts:GetCallerIdentity), the KMS key
+(kms:Encrypt, kms:Decrypt) and the bucket (s3:PutObject, s3:GetObject). The other backends
+are probed by writing and reading the key. It reports the first missing permission and exits with 1.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgPreflightKey, cmd.PersistentFlags().Lookup(cfgPreflightKey))
+
+		// the backend is probed without the caching and versioning layers
+		store, err := kvStoreForMode(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		if !preflight(store, appConfig.GetString(cfgPreflightKey), os.Stdout) {
+			exitCode = 1
+		}
+	},
+}
+
+// preflight prints the result of the probed operations of the kv store, it returns false
+// if one of them failed
+func preflight(store kv.Service, probeKey string, out io.Writer) bool {
+	for _, check := range kv.Preflight(store, probeKey) {
+		operation := check.Permission
+		if check.Resource != "" {
+			operation = fmt.Sprintf("%s on %s", check.Permission, check.Resource)
+		}
+		if check.Err != nil {
+			fmt.Fprintf(out, "FAILED %s, the %s permission is missing or denied: %s\n", operation, check.Permission, check.Err.Error())
+			return false
+		}
+		fmt.Fprintf(out, "OK     %s\n", operation)
+	}
+	return true
+}
+
+func init() {
+	preflightCmd.PersistentFlags().String(cfgPreflightKey, "bank-vaults-preflight", "The temporary key written and read to probe the kv backend")
+
+	rootCmd.AddCommand(preflightCmd)
+}
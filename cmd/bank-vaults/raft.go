@@ -0,0 +1,346 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabaoss"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const cfgRaftLeaderAddress = "raft-leader-address"
+const cfgRaftRemoveDeadPeers = "raft-remove-dead-peers"
+const cfgRaftDeadPeerGracePeriod = "raft-dead-peer-grace-period"
+const cfgRaftSnapshotPeriod = "snapshot-period"
+const cfgRaftSnapshotDir = "snapshot-dir"
+
+// raftSnapshotKeyPrefix is the prefix of the keys (or the file names) of the raft snapshots
+const raftSnapshotKeyPrefix = "vault-raft-snapshot-"
+
+var raftCmd = &cobra.Command{
+	Use:   "raft",
+	Short: "Manages the Integrated Storage (raft) of Vault",
+}
+
+var raftSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Takes snapshots of the raft storage of Vault and uploads them to the blob storage of the kv backend",
+	Long: `This command takes a snapshot of the raft storage of Vault with the root token and
+uploads it to the blob storage of --mode (the bucket of the GCS, S3 or OSS modes, without
+the KMS encryption, the snapshots are encrypted by Vault), or writes it to --snapshot-dir.
+The snapshots are taken once, or periodically with --snapshot-period, every snapshot has
+its own timestamped key, the old ones should be expired by the lifecycle of the bucket.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgRaftSnapshotPeriod, cmd.PersistentFlags().Lookup(cfgRaftSnapshotPeriod))
+		appConfig.BindPFlag(cfgRaftSnapshotDir, cmd.PersistentFlags().Lookup(cfgRaftSnapshotDir))
+
+		var snapshots kv.Service
+		var err error
+		if dir := appConfig.GetString(cfgRaftSnapshotDir); dir != "" {
+			snapshots, err = file.New(dir)
+		} else {
+			snapshots, err = raftSnapshotStore(appConfig)
+		}
+		if err != nil {
+			logrus.Fatalf("error creating the store of the raft snapshots: %s", err.Error())
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		period := appConfig.GetDuration(cfgRaftSnapshotPeriod)
+		for {
+			key, err := takeRaftSnapshot(v, snapshots, time.Now())
+			if err != nil {
+				logrus.Error(err.Error())
+				if period <= 0 {
+					exitCode = 1
+					return
+				}
+			} else {
+				logrus.Infof("stored raft snapshot %s", key)
+			}
+
+			if period <= 0 {
+				return
+			}
+			time.Sleep(period)
+		}
+	},
+}
+
+// raftSnapshotKey is the key of the raft snapshot taken at the time
+func raftSnapshotKey(now time.Time) string {
+	return raftSnapshotKeyPrefix + now.UTC().Format("20060102T150405Z") + ".snap"
+}
+
+// takeRaftSnapshot takes a snapshot of the raft storage and stores it under its timestamped key
+func takeRaftSnapshot(v vault.Vault, snapshots kv.Service, now time.Time) (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	err := v.RaftSnapshot(buffer)
+	if err != nil {
+		return "", err
+	}
+
+	key := raftSnapshotKey(now)
+	err = snapshots.Set(key, buffer.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("error storing raft snapshot '%s': %s", key, err.Error())
+	}
+	return key, nil
+}
+
+// raftSnapshotStore returns the blob storage of the kv backend of the mode, the snapshots are
+// stored without the KMS encryption of the values, which can only encrypt a few kilobytes
+func raftSnapshotStore(cfg *viper.Viper) (kv.Service, error) {
+	switch mode := cfg.GetString(cfgMode); mode {
+
+	case cfgModeValueGoogleCloudKMSGCS:
+		gcs, err := gcs.NewWithCredentialsFile(
+			cfg.GetString(cfgGoogleCloudStorageBucket),
+			cfg.GetString(cfgGoogleCloudStoragePrefix),
+			cfg.GetString(cfgKVCredentialsFile),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating google cloud storage kv store: %s", err.Error())
+		}
+		return gcs, nil
+
+	case cfgModeValueAWSKMS3:
+		s3Session, err := awsSession(cfg.GetString(cfgAWSS3Region), cfg.GetString(cfgKVCredentialsFile))
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS S3 session: %s", err.Error())
+		}
+		s3, err := s3.NewWithSession(s3Session, cfg.GetString(cfgAWSS3Bucket), cfg.GetString(cfgAWSS3Prefix))
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS S3 kv store: %s", err.Error())
+		}
+		return s3, nil
+
+	case cfgModeValueAlibabaKMSOSS:
+		oss, err := alibabaoss.New(
+			cfg.GetString(cfgAlibabaOSSEndpoint),
+			cfg.GetString(cfgAlibabaAccessKeyID),
+			cfg.GetString(cfgAlibabaAccessKeySecret),
+			cfg.GetString(cfgAlibabaOSSBucket),
+			cfg.GetString(cfgAlibabaOSSPrefix),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Alibaba OSS kv store: %s", err.Error())
+		}
+		return oss, nil
+
+	case cfgModeValueVaultTransit:
+		if cfg.GetString(cfgVaultTransitStorage) == cfgVaultTransitStorageValueK8S {
+			return nil, fmt.Errorf("the Kubernetes Secrets are too small for the raft snapshots, set --%s (e.g. to a persistent volume)", cfgRaftSnapshotDir)
+		}
+		return vaultTransitStorage(cfg)
+
+	case cfgModeValueK8S:
+		return nil, fmt.Errorf("the Kubernetes Secrets are too small for the raft snapshots, set --%s (e.g. to a persistent volume)", cfgRaftSnapshotDir)
+
+	case cfgModeValueConsul:
+		return nil, fmt.Errorf("the values of Consul are too small for the raft snapshots, set --%s (e.g. to a persistent volume)", cfgRaftSnapshotDir)
+
+	case cfgModeValueAzureKeyVault:
+		return nil, fmt.Errorf("the secrets of Azure Key Vault are too small for the raft snapshots, set --%s (e.g. to an Azure Files volume)", cfgRaftSnapshotDir)
+
+	default:
+		return kvStoreForMode(cfg)
+	}
+}
+
+// deadRaftPeers removes the servers from the raft cluster whose pods don't exist anymore, the
+// node ids of the servers have to be the names of the pods of a StatefulSet. A pod is missing
+// for a while when it is restarted or evicted, it keeps its raft data and rejoins as the same
+// peer, so a peer is only removed once its pod has been missing for the grace period and its
+// ordinal isn't below the replicas of the StatefulSet (it was scaled down).
+type deadRaftPeers struct {
+	podExists func(name string) (bool, error)
+	// the replicas of the StatefulSet
+	replicas    func(statefulSet string) (int, error)
+	gracePeriod time.Duration
+	// when the pods of the peers were found missing first
+	missingSince map[string]time.Time
+}
+
+func newDeadRaftPeers(podExists func(name string) (bool, error), replicas func(statefulSet string) (int, error), gracePeriod time.Duration) *deadRaftPeers {
+	return &deadRaftPeers{
+		podExists:    podExists,
+		replicas:     replicas,
+		gracePeriod:  gracePeriod,
+		missingSince: map[string]time.Time{},
+	}
+}
+
+// remove removes the dead peers, only the leader removes them
+func (d *deadRaftPeers) remove(v vault.Vault, now time.Time) error {
+	leader, err := v.Leader()
+	if err != nil {
+		return err
+	}
+	if !leader {
+		d.missingSince = map[string]time.Time{}
+		return nil
+	}
+
+	peers, err := v.RaftPeers()
+	if err != nil {
+		return err
+	}
+
+	missing := map[string]time.Time{}
+	for _, peer := range peers {
+		if peer.Leader {
+			continue
+		}
+		exists, err := d.podExists(peer.NodeID)
+		if err != nil {
+			return fmt.Errorf("error checking the pod of raft peer %s: %s", peer.NodeID, err.Error())
+		}
+		if exists {
+			continue
+		}
+
+		since, ok := d.missingSince[peer.NodeID]
+		if !ok {
+			since = now
+		}
+		missing[peer.NodeID] = since
+		if now.Sub(since) < d.gracePeriod {
+			continue
+		}
+
+		statefulSet, ordinal, ok := statefulSetPod(peer.NodeID)
+		if !ok {
+			logrus.Warnf("the pod of raft peer %s doesn't exist, it isn't removed, its node id isn't the name of a StatefulSet pod", peer.NodeID)
+			continue
+		}
+		replicas, err := d.replicas(statefulSet)
+		if err != nil {
+			return fmt.Errorf("error getting the replicas of StatefulSet %s: %s", statefulSet, err.Error())
+		}
+		if ordinal < replicas {
+			logrus.Warnf("the pod of raft peer %s has been missing for %s, it isn't removed, StatefulSet %s still has %d replicas", peer.NodeID, now.Sub(since), statefulSet, replicas)
+			continue
+		}
+
+		logrus.Infof("removing raft peer %s (%s), its pod doesn't exist anymore", peer.NodeID, peer.Address)
+		err = v.RaftRemovePeer(peer.NodeID)
+		if err != nil {
+			return err
+		}
+		delete(missing, peer.NodeID)
+	}
+	d.missingSince = missing
+	return nil
+}
+
+// statefulSetPod returns the StatefulSet and the ordinal of the pod name, e.g. vault and 2 of vault-2
+func statefulSetPod(name string) (string, int, bool) {
+	i := strings.LastIndex(name, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+	ordinal, err := strconv.Atoi(name[i+1:])
+	if err != nil || ordinal < 0 {
+		return "", 0, false
+	}
+	return name[:i], ordinal, true
+}
+
+// isRaftLeaderAddress tells if the leader API address is the address of this pod (its host is the
+// hostname, or starts with it, e.g. vault-0.vault in the pod vault-0 of a StatefulSet), the
+// leader is initialized instead of joined to itself
+func isRaftLeaderAddress(leaderAPIAddr string) bool {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false
+	}
+	leaderURL, err := url.Parse(leaderAPIAddr)
+	if err != nil {
+		return false
+	}
+	host := leaderURL.Hostname()
+	return host == hostname || strings.HasPrefix(host, hostname+".")
+}
+
+// podExistsInNamespace tells if the pod exists in the namespace
+func podExistsInNamespace(client kubernetes.Interface, namespace string) func(name string) (bool, error) {
+	return func(name string) (bool, error) {
+		_, err := client.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// statefulSetReplicas returns the replicas of the StatefulSets in the namespace
+func statefulSetReplicas(client kubernetes.Interface, namespace string) func(name string) (int, error) {
+	return func(name string) (int, error) {
+		statefulSet, err := client.AppsV1().StatefulSets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		if statefulSet.Spec.Replicas == nil {
+			return 1, nil
+		}
+		return int(*statefulSet.Spec.Replicas), nil
+	}
+}
+
+func init() {
+	raftSnapshotCmd.PersistentFlags().Duration(cfgRaftSnapshotPeriod, 0, "Take a snapshot at this period instead of only once")
+	raftSnapshotCmd.PersistentFlags().String(cfgRaftSnapshotDir, "", "Write the snapshots to this directory instead of the blob storage of --"+cfgMode)
+
+	raftCmd.AddCommand(raftSnapshotCmd)
+	rootCmd.AddCommand(raftCmd)
+}
@@ -0,0 +1,117 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRemoveDeadRaftPeers(t *testing.T) {
+	replicas := int32(2)
+	client := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "vault-0", Namespace: "vault"}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "vault"}, Spec: appsv1.StatefulSetSpec{Replicas: &replicas}},
+	)
+	peers := []vault.RaftPeer{
+		{NodeID: "vault-0", Address: "vault-0.vault:8201", Leader: true, Voter: true},
+		{NodeID: "vault-1", Address: "vault-1.vault:8201", Voter: true},
+		{NodeID: "vault-2", Address: "vault-2.vault:8201", Voter: true},
+		{NodeID: "node-a", Address: "node-a.vault:8201", Voter: true},
+	}
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// only the leader removes the peers
+	standby := &mockVault{raftPeers: peers}
+	deadPeers := newDeadRaftPeers(podExistsInNamespace(client, "vault"), statefulSetReplicas(client, "vault"), 10*time.Minute)
+	if err := deadPeers.remove(standby, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(standby.removedPeers) != 0 {
+		t.Errorf("expected a standby not to remove peers, removed %v", standby.removedPeers)
+	}
+
+	leader := &mockVault{active: true, raftPeers: peers}
+	if err := deadPeers.remove(leader, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := deadPeers.remove(leader, now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(leader.removedPeers) != 0 {
+		t.Errorf("expected no peer to be removed within the grace period, removed %v", leader.removedPeers)
+	}
+
+	// vault-1 is below the replicas (restarted), node-a isn't a StatefulSet pod
+	if err := deadPeers.remove(leader, now.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(leader.removedPeers, []string{"vault-2"}) {
+		t.Errorf("expected the peer of the scaled down pod to be removed, removed %v", leader.removedPeers)
+	}
+}
+
+func TestTakeRaftSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshots, err := file.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := takeRaftSnapshot(&mockVault{}, snapshots, time.Date(2019, 3, 1, 12, 30, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "vault-raft-snapshot-20190301T123000Z.snap" {
+		t.Errorf("unexpected snapshot key: %s", key)
+	}
+	snapshot, err := ioutil.ReadFile(filepath.Join(dir, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(snapshot) != "snapshot" {
+		t.Errorf("unexpected snapshot: %q", snapshot)
+	}
+}
+
+func TestIsRaftLeaderAddress(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !isRaftLeaderAddress("https://" + hostname + ".vault:8200") {
+		t.Error("expected the address of the pod to be the leader address")
+	}
+	if isRaftLeaderAddress("https://" + hostname + "-other.vault:8200") {
+		t.Error("expected the address of another pod not to be the leader address")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/versioned"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgRecoverKey = "key"
+const cfgRecoverVersion = "version"
+
+var recoverShareCmd = &cobra.Command{
+	Use:   "recover-share",
+	Short: "Restores a previous version of a key in the kv backend",
+	Long: `This command restores a previous version of a key (e.g. an unseal key share
+overwritten by mistake) kept by the kv backend with --kv-keep-versions, version 1
+being the latest previous one. The replaced value is kept as the latest previous
+version, so the recovery can be undone by recovering version 1 again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgRecoverKey, cmd.PersistentFlags().Lookup(cfgRecoverKey))
+		appConfig.BindPFlag(cfgRecoverVersion, cmd.PersistentFlags().Lookup(cfgRecoverVersion))
+
+		key := appConfig.GetString(cfgRecoverKey)
+		version := appConfig.GetInt(cfgRecoverVersion)
+
+		if key == "" {
+			logrus.Fatalf("--%s has to be set", cfgRecoverKey)
+		}
+		if appConfig.GetInt(cfgKVKeepVersions) <= 0 {
+			logrus.Fatalf("recovering a key requires --%s", cfgKVKeepVersions)
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		vaultConfig.ShareStores, err = shareStoresForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating share kv stores: %s", err.Error())
+		}
+
+		err = recoverKey(vault.StoreForKey(store, vaultConfig, key), key, version)
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+		fmt.Printf("%s: restored version %d\n", key, version)
+	},
+}
+
+// recoverKey restores the version of the key in the versioned store
+func recoverKey(store kv.Service, key string, version int) error {
+	if version < 1 {
+		return fmt.Errorf("the version has to be at least 1, got %d", version)
+	}
+	err := versioned.Restore(store, key, version)
+	if err != nil {
+		return fmt.Errorf("error recovering key '%s': %s", key, err.Error())
+	}
+	return nil
+}
+
+func init() {
+	recoverShareCmd.PersistentFlags().String(cfgRecoverKey, "", "The key to restore (e.g. vault-unseal-0)")
+	recoverShareCmd.PersistentFlags().Int(cfgRecoverVersion, 1, "The previous version of the key to restore, 1 is the latest previous version")
+
+	rootCmd.AddCommand(recoverShareCmd)
+}
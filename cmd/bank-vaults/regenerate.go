@@ -0,0 +1,87 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgPrintRootToken = "print-root-token"
+
+var regenerateRootCmd = &cobra.Command{
+	Use:   "regenerate-root",
+	Short: "Generates a new root token with the stored unseal keys",
+	Long: `This command drives the generate-root flow of Vault with the unseal keys (or the
+recovery keys of an auto-unseal seal) stored in the kv backend, e.g. when a root token
+is needed again after configure --auto-revoke-root-token revoked it. The new root token
+is stored in the kv backend, the next configure run with --auto-revoke-root-token uses
+it to update the policy of its own token, then revokes it again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgStoreRootToken, cmd.PersistentFlags().Lookup(cfgStoreRootToken))
+		appConfig.BindPFlag(cfgPrintRootToken, cmd.PersistentFlags().Lookup(cfgPrintRootToken))
+
+		storeRootToken := appConfig.GetBool(cfgStoreRootToken)
+		printRootToken := appConfig.GetBool(cfgPrintRootToken)
+		if !storeRootToken && !printRootToken {
+			logrus.Fatalf("the new root token would be lost, --%s or --%s has to be set", cfgStoreRootToken, cfgPrintRootToken)
+		}
+
+		store, err := kvStoreForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		vaultConfig.ShareStores, err = shareStoresForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating share kv stores: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		rootToken, err := v.RegenerateRoot(storeRootToken)
+		if err != nil {
+			logrus.Fatalf("error regenerating the root token: %s", err.Error())
+		}
+
+		if printRootToken {
+			fmt.Println(rootToken)
+		}
+	},
+}
+
+func init() {
+	regenerateRootCmd.PersistentFlags().Bool(cfgStoreRootToken, true, "Store the new root token in the kv backend")
+	regenerateRootCmd.PersistentFlags().Bool(cfgPrintRootToken, false, "Print the new root token to the standard output")
+
+	rootCmd.AddCommand(regenerateRootCmd)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgRekeyExtraKey = "extra-key"
+
+var rekeyStoreCmd = &cobra.Command{
+	Use:   "rekey-store",
+	Short: "Re-encrypts the keys stored in the kv backend",
+	Long: `This command reads the root token and the unseal keys (and the given extra keys)
+from the kv backend and writes them back, so they get encrypted with the current
+KMS key after a key rotation. Every key is read back and verified after writing it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgRekeyExtraKey, cmd.PersistentFlags().Lookup(cfgRekeyExtraKey))
+
+		store, err := kvStoreForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		vaultConfig.ShareStores, err = shareStoresForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating share kv stores: %s", err.Error())
+		}
+
+		keys := append(vault.StoredKeys(vaultConfig), appConfig.GetStringSlice(cfgRekeyExtraKey)...)
+
+		rekeyed, err := rekeyStore(keys, func(key string) kv.Service {
+			return vault.StoreForKey(store, vaultConfig, key)
+		})
+		if err != nil {
+			logrus.Fatalf("error rekeying kv store: %s", err.Error())
+		}
+
+		logrus.Infof("rekeyed %d of %d keys", len(rekeyed), len(keys))
+	},
+}
+
+// rekeyStore re-writes every key found in its store and verifies that it round-trips,
+// it returns the rekeyed keys, the missing ones are skipped
+func rekeyStore(keys []string, storeForKey func(key string) kv.Service) ([]string, error) {
+	var rekeyed []string
+
+	for _, key := range keys {
+		store := storeForKey(key)
+
+		value, found, err := getIfFound(store, key)
+		if err != nil {
+			return rekeyed, fmt.Errorf("error reading '%s': %s", key, err.Error())
+		}
+		if !found {
+			logrus.Warnf("key '%s' is not present, skipping", key)
+			continue
+		}
+
+		err = store.Set(key, value)
+		if err != nil {
+			return rekeyed, fmt.Errorf("error writing '%s': %s", key, err.Error())
+		}
+
+		written, err := store.Get(key)
+		if err != nil {
+			return rekeyed, fmt.Errorf("error reading back '%s': %s", key, err.Error())
+		}
+		if !bytes.Equal(written, value) {
+			return rekeyed, fmt.Errorf("key '%s' doesn't round-trip after rewriting it", key)
+		}
+
+		logrus.Infof("rekeyed '%s'", key)
+		rekeyed = append(rekeyed, key)
+	}
+
+	return rekeyed, nil
+}
+
+func init() {
+	rekeyStoreCmd.PersistentFlags().StringSlice(cfgRekeyExtraKey, nil, "Other keys to rekey besides the root token and the unseal keys (e.g. vault-seal-key-id)")
+
+	rootCmd.AddCommand(rekeyStoreCmd)
+}
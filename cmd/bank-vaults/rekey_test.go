@@ -0,0 +1,74 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+)
+
+// rewritingKV counts the writes of every key
+type rewritingKV struct {
+	*memory.Store
+	writes map[string]int
+}
+
+func (r *rewritingKV) Set(key string, val []byte) error {
+	r.writes[key]++
+	return r.Store.Set(key, val)
+}
+
+func TestRekeyStore(t *testing.T) {
+	store := &rewritingKV{Store: memory.New(map[string][]byte{
+		"vault-root":        []byte("root"),
+		"vault-unseal-0":    []byte("share-0"),
+		"vault-unseal-2":    []byte("share-2"),
+		"vault-seal-key-id": []byte("key-1"),
+	}), writes: map[string]int{}}
+	shareStore := &rewritingKV{Store: memory.New(map[string][]byte{
+		"vault-unseal-1": []byte("share-1"),
+	}), writes: map[string]int{}}
+
+	config := vault.Config{SecretShares: 3, ShareStores: map[int]kv.Service{1: shareStore}}
+	keys := append(vault.StoredKeys(config), "vault-seal-key-id", "vault-missing")
+
+	rekeyed, err := rekeyStore(keys, func(key string) kv.Service {
+		return vault.StoreForKey(store, config, key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rekeyed) != 5 {
+		t.Fatalf("expected every present key to be rekeyed, got %v", rekeyed)
+	}
+	for _, key := range []string{"vault-root", "vault-unseal-0", "vault-unseal-2", "vault-seal-key-id"} {
+		if store.writes[key] != 1 {
+			t.Fatalf("expected %s to be rewritten once, got %d", key, store.writes[key])
+		}
+	}
+	if shareStore.writes["vault-unseal-1"] != 1 || store.writes["vault-unseal-1"] != 0 {
+		t.Fatal("expected the share to be rewritten in its own store")
+	}
+	if store.writes["vault-missing"] != 0 {
+		t.Fatal("a missing key shouldn't be written")
+	}
+	if value, _ := store.Get("vault-unseal-2"); string(value) != "share-2" {
+		t.Fatalf("the value should be kept, got %s", value)
+	}
+}
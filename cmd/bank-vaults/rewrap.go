@@ -0,0 +1,71 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgRewrapPollPeriod = "rewrap-poll-period"
+
+var rewrapSealWrapCmd = &cobra.Command{
+	Use:   "rewrap-sealwrap",
+	Short: "Rewraps all seal-wrapped entries of the target Vault instance",
+	Long: `This command triggers sys/sealwrap/rewrap on the target Vault instance (Enterprise only),
+which is needed after enabling seal wrapping on already existing mounts, and waits
+until the rewrap process is finished.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgRewrapPollPeriod, cmd.PersistentFlags().Lookup(cfgRewrapPollPeriod))
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		if err = v.RewrapSealWrap(appConfig.GetDuration(cfgRewrapPollPeriod)); err != nil {
+			logrus.Fatalf("error rewrapping seal wrapped entries: %s", err.Error())
+		}
+	},
+}
+
+func init() {
+	rewrapSealWrapCmd.PersistentFlags().Duration(cfgRewrapPollPeriod, time.Second*5, "How often to poll the status of the rewrap process")
+
+	rootCmd.AddCommand(rewrapSealWrapCmd)
+}
@@ -0,0 +1,217 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfgRotateInterval = "rotate-interval"
+const cfgDBConnection = "db-connection"
+
+var rotateDBCredsCmd = &cobra.Command{
+	Use:   "rotate-db-creds",
+	Short: "Rotates the credentials of all configured database static roles",
+	Long: `This command reads the static roles of the database secret engines from the
+Vault configuration files and rotates their credentials immediately through the
+rotate-role endpoint, e.g. after a suspected credential leak.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+
+		vaultConfigFiles := appConfig.GetStringSlice(cfgVaultConfigFile)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		failed := false
+
+		for _, vaultConfigFile := range vaultConfigFiles {
+			rotations, err := v.RotateDatabaseStaticRoles(parseConfiguration(vaultConfigFile))
+			if err != nil {
+				logrus.Fatalf("error rotating database static roles of %s: %s", vaultConfigFile, err.Error())
+			}
+
+			for _, rotation := range rotations {
+				if rotation.Err != nil {
+					failed = true
+					fmt.Printf("%s/%s\tfailed: %s\n", rotation.Path, rotation.Role, rotation.Err.Error())
+				} else {
+					fmt.Printf("%s/%s\trotated\n", rotation.Path, rotation.Role)
+				}
+			}
+		}
+
+		if failed {
+			exitCode = 1
+		}
+	},
+}
+
+var rotateDBRootCmd = &cobra.Command{
+	Use:   "rotate-db-root",
+	Short: "Rotates the root credentials of the configured database connections",
+	Long: `This command reads the connections of the database secret engines from the
+Vault configuration files and rotates their root credentials through the rotate-root
+endpoint, on the active Vault node only. With --rotate-interval it keeps running and
+rotates them again at every interval, otherwise it rotates them once.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgRotateInterval, cmd.PersistentFlags().Lookup(cfgRotateInterval))
+		appConfig.BindPFlag(cfgDBConnection, cmd.PersistentFlags().Lookup(cfgDBConnection))
+
+		vaultConfigFiles := appConfig.GetStringSlice(cfgVaultConfigFile)
+		rotateInterval := appConfig.GetDuration(cfgRotateInterval)
+		connections := appConfig.GetStringSlice(cfgDBConnection)
+
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		v, err := vault.New(store, cl, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating vault helper: %s", err.Error())
+		}
+
+		var configs []*viper.Viper
+		for _, vaultConfigFile := range vaultConfigFiles {
+			configs = append(configs, parseConfiguration(vaultConfigFile))
+		}
+
+		if rotateInterval <= 0 {
+			if !rotateDatabaseRoots(v, configs, connections) {
+				exitCode = 1
+			}
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+		go func() {
+			select {
+			case sig := <-signals:
+				logrus.Infof("received %s, shutting down...", sig)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		rotateDatabaseRootsOnSchedule(ctx, v, configs, connections, rotateInterval)
+	},
+}
+
+// rotateDatabaseRoots rotates the root credentials of the connections of the configurations
+// if Vault is the active node, it returns false if any of the rotations failed
+func rotateDatabaseRoots(v vault.Vault, configs []*viper.Viper, connections []string) bool {
+	active, err := v.Active()
+	if err != nil {
+		logrus.Errorf("error checking if vault is active: %s", err.Error())
+		return false
+	}
+	if !active {
+		logrus.Infof("vault is in standby mode, skipping the root credential rotation")
+		return true
+	}
+
+	ok := true
+	for _, config := range configs {
+		rotations, err := v.RotateDatabaseRoots(config, connections)
+		if err != nil {
+			logrus.Errorf("error rotating the database root credentials of %s: %s", config.ConfigFileUsed(), err.Error())
+			ok = false
+			continue
+		}
+		for _, rotation := range rotations {
+			if rotation.Err != nil {
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// rotateDatabaseRootsOnSchedule rotates the root credentials after every interval until the context is cancelled,
+// a failed rotation is retried at the next interval
+func rotateDatabaseRootsOnSchedule(ctx context.Context, v vault.Vault, configs []*viper.Viper, connections []string, interval time.Duration) {
+	for {
+		logrus.Infof("rotating the database root credentials in %s", interval)
+		if !waitUnlessShutdown(ctx, interval) {
+			return
+		}
+		if !rotateDatabaseRoots(v, configs, connections) {
+			logrus.Warnf("rotating the database root credentials failed, retrying in %s", interval)
+		}
+	}
+}
+
+func init() {
+	rotateDBCredsCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+
+	rotateDBRootCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+	rotateDBRootCmd.PersistentFlags().Duration(cfgRotateInterval, 0, "Keep running and rotate the root credentials at this interval (0 rotates them once)")
+	rotateDBRootCmd.PersistentFlags().StringSlice(cfgDBConnection, nil, "Rotate only the root credentials of this connection (<path>/<name>), all configured connections are rotated if not set")
+
+	rootCmd.AddCommand(rotateDBCredsCmd)
+	rootCmd.AddCommand(rotateDBRootCmd)
+}
@@ -0,0 +1,48 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRotateDatabaseRootsOnSchedule(t *testing.T) {
+	v := &mockVault{active: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 110*time.Millisecond)
+	defer cancel()
+	rotateDatabaseRootsOnSchedule(ctx, v, []*viper.Viper{viper.New()}, nil, 30*time.Millisecond)
+
+	v.Lock()
+	defer v.Unlock()
+	if v.rootRotations < 2 || v.rootRotations > 3 {
+		t.Fatalf("expected the root credentials to be rotated at every interval, got %d rotations", v.rootRotations)
+	}
+}
+
+func TestRotateDatabaseRootsStandby(t *testing.T) {
+	v := &mockVault{active: false}
+
+	if !rotateDatabaseRoots(v, []*viper.Viper{viper.New()}, nil) {
+		t.Fatal("skipping the rotation on a standby node shouldn't fail")
+	}
+	if v.rootRotations != 0 {
+		t.Fatal("the root credentials shouldn't be rotated on a standby node")
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	cfgRedactPattern  = "redact-pattern"
+	cfgSanitizeOutput = "output"
+)
+
+// sanitizeRedacted replaces the redacted values in the sanitized config
+const sanitizeRedacted = "<redacted>"
+
+// sanitizeSensitiveKeys are the config keys holding secrets, the values of their whole subtree are redacted
+var sanitizeSensitiveKeys = map[string]bool{
+	"bindpass":    true,
+	"credentials": true,
+	"jwt":         true,
+	"pem_bundle":  true,
+	"secret_id":   true,
+	"token":       true,
+}
+
+// sanitizeSensitiveSuffixes catch the sensitive keys of the secret engine and auth method
+// configurations, e.g. password, secret_key, client_secret or token_reviewer_jwt
+var sanitizeSensitiveSuffixes = []string{"password", "secret", "_key", "_jwt", "_token"}
+
+var sanitizeConfigCmd = &cobra.Command{
+	Use:   "sanitize-config",
+	Short: "Prints a copy of the configuration files with the secrets redacted",
+	Long: `This command renders the configuration files without connecting to Vault and prints (or writes
+to --output) a copy of them which is safe to share, e.g. in support tickets. The values of the known
+sensitive keys (passwords, secret keys, tokens, the data of the startup secrets, ...) and the values
+matching any --redact-pattern regular expression are replaced with ` + sanitizeRedacted + `.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgSanitizeOutput, cmd.PersistentFlags().Lookup(cfgSanitizeOutput))
+
+		patterns, _ := cmd.PersistentFlags().GetStringArray(cfgRedactPattern)
+
+		out := io.Writer(os.Stdout)
+		if output := appConfig.GetString(cfgSanitizeOutput); output != "" {
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				logrus.Fatalf("error opening output file: %s", err.Error())
+			}
+			defer f.Close()
+			out = f
+		}
+
+		err := sanitizeConfigs(appConfig.GetStringSlice(cfgVaultConfigFile), patterns, out)
+
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+	},
+}
+
+// sanitizeConfigs writes the redacted copies of the configuration files to out, one YAML document per file
+func sanitizeConfigs(vaultConfigFiles []string, patterns []string, out io.Writer) error {
+	var redactPatterns []*regexp.Regexp
+	for _, pattern := range patterns {
+		redactPattern, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("error parsing redact pattern %q: %s", pattern, err.Error())
+		}
+		redactPatterns = append(redactPatterns, redactPattern)
+	}
+
+	for i, vaultConfigFile := range vaultConfigFiles {
+		buffer, err := defaultRenderer().renderConfiguration(vaultConfigFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", vaultConfigFile, err.Error())
+		}
+
+		// a MapSlice keeps the order and the case of the keys of the original file
+		var config yaml.MapSlice
+		err = yaml.Unmarshal(buffer.Bytes(), &config)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %s", vaultConfigFile, err.Error())
+		}
+
+		for j, section := range config {
+			if fmt.Sprint(section.Key) == "startupSecrets" {
+				sanitizeStartupSecrets(section.Value, redactPatterns)
+			}
+			config[j].Value = sanitizeValue(section.Value, false, redactPatterns)
+		}
+
+		sanitized, err := yaml.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %s", vaultConfigFile, err.Error())
+		}
+
+		if i > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		fmt.Fprintf(out, "# sanitized copy of %s\n", vaultConfigFile)
+		out.Write(sanitized)
+	}
+	return nil
+}
+
+// sanitizeStartupSecrets redacts the data of the startup secrets, which are secrets themselves
+func sanitizeStartupSecrets(startupSecrets interface{}, redactPatterns []*regexp.Regexp) {
+	secrets, _ := startupSecrets.([]interface{})
+	for _, secret := range secrets {
+		secret, _ := secret.(yaml.MapSlice)
+		for i, item := range secret {
+			if fmt.Sprint(item.Key) == "data" {
+				secret[i].Value = sanitizeValue(item.Value, true, redactPatterns)
+			}
+		}
+	}
+}
+
+// sanitizeValue redacts the value if it is under a sensitive key or matches a redact pattern,
+// the maps and lists are redacted recursively so their structure is kept
+func sanitizeValue(value interface{}, sensitive bool, redactPatterns []*regexp.Regexp) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		for i, item := range value {
+			value[i].Value = sanitizeValue(item.Value, sensitive || sanitizeSensitiveKey(fmt.Sprint(item.Key)), redactPatterns)
+		}
+		return value
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = sanitizeValue(item, sensitive || sanitizeSensitiveKey(key), redactPatterns)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = sanitizeValue(item, sensitive, redactPatterns)
+		}
+		return value
+	case nil:
+		return nil
+	}
+
+	if sensitive {
+		return sanitizeRedacted
+	}
+	if s, ok := value.(string); ok {
+		for _, redactPattern := range redactPatterns {
+			if redactPattern.MatchString(s) {
+				return sanitizeRedacted
+			}
+		}
+	}
+	return value
+}
+
+func sanitizeSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if sanitizeSensitiveKeys[key] {
+		return true
+	}
+	for _, suffix := range sanitizeSensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	sanitizeConfigCmd.PersistentFlags().StringSlice(cfgVaultConfigFile, []string{vault.DefaultConfigFile}, "The filename of the YAML/JSON Vault configuration")
+	sanitizeConfigCmd.PersistentFlags().StringArray(cfgRedactPattern, nil, "Redact the values matching this regular expression too, repeated for each pattern")
+	sanitizeConfigCmd.PersistentFlags().String(cfgSanitizeOutput, "", "Write the sanitized configuration to this file instead of the standard output")
+
+	rootCmd.AddCommand(sanitizeConfigCmd)
+}
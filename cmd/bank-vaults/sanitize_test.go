@@ -0,0 +1,91 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "vault-config.yml")
+	writeConfigFile(t, configFile, `secrets:
+  - type: aws
+    config:
+      access_key: VKIAJBRHKH6EVTTNXDHA
+      secret_key: vCtSM8ZUEQ3mOFVlYPBQkf2sO6F
+      region: eu-west-1
+  - type: database
+    configuration:
+      config:
+        - name: postgres
+          connection_url: "postgresql://vault:hunter2@db:5432/postgres"
+          password: hunter2
+auth:
+  - type: ldap
+    config:
+      binddn: cn=vault,dc=example,dc=com
+      bindpass: ldap-password
+startupSecrets:
+  - type: kv
+    path: secret/data/accounts/aws
+    data:
+      data:
+        AWS_SECRET_ACCESS_KEY: s3cr3t
+`)
+
+	out := bytes.NewBuffer(nil)
+	err = sanitizeConfigs([]string{configFile}, []string{`://[^:]+:[^@]+@`}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sanitized := out.String()
+
+	for _, secret := range []string{"VKIAJBRHKH6EVTTNXDHA", "vCtSM8ZUEQ3mOFVlYPBQkf2sO6F", "hunter2", "ldap-password", "s3cr3t"} {
+		if strings.Contains(sanitized, secret) {
+			t.Fatalf("expected %q to be redacted:\n%s", secret, sanitized)
+		}
+	}
+	for _, kept := range []string{
+		"region: eu-west-1",
+		"binddn: cn=vault,dc=example,dc=com",
+		"bindpass: <redacted>",
+		"connection_url: <redacted>",
+		"path: secret/data/accounts/aws",
+		"AWS_SECRET_ACCESS_KEY: <redacted>",
+	} {
+		if !strings.Contains(sanitized, kept) {
+			t.Fatalf("expected %q in the sanitized config:\n%s", kept, sanitized)
+		}
+	}
+	if strings.Index(sanitized, "secrets:") > strings.Index(sanitized, "auth:") {
+		t.Fatalf("expected the order of the sections to be kept:\n%s", sanitized)
+	}
+
+	err = sanitizeConfigs([]string{configFile}, []string{"("}, out)
+	if err == nil {
+		t.Fatal("expected an invalid redact pattern to be rejected")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/shamir"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// unsealSimulation is the result of reconstructing the master key from the stored shares
+type unsealSimulation struct {
+	found      []string
+	missing    []string
+	masterKey  []byte
+	consistent bool
+	// verified is false if there are not enough shares to compare reconstructions
+	verified bool
+}
+
+var simulateUnsealCmd = &cobra.Command{
+	Use:   "simulate-unseal",
+	Short: "Reconstructs the master key from the stored unseal keys without Vault",
+	Long: `This command reads the unseal keys from the kv store and combines them with Shamir's
+secret sharing locally, without contacting Vault. If there are more shares than the threshold,
+every threshold sized subset of them has to reconstruct the same master key.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := kvStoreForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		vaultConfig, err := vaultConfigForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error building vault config: %s", err.Error())
+		}
+
+		simulation, err := simulateUnseal(store, vaultConfig)
+
+		if err != nil {
+			logrus.Fatalf("error simulating unseal: %s", err.Error())
+		}
+
+		for _, key := range simulation.missing {
+			fmt.Printf("%s\tmissing\n", key)
+		}
+		fmt.Printf("found %d of %d unseal keys, threshold is %d\n", len(simulation.found), vaultConfig.SecretShares, vaultConfig.SecretThreshold)
+
+		switch {
+		case !simulation.consistent:
+			fmt.Println("the unseal keys reconstruct inconsistent master keys")
+			exitCode = 1
+		case !simulation.verified:
+			fmt.Println("the master key got reconstructed, but there are not enough unseal keys to verify it")
+		default:
+			fmt.Println("the unseal keys reconstruct a consistent master key")
+		}
+	},
+}
+
+// simulateUnseal combines the stored unseal keys, every threshold sized subset of them has to combine to the same key
+func simulateUnseal(store kv.Service, config vault.Config) (*unsealSimulation, error) {
+	simulation := &unsealSimulation{}
+	var shares [][]byte
+
+	// the first stored key is the root token
+	for _, key := range vault.StoredKeys(config)[1:] {
+		value, err := store.Get(key)
+		if err != nil {
+			if _, ok := err.(*kv.NotFoundError); ok {
+				simulation.missing = append(simulation.missing, key)
+				continue
+			}
+			return nil, fmt.Errorf("error getting %s: %s", key, err.Error())
+		}
+
+		share, err := hex.DecodeString(string(value))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s: %s", key, err.Error())
+		}
+
+		simulation.found = append(simulation.found, key)
+		shares = append(shares, share)
+	}
+
+	if len(shares) < config.SecretThreshold {
+		return nil, fmt.Errorf("only %d unseal keys found, %d are needed", len(shares), config.SecretThreshold)
+	}
+
+	simulation.consistent = true
+	simulation.verified = len(shares) > config.SecretThreshold
+
+	var err error
+	combinations(len(shares), config.SecretThreshold, func(indexes []int) bool {
+		subset := make([][]byte, 0, len(indexes))
+		for _, i := range indexes {
+			subset = append(subset, shares[i])
+		}
+
+		var masterKey []byte
+		masterKey, err = shamir.Combine(subset)
+		if err != nil {
+			err = fmt.Errorf("error combining unseal keys: %s", err.Error())
+			return false
+		}
+
+		if simulation.masterKey == nil {
+			simulation.masterKey = masterKey
+		} else if !bytes.Equal(simulation.masterKey, masterKey) {
+			simulation.consistent = false
+			return false
+		}
+		return true
+	})
+
+	return simulation, err
+}
+
+// combinations calls fn with every k sized combination of the indexes 0..n-1 until it returns false
+func combinations(n, k int, fn func(indexes []int) bool) {
+	indexes := make([]int, k)
+	var generate func(start, depth int) bool
+	generate = func(start, depth int) bool {
+		if depth == k {
+			return fn(indexes)
+		}
+		for i := start; i < n; i++ {
+			indexes[depth] = i
+			if !generate(i+1, depth+1) {
+				return false
+			}
+		}
+		return true
+	}
+	generate(0, 0)
+}
+
+func init() {
+	rootCmd.AddCommand(simulateUnsealCmd)
+}
@@ -0,0 +1,81 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/shamir"
+)
+
+func TestSimulateUnseal(t *testing.T) {
+	masterKey := []byte("0123456789abcdef0123456789abcdef")
+	shares, err := shamir.Split(masterKey, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := vault.Config{SecretShares: 5, SecretThreshold: 3}
+
+	store := memory.New(nil)
+	for i, share := range shares {
+		store.Set(vault.StoredKeys(config)[i+1], []byte(hex.EncodeToString(share)))
+	}
+
+	simulation, err := simulateUnseal(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !simulation.consistent || !simulation.verified {
+		t.Fatalf("expected a verified consistent master key: %+v", simulation)
+	}
+	if !bytes.Equal(simulation.masterKey, masterKey) {
+		t.Fatalf("expected the master key to be reconstructed, got %x", simulation.masterKey)
+	}
+
+	// corrupt one of the shares
+	corrupted := append([]byte{}, shares[4]...)
+	corrupted[0] ^= 0xff
+	store.Set("vault-unseal-4", []byte(hex.EncodeToString(corrupted)))
+
+	simulation, err = simulateUnseal(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simulation.consistent {
+		t.Fatal("expected the corrupted share to be detected")
+	}
+
+	// only the threshold is available
+	store = memory.New(nil)
+	for i, share := range shares[:3] {
+		store.Set(vault.StoredKeys(config)[i+1], []byte(hex.EncodeToString(share)))
+	}
+
+	simulation, err = simulateUnseal(store, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !simulation.consistent || simulation.verified || len(simulation.missing) != 2 {
+		t.Fatalf("expected an unverified master key with 2 missing keys: %+v", simulation)
+	}
+	if !bytes.Equal(simulation.masterKey, masterKey) {
+		t.Fatalf("expected the master key to be reconstructed, got %x", simulation.masterKey)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgSnapshotOutput = "output"
+
+// configSnapshot is the last successfully applied config file, the raw template is stored
+// instead of the rendered config, so the values read from the environment aren't persisted
+type configSnapshot struct {
+	ConfigFile string    `json:"config_file"`
+	ConfigHash string    `json:"config_hash"`
+	Template   string    `json:"template"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+var restoreConfigSnapshotCmd = &cobra.Command{
+	Use:   "restore-config-snapshot",
+	Short: "Fetches the last successfully applied Vault configuration from the kv backend",
+	Long: `This command reads the snapshot of a config file written to the kv backend by
+configure --persist-config-snapshot after a successful run, and writes the raw
+config template to the output file (or to the standard output).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgVaultConfigFile, cmd.PersistentFlags().Lookup(cfgVaultConfigFile))
+		appConfig.BindPFlag(cfgSnapshotOutput, cmd.PersistentFlags().Lookup(cfgSnapshotOutput))
+
+		store, err := kvStoreForConfig(appConfig)
+		if err != nil {
+			logrus.Fatalf("error creating kv store: %s", err.Error())
+		}
+
+		snapshot, err := restoreConfigSnapshot(store, appConfig.GetString(cfgVaultConfigFile))
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		logrus.Infof("restoring the snapshot of %s (hash %s) applied at %s", snapshot.ConfigFile, snapshot.ConfigHash, snapshot.Timestamp)
+
+		output := appConfig.GetString(cfgSnapshotOutput)
+		if output == "" {
+			fmt.Print(snapshot.Template)
+			return
+		}
+		err = ioutil.WriteFile(output, []byte(snapshot.Template), 0600)
+		if err != nil {
+			logrus.Fatalf("error writing config snapshot: %s", err.Error())
+		}
+	},
+}
+
+const configSnapshotKeyPrefix = "vault-config-snapshot-"
+
+// configSnapshotKey is the key of the snapshot of the config file in the kv store, by its file
+// name and a hash of its path, so the config files of different directories don't collide. The
+// path is relative to the root of the config files (e.g. the clone of --vault-config-git) if it
+// is set, absolute otherwise.
+func configSnapshotKey(configFile, root string) string {
+	path := configFile
+	if root != "" {
+		if relative, err := filepath.Rel(root, configFile); err == nil && !strings.HasPrefix(relative, "..") {
+			path = relative
+		}
+	} else if absolute, err := filepath.Abs(configFile); err == nil {
+		path = absolute
+	}
+	sum := sha256.Sum256([]byte(path))
+	return fmt.Sprint(configSnapshotKeyPrefix, filepath.Base(configFile), "-", hex.EncodeToString(sum[:])[:12])
+}
+
+// persistConfigSnapshot writes the snapshot of the applied config file to the kv store
+func persistConfigSnapshot(store kv.Service, configFile, hash string, now time.Time) error {
+	template, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %s", err.Error())
+	}
+
+	snapshot, err := json.Marshal(configSnapshot{
+		ConfigFile: configFile,
+		ConfigHash: hash,
+		Template:   string(template),
+		Timestamp:  now.UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding config snapshot: %s", err.Error())
+	}
+
+	key := configSnapshotKey(configFile, configureConfig.configRoot)
+	err = store.Set(key, snapshot)
+	if err != nil {
+		return fmt.Errorf("error storing key '%s': %s", key, err.Error())
+	}
+	return nil
+}
+
+// restoreConfigSnapshot reads the snapshot of the config file from the kv store, by the path of
+// the config file (relative to the repository of --vault-config-git for its config files), or by
+// its file name if only one config file of that name has a snapshot
+func restoreConfigSnapshot(store kv.Service, configFile string) (*configSnapshot, error) {
+	candidates := []string{configSnapshotKey(configFile, "")}
+	if !filepath.IsAbs(configFile) {
+		// the config files of --vault-config-git are relative to the repository
+		candidates = append(candidates, configSnapshotKey(configFile, "."))
+	}
+	for _, key := range candidates {
+		value, err := store.Get(key)
+		if _, notFound := err.(*kv.NotFoundError); notFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading key '%s': %s", key, err.Error())
+		}
+		return decodeConfigSnapshot(value)
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing the config snapshots: %s", err.Error())
+	}
+	var named []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, configSnapshotKeyPrefix+filepath.Base(configFile)+"-") {
+			named = append(named, key)
+		}
+	}
+	switch len(named) {
+	case 0:
+		return nil, fmt.Errorf("no config snapshot of %s is stored", configFile)
+	case 1:
+		value, err := store.Get(named[0])
+		if err != nil {
+			return nil, fmt.Errorf("error reading key '%s': %s", named[0], err.Error())
+		}
+		return decodeConfigSnapshot(value)
+	default:
+		return nil, fmt.Errorf("the config snapshots %s match the file name of %s, set its path", strings.Join(named, ", "), configFile)
+	}
+}
+
+func decodeConfigSnapshot(value []byte) (*configSnapshot, error) {
+	var snapshot configSnapshot
+	err := json.Unmarshal(value, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding config snapshot: %s", err.Error())
+	}
+	return &snapshot, nil
+}
+
+func init() {
+	restoreConfigSnapshotCmd.PersistentFlags().String(cfgVaultConfigFile, vault.DefaultConfigFile, "The config file to restore the snapshot of, by its path (relative to the repository of --vault-config-git), or by its file name if it is unique")
+	restoreConfigSnapshotCmd.PersistentFlags().String(cfgSnapshotOutput, "", "Write the config template to this file instead of the standard output")
+
+	rootCmd.AddCommand(restoreConfigSnapshotCmd)
+}
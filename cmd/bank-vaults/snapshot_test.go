@@ -0,0 +1,121 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/spf13/viper"
+)
+
+func TestConfigSnapshotRoundTrip(t *testing.T) {
+	template := `
+auth:
+  - type: userpass
+    config:
+      password: ${ env "USERPASS_PASSWORD" }
+`
+	configFile := filepath.Join(t.TempDir(), "vault-config.yml")
+	if err := ioutil.WriteFile(configFile, []byte(template), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := memory.New(nil)
+	configureConfig.snapshotStore = store
+	defer func() { configureConfig.snapshotStore = nil }()
+
+	config := parseConfiguration(configFile)
+	configurations := make(chan *viper.Viper, 1)
+	configurations <- config
+	close(configurations)
+	applyConfigurations(context.Background(), &mockVault{}, configurations, true)
+
+	snapshot, err := restoreConfigSnapshot(store, "vault-config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Template != template {
+		t.Errorf("expected the raw template in the snapshot, got %q", snapshot.Template)
+	}
+	if snapshot.ConfigHash != configurationHash(config) || snapshot.ConfigFile != configFile {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// failed runs are not persisted
+	other := memory.New(nil)
+	configureConfig.snapshotStore = other
+	configurations = make(chan *viper.Viper, 1)
+	configurations <- parseConfiguration(configFile)
+	close(configurations)
+	applyConfigurations(context.Background(), &mockVault{failSections: map[string]int{"auth": 1}}, configurations, true)
+
+	if _, err := restoreConfigSnapshot(other, "vault-config.yml"); err == nil {
+		t.Error("expected no snapshot of the failed configuration")
+	}
+}
+
+func TestConfigSnapshotKeysOfSameFileName(t *testing.T) {
+	root := t.TempDir()
+	var configFiles []string
+	for _, dir := range []string{"eu", "us"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+		configFile := filepath.Join(root, dir, "vault-config.yml")
+		if err := ioutil.WriteFile(configFile, []byte("# "+dir+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		configFiles = append(configFiles, configFile)
+	}
+
+	store := memory.New(nil)
+	for _, configFile := range configFiles {
+		if err := persistConfigSnapshot(store, configFile, "hash", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the config files of the same name don't overwrite each other's snapshot
+	for i, dir := range []string{"eu", "us"} {
+		snapshot, err := restoreConfigSnapshot(store, configFiles[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Template != "# "+dir+"\n" {
+			t.Errorf("expected the snapshot of %s, got %q", configFiles[i], snapshot.Template)
+		}
+	}
+	if _, err := restoreConfigSnapshot(store, "vault-config.yml"); err == nil {
+		t.Error("expected the file name to be ambiguous")
+	}
+
+	// the config files of a repository are keyed relative to it, the clone is temporary
+	configureConfig.configRoot = filepath.Join(root, "eu")
+	defer func() { configureConfig.configRoot = "" }()
+	other := memory.New(nil)
+	if err := persistConfigSnapshot(other, configFiles[0], "hash", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := other.List()
+	if len(keys) != 1 || keys[0] != configSnapshotKey("vault-config.yml", ".") {
+		t.Errorf("expected the snapshot to be keyed by the path in the repository, got %v", keys)
+	}
+}
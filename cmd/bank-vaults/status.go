@@ -0,0 +1,200 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	statusSuccess = "success"
+	statusFailure = "failure"
+)
+
+func kubernetesConfig() (*rest.Config, error) {
+	kubeconfig := os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
+	var config *rest.Config
+	var err error
+
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating k8s config: %s", err.Error())
+	}
+
+	return config, nil
+}
+
+func kubernetesClient() (kubernetes.Interface, error) {
+	config, err := kubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func kubernetesDynamicClient() (dynamic.Interface, error) {
+	config, err := kubernetesConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+// statusData returns the ConfigMap data describing the configuration results
+func statusData(results []configurationResult, timestamp time.Time) map[string]string {
+	status := statusSuccess
+	var hashes, errs []string
+
+	for _, result := range results {
+		hashes = append(hashes, result.hash)
+		if result.err != nil {
+			status = statusFailure
+			errs = append(errs, fmt.Sprintf("%s: %s", result.configFile, result.err.Error()))
+		}
+	}
+
+	return map[string]string{
+		"status":    status,
+		"hash":      fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(hashes, "\n")))),
+		"timestamp": timestamp.UTC().Format(time.RFC3339),
+		"errors":    strings.Join(errs, "\n"),
+	}
+}
+
+// writeStatusConfigMap creates or updates the namespace/name ConfigMap with the configuration results
+func writeStatusConfigMap(client kubernetes.Interface, namespacedName string, results []configurationResult, timestamp time.Time) error {
+	parts := strings.SplitN(namespacedName, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("status configmap should be in namespace/name format: %s", namespacedName)
+	}
+	namespace, name := parts[0], parts[1]
+
+	data := statusData(results, timestamp)
+
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: data,
+		}
+		_, err = client.CoreV1().ConfigMaps(namespace).Create(configMap)
+		if err != nil {
+			return fmt.Errorf("error creating configmap %s: %s", namespacedName, err.Error())
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting configmap %s: %s", namespacedName, err.Error())
+	}
+
+	configMap.Data = data
+	_, err = client.CoreV1().ConfigMaps(namespace).Update(configMap)
+	if err != nil {
+		return fmt.Errorf("error updating configmap %s: %s", namespacedName, err.Error())
+	}
+	return nil
+}
+
+// statusCRD is the custom resource (e.g. the Vault CR of the operator) whose status subresource
+// is patched with the configuration results
+type statusCRD struct {
+	resource  schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+// parseStatusCRD parses the group/version/kind/namespace/name format of --status-crd,
+// the resource of the kind is guessed (lowercase plural) as there is no discovery
+func parseStatusCRD(value string) (statusCRD, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) != 5 {
+		return statusCRD{}, fmt.Errorf("status crd should be in group/version/kind/namespace/name format: %s", value)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return statusCRD{}, fmt.Errorf("status crd should be in group/version/kind/namespace/name format: %s", value)
+		}
+	}
+	resource, _ := meta.UnsafeGuessKindToResource(schema.GroupVersionKind{Group: parts[0], Version: parts[1], Kind: parts[2]})
+	return statusCRD{resource: resource, namespace: parts[3], name: parts[4]}, nil
+}
+
+// statusCRDPatch returns the merge patch of the status subresource, the applied hash is
+// kept from the last successful run and the last error is removed once a run succeeds
+func statusCRDPatch(results []configurationResult, timestamp time.Time) ([]byte, error) {
+	data := statusData(results, timestamp)
+
+	condition := map[string]interface{}{
+		"type":               "Configured",
+		"lastTransitionTime": data["timestamp"],
+	}
+	status := map[string]interface{}{
+		"configStatus":   data["status"],
+		"lastConfigured": data["timestamp"],
+		"lastError":      nil,
+		"configurations": len(results),
+		"conditions":     []interface{}{condition},
+	}
+	if data["status"] == statusSuccess {
+		status["appliedHash"] = data["hash"]
+		condition["status"] = "True"
+		condition["reason"] = "Applied"
+		condition["message"] = "all configurations were applied"
+	} else {
+		status["lastError"] = data["errors"]
+		condition["status"] = "False"
+		condition["reason"] = "Failed"
+		condition["message"] = data["errors"]
+	}
+
+	return json.Marshal(map[string]interface{}{"status": status})
+}
+
+// patchStatusCRD patches the status subresource of the custom resource with the configuration results
+func patchStatusCRD(client dynamic.Interface, crd statusCRD, results []configurationResult, timestamp time.Time) error {
+	patch, err := statusCRDPatch(results, timestamp)
+	if err != nil {
+		return fmt.Errorf("error encoding status patch: %s", err.Error())
+	}
+
+	_, err = client.Resource(crd.resource).Namespace(crd.namespace).Patch(crd.name, types.MergePatchType, patch, metav1.UpdateOptions{}, "status")
+	if err != nil {
+		return fmt.Errorf("error patching the status of %s %s/%s: %s", crd.resource.Resource, crd.namespace, crd.name, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWriteStatusConfigMap(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	timestamp := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	results := []configurationResult{
+		{configFile: "vault-config.yml", hash: "abc", applied: true},
+	}
+	err := writeStatusConfigMap(client, "vault/vault-configure-status", results, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps("vault").Get("vault-configure-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["status"] != statusSuccess {
+		t.Fatalf("expected success status, got %q", configMap.Data["status"])
+	}
+	if configMap.Data["timestamp"] != "2019-03-01T12:00:00Z" {
+		t.Fatalf("unexpected timestamp %q", configMap.Data["timestamp"])
+	}
+	successHash := configMap.Data["hash"]
+	if successHash == "" {
+		t.Fatal("expected applied hash")
+	}
+
+	// a second run updates the existing ConfigMap
+	results = []configurationResult{
+		{configFile: "vault-config.yml", hash: "def", err: fmt.Errorf("permission denied")},
+	}
+	err = writeStatusConfigMap(client, "vault/vault-configure-status", results, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configMap, err = client.CoreV1().ConfigMaps("vault").Get("vault-configure-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["status"] != statusFailure {
+		t.Fatalf("expected failure status, got %q", configMap.Data["status"])
+	}
+	if configMap.Data["errors"] != "vault-config.yml: permission denied" {
+		t.Fatalf("unexpected errors %q", configMap.Data["errors"])
+	}
+	if configMap.Data["hash"] == successHash {
+		t.Fatal("expected the hash to change")
+	}
+
+	if err := writeStatusConfigMap(client, "invalid", results, timestamp); err == nil {
+		t.Fatal("expected error for invalid configmap name")
+	}
+}
+
+func TestPatchStatusCRD(t *testing.T) {
+	crd, err := parseStatusCRD("vault.banzaicloud.com/v1alpha1/Vault/vault/vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crd.resource.Resource != "vaults" || crd.resource.Group != "vault.banzaicloud.com" || crd.namespace != "vault" || crd.name != "vault" {
+		t.Fatalf("unexpected status crd: %+v", crd)
+	}
+	for _, invalid := range []string{"vault/vault", "vault.banzaicloud.com/v1alpha1/Vault//vault"} {
+		if _, err := parseStatusCRD(invalid); err == nil {
+			t.Errorf("expected error for invalid status crd %s", invalid)
+		}
+	}
+
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	var patches []k8stesting.PatchAction
+	client.PrependReactor("patch", "vaults", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(k8stesting.PatchAction))
+		return true, &unstructured.Unstructured{}, nil
+	})
+
+	timestamp := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	statusOf := func(i int) map[string]interface{} {
+		patch := patches[i]
+		if patch.GetSubresource() != "status" || patch.GetNamespace() != "vault" || patch.GetName() != "vault" {
+			t.Fatalf("expected a patch of the status subresource of vault/vault: %+v", patch)
+		}
+		var body struct {
+			Status map[string]interface{} `json:"status"`
+		}
+		if err := json.Unmarshal(patch.GetPatch(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Status
+	}
+
+	err = patchStatusCRD(client, crd, []configurationResult{{configFile: "vault-config.yml", hash: "abc", applied: true}}, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := statusOf(0)
+	if status["appliedHash"] == nil || status["appliedHash"] == "" {
+		t.Fatalf("expected the applied hash in the status: %v", status)
+	}
+	if lastError, ok := status["lastError"]; !ok || lastError != nil {
+		t.Fatalf("expected the last error to be removed: %v", status)
+	}
+	condition := status["conditions"].([]interface{})[0].(map[string]interface{})
+	if condition["type"] != "Configured" || condition["status"] != "True" || condition["lastTransitionTime"] != "2019-03-01T12:00:00Z" {
+		t.Fatalf("unexpected condition: %v", condition)
+	}
+
+	err = patchStatusCRD(client, crd, []configurationResult{{configFile: "vault-config.yml", hash: "def", err: fmt.Errorf("permission denied")}}, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = statusOf(1)
+	if _, ok := status["appliedHash"]; ok {
+		t.Fatalf("a failed run shouldn't change the applied hash: %v", status)
+	}
+	if status["lastError"] != "vault-config.yml: permission denied" {
+		t.Fatalf("unexpected last error: %v", status["lastError"])
+	}
+	condition = status["conditions"].([]interface{})[0].(map[string]interface{})
+	if condition["status"] != "False" || condition["reason"] != "Failed" {
+		t.Fatalf("unexpected condition: %v", condition)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const cfgTerraformImportFormat = "format"
+
+// the mounts and policies which are built into Vault and can't be managed by Terraform
+var terraformBuiltinMounts = map[string]bool{"sys": true, "cubbyhole": true, "identity": true}
+var terraformBuiltinAuths = map[string]bool{"token": true}
+var terraformBuiltinPolicies = map[string]bool{"root": true, "default": true}
+
+var terraformInvalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// terraformImport is a Vault resource which can be imported into Terraform
+type terraformImport struct {
+	resourceType string
+	name         string
+	id           string
+}
+
+func (i terraformImport) address() string {
+	return fmt.Sprintf("%s.%s", i.resourceType, i.name)
+}
+
+var emitTerraformImportsCmd = &cobra.Command{
+	Use:   "emit-terraform-imports",
+	Short: "Prints Terraform imports for the mounts, auth methods and policies of Vault",
+	Long: `This command reads the secret engine mounts, auth methods and ACL policies of the running
+Vault instance (authenticated with VAULT_TOKEN) and prints Terraform import blocks (--format blocks,
+Terraform 1.5+) or terraform import commands (--format commands) for the vault provider resources.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		appConfig.BindPFlag(cfgTerraformImportFormat, cmd.PersistentFlags().Lookup(cfgTerraformImportFormat))
+
+		cl, err := api.NewClient(nil)
+
+		if err != nil {
+			logrus.Fatalf("error connecting to vault: %s", err.Error())
+		}
+
+		imports, err := terraformImports(cl)
+
+		if err != nil {
+			logrus.Fatalf("error reading vault resources: %s", err.Error())
+		}
+
+		output, err := formatTerraformImports(imports, appConfig.GetString(cfgTerraformImportFormat))
+
+		if err != nil {
+			logrus.Fatal(err.Error())
+		}
+
+		fmt.Print(output)
+	},
+}
+
+// terraformImports returns the importable mounts, auth methods and policies in a stable order
+func terraformImports(cl *api.Client) ([]terraformImport, error) {
+	var imports []terraformImport
+
+	mounts, err := cl.Sys().ListMounts()
+	if err != nil {
+		return nil, fmt.Errorf("error reading mounts from vault: %s", err.Error())
+	}
+	var mountPaths []string
+	for path := range mounts {
+		mountPaths = append(mountPaths, strings.TrimSuffix(path, "/"))
+	}
+	sort.Strings(mountPaths)
+	for _, path := range mountPaths {
+		if !terraformBuiltinMounts[path] {
+			imports = append(imports, terraformImport{"vault_mount", terraformName(path), path})
+		}
+	}
+
+	auths, err := cl.Sys().ListAuth()
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth backends vault: %s", err.Error())
+	}
+	var authPaths []string
+	for path := range auths {
+		authPaths = append(authPaths, strings.TrimSuffix(path, "/"))
+	}
+	sort.Strings(authPaths)
+	for _, path := range authPaths {
+		if !terraformBuiltinAuths[path] {
+			imports = append(imports, terraformImport{"vault_auth_backend", terraformName(path), path})
+		}
+	}
+
+	policies, err := cl.Sys().ListPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("error listing policies of vault: %s", err.Error())
+	}
+	sort.Strings(policies)
+	for _, policy := range policies {
+		if !terraformBuiltinPolicies[policy] {
+			imports = append(imports, terraformImport{"vault_policy", terraformName(policy), policy})
+		}
+	}
+
+	return imports, nil
+}
+
+// terraformName converts a path or name to a valid Terraform resource name
+func terraformName(name string) string {
+	name = terraformInvalidNameChars.ReplaceAllString(name, "_")
+	if name == "" || !(name[0] == '_' || (name[0] >= 'a' && name[0] <= 'z') || (name[0] >= 'A' && name[0] <= 'Z')) {
+		name = "_" + name
+	}
+	return name
+}
+
+func formatTerraformImports(imports []terraformImport, format string) (string, error) {
+	buffer := bytes.NewBuffer(nil)
+
+	for _, i := range imports {
+		switch format {
+		case "blocks":
+			fmt.Fprintf(buffer, "import {\n  to = %s\n  id = %q\n}\n\n", i.address(), i.id)
+		case "commands":
+			fmt.Fprintf(buffer, "terraform import %s '%s'\n", i.address(), i.id)
+		default:
+			return "", fmt.Errorf("unknown format %s, should be blocks or commands", format)
+		}
+	}
+
+	return buffer.String(), nil
+}
+
+func init() {
+	emitTerraformImportsCmd.PersistentFlags().String(cfgTerraformImportFormat, "blocks", "The output format: import blocks (blocks) or terraform import commands (commands)")
+
+	rootCmd.AddCommand(emitTerraformImportsCmd)
+}
@@ -0,0 +1,102 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestTerraformImports(t *testing.T) {
+	responses := map[string]interface{}{
+		"/v1/sys/mounts": map[string]interface{}{"data": map[string]interface{}{
+			"secret/":    map[string]interface{}{"type": "kv"},
+			"sys/":       map[string]interface{}{"type": "system"},
+			"cubbyhole/": map[string]interface{}{"type": "cubbyhole"},
+			"identity/":  map[string]interface{}{"type": "identity"},
+		}},
+		"/v1/sys/auth": map[string]interface{}{"data": map[string]interface{}{
+			"token/":      map[string]interface{}{"type": "token"},
+			"kubernetes/": map[string]interface{}{"type": "kubernetes"},
+		}},
+		"/v1/sys/policies/acl": map[string]interface{}{"data": map[string]interface{}{
+			"keys": []string{"allow_secrets", "default", "root"},
+		}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	cl, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imports, err := terraformImports(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := formatTerraformImports(imports, "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `import {
+  to = vault_mount.secret
+  id = "secret"
+}
+
+import {
+  to = vault_auth_backend.kubernetes
+  id = "kubernetes"
+}
+
+import {
+  to = vault_policy.allow_secrets
+  id = "allow_secrets"
+}
+
+`
+	if output != expected {
+		t.Fatalf("unexpected import blocks:\n%s", output)
+	}
+
+	output, err = formatTerraformImports(imports, "commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "terraform import vault_mount.secret 'secret'\n") {
+		t.Fatalf("unexpected import commands:\n%s", output)
+	}
+
+	if terraformName("pki/intermediate") != "pki_intermediate" || terraformName("1password") != "_1password" {
+		t.Fatal("unexpected terraform resource names")
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/sirupsen/logrus"
+)
+
+// traceSpan is a finished or in-flight OpenTelemetry span
+type traceSpan struct {
+	tracer       *tracer
+	traceID      string
+	spanID       string
+	parentSpanID string
+	name         string
+	kind         int
+	start        time.Time
+	end          time.Time
+	attributes   map[string]string
+	err          error
+}
+
+// spanExporter sends the spans of a finished trace somewhere
+type spanExporter interface {
+	export(spans []*traceSpan) error
+}
+
+// tracer implements vault.Tracer, the parent of a span is the span of its context, so the
+// spans started concurrently (e.g. of several clusters) are nested correctly, and the spans of
+// a trace are exported when all of them ended
+type tracer struct {
+	sync.Mutex
+	exporter spanExporter
+	// the number of spans in flight and the finished spans by trace id
+	inFlight map[string]int
+	finished map[string][]*traceSpan
+}
+
+func newTracer(exporter spanExporter) *tracer {
+	return &tracer{exporter: exporter, inFlight: map[string]int{}, finished: map[string][]*traceSpan{}}
+}
+
+// span kinds of the OTLP protocol
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+type spanContextKey struct{}
+
+// spanFromContext returns the span the context carries, nil if it carries none
+func spanFromContext(ctx context.Context) *traceSpan {
+	span, _ := ctx.Value(spanContextKey{}).(*traceSpan)
+	return span
+}
+
+func (t *tracer) StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, vault.Span) {
+	span := t.startSpan(spanFromContext(ctx), "", name, spanKindInternal, attributes)
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// Inject sets the W3C traceparent header of the span of the context
+func (t *tracer) Inject(ctx context.Context, headers http.Header) {
+	if span := spanFromContext(ctx); span != nil {
+		headers.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01", span.traceID, span.spanID))
+	}
+}
+
+// startSpan starts a child span of the parent, or of the parent span id of the trace id if the
+// parent isn't known (e.g. from a traceparent header), a new trace without either
+func (t *tracer) startSpan(parent *traceSpan, traceID, name string, kind int, attributes map[string]string) *traceSpan {
+	t.Lock()
+	defer t.Unlock()
+
+	span := &traceSpan{
+		tracer:     t,
+		spanID:     randomID(8),
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	if parent != nil {
+		span.traceID = parent.traceID
+		span.parentSpanID = parent.spanID
+	} else if traceID != "" {
+		span.traceID = traceID
+	} else {
+		span.traceID = randomID(16)
+	}
+	t.inFlight[span.traceID]++
+	return span
+}
+
+func (s *traceSpan) End(err error) {
+	t := s.tracer
+	t.Lock()
+
+	s.end = time.Now()
+	s.err = err
+	t.finished[s.traceID] = append(t.finished[s.traceID], s)
+	t.inFlight[s.traceID]--
+
+	var spans []*traceSpan
+	if t.inFlight[s.traceID] <= 0 {
+		spans = t.finished[s.traceID]
+		delete(t.finished, s.traceID)
+		delete(t.inFlight, s.traceID)
+	}
+	t.Unlock()
+
+	if spans != nil {
+		if err := t.exporter.export(spans); err != nil {
+			logrus.Errorf("error exporting trace: %s", err.Error())
+		}
+	}
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// traceparentHeader carries the parent span of the Vault API calls, see https://www.w3.org/TR/trace-context/
+const traceparentHeader = "Traceparent"
+
+// tracingTransport creates a client span for every Vault API call made inside of a span, the
+// parent span is the one of the traceparent header of the call
+type tracingTransport struct {
+	tracer *tracer
+	next   http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parts := strings.Split(req.Header.Get(traceparentHeader), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return t.next.RoundTrip(req)
+	}
+
+	span := t.tracer.startSpan(nil, parts[1], req.Method+" "+req.URL.Path, spanKindClient, map[string]string{
+		"http.method": req.Method,
+		"http.url":    req.URL.String(),
+	})
+	span.parentSpanID = parts[2]
+
+	// the call carries its own span to Vault
+	req = req.WithContext(req.Context())
+	req.Header = cloneHeader(req.Header)
+	req.Header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01", span.traceID, span.spanID))
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		span.attributes["http.status_code"] = strconv.Itoa(resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	span.End(err)
+
+	return resp, err
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for key, values := range header {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// otlpExporter exports the spans to an OpenTelemetry collector with OTLP/HTTP (JSON encoding)
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+// newOTLPExporter returns an exporter for the endpoint, the default /v1/traces path is used
+// if the endpoint has no path
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing otel endpoint: %s", err.Error())
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("error parsing otel endpoint: %s is not an http(s) url", endpoint)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+	return &otlpExporter{endpoint: u.String(), client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (e *otlpExporter) export(spans []*traceSpan) error {
+	body, err := json.Marshal(otlpTraces(spans))
+	if err != nil {
+		return fmt.Errorf("error encoding spans: %s", err.Error())
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error sending spans to %s: %s", e.endpoint, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error sending spans to %s: %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// otlpTraces converts the spans to an OTLP ExportTraceServiceRequest
+func otlpTraces(spans []*traceSpan) map[string]interface{} {
+	otlpSpans := make([]interface{}, 0, len(spans))
+	for _, span := range spans {
+		status := map[string]interface{}{"code": 1}
+		if span.err != nil {
+			status = map[string]interface{}{"code": 2, "message": span.err.Error()}
+		}
+
+		otlpSpan := map[string]interface{}{
+			"traceId":           span.traceID,
+			"spanId":            span.spanID,
+			"name":              span.name,
+			"kind":              span.kind,
+			"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.attributes),
+			"status":            status,
+		}
+		if span.parentSpanID != "" {
+			otlpSpan["parentSpanId"] = span.parentSpanID
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]string{"service.name": "bank-vaults"}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "bank-vaults"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attributes map[string]string) []interface{} {
+	otlpAttributes := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		otlpAttributes = append(otlpAttributes, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": value},
+		})
+	}
+	return otlpAttributes
+}
@@ -0,0 +1,212 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+)
+
+// memoryExporter keeps the exported spans for the tests
+type memoryExporter struct {
+	spans []*traceSpan
+}
+
+func (e *memoryExporter) export(spans []*traceSpan) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestConfigureTraceSpans(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{}}`))
+	}))
+	defer server.Close()
+
+	exporter := &memoryExporter{}
+	tracer := newTracer(exporter)
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = server.URL
+	clientConfig.HttpClient.Transport = &tracingTransport{tracer: tracer, next: clientConfig.HttpClient.Transport}
+	cl, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vault.New(memory.New(map[string][]byte{"vault-root": []byte("root")}), cl, vault.Config{Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := viper.New()
+	config.SetConfigType("yaml")
+	err = config.ReadConfig(bytes.NewBufferString(`
+policies:
+  - name: developer
+    rules: path "secret/*" { capabilities = ["read"] }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// calls outside of a configuration run are not traced
+	if _, err := v.Sealed(); err != nil {
+		t.Fatal(err)
+	}
+	if len(exporter.spans) != 0 {
+		t.Fatalf("unexpected spans outside of a configuration run: %d", len(exporter.spans))
+	}
+
+	err = v.ConfigureSections(config, []string{"auth", "policies"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]*traceSpan{}
+	for _, span := range exporter.spans {
+		spans[span.name] = span
+	}
+
+	run := spans["configure"]
+	if run == nil || run.parentSpanID != "" {
+		t.Fatalf("expected a root span for the configuration run, got: %v", run)
+	}
+	for _, name := range []string{"configure auth", "configure policies"} {
+		section := spans[name]
+		if section == nil {
+			t.Fatalf("expected a span for section %s", name)
+		}
+		if section.traceID != run.traceID || section.parentSpanID != run.spanID {
+			t.Fatalf("expected span %s to be a child of the run span", name)
+		}
+	}
+	if spans["configure secrets"] != nil {
+		t.Fatal("unexpected span for a section which wasn't configured")
+	}
+
+	call := spans["PUT /v1/sys/policies/acl/developer"]
+	if call == nil || call.parentSpanID != spans["configure policies"].spanID {
+		t.Fatalf("expected a span for the policy api call in the policies section, got: %v", call)
+	}
+	if call.attributes["http.status_code"] != "200" {
+		t.Fatalf("unexpected api call attributes: %v", call.attributes)
+	}
+}
+
+func TestConcurrentTraceSpans(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the concurrent calls overlap
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{}}`))
+	}))
+	defer server.Close()
+
+	exporter := &memoryExporter{}
+	tracer := newTracer(exporter)
+
+	clientConfig := api.DefaultConfig()
+	clientConfig.Address = server.URL
+	clientConfig.HttpClient.Transport = &tracingTransport{tracer: tracer, next: clientConfig.HttpClient.Transport}
+	cl, err := api.NewClient(clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := vault.New(memory.New(map[string][]byte{"vault-root": []byte("root")}), cl, vault.Config{Tracer: tracer, Concurrency: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var policies []string
+	for i := 0; i < 8; i++ {
+		policies = append(policies, fmt.Sprintf("  - name: policy-%d\n    rules: path \"secret/*\" { capabilities = [\"read\"] }\n", i))
+	}
+	config := viper.New()
+	config.SetConfigType("yaml")
+	if err := config.ReadConfig(bytes.NewBufferString("policies:\n" + strings.Join(policies, ""))); err != nil {
+		t.Fatal(err)
+	}
+
+	err = v.ConfigureSections(config, []string{"policies"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var section *traceSpan
+	for _, span := range exporter.spans {
+		if span.name == "configure policies" {
+			section = span
+		}
+	}
+	if section == nil {
+		t.Fatal("expected a span for the policies section")
+	}
+	calls := 0
+	for _, span := range exporter.spans {
+		if strings.HasPrefix(span.name, "PUT /v1/sys/policies/acl/") {
+			calls++
+			if span.traceID != section.traceID || span.parentSpanID != section.spanID {
+				t.Errorf("expected the concurrent api call %s to be a child of the section span", span.name)
+			}
+		}
+	}
+	if calls != 8 {
+		t.Fatalf("expected a span for every policy api call, got %d", calls)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var path string
+	var request map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+	}))
+	defer collector.Close()
+
+	exporter, err := newOTLPExporter(collector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := newTracer(exporter)
+	_, span := tracer.StartSpan(context.Background(), "configure", nil)
+	span.End(nil)
+
+	if path != "/v1/traces" {
+		t.Fatalf("expected the spans to be sent to the default traces path, got: %s", path)
+	}
+	spans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 1 || spans[0].(map[string]interface{})["name"] != "configure" {
+		t.Fatalf("unexpected exported spans: %v", spans)
+	}
+}
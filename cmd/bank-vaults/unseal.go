@@ -15,7 +15,7 @@
 package main
 
 import (
-	"os"
+	"fmt"
 	"time"
 
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
@@ -25,13 +25,18 @@ import (
 )
 
 const cfgUnsealPeriod = "unseal-period"
+const cfgUnsealRetryInitial = "unseal-retry-initial"
 const cfgInit = "init"
 const cfgOnce = "once"
+const cfgSealTransitionWebhook = "seal-transition-webhook"
+const cfgSealTransitionDebounce = "seal-transition-debounce"
 
 type unsealCfg struct {
-	unsealPeriod time.Duration
-	proceedInit  bool
-	runOnce      bool
+	unsealPeriod       time.Duration
+	unsealRetryInitial time.Duration
+	proceedInit        bool
+	runOnce            bool
+	raftLeaderAddress  string
 }
 
 var unsealConfig unsealCfg
@@ -44,16 +49,26 @@ from one of the followings:
 - Google Cloud KMS keyring (backed by GCS)
 - AWS KMS keyring (backed by S3)
 - Azure Key Vault
-- Kubernetes Secrets (should be used only for development purposes)`,
+- Kubernetes Secrets (should be used only for development purposes)
+
+It runs standalone, without a Vault configuration file, and serves the seal state and
+the unseal timing as Prometheus metrics on --metrics-address.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		appConfig.BindPFlag(cfgUnsealPeriod, cmd.PersistentFlags().Lookup(cfgUnsealPeriod))
 		appConfig.BindPFlag(cfgInit, cmd.PersistentFlags().Lookup(cfgInit))
 		appConfig.BindPFlag(cfgOnce, cmd.PersistentFlags().Lookup(cfgOnce))
 		appConfig.BindPFlag(cfgInitRootToken, cmd.PersistentFlags().Lookup(cfgInitRootToken))
 		appConfig.BindPFlag(cfgStoreRootToken, cmd.PersistentFlags().Lookup(cfgStoreRootToken))
+		appConfig.BindPFlag(cfgSealTransitionWebhook, cmd.PersistentFlags().Lookup(cfgSealTransitionWebhook))
+		appConfig.BindPFlag(cfgSealTransitionDebounce, cmd.PersistentFlags().Lookup(cfgSealTransitionDebounce))
+		bindMetricsAddress(cmd.PersistentFlags())
+		appConfig.BindPFlag(cfgRaftLeaderAddress, cmd.PersistentFlags().Lookup(cfgRaftLeaderAddress))
+		appConfig.BindPFlag(cfgRaftRemoveDeadPeers, cmd.PersistentFlags().Lookup(cfgRaftRemoveDeadPeers))
+		appConfig.BindPFlag(cfgRaftDeadPeerGracePeriod, cmd.PersistentFlags().Lookup(cfgRaftDeadPeerGracePeriod))
 		unsealConfig.unsealPeriod = appConfig.GetDuration(cfgUnsealPeriod)
 		unsealConfig.proceedInit = appConfig.GetBool(cfgInit)
 		unsealConfig.runOnce = appConfig.GetBool(cfgOnce)
+		unsealConfig.raftLeaderAddress = appConfig.GetString(cfgRaftLeaderAddress)
 
 		store, err := kvStoreForConfig(appConfig)
 
@@ -73,52 +88,70 @@ from one of the followings:
 			logrus.Fatalf("error building vault config: %s", err.Error())
 		}
 
+		vaultConfig.ShareStores, err = shareStoresForConfig(appConfig)
+
+		if err != nil {
+			logrus.Fatalf("error creating the kv stores of the unseal key shares: %s", err.Error())
+		}
+
 		v, err := vault.New(store, cl, vaultConfig)
 
 		if err != nil {
 			logrus.Fatalf("error creating vault helper: %s", err.Error())
 		}
 
-		metrics := prometheusExporter{Vault: v}
-		go metrics.Run()
+		if metricsAddress := appConfig.GetString(cfgMetricsAddress); metricsAddress != "" {
+			metrics := prometheusExporter{Vault: v}
+			go metrics.Run(metricsAddress, newHealthChecker(store, v, false))
+		}
+
+		var notifier *sealTransitionNotifier
+		if webhook := appConfig.GetString(cfgSealTransitionWebhook); webhook != "" {
+			notifier = newSealTransitionNotifier(webhook, cl.Address(), appConfig.GetDuration(cfgSealTransitionDebounce))
+		}
+
+		// the raft peers are the pods of Vault, the ones of the deleted pods are removed by the leader
+		var raftDeadPeers *deadRaftPeers
+		if appConfig.GetBool(cfgRaftRemoveDeadPeers) {
+			client, err := kubernetesClient()
+			if err != nil {
+				logrus.Fatalf("error creating kubernetes client: %s", err.Error())
+			}
+			namespace := podNamespace()
+			raftDeadPeers = newDeadRaftPeers(podExistsInNamespace(client, namespace), statefulSetReplicas(client, namespace), appConfig.GetDuration(cfgRaftDeadPeerGracePeriod))
+		}
 
 		for {
-			func() {
-				if unsealConfig.proceedInit {
-					logrus.Infof("initializing vault...")
-					if err = v.Init(); err != nil {
-						logrus.Fatalf("error initializing vault: %s", err.Error())
-					} else {
-						unsealConfig.proceedInit = false
-					}
+			// the nodes joining a raft cluster aren't initialized, they are unsealed with the keys of the cluster
+			if unsealConfig.raftLeaderAddress != "" && !isRaftLeaderAddress(unsealConfig.raftLeaderAddress) {
+				if err := v.RaftJoin(unsealConfig.raftLeaderAddress); err != nil {
+					logrus.Error(err.Error())
 				}
-
-				logrus.Infof("checking if vault is sealed...")
-				sealed, err := v.Sealed()
-				if err != nil {
-					logrus.Errorf("error checking if vault is sealed: %s", err.Error())
-					exitIfNecessary(1)
-					return
+			} else if unsealConfig.proceedInit {
+				logrus.Infof("initializing vault...")
+				if err = v.Init(); err != nil {
+					logrus.Fatalf("error initializing vault: %s", err.Error())
+				} else {
+					unsealConfig.proceedInit = false
 				}
+			}
 
-				logrus.Infof("vault sealed: %t", sealed)
-
-				// If vault is not sealed, we stop here and wait another unsealPeriod
-				if !sealed {
-					exitIfNecessary(0)
+			if err := unsealVault(v, notifier); err != nil {
+				logrus.Error(err.Error())
+				if unsealConfig.runOnce {
+					exitCode = 1
 					return
 				}
-
-				if err = v.Unseal(); err != nil {
-					logrus.Errorf("error unsealing vault: %s", err.Error())
-					exitIfNecessary(1)
+			} else {
+				if raftDeadPeers != nil {
+					if err := raftDeadPeers.remove(v, time.Now()); err != nil {
+						logrus.Errorf("error removing dead raft peers: %s", err.Error())
+					}
+				}
+				if unsealConfig.runOnce {
 					return
 				}
-
-				logrus.Infof("successfully unsealed vault")
-
-				exitIfNecessary(0)
-			}()
+			}
 
 			// wait unsealPeriod before trying again
 			time.Sleep(unsealConfig.unsealPeriod)
@@ -126,10 +159,44 @@ from one of the followings:
 	},
 }
 
-func exitIfNecessary(code int) {
-	if unsealConfig.runOnce {
-		os.Exit(code)
+// unsealVault checks if Vault is sealed and unseals it if it is, the seal state
+// and the duration of the unseal attempts are recorded in the metrics
+func unsealVault(v vault.Vault, notifier *sealTransitionNotifier) error {
+	logrus.Infof("checking if vault is sealed...")
+	sealed, err := v.Sealed()
+	if err != nil {
+		unsealErrors.Inc()
+		return fmt.Errorf("error checking if vault is sealed: %s", err.Error())
 	}
+
+	logrus.Infof("vault sealed: %t", sealed)
+	vaultSealed.Set(bToF(sealed))
+
+	if notifier != nil {
+		notifier.observe(sealed, time.Now())
+	}
+
+	// If vault is not sealed, we stop here and wait another unsealPeriod
+	if !sealed {
+		return nil
+	}
+
+	start := time.Now()
+	unsealAttempts.Inc()
+	err = v.Unseal()
+	unsealDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		unsealErrors.Inc()
+		return fmt.Errorf("error unsealing vault: %s", err.Error())
+	}
+
+	logrus.Infof("successfully unsealed vault")
+	vaultSealed.Set(0)
+
+	if notifier != nil {
+		notifier.observe(false, time.Now())
+	}
+	return nil
 }
 
 func init() {
@@ -138,6 +205,13 @@ func init() {
 	unsealCmd.PersistentFlags().Bool(cfgOnce, false, "Run unseal only once")
 	unsealCmd.PersistentFlags().String(cfgInitRootToken, "", "root token for the new vault cluster (only if -init=true)")
 	unsealCmd.PersistentFlags().Bool(cfgStoreRootToken, true, "should the root token be stored in the key store (only if -init=true)")
+	unsealCmd.PersistentFlags().String(cfgSealTransitionWebhook, "", "POST a JSON payload to this URL when the seal state of vault changes")
+	unsealCmd.PersistentFlags().Duration(cfgSealTransitionDebounce, time.Second*30, "How long a new seal state has to be observed before it is posted to the seal transition webhook")
+	addMetricsAddressFlags(unsealCmd.PersistentFlags(), ":9091", "Serve the Prometheus metrics of the seal state and the unseal attempts, and the /healthz (the kv backend is reachable) and /readyz (Vault is unsealed) probes on this address (alias --metrics-listen-address), disabled if empty")
+
+	unsealCmd.PersistentFlags().String(cfgRaftLeaderAddress, "", "Join the raft cluster of the Vault at this API address (e.g. https://vault-0.vault:8200) instead of initializing it, if the Vault isn't initialized yet, the pod of the address itself is initialized with --"+cfgInit)
+	unsealCmd.PersistentFlags().Bool(cfgRaftRemoveDeadPeers, false, "Remove the raft peers whose pods don't exist anymore (in POD_NAMESPACE) and whose ordinals are above the replicas of their StatefulSet on the leader, the node ids of the peers have to be the pod names (the service account needs the get verb on pods and statefulsets)")
+	unsealCmd.PersistentFlags().Duration(cfgRaftDeadPeerGracePeriod, 10*time.Minute, "How long the pod of a raft peer has to be missing before the peer is removed by --"+cfgRaftRemoveDeadPeers)
 
 	rootCmd.AddCommand(unsealCmd)
 }
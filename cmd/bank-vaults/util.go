@@ -16,52 +16,210 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabakms"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/alibabaoss"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/awskms"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/azurekv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/cache"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/consul"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/dev"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/gckms"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/gcs"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/k8s"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/pkcs11"
 	"github.com/banzaicloud/bank-vaults/pkg/kv/s3"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/vaulttransit"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/versioned"
 	"github.com/banzaicloud/bank-vaults/pkg/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 func vaultConfigForConfig(cfg *viper.Viper) (vault.Config, error) {
 
+	shareDecoder, err := vault.ShareDecoderByName(appConfig.GetString(cfgShareDecoder))
+	if err != nil {
+		return vault.Config{}, err
+	}
+
+	managedMetadata, err := parseManagedMetadata(appConfig.GetStringSlice(cfgManagedMetadata))
+	if err != nil {
+		return vault.Config{}, err
+	}
+
 	return vault.Config{
 		SecretShares:    appConfig.GetInt(cfgSecretShares),
 		SecretThreshold: appConfig.GetInt(cfgSecretThreshold),
 
 		InitRootToken:  appConfig.GetString(cfgInitRootToken),
 		StoreRootToken: appConfig.GetBool(cfgStoreRootToken),
+
+		MountDescriptionTemplate: appConfig.GetString(cfgMountDescriptionTemplate),
+		ManagedMetadata:          managedMetadata,
+		PruneSecrets:             appConfig.GetBool(cfgPruneSecrets),
+		PruneAuth:                appConfig.GetBool(cfgPruneAuth),
+		AllowDefaultPolicyEdit:   appConfig.GetBool(cfgAllowDefaultPolicyEdit),
+		AtomicPolicies:           appConfig.GetBool(cfgAtomicPolicies),
+		ValidatePolicyTemplates:  appConfig.GetBool(cfgValidatePolicyTemplates),
+		FailOnDeprecated:         appConfig.GetBool(cfgFailOnDeprecated),
+		ConfirmIdentityPurge:     appConfig.GetBool(cfgConfirmIdentityPurge),
+		VerifyAccess:             appConfig.GetBool(cfgVerifyAccess),
+		EarlyAudit:               appConfig.GetBool(cfgEarlyAudit),
+		ContinueOnSectionFailure: appConfig.GetBool(cfgContinueOnSectionFailure),
+		RevokeLeasesOnDisable:    appConfig.GetBool(cfgRevokeLeasesOnDisable),
+		AutoRevokeRootToken:      appConfig.GetBool(cfgAutoRevokeRootToken),
+		ConfigurerTokenPeriod:    appConfig.GetDuration(cfgConfigurerTokenPeriod),
+		ConfigureRateLimit:       appConfig.GetFloat64(cfgConfigureRateLimit),
+		SectionBreakerThreshold:  appConfig.GetInt(cfgSectionBreakerThreshold),
+		SectionBreakerCooldown:   appConfig.GetDuration(cfgSectionBreakerCooldown),
+		Concurrency:              appConfig.GetInt(cfgConfigureConcurrency),
+		WriteRetries:             appConfig.GetInt(cfgWriteRetries),
+		WriteRetryInterval:       appConfig.GetDuration(cfgWriteRetryInterval),
+
+		AuthMethod: appConfig.GetString(cfgAuthMethod),
+		AuthRole:   appConfig.GetString(cfgAuthRole),
+		AuthPath:   appConfig.GetString(cfgAuthPath),
+
+		AppRoleRoleID:          appConfig.GetString(cfgAppRoleRoleID),
+		AppRoleSecretIDFile:    appConfig.GetString(cfgAppRoleSecretIDFile),
+		AppRoleSecretIDWrapped: appConfig.GetBool(cfgAppRoleSecretIDWrapped),
+
+		ShareDecoder: shareDecoder,
 	}, nil
 }
 
+// parseManagedMetadata parses the key=value pairs of the managed metadata stamp
+func parseManagedMetadata(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	metadata := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("error parsing managed metadata '%s': expected key=value", pair)
+		}
+		metadata[parts[0]] = parts[1]
+	}
+	return metadata, nil
+}
+
+// parseShareBackends parses the <share index>=<backend config file> assignments of the unseal key shares
+func parseShareBackends(assignments []string) (map[int]string, error) {
+	shareBackends := map[int]string{}
+	for _, assignment := range assignments {
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("error parsing share backend '%s': expected <share index>=<backend config file>", assignment)
+		}
+		index, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing share index of '%s': %s", assignment, err.Error())
+		}
+		if _, ok := shareBackends[index]; ok {
+			return nil, fmt.Errorf("error parsing share backends: share %d is assigned more than once", index)
+		}
+		shareBackends[index] = parts[1]
+	}
+	return shareBackends, nil
+}
+
+// shareStoresForConfig creates the kv stores of the unseal key shares assigned to other backends,
+// the shares assigned to the same backend config file share its store. It warns if a backend
+// (including the default one of the unassigned shares) holds a threshold of shares.
+func shareStoresForConfig(cfg *viper.Viper) (map[int]kv.Service, error) {
+	shareBackends, err := parseShareBackends(cfg.GetStringSlice(cfgShareBackend))
+	if err != nil {
+		return nil, err
+	}
+	if len(shareBackends) == 0 {
+		return nil, nil
+	}
+
+	shares := map[string]int{}
+	for i := 0; i < cfg.GetInt(cfgSecretShares); i++ {
+		backend, ok := shareBackends[i]
+		if !ok {
+			backend = "--" + cfgMode + " " + cfg.GetString(cfgMode)
+		}
+		shares[backend]++
+	}
+	for backend, count := range shares {
+		if count >= cfg.GetInt(cfgSecretThreshold) {
+			logrus.Warnf("the kv backend %s holds %d unseal key shares, enough to unseal vault", backend, count)
+		}
+	}
+
+	stores := map[string]kv.Service{}
+	shareStores := map[int]kv.Service{}
+	for index, backend := range shareBackends {
+		store, ok := stores[backend]
+		if !ok {
+			store, err = kvStoreForConfigFile(backend)
+			if err != nil {
+				return nil, fmt.Errorf("error creating kv store of share %d: %s", index, err.Error())
+			}
+			stores[backend] = store
+		}
+		shareStores[index] = store
+	}
+	return shareStores, nil
+}
+
+// kvStoreForConfig returns the kv store of the configured backend mode, instrumented with metrics,
+// keeping the previous versions of the keys and cached for the cache TTL if they are set
 func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
+	store, err := kvStoreForMode(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store = newInstrumentedKV(store, cfg.GetString(cfgMode))
+
+	if versions := cfg.GetInt(cfgKVKeepVersions); versions > 0 {
+		store = versioned.New(store, versions)
+	}
+
+	if ttl := cfg.GetDuration(cfgKVCacheTTL); ttl > 0 {
+		store = cache.New(store, ttl)
+	}
+
+	return store, nil
+}
+
+func kvStoreForMode(cfg *viper.Viper) (kv.Service, error) {
 
 	switch mode := cfg.GetString(cfgMode); mode {
 
 	case cfgModeValueGoogleCloudKMSGCS:
-		gcs, err := gcs.New(
+		credentialsFile := cfg.GetString(cfgKVCredentialsFile)
+
+		gcs, err := gcs.NewWithCredentialsFile(
 			cfg.GetString(cfgGoogleCloudStorageBucket),
 			cfg.GetString(cfgGoogleCloudStoragePrefix),
+			credentialsFile,
 		)
 
 		if err != nil {
 			return nil, fmt.Errorf("error creating google cloud storage kv store: %s", err.Error())
 		}
 
-		kms, err := gckms.New(gcs,
+		kms, err := gckms.NewWithCredentialsFile(gcs,
 			cfg.GetString(cfgGoogleCloudKMSProject),
 			cfg.GetString(cfgGoogleCloudKMSLocation),
 			cfg.GetString(cfgGoogleCloudKMSKeyRing),
 			cfg.GetString(cfgGoogleCloudKMSCryptoKey),
+			credentialsFile,
 		)
 
 		if err != nil {
@@ -71,8 +229,16 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 		return kms, nil
 
 	case cfgModeValueAWSKMS3:
-		s3, err := s3.New(
-			cfg.GetString(cfgAWSS3Region),
+		credentialsFile := cfg.GetString(cfgKVCredentialsFile)
+
+		s3Session, err := awsSession(cfg.GetString(cfgAWSS3Region), credentialsFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS S3 session: %s", err.Error())
+		}
+
+		s3, err := s3.NewWithSession(
+			s3Session,
 			cfg.GetString(cfgAWSS3Bucket),
 			cfg.GetString(cfgAWSS3Prefix),
 		)
@@ -81,7 +247,13 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 			return nil, fmt.Errorf("error creating AWS S3 kv store: %s", err.Error())
 		}
 
-		kms, err := awskms.New(s3, cfg.GetString(cfgAWSKMSRegion), cfg.GetString(cfgAWSKMSKeyID))
+		kmsSession, err := awsSession(cfg.GetString(cfgAWSKMSRegion), credentialsFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS KMS session: %s", err.Error())
+		}
+
+		kms, err := awskms.NewWithSession(kmsSession, s3, cfg.GetString(cfgAWSKMSKeyID))
 
 		if err != nil {
 			return nil, fmt.Errorf("error creating AWS KMS kv store: %s", err.Error())
@@ -90,7 +262,13 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 		return kms, nil
 
 	case cfgModeValueAzureKeyVault:
-		akv, err := azurekv.New(cfg.GetString(cfgAzureKeyVaultName))
+		var akv kv.Service
+		var err error
+		if credentialsFile := cfg.GetString(cfgKVCredentialsFile); credentialsFile != "" {
+			akv, err = azurekv.NewWithCredentialsFile(cfg.GetString(cfgAzureKeyVaultName), credentialsFile)
+		} else {
+			akv, err = azurekv.New(cfg.GetString(cfgAzureKeyVaultName))
+		}
 		if err != nil {
 			return nil, fmt.Errorf("error creating Azure Key Vault kv store: %s", err.Error())
 		}
@@ -144,7 +322,66 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 			return nil, fmt.Errorf("error creating K8S Secret kv store: %s", err.Error())
 		}
 
-		return k8s, nil
+		return pkcs11Store(cfg, k8s)
+
+	case cfgModeValueConsul:
+		consul, err := consulStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		// the values are encrypted with a KMS key if one is configured, Consul KV stores them as they are
+		switch {
+		case cfg.GetString(cfgAWSKMSKeyID) != "":
+			kmsSession, err := awsSession(cfg.GetString(cfgAWSKMSRegion), cfg.GetString(cfgKVCredentialsFile))
+			if err != nil {
+				return nil, fmt.Errorf("error creating AWS KMS session: %s", err.Error())
+			}
+			kms, err := awskms.NewWithSession(kmsSession, consul, cfg.GetString(cfgAWSKMSKeyID))
+			if err != nil {
+				return nil, fmt.Errorf("error creating AWS KMS kv store: %s", err.Error())
+			}
+			return kms, nil
+
+		case cfg.GetString(cfgGoogleCloudKMSCryptoKey) != "":
+			kms, err := gckms.NewWithCredentialsFile(consul,
+				cfg.GetString(cfgGoogleCloudKMSProject),
+				cfg.GetString(cfgGoogleCloudKMSLocation),
+				cfg.GetString(cfgGoogleCloudKMSKeyRing),
+				cfg.GetString(cfgGoogleCloudKMSCryptoKey),
+				cfg.GetString(cfgKVCredentialsFile),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error creating google cloud kms kv store: %s", err.Error())
+			}
+			return kms, nil
+		}
+
+		logrus.Warnf("the values are stored in Consul KV unencrypted, set --%s or --%s to encrypt them", cfgAWSKMSKeyID, cfgGoogleCloudKMSCryptoKey)
+		return consul, nil
+
+	case cfgModeValueVaultTransit:
+		storage, err := vaultTransitStorage(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		transit, err := vaulttransit.New(storage,
+			cfg.GetString(cfgVaultTransitAddress),
+			cfg.GetString(cfgVaultTransitToken),
+			cfg.GetString(cfgVaultTransitMountPath),
+			cfg.GetString(cfgVaultTransitKeyName),
+			&api.TLSConfig{
+				CACert:        cfg.GetString(cfgVaultTransitCACert),
+				TLSServerName: cfg.GetString(cfgVaultTransitTLSServerName),
+				Insecure:      cfg.GetBool(cfgVaultTransitSkipVerify),
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating Vault Transit kv store: %s", err.Error())
+		}
+
+		return transit, nil
 
 	case cfgModeValueDev:
 		dev, err := dev.New()
@@ -160,9 +397,102 @@ func kvStoreForConfig(cfg *viper.Viper) (kv.Service, error) {
 			return nil, fmt.Errorf("error creating File kv store: %s", err.Error())
 		}
 
-		return file, nil
+		return pkcs11Store(cfg, file)
 
 	default:
 		return nil, fmt.Errorf("Unsupported backend mode: '%s'", cfg.GetString(cfgMode))
 	}
 }
+
+// pkcs11Store encrypts the values of the store with the key of the HSM if a PKCS#11 module is configured
+func pkcs11Store(cfg *viper.Viper, store kv.Service) (kv.Service, error) {
+	if cfg.GetString(cfgPKCS11Module) == "" {
+		return store, nil
+	}
+
+	hsm, err := pkcs11.New(store, pkcs11.Config{
+		ModulePath: cfg.GetString(cfgPKCS11Module),
+		SlotID:     uint(cfg.GetInt(cfgPKCS11Slot)),
+		PIN:        cfg.GetString(cfgPKCS11PIN),
+		KeyLabel:   cfg.GetString(cfgPKCS11KeyLabel),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating PKCS#11 kv store: %s", err.Error())
+	}
+	return hsm, nil
+}
+
+func consulStore(cfg *viper.Viper) (kv.Service, error) {
+	address := cfg.GetString(cfgConsulAddress)
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	token := cfg.GetString(cfgConsulToken)
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+
+	consul, err := consul.New(address, token, cfg.GetString(cfgConsulPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("error creating Consul kv store: %s", err.Error())
+	}
+	return consul, nil
+}
+
+// vaultTransitStorage returns the unencrypted kv store of the values encrypted with Vault Transit
+func vaultTransitStorage(cfg *viper.Viper) (kv.Service, error) {
+	switch storage := cfg.GetString(cfgVaultTransitStorage); storage {
+
+	case cfgVaultTransitStorageValueS3:
+		s3Session, err := awsSession(cfg.GetString(cfgAWSS3Region), cfg.GetString(cfgKVCredentialsFile))
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS S3 session: %s", err.Error())
+		}
+		s3, err := s3.NewWithSession(s3Session, cfg.GetString(cfgAWSS3Bucket), cfg.GetString(cfgAWSS3Prefix))
+		if err != nil {
+			return nil, fmt.Errorf("error creating AWS S3 kv store: %s", err.Error())
+		}
+		return s3, nil
+
+	case cfgVaultTransitStorageValueGCS:
+		gcs, err := gcs.NewWithCredentialsFile(
+			cfg.GetString(cfgGoogleCloudStorageBucket),
+			cfg.GetString(cfgGoogleCloudStoragePrefix),
+			cfg.GetString(cfgKVCredentialsFile),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error creating google cloud storage kv store: %s", err.Error())
+		}
+		return gcs, nil
+
+	case cfgVaultTransitStorageValueK8S:
+		k8s, err := k8s.New(cfg.GetString(cfgK8SNamespace), cfg.GetString(cfgK8SSecret))
+		if err != nil {
+			return nil, fmt.Errorf("error creating K8S Secret kv store: %s", err.Error())
+		}
+		return k8s, nil
+
+	case cfgVaultTransitStorageValueFile:
+		file, err := file.New(cfg.GetString(cfgFilePath))
+		if err != nil {
+			return nil, fmt.Errorf("error creating File kv store: %s", err.Error())
+		}
+		return file, nil
+
+	case cfgVaultTransitStorageValueConsul:
+		return consulStore(cfg)
+
+	default:
+		return nil, fmt.Errorf("Unsupported Vault Transit storage: '%s'", storage)
+	}
+}
+
+// awsSession creates an AWS session for the region, with the credentials of the
+// shared credentials file instead of the default credential chain if it is not empty
+func awsSession(region, credentialsFile string) (*session.Session, error) {
+	config := aws.NewConfig().WithRegion(region)
+	if credentialsFile != "" {
+		config = config.WithCredentials(credentials.NewSharedCredentials(credentialsFile, ""))
+	}
+	return session.NewSession(config)
+}
@@ -0,0 +1,80 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretFile is a file and the vault:path#key reference of the secret written to it
+type secretFile struct {
+	destination string
+	reference   string
+}
+
+// parseSecretFiles parses the comma separated destination=vault:path#key pairs of VAULT_FILES,
+// the reference can have the >> prefix of the environment variables
+func parseSecretFiles(value string) ([]secretFile, error) {
+	var files []secretFile
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || split[0] == "" {
+			return nil, fmt.Errorf("secret file '%s' is not in destination=vault:path#key form", pair)
+		}
+		reference := split[1]
+		update := strings.HasPrefix(reference, ">>")
+		reference = strings.TrimPrefix(reference, ">>")
+		if !strings.HasPrefix(reference, "vault:") {
+			return nil, fmt.Errorf("secret file '%s' is not in destination=vault:path#key form", pair)
+		}
+		reference = strings.TrimPrefix(reference, "vault:")
+		if update {
+			reference = ">>" + reference
+		}
+		files = append(files, secretFile{destination: split[0], reference: reference})
+	}
+	return files, nil
+}
+
+// writeSecretFiles writes the secrets to the files readable only by the process, the files
+// should be on a tmpfs volume (the in-memory volume injected by the webhook)
+func writeSecretFiles(files []secretFile, read secretReader) error {
+	values := secretValues(read)
+
+	for _, file := range files {
+		value, err := values(file.reference)
+		if err != nil {
+			return fmt.Errorf("failed to read the secret of '%s': %s", file.destination, err.Error())
+		}
+
+		err = os.MkdirAll(filepath.Dir(file.destination), 0700)
+		if err != nil {
+			return fmt.Errorf("failed to create the directory of '%s': %s", file.destination, err.Error())
+		}
+		err = ioutil.WriteFile(file.destination, []byte(fmt.Sprint(value)), 0600)
+		if err != nil {
+			return fmt.Errorf("failed to write '%s': %s", file.destination, err.Error())
+		}
+	}
+	return nil
+}
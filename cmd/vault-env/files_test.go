@@ -0,0 +1,76 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	username := filepath.Join(dir, "secrets", "username")
+	password := filepath.Join(dir, "secrets", "password")
+	files, err := parseSecretFiles(fmt.Sprintf("%s=>>vault:database/creds/app#username, %s=>>vault:database/creds/app#password", username, password))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads := 0
+	read := func(path string, update bool) (map[string]interface{}, error) {
+		if path != "database/creds/app" || !update {
+			return nil, fmt.Errorf("unexpected read of %s (update: %t)", path, update)
+		}
+		reads++
+		return map[string]interface{}{
+			"username": fmt.Sprintf("v-app-%d", reads),
+			"password": fmt.Sprintf("password-%d", reads),
+		}, nil
+	}
+
+	err = writeSecretFiles(files, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string]string{username: "v-app-1", password: "password-1"} {
+		written, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(written) != expected {
+			t.Errorf("expected %s in %s, got %s", expected, file, written)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected %s to be readable only by the owner, got %s", file, info.Mode())
+		}
+	}
+
+	if _, err := parseSecretFiles("/vault/secrets/password=secret/data/app#password"); err == nil {
+		t.Error("expected a secret file without a vault: reference to fail")
+	}
+}
@@ -47,6 +47,8 @@ var sanitizeEnvmap = map[string]bool {
 	"VAULT_MFA": true,
 	"VAULT_ROLE": true,
 	"VAULT_PATH": true,
+	"VAULT_TEMPLATES": true,
+	"VAULT_FILES": true,
 }
 
 // Appends variable an entry (name=value) into the environ list.
@@ -58,6 +60,33 @@ func (environ *sanitizedEnviron) append(iname interface{}, ivalue interface{}) {
 	}
 }
 
+// readSecret returns the data of the secret at the path (unwrapped for KV version 2),
+// the path is written instead of read if update is set
+func readSecret(client *vault.Client, path string, update bool) (map[string]interface{}, error) {
+	var secret *vaultapi.Secret
+	var err error
+
+	if update {
+		var empty map[string]interface{}
+		secret, err = client.Vault().Logical().Write(path, empty)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write secret '%s': %s", path, err.Error())
+		}
+	} else {
+		secret, err = client.Vault().Logical().Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret '%s': %s", path, err.Error())
+		}
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+	if v2Data, ok := secret.Data["data"]; ok {
+		return cast.ToStringMap(v2Data), nil
+	}
+	return cast.ToStringMap(secret.Data), nil
+}
 
 func main() {
 
@@ -101,48 +130,52 @@ func main() {
 				key = split[1]
 			}
 			
-			var secret *vaultapi.Secret
-			var err error
-			
-			if update {
-				var empty map[string]interface{}
-				secret, err = client.Vault().Logical().Write(path, empty)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "failed to write secret '%s': %s\n", path, err.Error())
-					os.Exit(1)
-				}
-			} else {
-				secret, err = client.Vault().Logical().Read(path)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "failed to read secret '%s': %s\n", path, err.Error())
-					os.Exit(1)
-				}
-			}
-		
-
-			if secret == nil {
-				fmt.Fprintf(os.Stderr, "path not found: %s\n", path)
+			data, err := readSecret(client, path, update)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 				os.Exit(1)
+			}
+			if value, ok := data[key]; ok {
+				sanitized.append(name, value)
 			} else {
-				var data map[string]interface{}
-				v2Data, ok := secret.Data["data"]
-				if ok {
-					data = cast.ToStringMap(v2Data)
-				} else {
-					data = cast.ToStringMap(secret.Data)
-				}
-				if value, ok := data[key]; ok {
-					sanitized.append(name, value)
-				} else {
-					fmt.Fprintf(os.Stderr, "key not found: %s\n", key)
-					os.Exit(1)
-				}
+				fmt.Fprintf(os.Stderr, "key not found: %s\n", key)
+				os.Exit(1)
 			}
 		} else {
 			sanitized.append(name, value)
 		}
 	}
 
+	if templates := os.Getenv("VAULT_TEMPLATES"); templates != "" {
+		files, err := parseTemplateFiles(templates)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse VAULT_TEMPLATES: %s\n", err.Error())
+			os.Exit(1)
+		}
+		err = renderTemplates(files, func(path string, update bool) (map[string]interface{}, error) {
+			return readSecret(client, path, update)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if secretFiles := os.Getenv("VAULT_FILES"); secretFiles != "" {
+		files, err := parseSecretFiles(secretFiles)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to parse VAULT_FILES: %s\n", err.Error())
+			os.Exit(1)
+		}
+		err = writeSecretFiles(files, func(path string, update bool) (map[string]interface{}, error) {
+			return readSecret(client, path, update)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+	}
+
 	if len(os.Args) == 1 {
 		fmt.Fprintf(os.Stderr, "no command is given, currently vault-env can't determine the entrypoint (command), please specify it explicitly")
 		os.Exit(1)
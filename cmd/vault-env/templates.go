@@ -0,0 +1,129 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+)
+
+// templateFile is a template file and the file it is rendered to
+type templateFile struct {
+	source      string
+	destination string
+}
+
+// secretReader returns the data of the secret at the path, it is written (e.g. to issue a
+// certificate) instead of read if update is set
+type secretReader func(path string, update bool) (map[string]interface{}, error)
+
+// parseTemplateFiles parses the comma separated source=destination pairs of VAULT_TEMPLATES
+func parseTemplateFiles(value string) ([]templateFile, error) {
+	var files []templateFile
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			return nil, fmt.Errorf("template '%s' is not in source=destination form", pair)
+		}
+		files = append(files, templateFile{source: split[0], destination: split[1]})
+	}
+	return files, nil
+}
+
+// templateFuncs are the sprig functions and vault, which returns the value of a key of a
+// secret given as path#key, or the data of the secret without a key, e.g.
+// ${ vault "secret/data/db#password" } or ${ (vault "secret/data/db").password }. The path can
+// have the >> prefix of the environment variables to write it.
+func templateFuncs(read secretReader) template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["vault"] = secretValues(read)
+	return funcs
+}
+
+// secretValues returns the function resolving the path#key (or path) references of the templates
+// and the secret files. Every secret is only requested once, so the keys of a dynamic secret
+// (e.g. a database username and password) belong together.
+func secretValues(read secretReader) func(reference string) (interface{}, error) {
+	secrets := map[string]map[string]interface{}{}
+
+	return func(reference string) (interface{}, error) {
+		split := strings.SplitN(reference, "#", 2)
+		path := split[0]
+
+		data, ok := secrets[path]
+		if !ok {
+			var err error
+			data, err = read(strings.TrimPrefix(path, ">>"), strings.HasPrefix(path, ">>"))
+			if err != nil {
+				return nil, err
+			}
+			secrets[path] = data
+		}
+
+		if len(split) == 1 {
+			return data, nil
+		}
+		value, ok := data[split[1]]
+		if !ok {
+			return nil, fmt.Errorf("key not found: %s", split[1])
+		}
+		return value, nil
+	}
+}
+
+// renderTemplates renders the template files with the secrets and writes them readable only by
+// the process, the destination should be on a tmpfs volume (e.g. the in-memory volume of vault-env)
+// so the secrets don't end up on a disk
+func renderTemplates(files []templateFile, read secretReader) error {
+	funcs := templateFuncs(read)
+
+	for _, file := range files {
+		name := filepath.Base(file.source)
+		tmpl, err := template.New(name).
+			Funcs(funcs).
+			Delims("${", "}").
+			ParseFiles(file.source)
+		if err != nil {
+			return fmt.Errorf("failed to parse template '%s': %s", file.source, err.Error())
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err = tmpl.ExecuteTemplate(buffer, name, nil)
+		if err != nil {
+			return fmt.Errorf("failed to render template '%s': %s", file.source, err.Error())
+		}
+
+		err = os.MkdirAll(filepath.Dir(file.destination), 0700)
+		if err != nil {
+			return fmt.Errorf("failed to create the directory of '%s': %s", file.destination, err.Error())
+		}
+		err = ioutil.WriteFile(file.destination, buffer.Bytes(), 0600)
+		if err != nil {
+			return fmt.Errorf("failed to write '%s': %s", file.destination, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "application.properties.tmpl")
+	err = ioutil.WriteFile(source, []byte(`spring.datasource.url=jdbc:postgresql://db:5432/app
+spring.datasource.username=${ vault "database/creds/app#username" }
+spring.datasource.password=${ vault "database/creds/app#password" }
+api.key=${ (vault "secret/data/app").apiKey | upper }
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	destination := filepath.Join(dir, "secrets", "application.properties")
+
+	files, err := parseTemplateFiles(source + "=" + destination + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads := map[string]int{}
+	read := func(path string, update bool) (map[string]interface{}, error) {
+		reads[path]++
+		switch path {
+		case "database/creds/app":
+			// a dynamic secret, every read returns new credentials
+			return map[string]interface{}{
+				"username": fmt.Sprintf("v-app-%d", reads[path]),
+				"password": fmt.Sprintf("password-%d", reads[path]),
+			}, nil
+		case "secret/data/app":
+			return map[string]interface{}{"apiKey": "abc"}, nil
+		}
+		return nil, fmt.Errorf("path not found: %s", path)
+	}
+
+	err = renderTemplates(files, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := ioutil.ReadFile(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `spring.datasource.url=jdbc:postgresql://db:5432/app
+spring.datasource.username=v-app-1
+spring.datasource.password=password-1
+api.key=ABC
+`
+	if string(rendered) != expected {
+		t.Errorf("expected the rendered file:\n%s\ngot:\n%s", expected, rendered)
+	}
+	if reads["database/creds/app"] != 1 {
+		t.Errorf("expected the dynamic secret to be read once, got %d", reads["database/creds/app"])
+	}
+	info, err := os.Stat(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the rendered file to be readable only by the owner, got %s", info.Mode())
+	}
+
+	err = ioutil.WriteFile(source, []byte(`${ vault "secret/data/app#missing" }`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := renderTemplates(files, read); err == nil || !strings.Contains(err.Error(), "key not found: missing") {
+		t.Errorf("expected a missing key to fail the rendering, got %v", err)
+	}
+
+	if _, err := parseTemplateFiles("/templates/app.conf"); err == nil {
+		t.Error("expected a template without a destination to fail")
+	}
+}
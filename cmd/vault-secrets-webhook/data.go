@@ -0,0 +1,207 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// secretReader returns the data of the secret at the path
+type secretReader func(path string) (map[string]interface{}, error)
+
+// serviceAccountTokenExpiration is the lifetime of the Service Account tokens the webhook
+// requests to log in to Vault, the shortest one the TokenRequest API allows
+const serviceAccountTokenExpiration = 600
+
+// mutateData tells if the vault: references of the ConfigMap or Secret have to be resolved
+func mutateData(obj metav1.Object) bool {
+	mutate, _ := strconv.ParseBool(obj.GetAnnotations()["vault.security.banzaicloud.io/mutate"])
+	return mutate
+}
+
+func isVaultReference(value string) bool {
+	return strings.HasPrefix(value, "vault:") || strings.HasPrefix(value, ">>vault:")
+}
+
+// resolveReference returns the value of the vault:path#key reference, the >>vault: references
+// would write to Vault on every admission, they are only supported in the pods
+func resolveReference(reference string, read secretReader) (string, error) {
+	if strings.HasPrefix(reference, ">>") {
+		return "", fmt.Errorf("the >>vault: references can only be used in pods")
+	}
+	path := strings.TrimPrefix(reference, "vault:")
+	split := strings.SplitN(path, "#", 2)
+
+	var key string
+	if len(split) > 1 {
+		key = split[1]
+	}
+
+	data, err := read(split[0])
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key not found: %s", key)
+	}
+	return cast.ToStringE(value)
+}
+
+// lazySecretReader creates the reader (e.g. logs in to Vault) at the first read only, so the
+// objects without references don't need a Vault client, the returned function closes it
+func lazySecretReader(newReader func() (secretReader, func(), error)) (secretReader, func()) {
+	var read secretReader
+	var closeReader func()
+
+	lazy := func(path string) (map[string]interface{}, error) {
+		if read == nil {
+			var err error
+			read, closeReader, err = newReader()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return read(path)
+	}
+	return lazy, func() {
+		if closeReader != nil {
+			closeReader()
+		}
+	}
+}
+
+// mutateConfigMap replaces the vault: references of the data with the secrets
+func mutateConfigMap(configMap *corev1.ConfigMap, read secretReader) error {
+	for key, value := range configMap.Data {
+		if !isVaultReference(value) {
+			continue
+		}
+		resolved, err := resolveReference(value, read)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the key %s of ConfigMap %s: %s", key, configMap.Name, err.Error())
+		}
+		configMap.Data[key] = resolved
+	}
+	return nil
+}
+
+// mutateSecret replaces the vault: references of the data and the string data with the secrets
+func mutateSecret(secret *corev1.Secret, read secretReader) error {
+	for key, value := range secret.Data {
+		if !isVaultReference(string(value)) {
+			continue
+		}
+		resolved, err := resolveReference(string(value), read)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the key %s of Secret %s: %s", key, secret.Name, err.Error())
+		}
+		secret.Data[key] = []byte(resolved)
+	}
+	for key, value := range secret.StringData {
+		if !isVaultReference(value) {
+			continue
+		}
+		resolved, err := resolveReference(value, read)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the key %s of Secret %s: %s", key, secret.Name, err.Error())
+		}
+		secret.StringData[key] = resolved
+	}
+	return nil
+}
+
+// vaultSecretReader logs in to Vault with the role of the annotations and a short-lived token of
+// the Service Account of the annotations in the namespace of the object, so the role has to be
+// bound to that Service Account, not to the one of the webhook. The returned function revokes
+// the Vault token.
+func vaultSecretReader(vaultConfig vaultConfig, namespace string, client kubernetes.Interface) (secretReader, func(), error) {
+	config := vaultapi.DefaultConfig()
+	if vaultConfig.addr != "" {
+		config.Address = vaultConfig.addr
+	}
+	if skipVerify, _ := strconv.ParseBool(vaultConfig.skipVerify); skipVerify {
+		err := config.ConfigureTLS(&vaultapi.TLSConfig{Insecure: true})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cl, err := vaultapi.NewClient(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create vault client: %s", err.Error())
+	}
+	// never the token of the webhook itself
+	cl.ClearToken()
+
+	jwt, err := serviceAccountToken(client, namespace, vaultConfig.serviceAccount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	login, err := cl.Logical().Write(fmt.Sprintf("auth/%s/login", vaultConfig.path), map[string]interface{}{
+		"jwt":  jwt,
+		"role": vaultConfig.role,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to log in to vault as %s/%s: %s", namespace, vaultConfig.serviceAccount, err.Error())
+	}
+	if login == nil || login.Auth == nil || login.Auth.ClientToken == "" {
+		return nil, nil, fmt.Errorf("failed to log in to vault as %s/%s: no token returned", namespace, vaultConfig.serviceAccount)
+	}
+	cl.SetToken(login.Auth.ClientToken)
+
+	read := func(path string) (map[string]interface{}, error) {
+		secret, err := cl.Logical().Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret '%s': %s", path, err.Error())
+		}
+		if secret == nil {
+			return nil, fmt.Errorf("path not found: %s", path)
+		}
+		if v2Data, ok := secret.Data["data"]; ok {
+			return cast.ToStringMap(v2Data), nil
+		}
+		return cast.ToStringMap(secret.Data), nil
+	}
+	revoke := func() {
+		cl.Auth().Token().RevokeSelf("")
+	}
+	return read, revoke, nil
+}
+
+// serviceAccountToken requests a short-lived token of the Service Account
+func serviceAccountToken(client kubernetes.Interface, namespace, name string) (string, error) {
+	expiration := int64(serviceAccountTokenExpiration)
+	tokenRequest, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expiration},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to request a token of service account %s/%s: %s", namespace, name, err.Error())
+	}
+	if tokenRequest.Status.Token == "" {
+		return "", fmt.Errorf("failed to request a token of service account %s/%s: no token returned", namespace, name)
+	}
+	return tokenRequest.Status.Token, nil
+}
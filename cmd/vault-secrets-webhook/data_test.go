@@ -0,0 +1,217 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testSecretReader(path string) (map[string]interface{}, error) {
+	if path == "secret/data/app" {
+		return map[string]interface{}{"password": "s3cr3t", "port": 5432}, nil
+	}
+	return nil, fmt.Errorf("path not found: %s", path)
+}
+
+func TestMutateConfigMap(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Annotations: map[string]string{"vault.security.banzaicloud.io/mutate": "true"},
+		},
+		Data: map[string]string{
+			"password": "vault:secret/data/app#password",
+			"port":     "vault:secret/data/app#port",
+			"host":     "db",
+		},
+	}
+	if !mutateData(configMap) {
+		t.Fatal("expected the annotated ConfigMap to be mutated")
+	}
+
+	err := mutateConfigMap(configMap, testSecretReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"password": "s3cr3t", "port": "5432", "host": "db"}
+	for key, value := range expected {
+		if configMap.Data[key] != value {
+			t.Errorf("expected %s to be %s, got %s", key, value, configMap.Data[key])
+		}
+	}
+
+	configMap.Data["missing"] = "vault:secret/data/app#missing"
+	if err := mutateConfigMap(configMap, testSecretReader); err == nil {
+		t.Error("expected a missing key to fail the mutation")
+	}
+
+	// the writes to Vault aren't run on the admission of ConfigMaps and Secrets
+	delete(configMap.Data, "missing")
+	configMap.Data["username"] = ">>vault:database/creds/app#username"
+	if err := mutateConfigMap(configMap, testSecretReader); err == nil {
+		t.Error("expected a >>vault: reference to fail the mutation")
+	}
+}
+
+func TestMutateSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Data:       map[string][]byte{"password": []byte("vault:secret/data/app#password")},
+		StringData: map[string]string{"port": "vault:secret/data/app#port"},
+	}
+	if mutateData(secret) {
+		t.Fatal("expected the Secret without the annotation not to be mutated")
+	}
+
+	err := mutateSecret(secret, testSecretReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["password"]) != "s3cr3t" || secret.StringData["port"] != "5432" {
+		t.Errorf("expected the references to be resolved, got %v %v", secret.Data, secret.StringData)
+	}
+}
+
+func TestVaultSecretReader(t *testing.T) {
+	var login map[string]interface{}
+	var readToken string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			json.NewDecoder(r.Body).Decode(&login)
+			json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": "app-token"}})
+		case "/v1/secret/data/app":
+			readToken = r.Header.Get("X-Vault-Token")
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": map[string]interface{}{"password": "s3cr3t"}}})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	var tokenNamespace, tokenServiceAccount string
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateActionImpl)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tokenNamespace = create.GetNamespace()
+		tokenServiceAccount = create.Name
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "app-jwt"}}, nil
+	})
+
+	vaultConfig := parseVaultConfig(&metav1.ObjectMeta{Annotations: map[string]string{
+		"vault.security.banzaicloud.io/vault-addr":           server.URL,
+		"vault.security.banzaicloud.io/vault-role":           "app",
+		"vault.security.banzaicloud.io/vault-serviceaccount": "app",
+	}})
+	read, closeReader, err := vaultSecretReader(vaultConfig, "apps", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeReader()
+
+	if tokenNamespace != "apps" || tokenServiceAccount != "app" {
+		t.Errorf("expected a token of the service account of the namespace, got %s/%s", tokenNamespace, tokenServiceAccount)
+	}
+	if login["jwt"] != "app-jwt" || login["role"] != "app" {
+		t.Errorf("expected the login with the token of the service account, got %v", login)
+	}
+
+	data, err := read("secret/data/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["password"] != "s3cr3t" || readToken != "app-token" {
+		t.Errorf("expected the secret to be read with the token of the namespace, got %v with %s", data, readToken)
+	}
+}
+
+func TestLazySecretReader(t *testing.T) {
+	created, closed := 0, 0
+	read, closeReader := lazySecretReader(func() (secretReader, func(), error) {
+		created++
+		return testSecretReader, func() { closed++ }, nil
+	})
+
+	err := mutateConfigMap(&corev1.ConfigMap{Data: map[string]string{"host": "db"}}, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 0 {
+		t.Fatal("expected no reader to be created without references")
+	}
+
+	err = mutateConfigMap(&corev1.ConfigMap{Data: map[string]string{
+		"a": "vault:secret/data/app#password",
+		"b": "vault:secret/data/app#port",
+	}}, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeReader()
+	if created != 1 || closed != 1 {
+		t.Errorf("expected the reader to be created and closed once, got %d and %d", created, closed)
+	}
+}
+
+func TestMutateContainersSecretFiles(t *testing.T) {
+	vaultConfig := parseVaultConfig(&metav1.ObjectMeta{Annotations: map[string]string{
+		"vault.security.banzaicloud.io/vault-files": "db-password=vault:secret/data/db#password, db-username=>>vault:database/creds/app#username",
+	}})
+	containers := []corev1.Container{{Name: "app", Command: []string{"app"}}}
+
+	mutated, err := mutateContainers(containers, vaultConfig, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mutated {
+		t.Fatal("expected the container to be mutated for the secret files")
+	}
+
+	var files string
+	for _, env := range containers[0].Env {
+		if env.Name == "VAULT_FILES" {
+			files = env.Value
+		}
+	}
+	expected := "/vault/secrets/db-password=vault:secret/data/db#password,/vault/secrets/db-username=>>vault:database/creds/app#username"
+	if files != expected {
+		t.Errorf("expected VAULT_FILES %s, got %s", expected, files)
+	}
+	if mount := containers[0].VolumeMounts[len(containers[0].VolumeMounts)-1]; mount.Name != "vault-secrets" || mount.MountPath != "/vault/secrets" {
+		t.Errorf("expected the in-memory volume to be mounted, got %v", mount)
+	}
+	if containers[0].Command[0] != "/vault/vault-env" {
+		t.Errorf("expected the command to be run by vault-env, got %v", containers[0].Command)
+	}
+
+	volumes := getVolumes("app", vaultConfig)
+	if last := volumes[len(volumes)-1]; last.Name != "vault-secrets" || last.EmptyDir == nil || last.EmptyDir.Medium != corev1.StorageMediumMemory {
+		t.Errorf("expected an in-memory vault-secrets volume, got %v", last)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 
@@ -40,6 +41,10 @@ type vaultConfig struct {
 	path       string
 	skipVerify string
 	useAgent   bool
+	files      string
+	filesPath  string
+	// the Service Account the references of ConfigMaps and Secrets are read with
+	serviceAccount string
 }
 
 var vaultAgentConfig = `
@@ -134,15 +139,60 @@ func getVolumes(name string, vaultConfig vaultConfig) []corev1.Volume {
 		})
 	}
 
+	if vaultConfig.files != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: "vault-secrets",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{
+					Medium: corev1.StorageMediumMemory,
+				},
+			},
+		})
+	}
+
 	return volumes
 }
 
+// getSecretFiles returns the VAULT_FILES of vault-env, the comma separated name=vault:path#key
+// pairs of the vault-files annotation with the names under the path of the in-memory volume
+func getSecretFiles(vaultConfig vaultConfig) string {
+	var files []string
+	for _, pair := range strings.Split(vaultConfig.files, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		split := strings.SplitN(pair, "=", 2)
+		if len(split) != 2 {
+			// vault-env fails on the malformed pair
+			files = append(files, pair)
+			continue
+		}
+		files = append(files, path.Join(vaultConfig.filesPath, split[0])+"="+split[1])
+	}
+	return strings.Join(files, ",")
+}
+
 func vaultSecretsMutator(ctx context.Context, obj metav1.Object) (bool, error) {
 	var podSpec *corev1.PodSpec
 
 	switch v := obj.(type) {
 	case *corev1.Pod:
 		podSpec = &v.Spec
+	case *corev1.ConfigMap:
+		if !mutateData(v) {
+			return false, nil
+		}
+		read, closeReader := lazySecretReader(namespaceSecretReader(obj, whcontext.GetAdmissionRequest(ctx).Namespace))
+		defer closeReader()
+		return false, mutateConfigMap(v, read)
+	case *corev1.Secret:
+		if !mutateData(v) {
+			return false, nil
+		}
+		read, closeReader := lazySecretReader(namespaceSecretReader(obj, whcontext.GetAdmissionRequest(ctx).Namespace))
+		defer closeReader()
+		return false, mutateSecret(v, read)
 	default:
 		return false, nil
 	}
@@ -152,6 +202,18 @@ func vaultSecretsMutator(ctx context.Context, obj metav1.Object) (bool, error) {
 	return false, mutatePodSpec(obj, podSpec, vaultConfig, whcontext.GetAdmissionRequest(ctx).Namespace)
 }
 
+// namespaceSecretReader creates the Vault secret reader of the ConfigMap or Secret, which logs in
+// as the Service Account of its namespace
+func namespaceSecretReader(obj metav1.Object, ns string) func() (secretReader, func(), error) {
+	return func() (secretReader, func(), error) {
+		clientset, err := newClientSet()
+		if err != nil {
+			return nil, nil, err
+		}
+		return vaultSecretReader(parseVaultConfig(obj), ns, clientset)
+	}
+}
+
 func parseVaultConfig(obj metav1.Object) vaultConfig {
 	var vaultConfig vaultConfig
 	annotations := obj.GetAnnotations()
@@ -164,8 +226,17 @@ func parseVaultConfig(obj metav1.Object) vaultConfig {
 	if vaultConfig.path == "" {
 		vaultConfig.path = "kubernetes"
 	}
+	vaultConfig.serviceAccount = annotations["vault.security.banzaicloud.io/vault-serviceaccount"]
+	if vaultConfig.serviceAccount == "" {
+		vaultConfig.serviceAccount = "default"
+	}
 	vaultConfig.skipVerify = annotations["vault.security.banzaicloud.io/vault-skip-verify"]
 	vaultConfig.useAgent, _ = strconv.ParseBool(annotations["vault.security.banzaicloud.io/vault-agent"])
+	vaultConfig.files = annotations["vault.security.banzaicloud.io/vault-files"]
+	vaultConfig.filesPath = annotations["vault.security.banzaicloud.io/vault-files-path"]
+	if vaultConfig.filesPath == "" {
+		vaultConfig.filesPath = "/vault/secrets"
+	}
 	return vaultConfig
 }
 
@@ -291,7 +362,8 @@ func mutateContainers(containers []corev1.Container, vaultConfig vaultConfig, ns
 		}
 
 		for _, env := range container.Env {
-			if strings.HasPrefix(env.Value, "vault:") {
+			// the template files are rendered by vault-env too
+			if strings.HasPrefix(env.Value, "vault:") || env.Name == "VAULT_TEMPLATES" {
 				envVars = append(envVars, env)
 			}
 			if env.ValueFrom != nil {
@@ -305,7 +377,7 @@ func mutateContainers(containers []corev1.Container, vaultConfig vaultConfig, ns
 				envVars = append(envVars, *valueFrom)
 			}
 		}
-		if len(envVars) == 0 {
+		if len(envVars) == 0 && vaultConfig.files == "" {
 			continue
 		}
 
@@ -349,6 +421,18 @@ func mutateContainers(containers []corev1.Container, vaultConfig vaultConfig, ns
 			})
 		}
 
+		// the secret files are written by vault-env to the in-memory volume
+		if vaultConfig.files != "" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      "vault-secrets",
+				MountPath: vaultConfig.filesPath,
+			})
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  "VAULT_FILES",
+				Value: getSecretFiles(vaultConfig),
+			})
+		}
+
 		containers[i] = container
 	}
 
@@ -440,9 +524,13 @@ func main() {
 	mutator := mutating.MutatorFunc(vaultSecretsMutator)
 
 	podHandler := handlerFor(mutating.WebhookConfig{Name: "vault-secrets-pods", Obj: &corev1.Pod{}}, mutator, logger)
+	configMapHandler := handlerFor(mutating.WebhookConfig{Name: "vault-secrets-configmaps", Obj: &corev1.ConfigMap{}}, mutator, logger)
+	secretHandler := handlerFor(mutating.WebhookConfig{Name: "vault-secrets-secrets", Obj: &corev1.Secret{}}, mutator, logger)
 
 	mux := http.NewServeMux()
 	mux.Handle("/pods", podHandler)
+	mux.Handle("/configmaps", configMapHandler)
+	mux.Handle("/secrets", secretHandler)
 
 	logger.Infof("Listening on :8443")
 	err := http.ListenAndServeTLS(":8443", viper.GetString("tls_cert_file"), viper.GetString("tls_private_key_file"), mux)
@@ -288,6 +288,7 @@ type UnsealConfig struct {
 	Alibaba    *AlibabaUnsealConfig    `json:"alibaba,omitempty"`
 	Azure      *AzureUnsealConfig      `json:"azure,omitempty"`
 	AWS        *AWSUnsealConfig        `json:"aws,omitempty"`
+	Consul     *ConsulUnsealConfig     `json:"consul,omitempty"`
 }
 
 // ToArgs returns the UnsealConfig as and argument array for bank-vaults
@@ -354,6 +355,20 @@ func (usc *UnsealConfig) ToArgs(vault *Vault) []string {
 			usc.Alibaba.OSSPrefix,
 		}
 	}
+	if usc.Consul != nil {
+		args := []string{
+			"--mode",
+			"consul",
+			"--consul-address",
+			usc.Consul.Address,
+			"--consul-prefix",
+			usc.Consul.Prefix,
+		}
+		if usc.Consul.KMSKeyID != "" {
+			args = append(args, "--aws-kms-key-id", usc.Consul.KMSKeyID, "--aws-kms-region", usc.Consul.KMSRegion)
+		}
+		return args
+	}
 	return []string{}
 }
 
@@ -396,6 +411,16 @@ type AWSUnsealConfig struct {
 	S3Region  string `json:"s3Region"`
 }
 
+// ConsulUnsealConfig holds the parameters for Consul KV based unsealing, the ACL token is passed
+// as the BANK_VAULTS_CONSUL_TOKEN environment variable (see envsConfig), the values are encrypted
+// with the AWS KMS key if it is set
+type ConsulUnsealConfig struct {
+	Address   string `json:"address"`
+	Prefix    string `json:"prefix"`
+	KMSKeyID  string `json:"kmsKeyId,omitempty"`
+	KMSRegion string `json:"kmsRegion,omitempty"`
+}
+
 // CredentialsConfig configuration for a credentials file provided as a secret
 type CredentialsConfig struct {
 	Env        string `json:"env"`
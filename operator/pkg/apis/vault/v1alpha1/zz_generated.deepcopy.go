@@ -73,6 +73,22 @@ func (in *AzureUnsealConfig) DeepCopy() *AzureUnsealConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConsulUnsealConfig) DeepCopyInto(out *ConsulUnsealConfig) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConsulUnsealConfig.
+func (in *ConsulUnsealConfig) DeepCopy() *ConsulUnsealConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ConsulUnsealConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CredentialsConfig) DeepCopyInto(out *CredentialsConfig) {
 	*out = *in
@@ -204,6 +220,11 @@ func (in *UnsealConfig) DeepCopyInto(out *UnsealConfig) {
 		*out = new(AWSUnsealConfig)
 		**out = **in
 	}
+	if in.Consul != nil {
+		in, out := &in.Consul, &out.Consul
+		*out = new(ConsulUnsealConfig)
+		**out = **in
+	}
 	return
 }
 
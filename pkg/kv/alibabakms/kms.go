@@ -102,3 +102,13 @@ func (a *alibabaKMS) Test(key string) error {
 
 	return nil
 }
+
+// List returns the keys of the backend store, the names of the keys are not encrypted
+func (a *alibabaKMS) List() ([]string, error) {
+	return a.store.List()
+}
+
+// Delete deletes the encrypted value from the underlying store
+func (a *alibabaKMS) Delete(key string) error {
+	return kv.Delete(a.store, key)
+}
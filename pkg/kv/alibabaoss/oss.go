@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
@@ -84,6 +86,21 @@ func (o *ossStorage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (o *ossStorage) Delete(key string) error {
+	objectKey := objectNameWithPrefix(o.prefix, key)
+
+	bucket, err := o.client.Bucket(o.bucket)
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.DeleteObject(objectKey); err != nil {
+		return fmt.Errorf("error deleting key '%s' from OSS bucket '%s': '%s'", objectKey, o.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
@@ -92,3 +109,29 @@ func (o *ossStorage) Test(key string) error {
 	// TODO: Implement me properly
 	return nil
 }
+
+func (o *ossStorage) List() ([]string, error) {
+	bucket, err := o.client.Bucket(o.bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	marker := ""
+	for {
+		result, err := bucket.ListObjects(oss.Prefix(o.prefix), oss.Marker(marker))
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects of OSS bucket '%s': %s", o.bucket, err.Error())
+		}
+		for _, object := range result.Objects {
+			keys = append(keys, strings.TrimPrefix(object.Key, o.prefix))
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -31,6 +31,7 @@ type awsKMS struct {
 }
 
 var _ kv.Service = &awsKMS{}
+var _ kv.Preflighter = &awsKMS{}
 
 // NewWithSession creates a new kv.Service encrypted by AWS KMS with and existing AWS Session
 func NewWithSession(sess *session.Session, store kv.Service, kmsID string) (kv.Service, error) {
@@ -120,3 +121,32 @@ func (a *awsKMS) Test(key string) error {
 
 	return nil
 }
+
+// List returns the keys of the backend store, the names of the keys are not encrypted
+func (a *awsKMS) List() ([]string, error) {
+	return a.store.List()
+}
+
+// Preflight probes the KMS permissions with the KMS key, then the permissions of the backend store
+func (a *awsKMS) Preflight(probeKey string) []kv.PreflightCheck {
+	resource := a.kmsID
+
+	cipherText, err := a.encrypt([]byte("bank-vaults preflight"))
+	checks := []kv.PreflightCheck{{Permission: "kms:Encrypt", Resource: resource, Err: err}}
+	if err != nil {
+		return checks
+	}
+
+	_, err = a.decrypt(cipherText)
+	checks = append(checks, kv.PreflightCheck{Permission: "kms:Decrypt", Resource: resource, Err: err})
+	if err != nil {
+		return checks
+	}
+
+	return append(checks, kv.Preflight(a.store, probeKey)...)
+}
+
+// Delete deletes the encrypted value from the underlying store
+func (a *awsKMS) Delete(key string) error {
+	return kv.Delete(a.store, key)
+}
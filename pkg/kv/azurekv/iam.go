@@ -42,6 +42,17 @@ func init() {
 	}
 }
 
+// NewKeyvaultAuthorizerFromFile creates an authorizer for the keyvault dataplane from an Azure SDK auth file
+func NewKeyvaultAuthorizerFromFile(authFile string) (autorest.Authorizer, error) {
+	// the auth file location can be only passed in the environment
+	err := os.Setenv("AZURE_AUTH_LOCATION", authFile)
+	if err != nil {
+		return nil, err
+	}
+	resource := strings.TrimSuffix(azure.PublicCloud.KeyVaultEndpoint, "/")
+	return auth.NewAuthorizerFromFileWithResource(resource)
+}
+
 // GetKeyvaultAuthorizer gets an authorizer for the keyvault dataplane
 func GetKeyvaultAuthorizer() autorest.Authorizer {
 	return keyvaultAuthorizer
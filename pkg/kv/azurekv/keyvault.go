@@ -18,6 +18,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path"
+	"sort"
 
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
 	"github.com/Azure/go-autorest/autorest"
@@ -40,8 +42,27 @@ func New(name string) (kv.Service, error) {
 		return nil, fmt.Errorf("invalid Key Vault specified: '%s'", name)
 	}
 
+	return newWithAuthorizer(name, GetKeyvaultAuthorizer())
+}
+
+// NewWithCredentialsFile creates a new kv.Service backed by Azure Key Vault, authenticated
+// with the Azure SDK auth file (see AZURE_AUTH_LOCATION) instead of the environment
+func NewWithCredentialsFile(name, credentialsFile string) (kv.Service, error) {
+	if name == "" {
+		return nil, fmt.Errorf("invalid Key Vault specified: '%s'", name)
+	}
+
+	authorizer, err := NewKeyvaultAuthorizerFromFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error creating authorizer from %s: %s", credentialsFile, err.Error())
+	}
+
+	return newWithAuthorizer(name, authorizer)
+}
+
+func newWithAuthorizer(name string, authorizer autorest.Authorizer) (kv.Service, error) {
 	keyClient := keyvault.New()
-	keyClient.Authorizer = GetKeyvaultAuthorizer()
+	keyClient.Authorizer = authorizer
 	return &azureKeyVault{
 		client:       &keyClient,
 		vaultBaseURL: fmt.Sprintf("https://%s.%s", name, azure.PublicCloud.KeyVaultDNSSuffix),
@@ -75,7 +96,47 @@ func (a *azureKeyVault) Set(key string, val []byte) error {
 	return err
 }
 
+func (a *azureKeyVault) Delete(key string) error {
+
+	_, err := a.client.DeleteSecret(context.Background(), a.vaultBaseURL, key)
+
+	if err != nil {
+		if err, ok := err.(autorest.DetailedError); ok && err.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
 func (a *azureKeyVault) Test(key string) error {
 	// TODO: Implement me properly
 	return nil
 }
+
+func (a *azureKeyVault) List() ([]string, error) {
+	ctx := context.Background()
+
+	secrets, err := a.client.GetSecretsComplete(ctx, a.vaultBaseURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets of key vault: %s", err.Error())
+	}
+
+	var keys []string
+	for ; secrets.NotDone(); err = secrets.NextWithContext(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("error listing secrets of key vault: %s", err.Error())
+		}
+		// the ID of a secret is https://<vault>.vault.azure.net/secrets/<name>
+		if id := secrets.Value().ID; id != nil {
+			keys = append(keys, path.Base(*id))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing secrets of key vault: %s", err.Error())
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,92 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+type cache struct {
+	sync.Mutex
+	store   kv.Service
+	ttl     time.Duration
+	entries map[string]entry
+	now     func() time.Time
+}
+
+// New creates a new kv.Service which keeps the values read from the store in memory
+// for the ttl, a Set of a key invalidates its cached value
+func New(store kv.Service, ttl time.Duration) kv.Service {
+	return &cache{
+		store:   store,
+		ttl:     ttl,
+		entries: map[string]entry{},
+		now:     time.Now,
+	}
+}
+
+func (c *cache) Set(key string, val []byte) error {
+	c.Lock()
+	delete(c.entries, key)
+	c.Unlock()
+
+	return c.store.Set(key, val)
+}
+
+func (c *cache) Get(key string) ([]byte, error) {
+	c.Lock()
+	cached, ok := c.entries[key]
+	c.Unlock()
+
+	if ok && c.now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	// the errors (e.g. kv.NotFoundError) are not cached
+	val, err := c.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	c.entries[key] = entry{value: val, expires: c.now().Add(c.ttl)}
+	c.Unlock()
+
+	return val, nil
+}
+
+func (c *cache) Test(key string) error {
+	return c.store.Test(key)
+}
+
+func (c *cache) List() ([]string, error) {
+	return c.store.List()
+}
+
+func (c *cache) Delete(key string) error {
+	c.Lock()
+	delete(c.entries, key)
+	c.Unlock()
+
+	return kv.Delete(c.store, key)
+}
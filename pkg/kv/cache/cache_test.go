@@ -0,0 +1,117 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// countingKV is an in-memory kv.Service which counts the Get calls
+type countingKV struct {
+	values map[string][]byte
+	gets   int
+}
+
+func (c *countingKV) Set(key string, val []byte) error {
+	c.values[key] = val
+	return nil
+}
+
+func (c *countingKV) Get(key string) ([]byte, error) {
+	c.gets++
+	val, ok := c.values[key]
+	if !ok {
+		return nil, kv.NewNotFoundError("key '%s' is not present", key)
+	}
+	return val, nil
+}
+
+func (c *countingKV) Test(key string) error {
+	return nil
+}
+
+func (c *countingKV) Delete(key string) error {
+	delete(c.values, key)
+	return nil
+}
+
+func (c *countingKV) List() ([]string, error) {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestCache(t *testing.T) {
+	backend := &countingKV{values: map[string][]byte{"vault-unseal-0": []byte("key0")}}
+	now := time.Now()
+
+	store := New(backend, time.Minute).(*cache)
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		val, err := store.Get("vault-unseal-0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(val) != "key0" {
+			t.Fatalf("unexpected value: %s", val)
+		}
+	}
+	if backend.gets != 1 {
+		t.Fatalf("expected the second get within the ttl to be cached, backend was hit %d times", backend.gets)
+	}
+
+	// a set invalidates the cached value
+	err := store.Set("vault-unseal-0", []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val, err := store.Get("vault-unseal-0")
+	if err != nil || string(val) != "new" || backend.gets != 2 {
+		t.Fatalf("expected the value to be read again after a set: %s %v (gets: %d)", val, err, backend.gets)
+	}
+
+	// expired
+	now = now.Add(time.Minute)
+	store.Get("vault-unseal-0")
+	if backend.gets != 3 {
+		t.Fatalf("expected the value to be read again after the ttl, gets: %d", backend.gets)
+	}
+
+	// missing keys are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get("vault-root"); err == nil {
+			t.Fatal("expected a not found error")
+		}
+	}
+	if backend.gets != 5 {
+		t.Fatalf("expected missing keys to hit the backend every time, gets: %d", backend.gets)
+	}
+
+	// a delete invalidates the cached value
+	if err := kv.Delete(store, "vault-unseal-0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("vault-unseal-0"); err == nil {
+		t.Fatal("expected a not found error after a delete")
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// DefaultAddress is the address of the local Consul agent
+const DefaultAddress = "http://127.0.0.1:8500"
+
+type consulStorage struct {
+	client  *http.Client
+	address string
+	token   string
+	prefix  string
+}
+
+// New creates a new kv.Service backed by the KV store of Consul, the requests are authenticated
+// with the ACL token if it is not empty
+func New(address, token, prefix string) (kv.Service, error) {
+	if address == "" {
+		address = DefaultAddress
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("error parsing consul address '%s': %s", address, err.Error())
+	}
+
+	return &consulStorage{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		prefix:  prefix,
+	}, nil
+}
+
+func (c *consulStorage) Set(key string, val []byte) error {
+	n := c.prefix + key
+
+	// https://www.consul.io/api/kv.html#create-update-key
+	resp, err := c.do("PUT", n, "", val)
+	if err != nil {
+		return fmt.Errorf("error writing key '%s' to consul: %s", n, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error writing key '%s' to consul: %s", n, responseError(resp))
+	}
+	return nil
+}
+
+func (c *consulStorage) Get(key string) ([]byte, error) {
+	n := c.prefix + key
+
+	// https://www.consul.io/api/kv.html#read-key
+	resp, err := c.do("GET", n, "raw", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key '%s' from consul: %s", n, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, kv.NewNotFoundError("key '%s' is not present in consul", n)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading key '%s' from consul: %s", n, responseError(resp))
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading value of key '%s': %s", n, err.Error())
+	}
+	return b, nil
+}
+
+func (c *consulStorage) Delete(key string) error {
+	n := c.prefix + key
+
+	// https://www.consul.io/api/kv.html#delete-key
+	resp, err := c.do("DELETE", n, "", nil)
+	if err != nil {
+		return fmt.Errorf("error deleting key '%s' from consul: %s", n, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error deleting key '%s' from consul: %s", n, responseError(resp))
+	}
+	return nil
+}
+
+func (c *consulStorage) Test(key string) error {
+	// TODO: Implement me properly
+	return nil
+}
+
+func (c *consulStorage) List() ([]string, error) {
+	// https://www.consul.io/api/kv.html#keys
+	resp, err := c.do("GET", c.prefix, "keys", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys of consul: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error listing keys of consul: %s", responseError(resp))
+	}
+
+	var names []string
+	err = json.NewDecoder(resp.Body).Decode(&names)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding keys of consul: %s", err.Error())
+	}
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, strings.TrimPrefix(name, c.prefix))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// do sends a request of the KV API, the flag is a query parameter without value (e.g. raw or keys)
+func (c *consulStorage) do(method, key, flag string, body []byte) (*http.Response, error) {
+	u := fmt.Sprintf("%s/v1/kv/%s", c.address, strings.TrimPrefix(key, "/"))
+	if flag != "" {
+		u += "?" + flag
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	return c.client.Do(req)
+}
+
+func responseError(resp *http.Response) string {
+	body, _ := ioutil.ReadAll(resp.Body)
+	if message := strings.TrimSpace(string(body)); message != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, message)
+	}
+	return resp.Status
+}
@@ -0,0 +1,123 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// fakeConsul is the KV API of a Consul agent requiring the ACL token
+func fakeConsul(t *testing.T, token string) *httptest.Server {
+	var lock sync.Mutex
+	values := map[string][]byte{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Consul-Token") != token {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch {
+		case r.Method == "PUT":
+			body, _ := ioutil.ReadAll(r.Body)
+			values[key] = body
+			w.Write([]byte("true"))
+		case r.Method == "GET" && r.URL.RawQuery == "keys":
+			var keys []string
+			for k := range values {
+				if strings.HasPrefix(k, key) {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(keys)
+		case r.Method == "GET" && r.URL.RawQuery == "raw":
+			value, ok := values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(value)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+}
+
+func TestConsulStorage(t *testing.T) {
+	server := fakeConsul(t, "acl-token")
+	defer server.Close()
+
+	store, err := New(server.URL, "acl-token", "vault/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List()
+	if err != nil || len(keys) != 0 {
+		t.Fatalf("expected no keys in an empty prefix, got %v, %v", keys, err)
+	}
+
+	_, err = store.Get("vault-root")
+	if _, ok := err.(*kv.NotFoundError); !ok {
+		t.Fatalf("expected a NotFoundError for a missing key, got %v", err)
+	}
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		err = store.Set(key, []byte(key+"-value"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, err := store.Get("vault-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "vault-root-value" {
+		t.Fatalf("expected the stored value, got %q", value)
+	}
+
+	keys, err = store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "vault-root,vault-unseal-0,vault-unseal-1" {
+		t.Fatalf("expected the sorted keys without the prefix, got %v", keys)
+	}
+
+	unauthorized, err := New(server.URL, "wrong-token", "vault/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unauthorized.Set("vault-root", []byte("root")); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Fatalf("expected the ACL error of consul, got %v", err)
+	}
+}
@@ -20,13 +20,11 @@ import (
 	"os"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
 )
 
-type dev struct {
-	rootToken []byte
-}
-
-// New creates a new kv.Service backed by memory, only the root token is stored, should be used with: vault server -dev
+// New creates a new kv.Service backed by memory, seeded with the root token, should be used with: vault server -dev
+// The other keys set are kept until the process exits, List returns the keys actually stored
 func New() (service kv.Service, err error) {
 
 	rootToken := []byte(os.Getenv("VAULT_TOKEN"))
@@ -39,24 +37,7 @@ func New() (service kv.Service, err error) {
 		}
 	}
 
-	service = &dev{rootToken}
+	service = memory.New(map[string][]byte{"vault-root": rootToken})
 
 	return
 }
-
-func (d *dev) Set(key string, val []byte) error {
-	return nil
-}
-
-func (d *dev) Get(key string) ([]byte, error) {
-
-	if key == "vault-root" {
-		return d.rootToken, nil
-	}
-
-	return nil, kv.NewNotFoundError("key '%s' is not present in secret", key)
-}
-
-func (d *dev) Test(key string) error {
-	return nil
-}
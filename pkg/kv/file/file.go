@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 )
@@ -47,6 +48,31 @@ func (f *file) Get(key string) ([]byte, error) {
 	return val, err
 }
 
+func (f *file) Delete(key string) error {
+	err := os.Remove(path.Join(f.path, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
 func (f *file) Test(key string) error {
 	return nil
 }
+
+func (f *file) List() ([]string, error) {
+	files, err := ioutil.ReadDir(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, file := range files {
+		if !file.IsDir() {
+			keys = append(keys, file.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,68 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+func TestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bank-vaults-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	service, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"vault-unseal-1", "vault-root", "vault-unseal-0"} {
+		if err := service.Set(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// directories aren't keys
+	if err := os.Mkdir(path.Join(dir, "subdir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := service.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"vault-root", "vault-unseal-0", "vault-unseal-1"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+
+	if err := kv.Delete(service, "vault-root"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = service.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"vault-unseal-0", "vault-unseal-1"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v after the delete, got %v", expected, keys)
+	}
+}
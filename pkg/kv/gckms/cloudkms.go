@@ -18,8 +18,11 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	cloudkms "google.golang.org/api/cloudkms/v1"
 )
@@ -37,8 +40,13 @@ var _ kv.Service = &googleKms{}
 
 // New creates a new kv.Service encrypted by Google KMS
 func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Service, error) {
-	ctx := context.Background()
-	client, err := google.DefaultClient(ctx, cloudkms.CloudPlatformScope)
+	return NewWithCredentialsFile(store, project, location, keyring, cryptoKey, "")
+}
+
+// NewWithCredentialsFile creates a new kv.Service encrypted by Google KMS, authenticated
+// with the JSON key file instead of the default credentials if it is not empty
+func NewWithCredentialsFile(store kv.Service, project, location, keyring, cryptoKey, credentialsFile string) (kv.Service, error) {
+	client, err := httpClient(context.Background(), credentialsFile)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating google client: %s", err.Error())
@@ -57,6 +65,24 @@ func New(store kv.Service, project, location, keyring, cryptoKey string) (kv.Ser
 	}, nil
 }
 
+func httpClient(ctx context.Context, credentialsFile string) (*http.Client, error) {
+	if credentialsFile == "" {
+		return google.DefaultClient(ctx, cloudkms.CloudPlatformScope)
+	}
+
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %s", err.Error())
+	}
+
+	credentials, err := google.CredentialsFromJSON(ctx, data, cloudkms.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing credentials file: %s", err.Error())
+	}
+
+	return oauth2.NewClient(ctx, credentials.TokenSource), nil
+}
+
 func (g *googleKms) encrypt(s []byte) ([]byte, error) {
 	resp, err := g.svc.Projects.Locations.KeyRings.CryptoKeys.Encrypt(g.keyPath, &cloudkms.EncryptRequest{
 		Plaintext: base64.StdEncoding.EncodeToString(s),
@@ -105,3 +131,13 @@ func (g *googleKms) Test(key string) error {
 	// TODO: Implement me properly
 	return nil
 }
+
+// List returns the keys of the backend store, the names of the keys are not encrypted
+func (g *googleKms) List() ([]string, error) {
+	return g.store.List()
+}
+
+// Delete deletes the encrypted value from the underlying store
+func (g *googleKms) Delete(key string) error {
+	return kv.Delete(g.store, key)
+}
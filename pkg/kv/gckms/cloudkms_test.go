@@ -0,0 +1,92 @@
+// Copyright © 2018 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gckms
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "file-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer tokenServer.Close()
+
+	var authorization string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer apiServer.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	credentials, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "bank-vaults",
+		"private_key_id": "1",
+		"private_key":    string(privateKey),
+		"client_email":   "bank-vaults@bank-vaults.iam.gserviceaccount.com",
+		"client_id":      "1",
+		"token_uri":      tokenServer.URL,
+	})
+
+	dir, err := ioutil.TempDir("", "gckms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	err = ioutil.WriteFile(credentialsFile, credentials, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := httpClient(context.Background(), credentialsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get(apiServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if authorization != "Bearer file-token" {
+		t.Fatalf("expected the token of the credentials file to be used, got %q", authorization)
+	}
+
+	_, err = NewWithCredentialsFile(nil, "project", "global", "keyring", "key", filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Fatal("expected missing credentials file to fail")
+	}
+}
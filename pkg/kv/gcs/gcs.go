@@ -18,9 +18,13 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 type gcsStorage struct {
@@ -31,7 +35,18 @@ type gcsStorage struct {
 
 // New creates a new kv.Service backed by Google GCS
 func New(bucket, prefix string) (kv.Service, error) {
-	cl, err := storage.NewClient(context.Background())
+	return NewWithCredentialsFile(bucket, prefix, "")
+}
+
+// NewWithCredentialsFile creates a new kv.Service backed by Google GCS, authenticated
+// with the JSON key file instead of the default credentials if it is not empty
+func NewWithCredentialsFile(bucket, prefix, credentialsFile string) (kv.Service, error) {
+	var opts []option.ClientOption
+	if credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsFile))
+	}
+
+	cl, err := storage.NewClient(context.Background(), opts...)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating gcs client: %s", err.Error())
@@ -74,6 +89,17 @@ func (g *gcsStorage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (g *gcsStorage) Delete(key string) error {
+	n := objectNameWithPrefix(g.prefix, key)
+
+	err := g.cl.Bucket(g.bucket).Object(n).Delete(context.Background())
+	if err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("error deleting key '%s' from gcs bucket '%s': %s", n, g.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
@@ -82,3 +108,22 @@ func (g *gcsStorage) Test(key string) error {
 	// TODO: Implement me properly
 	return nil
 }
+
+func (g *gcsStorage) List() ([]string, error) {
+	var keys []string
+
+	objects := g.cl.Bucket(g.bucket).Objects(context.Background(), &storage.Query{Prefix: g.prefix})
+	for {
+		object, err := objects.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects of gcs bucket '%s': %s", g.bucket, err.Error())
+		}
+		keys = append(keys, strings.TrimPrefix(object.Name, g.prefix))
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
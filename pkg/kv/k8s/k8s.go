@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	"k8s.io/api/core/v1"
@@ -120,6 +121,45 @@ func (k *k8sStorage) Get(key string) ([]byte, error) {
 	return val, nil
 }
 
+func (k *k8sStorage) Delete(key string) error {
+	secret, err := k.cl.CoreV1().Secrets(k.namespace).Get(k.secret, metav1.GetOptions{})
+
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error getting secret for key '%s': %s", key, err.Error())
+	}
+
+	if _, ok := secret.Data[key]; !ok {
+		return nil
+	}
+	delete(secret.Data, key)
+
+	_, err = k.cl.CoreV1().Secrets(k.namespace).Update(secret)
+	if err != nil {
+		return fmt.Errorf("error deleting secret key '%s' from secret '%s': '%s'", key, k.secret, err.Error())
+	}
+	return nil
+}
+
 func (k *k8sStorage) Test(key string) error {
 	return nil
 }
+
+func (k *k8sStorage) List() ([]string, error) {
+	secret, err := k.cl.CoreV1().Secrets(k.namespace).Get(k.secret, metav1.GetOptions{})
+
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting secret '%s': %s", k.secret, err.Error())
+	}
+
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -36,4 +36,56 @@ type Service interface {
 	Set(key string, value []byte) error
 	Get(key string) ([]byte, error)
 	Test(key string) error
+	// List returns the keys of the store (without the prefix of the backend) in alphabetical
+	// order, e.g. for diagnostics, the values are not read
+	List() ([]string, error)
+}
+
+// PreflightCheck is the result of probing one of the operations a backend needs,
+// Permission is the permission the operation requires (e.g. s3:PutObject)
+type PreflightCheck struct {
+	Permission string
+	Resource   string
+	Err        error
+}
+
+// Preflighter is implemented by the backends which can probe the cloud permissions they need
+// (with the probe key) before they are used, the probing stops at the first failing operation
+type Preflighter interface {
+	Preflight(probeKey string) []PreflightCheck
+}
+
+// Preflight probes the operations the backend needs, the backends which don't implement
+// Preflighter are probed by writing and reading the probe key
+func Preflight(service Service, probeKey string) []PreflightCheck {
+	if preflighter, ok := service.(Preflighter); ok {
+		return preflighter.Preflight(probeKey)
+	}
+
+	probe := []byte("bank-vaults preflight")
+	checks := []PreflightCheck{{Permission: "write", Resource: probeKey, Err: service.Set(probeKey, probe)}}
+	if checks[0].Err != nil {
+		return checks
+	}
+
+	value, err := service.Get(probeKey)
+	if err == nil && string(value) != string(probe) {
+		err = fmt.Errorf("the probe key read back doesn't match the one written")
+	}
+	return append(checks, PreflightCheck{Permission: "read", Resource: probeKey, Err: err})
+}
+
+// Deleter is implemented by the backends which can delete keys (e.g. to delete the source copies
+// after migrating the keys to another backend), deleting a missing key is not an error
+type Deleter interface {
+	Delete(key string) error
+}
+
+// Delete deletes the key from the backend, it fails if the backend doesn't implement Deleter
+func Delete(service Service, key string) error {
+	deleter, ok := service.(Deleter)
+	if !ok {
+		return fmt.Errorf("the kv backend can't delete key '%s'", key)
+	}
+	return deleter.Delete(key)
 }
@@ -0,0 +1,90 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// Store is a kv.Service keeping the values in memory, they are lost when the process exits
+type Store struct {
+	sync.Mutex
+	values map[string][]byte
+}
+
+var _ kv.Service = &Store{}
+var _ kv.Deleter = &Store{}
+
+// New creates a new kv.Service backed by memory, with the initial values if they aren't nil
+func New(values map[string][]byte) *Store {
+	store := &Store{values: map[string][]byte{}}
+	for key, value := range values {
+		store.values[key] = value
+	}
+	return store
+}
+
+func (m *Store) Set(key string, val []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] = val
+	return nil
+}
+
+func (m *Store) Get(key string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	val, ok := m.values[key]
+	if !ok {
+		return nil, kv.NewNotFoundError("key '%s' is not present", key)
+	}
+	return val, nil
+}
+
+func (m *Store) Test(key string) error {
+	return nil
+}
+
+func (m *Store) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+func (m *Store) List() ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Values returns a copy of the stored values
+func (m *Store) Values() map[string][]byte {
+	m.Lock()
+	defer m.Unlock()
+	values := make(map[string][]byte, len(m.values))
+	for key, value := range m.values {
+		values[key] = value
+	}
+	return values
+}
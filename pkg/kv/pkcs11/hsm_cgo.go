@@ -0,0 +1,291 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build pkcs11 && cgo
+// +build pkcs11,cgo
+
+package pkcs11
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+
+// The subset of the PKCS#11 v2.40 types and functions used by the key wrapper, the functions
+// are looked up by their exported C_ names in the dlopen-ed module.
+
+typedef unsigned long CK_ULONG;
+typedef unsigned char CK_BYTE;
+typedef CK_ULONG CK_RV;
+
+typedef struct {
+	CK_ULONG type;
+	void *pValue;
+	CK_ULONG ulValueLen;
+} CK_ATTRIBUTE;
+
+typedef struct {
+	CK_ULONG mechanism;
+	void *pParameter;
+	CK_ULONG ulParameterLen;
+} CK_MECHANISM;
+
+typedef struct {
+	CK_BYTE *pIv;
+	CK_ULONG ulIvLen;
+	CK_ULONG ulIvBits;
+	CK_BYTE *pAAD;
+	CK_ULONG ulAADLen;
+	CK_ULONG ulTagBits;
+} CK_GCM_PARAMS;
+
+typedef struct {
+	void *CreateMutex;
+	void *DestroyMutex;
+	void *LockMutex;
+	void *UnlockMutex;
+	CK_ULONG flags;
+	void *pReserved;
+} CK_C_INITIALIZE_ARGS;
+
+#define CKR_OK                           0x000
+#define CKR_USER_ALREADY_LOGGED_IN       0x100
+#define CKR_CRYPTOKI_ALREADY_INITIALIZED 0x191
+#define CKR_FUNCTION_NOT_SUPPORTED       0x054
+#define CKR_SESSION_CLOSED               0x0B0
+#define CKR_SESSION_HANDLE_INVALID       0x0B3
+#define CKF_OS_LOCKING_OK                0x002
+#define CKF_RW_SESSION                   0x002
+#define CKF_SERIAL_SESSION               0x004
+#define CKU_USER                         1
+#define CKA_CLASS                        0x000
+#define CKA_LABEL                        0x003
+#define CKO_SECRET_KEY                   4
+#define CKM_AES_GCM                      0x1087
+#define GCM_TAG_BITS                     128
+
+typedef struct {
+	void *handle;
+	CK_RV (*Initialize)(CK_C_INITIALIZE_ARGS *);
+	CK_RV (*OpenSession)(CK_ULONG, CK_ULONG, void *, void *, CK_ULONG *);
+	CK_RV (*Login)(CK_ULONG, CK_ULONG, CK_BYTE *, CK_ULONG);
+	CK_RV (*FindObjectsInit)(CK_ULONG, CK_ATTRIBUTE *, CK_ULONG);
+	CK_RV (*FindObjects)(CK_ULONG, CK_ULONG *, CK_ULONG, CK_ULONG *);
+	CK_RV (*FindObjectsFinal)(CK_ULONG);
+	CK_RV (*EncryptInit)(CK_ULONG, CK_MECHANISM *, CK_ULONG);
+	CK_RV (*Encrypt)(CK_ULONG, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+	CK_RV (*DecryptInit)(CK_ULONG, CK_MECHANISM *, CK_ULONG);
+	CK_RV (*Decrypt)(CK_ULONG, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+} p11_module;
+
+static p11_module *p11_load(const char *path) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (handle == NULL) {
+		return NULL;
+	}
+	p11_module *m = calloc(1, sizeof(p11_module));
+	m->handle = handle;
+	m->Initialize = dlsym(handle, "C_Initialize");
+	m->OpenSession = dlsym(handle, "C_OpenSession");
+	m->Login = dlsym(handle, "C_Login");
+	m->FindObjectsInit = dlsym(handle, "C_FindObjectsInit");
+	m->FindObjects = dlsym(handle, "C_FindObjects");
+	m->FindObjectsFinal = dlsym(handle, "C_FindObjectsFinal");
+	m->EncryptInit = dlsym(handle, "C_EncryptInit");
+	m->Encrypt = dlsym(handle, "C_Encrypt");
+	m->DecryptInit = dlsym(handle, "C_DecryptInit");
+	m->Decrypt = dlsym(handle, "C_Decrypt");
+	if (!m->Initialize || !m->OpenSession || !m->Login || !m->FindObjectsInit || !m->FindObjects ||
+		!m->FindObjectsFinal || !m->EncryptInit || !m->Encrypt || !m->DecryptInit || !m->Decrypt) {
+		dlclose(handle);
+		free(m);
+		return NULL;
+	}
+	return m;
+}
+
+static CK_RV p11_open(p11_module *m, CK_ULONG slot, CK_BYTE *pin, CK_ULONG pinLen, CK_ULONG *session) {
+	CK_C_INITIALIZE_ARGS args = {0};
+	args.flags = CKF_OS_LOCKING_OK;
+	CK_RV rv = m->Initialize(&args);
+	if (rv != CKR_OK && rv != CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return rv;
+	}
+	rv = m->OpenSession(slot, CKF_SERIAL_SESSION | CKF_RW_SESSION, NULL, NULL, session);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	rv = m->Login(*session, CKU_USER, pin, pinLen);
+	if (rv != CKR_OK && rv != CKR_USER_ALREADY_LOGGED_IN) {
+		return rv;
+	}
+	return CKR_OK;
+}
+
+static CK_RV p11_find_key(p11_module *m, CK_ULONG session, CK_BYTE *label, CK_ULONG labelLen, CK_ULONG *key, CK_ULONG *count) {
+	CK_ULONG class = CKO_SECRET_KEY;
+	CK_ATTRIBUTE template[2] = {
+		{CKA_CLASS, &class, sizeof(class)},
+		{CKA_LABEL, label, labelLen},
+	};
+	CK_RV rv = m->FindObjectsInit(session, template, 2);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	rv = m->FindObjects(session, key, 1, count);
+	CK_RV finalRv = m->FindObjectsFinal(session);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return finalRv;
+}
+
+// p11_crypt encrypts or decrypts in with AES-GCM, out has to have room for in and the tag
+static CK_RV p11_crypt(p11_module *m, int encrypt, CK_ULONG session, CK_ULONG key, CK_BYTE *iv, CK_ULONG ivLen,
+	CK_BYTE *in, CK_ULONG inLen, CK_BYTE *out, CK_ULONG *outLen) {
+	CK_GCM_PARAMS params = {iv, ivLen, ivLen * 8, NULL, 0, GCM_TAG_BITS};
+	CK_MECHANISM mechanism = {CKM_AES_GCM, &params, sizeof(params)};
+	if (encrypt) {
+		CK_RV rv = m->EncryptInit(session, &mechanism, key);
+		if (rv != CKR_OK) {
+			return rv;
+		}
+		return m->Encrypt(session, in, inLen, out, outLen);
+	}
+	CK_RV rv = m->DecryptInit(session, &mechanism, key);
+	if (rv != CKR_OK) {
+		return rv;
+	}
+	return m->Decrypt(session, in, inLen, out, outLen);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// gcmTagSize is the size of the authentication tag appended to the cipher text
+const gcmTagSize = 16
+
+// pkcs11HSM is a logged in session of the token of the slot, the session can't be used
+// concurrently, so the operations are serialized
+type pkcs11HSM struct {
+	sync.Mutex
+	config  Config
+	module  *C.p11_module
+	session C.CK_ULONG
+	key     C.CK_ULONG
+}
+
+func openHSM(config Config) (hsm, error) {
+	path := C.CString(config.ModulePath)
+	defer C.free(unsafe.Pointer(path))
+
+	module := C.p11_load(path)
+	if module == nil {
+		reason := "it doesn't export the C_ functions of PKCS#11"
+		if dlerr := C.dlerror(); dlerr != nil {
+			reason = C.GoString(dlerr)
+		}
+		return nil, fmt.Errorf("error loading PKCS#11 module '%s': %s", config.ModulePath, reason)
+	}
+
+	h := &pkcs11HSM{config: config, module: module}
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// open logs in to a new session of the token and looks up the key, the object handles are
+// only valid in the session they were found in
+func (h *pkcs11HSM) open() error {
+	pin := C.CBytes([]byte(h.config.PIN))
+	defer C.free(pin)
+	rv := C.p11_open(h.module, C.CK_ULONG(h.config.SlotID), (*C.CK_BYTE)(pin), C.CK_ULONG(len(h.config.PIN)), &h.session)
+	if rv != C.CKR_OK {
+		return fmt.Errorf("error logging in to the token of PKCS#11 slot %d: %s", h.config.SlotID, ckrError(rv))
+	}
+
+	label := C.CBytes([]byte(h.config.KeyLabel))
+	defer C.free(label)
+	var count C.CK_ULONG
+	rv = C.p11_find_key(h.module, h.session, (*C.CK_BYTE)(label), C.CK_ULONG(len(h.config.KeyLabel)), &h.key, &count)
+	if rv != C.CKR_OK {
+		return fmt.Errorf("error finding PKCS#11 key '%s': %s", h.config.KeyLabel, ckrError(rv))
+	}
+	if count == 0 {
+		return fmt.Errorf("PKCS#11 key '%s' not found in slot %d", h.config.KeyLabel, h.config.SlotID)
+	}
+
+	return nil
+}
+
+func (h *pkcs11HSM) encrypt(nonce, plainText []byte) ([]byte, error) {
+	return h.crypt(true, nonce, plainText, len(plainText)+gcmTagSize)
+}
+
+func (h *pkcs11HSM) decrypt(nonce, cipherText []byte) ([]byte, error) {
+	return h.crypt(false, nonce, cipherText, len(cipherText))
+}
+
+// crypt re-opens the session once if the HSM dropped it, e.g. after a restart of the HSM
+// or a network HSM closing idle sessions
+func (h *pkcs11HSM) crypt(encrypt bool, nonce, in []byte, outSize int) ([]byte, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	out, rv := h.cryptSession(encrypt, nonce, in, outSize)
+	if rv == C.CKR_SESSION_HANDLE_INVALID || rv == C.CKR_SESSION_CLOSED {
+		if err := h.open(); err != nil {
+			return nil, fmt.Errorf("error re-opening the PKCS#11 session after %s: %s", ckrError(rv), err.Error())
+		}
+		out, rv = h.cryptSession(encrypt, nonce, in, outSize)
+	}
+	if rv != C.CKR_OK {
+		return nil, ckrError(rv)
+	}
+	return out, nil
+}
+
+func (h *pkcs11HSM) cryptSession(encrypt bool, nonce, in []byte, outSize int) ([]byte, C.CK_RV) {
+	// the C functions can't get Go memory holding Go pointers (the GCM parameters), so all the
+	// buffers are allocated by C
+	iv := C.CBytes(nonce)
+	defer C.free(iv)
+	input := C.CBytes(in)
+	defer C.free(input)
+	output := C.malloc(C.size_t(outSize + 1))
+	defer C.free(output)
+
+	var mode C.int
+	if encrypt {
+		mode = 1
+	}
+	outLen := C.CK_ULONG(outSize)
+	rv := C.p11_crypt(h.module, mode, h.session, h.key, (*C.CK_BYTE)(iv), C.CK_ULONG(len(nonce)),
+		(*C.CK_BYTE)(input), C.CK_ULONG(len(in)), (*C.CK_BYTE)(output), &outLen)
+	if rv != C.CKR_OK {
+		return nil, rv
+	}
+	return C.GoBytes(output, C.int(outLen)), C.CKR_OK
+}
+
+func ckrError(rv C.CK_RV) error {
+	return fmt.Errorf("PKCS#11 error 0x%x", uint64(rv))
+}
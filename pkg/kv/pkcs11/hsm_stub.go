@@ -0,0 +1,24 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !pkcs11 || !cgo
+// +build !pkcs11 !cgo
+
+package pkcs11
+
+import "fmt"
+
+func openHSM(config Config) (hsm, error) {
+	return nil, fmt.Errorf("bank-vaults was built without PKCS#11 support, build it with cgo and -tags pkcs11")
+}
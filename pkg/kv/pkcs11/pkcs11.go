@@ -0,0 +1,166 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs11
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+// nonceSize is the size of the AES-GCM nonce stored before the cipher text
+const nonceSize = 12
+
+// Config is the HSM token of the PKCS#11 module and the label of the AES key on it
+type Config struct {
+	// ModulePath is the path of the PKCS#11 library of the HSM, e.g. /usr/lib/softhsm/libsofthsm2.so
+	ModulePath string
+	SlotID     uint
+	PIN        string
+	KeyLabel   string
+}
+
+// hsm encrypts and decrypts with AES-GCM using the key on the HSM token, the key never leaves it
+type hsm interface {
+	encrypt(nonce, plainText []byte) ([]byte, error)
+	decrypt(nonce, cipherText []byte) ([]byte, error)
+}
+
+type pkcs11KMS struct {
+	store kv.Service
+	hsm   hsm
+
+	keyLabel string
+}
+
+var _ kv.Service = &pkcs11KMS{}
+var _ kv.Preflighter = &pkcs11KMS{}
+
+// New creates a new kv.Service encrypted by the AES key of an HSM token through its PKCS#11 module,
+// the values are stored as the nonce followed by the AES-GCM cipher text. The PKCS#11 support needs
+// cgo and the pkcs11 build tag, see hsm_cgo.go.
+func New(store kv.Service, config Config) (kv.Service, error) {
+	if config.ModulePath == "" {
+		return nil, fmt.Errorf("invalid PKCS#11 module path specified: '%s'", config.ModulePath)
+	}
+	if config.KeyLabel == "" {
+		return nil, fmt.Errorf("invalid PKCS#11 key label specified: '%s'", config.KeyLabel)
+	}
+
+	hsm, err := openHSM(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkcs11KMS{store: store, hsm: hsm, keyLabel: config.KeyLabel}, nil
+}
+
+func (p *pkcs11KMS) encrypt(plainText []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %s", err.Error())
+	}
+
+	cipherText, err := p.hsm.encrypt(nonce, plainText)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting with PKCS#11 key '%s': %s", p.keyLabel, err.Error())
+	}
+	return append(nonce, cipherText...), nil
+}
+
+func (p *pkcs11KMS) decrypt(cipherText []byte) ([]byte, error) {
+	if len(cipherText) < nonceSize {
+		return nil, fmt.Errorf("error decrypting with PKCS#11 key '%s': the cipher text is too short", p.keyLabel)
+	}
+
+	plainText, err := p.hsm.decrypt(cipherText[:nonceSize], cipherText[nonceSize:])
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting with PKCS#11 key '%s': %s", p.keyLabel, err.Error())
+	}
+	return plainText, nil
+}
+
+func (p *pkcs11KMS) Get(key string) ([]byte, error) {
+	cipherText, err := p.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.decrypt(cipherText)
+}
+
+func (p *pkcs11KMS) Set(key string, val []byte) error {
+	cipherText, err := p.encrypt(val)
+	if err != nil {
+		return err
+	}
+
+	return p.store.Set(key, cipherText)
+}
+
+func (p *pkcs11KMS) Test(key string) error {
+	inputString := "test"
+
+	err := p.store.Test(key)
+	if err != nil {
+		return fmt.Errorf("test of backend store failed: %s", err.Error())
+	}
+
+	cipherText, err := p.encrypt([]byte(inputString))
+	if err != nil {
+		return err
+	}
+
+	plainText, err := p.decrypt(cipherText)
+	if err != nil {
+		return err
+	}
+
+	if string(plainText) != inputString {
+		return fmt.Errorf("encrypted and decryped text doesn't match: exp: '%v', act: '%v'", inputString, string(plainText))
+	}
+
+	return nil
+}
+
+// List returns the keys of the backend store, the names of the keys are not encrypted
+func (p *pkcs11KMS) List() ([]string, error) {
+	return p.store.List()
+}
+
+// Preflight probes the encryption and decryption with the key of the HSM token, then the
+// permissions of the backend store
+func (p *pkcs11KMS) Preflight(probeKey string) []kv.PreflightCheck {
+	cipherText, err := p.encrypt([]byte("bank-vaults preflight"))
+	checks := []kv.PreflightCheck{{Permission: "CKA_ENCRYPT", Resource: p.keyLabel, Err: err}}
+	if err != nil {
+		return checks
+	}
+
+	_, err = p.decrypt(cipherText)
+	checks = append(checks, kv.PreflightCheck{Permission: "CKA_DECRYPT", Resource: p.keyLabel, Err: err})
+	if err != nil {
+		return checks
+	}
+
+	return append(checks, kv.Preflight(p.store, probeKey)...)
+}
+
+// Delete deletes the encrypted value from the underlying store
+func (p *pkcs11KMS) Delete(key string) error {
+	return kv.Delete(p.store, key)
+}
@@ -0,0 +1,102 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+)
+
+// softwareHSM is an hsm doing the AES-GCM of the token in software
+type softwareHSM struct {
+	aead cipher.AEAD
+}
+
+func newSoftwareHSM(t *testing.T) *softwareHSM {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softwareHSM{aead: aead}
+}
+
+func (s *softwareHSM) encrypt(nonce, plainText []byte) ([]byte, error) {
+	return s.aead.Seal(nil, nonce, plainText, nil), nil
+}
+
+func (s *softwareHSM) decrypt(nonce, cipherText []byte) ([]byte, error) {
+	return s.aead.Open(nil, nonce, cipherText, nil)
+}
+
+func TestPKCS11(t *testing.T) {
+	backend := memory.New(nil)
+	store := &pkcs11KMS{store: backend, hsm: newSoftwareHSM(t), keyLabel: "bank-vaults"}
+
+	if err := store.Test("vault-test"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.Set("vault-unseal-0", []byte("key0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := backend.Values()["vault-unseal-0"]
+	if len(stored) != nonceSize+len("key0")+16 || bytes.Contains(stored, []byte("key0")) {
+		t.Fatalf("expected the nonce and the cipher text in the backend, got %x", stored)
+	}
+
+	val, err := store.Get("vault-unseal-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "key0" {
+		t.Fatalf("expected the decrypted value, got %s", val)
+	}
+
+	// the same value is encrypted with a new nonce every time
+	store.Set("vault-unseal-1", []byte("key0"))
+	if bytes.Equal(backend.Values()["vault-unseal-1"], stored) {
+		t.Fatal("expected a unique cipher text for every write")
+	}
+
+	stored[len(stored)-1] ^= 1
+	if _, err := store.Get("vault-unseal-0"); err == nil {
+		t.Fatal("expected a modified cipher text to fail the decryption")
+	}
+
+	for _, check := range store.Preflight("vault-preflight") {
+		if check.Err != nil {
+			t.Errorf("unexpected failing preflight check %s: %s", check.Permission, check.Err)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	backend := memory.New(nil)
+	if _, err := New(backend, Config{KeyLabel: "bank-vaults"}); err == nil {
+		t.Error("expected an error without a module path")
+	}
+	if _, err := New(backend, Config{ModulePath: "/nonexistent/libpkcs11.so", KeyLabel: "bank-vaults"}); err == nil {
+		t.Error("expected an error loading a missing module")
+	}
+}
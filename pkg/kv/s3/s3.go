@@ -18,21 +18,29 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 )
 
 type s3Storage struct {
-	client *awss3.S3
+	client s3iface.S3API
+	sts    stsiface.STSAPI
 	bucket string
 	prefix string
 }
 
+var _ kv.Preflighter = &s3Storage{}
+
 // New creates a new kv.Service backed by AWS S3
 func New(region, bucket, prefix string) (kv.Service, error) {
 	if region == "" {
@@ -45,9 +53,18 @@ func New(region, bucket, prefix string) (kv.Service, error) {
 
 	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion(region)))
 
+	return NewWithSession(sess, bucket, prefix)
+}
+
+// NewWithSession creates a new kv.Service backed by AWS S3 with an existing AWS Session
+func NewWithSession(sess *session.Session, bucket, prefix string) (kv.Service, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket must be specified")
+	}
+
 	cl := awss3.New(sess)
 
-	return &s3Storage{cl, bucket, prefix}, nil
+	return &s3Storage{cl, sts.New(sess), bucket, prefix}, nil
 }
 
 func (s3 *s3Storage) Set(key string, val []byte) error {
@@ -92,6 +109,20 @@ func (s3 *s3Storage) Get(key string) ([]byte, error) {
 	return b, nil
 }
 
+func (s3 *s3Storage) Delete(key string) error {
+	n := objectNameWithPrefix(s3.prefix, key)
+	input := awss3.DeleteObjectInput{
+		Bucket: aws.String(s3.bucket),
+		Key:    aws.String(n),
+	}
+
+	if _, err := s3.client.DeleteObject(&input); err != nil {
+		return fmt.Errorf("error deleting key '%s' from s3 bucket '%s': '%s'", n, s3.bucket, err.Error())
+	}
+
+	return nil
+}
+
 func objectNameWithPrefix(prefix, key string) string {
 	return fmt.Sprintf("%s%s", prefix, key)
 }
@@ -100,3 +131,51 @@ func (s3 *s3Storage) Test(key string) error {
 	// TODO: Implement me properly
 	return nil
 }
+
+func (s3 *s3Storage) List() ([]string, error) {
+	var keys []string
+
+	input := awss3.ListObjectsV2Input{
+		Bucket: aws.String(s3.bucket),
+		Prefix: aws.String(s3.prefix),
+	}
+
+	err := s3.client.ListObjectsV2Pages(&input, func(page *awss3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(object.Key), s3.prefix))
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects of s3 bucket '%s': %s", s3.bucket, err.Error())
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Preflight probes the identity of the caller and the object permissions on the probe key,
+// the probe object is deleted afterwards if possible (bank-vaults doesn't need s3:DeleteObject)
+func (s3 *s3Storage) Preflight(probeKey string) []kv.PreflightCheck {
+	var checks []kv.PreflightCheck
+
+	_, err := s3.sts.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	checks = append(checks, kv.PreflightCheck{Permission: "sts:GetCallerIdentity", Err: err})
+	if err != nil {
+		return checks
+	}
+
+	n := objectNameWithPrefix(s3.prefix, probeKey)
+	resource := fmt.Sprintf("arn:aws:s3:::%s/%s", s3.bucket, n)
+
+	err = s3.Set(probeKey, []byte("bank-vaults preflight"))
+	checks = append(checks, kv.PreflightCheck{Permission: "s3:PutObject", Resource: resource, Err: err})
+	if err != nil {
+		return checks
+	}
+	defer s3.client.DeleteObject(&awss3.DeleteObjectInput{Bucket: aws.String(s3.bucket), Key: aws.String(n)})
+
+	_, err = s3.Get(probeKey)
+	return append(checks, kv.PreflightCheck{Permission: "s3:GetObject", Resource: resource, Err: err})
+}
@@ -0,0 +1,72 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+type fakeSTS struct {
+	stsiface.STSAPI
+}
+
+func (fakeSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:sts::123456789012:assumed-role/vault/pod")}, nil
+}
+
+// denyingS3 denies PutObject, the other operations must not be called
+type denyingS3 struct {
+	s3iface.S3API
+	puts int
+}
+
+func (s *denyingS3) PutObject(*awss3.PutObjectInput) (*awss3.PutObjectOutput, error) {
+	s.puts++
+	return nil, awserr.New("AccessDenied", "Access Denied", nil)
+}
+
+func TestPreflightDeniedPutObject(t *testing.T) {
+	client := &denyingS3{}
+	store := &s3Storage{client: client, sts: fakeSTS{}, bucket: "vault-unseal", prefix: "cluster-a/"}
+
+	checks := kv.Preflight(store, "bank-vaults-preflight")
+
+	if len(checks) != 2 {
+		t.Fatalf("expected the probing to stop at the denied PutObject, got %+v", checks)
+	}
+	if checks[0].Permission != "sts:GetCallerIdentity" || checks[0].Err != nil {
+		t.Fatalf("unexpected caller identity check: %+v", checks[0])
+	}
+
+	denied := checks[1]
+	if denied.Permission != "s3:PutObject" || denied.Resource != "arn:aws:s3:::vault-unseal/cluster-a/bank-vaults-preflight" {
+		t.Fatalf("unexpected denied check: %+v", denied)
+	}
+	if denied.Err == nil || !strings.Contains(denied.Err.Error(), "AccessDenied") {
+		t.Fatalf("expected the access denied error, got: %v", denied.Err)
+	}
+	if client.puts != 1 {
+		t.Fatalf("expected a single probe PutObject, got %d", client.puts)
+	}
+}
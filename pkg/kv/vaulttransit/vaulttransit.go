@@ -0,0 +1,214 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaulttransit
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+)
+
+// DefaultMountPath is the path the Transit secrets engine is mounted at by default
+const DefaultMountPath = "transit"
+
+type vaultTransit struct {
+	store     kv.Service
+	cl        *api.Client
+	mountPath string
+	keyName   string
+}
+
+var _ kv.Service = &vaultTransit{}
+var _ kv.Preflighter = &vaultTransit{}
+
+// New creates a new kv.Service encrypted by the Transit secrets engine of another Vault cluster,
+// the cluster is reached at the address with the token and the TLS config (nil verifies the
+// certificate of the cluster with the system CAs). The client is configured only by these,
+// the VAULT_ environment variables (e.g. VAULT_CACERT) belong to the Vault cluster being unsealed.
+func New(store kv.Service, address, token, mountPath, keyName string, tlsConfig *api.TLSConfig) (kv.Service, error) {
+	if address == "" {
+		return nil, fmt.Errorf("invalid address of the transit vault specified: '%s'", address)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("the token of the transit vault has to be specified")
+	}
+
+	// the same defaults as api.DefaultConfig, without reading the environment
+	config := &api.Config{
+		Address:    address,
+		HttpClient: cleanhttp.DefaultPooledClient(),
+		Backoff:    retryablehttp.LinearJitterBackoff,
+		MaxRetries: 2,
+	}
+	config.HttpClient.Timeout = 60 * time.Second
+	config.HttpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// the client of Vault handles the redirects itself
+		return http.ErrUseLastResponse
+	}
+	transport := config.HttpClient.Transport.(*http.Transport)
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if tlsConfig != nil {
+		err := config.ConfigureTLS(tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring TLS of the transit vault client: %s", err.Error())
+		}
+	}
+
+	cl, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating transit vault client: %s", err.Error())
+	}
+	cl.SetToken(token)
+
+	return NewWithClient(store, cl, mountPath, keyName)
+}
+
+// NewWithClient creates a new kv.Service encrypted by the Transit secrets engine the client is connected to
+func NewWithClient(store kv.Service, cl *api.Client, mountPath, keyName string) (kv.Service, error) {
+	if keyName == "" {
+		return nil, fmt.Errorf("invalid transit key name specified: '%s'", keyName)
+	}
+	if mountPath == "" {
+		mountPath = DefaultMountPath
+	}
+
+	return &vaultTransit{
+		store:     store,
+		cl:        cl,
+		mountPath: mountPath,
+		keyName:   keyName,
+	}, nil
+}
+
+func (t *vaultTransit) encryptPath() string {
+	return fmt.Sprintf("%s/encrypt/%s", t.mountPath, t.keyName)
+}
+
+func (t *vaultTransit) decryptPath() string {
+	return fmt.Sprintf("%s/decrypt/%s", t.mountPath, t.keyName)
+}
+
+// encrypt returns the ciphertext of Transit, e.g. vault:v1:..., which keeps the version of the key
+func (t *vaultTransit) encrypt(plainText []byte) ([]byte, error) {
+	// https://www.vaultproject.io/api/secret/transit/index.html#encrypt-data
+	secret, err := t.cl.Logical().Write(t.encryptPath(), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plainText),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting with transit key '%s': %s", t.keyName, err.Error())
+	}
+	if secret == nil || secret.Data["ciphertext"] == nil {
+		return nil, fmt.Errorf("error encrypting with transit key '%s': no ciphertext returned", t.keyName)
+	}
+	return []byte(cast.ToString(secret.Data["ciphertext"])), nil
+}
+
+func (t *vaultTransit) decrypt(cipherText []byte) ([]byte, error) {
+	// https://www.vaultproject.io/api/secret/transit/index.html#decrypt-data
+	secret, err := t.cl.Logical().Write(t.decryptPath(), map[string]interface{}{
+		"ciphertext": string(cipherText),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting with transit key '%s': %s", t.keyName, err.Error())
+	}
+	if secret == nil || secret.Data["plaintext"] == nil {
+		return nil, fmt.Errorf("error decrypting with transit key '%s': no plaintext returned", t.keyName)
+	}
+
+	plainText, err := base64.StdEncoding.DecodeString(cast.ToString(secret.Data["plaintext"]))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding plaintext of transit key '%s': %s", t.keyName, err.Error())
+	}
+	return plainText, nil
+}
+
+func (t *vaultTransit) Get(key string) ([]byte, error) {
+	cipherText, err := t.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.decrypt(cipherText)
+}
+
+func (t *vaultTransit) Set(key string, val []byte) error {
+	cipherText, err := t.encrypt(val)
+	if err != nil {
+		return err
+	}
+
+	return t.store.Set(key, cipherText)
+}
+
+func (t *vaultTransit) Test(key string) error {
+	inputString := "test"
+
+	err := t.store.Test(key)
+	if err != nil {
+		return fmt.Errorf("test of backend store failed: %s", err.Error())
+	}
+
+	cipherText, err := t.encrypt([]byte(inputString))
+	if err != nil {
+		return err
+	}
+
+	plainText, err := t.decrypt(cipherText)
+	if err != nil {
+		return err
+	}
+
+	if string(plainText) != inputString {
+		return fmt.Errorf("encrypted and decryped text doesn't match: exp: '%v', act: '%v'", inputString, string(plainText))
+	}
+
+	return nil
+}
+
+// List returns the keys of the backend store, the names of the keys are not encrypted
+func (t *vaultTransit) List() ([]string, error) {
+	return t.store.List()
+}
+
+// Preflight probes the policy of the token with the transit key, then the permissions of the backend store
+func (t *vaultTransit) Preflight(probeKey string) []kv.PreflightCheck {
+	cipherText, err := t.encrypt([]byte("bank-vaults preflight"))
+	checks := []kv.PreflightCheck{{Permission: "update", Resource: t.encryptPath(), Err: err}}
+	if err != nil {
+		return checks
+	}
+
+	_, err = t.decrypt(cipherText)
+	checks = append(checks, kv.PreflightCheck{Permission: "update", Resource: t.decryptPath(), Err: err})
+	if err != nil {
+		return checks
+	}
+
+	return append(checks, kv.Preflight(t.store, probeKey)...)
+}
+
+// Delete deletes the encrypted value from the underlying store
+func (t *vaultTransit) Delete(key string) error {
+	return kv.Delete(t.store, key)
+}
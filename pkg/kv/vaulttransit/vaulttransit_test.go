@@ -0,0 +1,180 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vaulttransit
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv/file"
+	"github.com/hashicorp/vault/api"
+)
+
+// fakeTransit is a Transit engine mounted at the path, its "ciphertext" is the base64 plaintext
+// with the version prefix of Transit
+func fakeTransit(t *testing.T, token, mountPath, keyName string) *httptest.Server {
+	return httptest.NewServer(fakeTransitHandler(t, token, mountPath, keyName))
+}
+
+func fakeTransitHandler(t *testing.T, token, mountPath, keyName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+
+		var data map[string]string
+		switch r.URL.Path {
+		case "/v1/" + mountPath + "/encrypt/" + keyName:
+			data = map[string]string{"ciphertext": "vault:v1:" + request["plaintext"]}
+		case "/v1/" + mountPath + "/decrypt/" + keyName:
+			if !strings.HasPrefix(request["ciphertext"], "vault:v1:") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["invalid ciphertext: no prefix"]}`))
+				return
+			}
+			data = map[string]string{"plaintext": strings.TrimPrefix(request["ciphertext"], "vault:v1:")}
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	})
+}
+
+func TestVaultTransit(t *testing.T) {
+	server := fakeTransit(t, "transit-token", "unseal-transit", "bank-vaults")
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vaulttransit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := file.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transit, err := New(store, server.URL, "transit-token", "unseal-transit", "bank-vaults", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transit.Set("vault-root", []byte("root-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := ioutil.ReadFile(filepath.Join(dir, "vault-root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(stored), "vault:v1:") || strings.Contains(string(stored), "root-token") {
+		t.Fatalf("expected the ciphertext of transit in the backend store, got %q", stored)
+	}
+
+	value, err := transit.Get("vault-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "root-token" {
+		t.Fatalf("expected the decrypted value, got %q", value)
+	}
+
+	for _, check := range transit.(*vaultTransit).Preflight("vault-preflight") {
+		if check.Err != nil {
+			t.Fatalf("expected the preflight of %s %s to pass, got %v", check.Permission, check.Resource, check.Err)
+		}
+	}
+
+	unauthorized, err := New(store, server.URL, "wrong-token", "unseal-transit", "bank-vaults", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unauthorized.Get("vault-root"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected the permission error of the transit vault, got %v", err)
+	}
+
+	if _, err := New(store, server.URL, "", "", "bank-vaults", nil); err == nil {
+		t.Fatal("expected a missing token to fail")
+	}
+	if _, err := New(store, server.URL, "transit-token", "", "", nil); err == nil {
+		t.Fatal("expected a missing key name to fail")
+	}
+}
+
+func TestVaultTransitTLS(t *testing.T) {
+	server := httptest.NewTLSServer(fakeTransitHandler(t, "transit-token", "unseal-transit", "bank-vaults"))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vaulttransit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := file.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert := filepath.Join(dir, "ca.crt")
+	err = ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the TLS settings of the Vault cluster being unsealed don't apply to the transit vault
+	os.Setenv("VAULT_SKIP_VERIFY", "true")
+	defer os.Unsetenv("VAULT_SKIP_VERIFY")
+
+	transit, err := New(store, server.URL, "transit-token", "unseal-transit", "bank-vaults", &api.TLSConfig{CACert: caCert, TLSServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transit.Set("vault-root", []byte("root-token")); err != nil {
+		t.Fatal(err)
+	}
+
+	untrusted, err := New(store, server.URL, "transit-token", "unseal-transit", "bank-vaults", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := untrusted.Set("vault-root", []byte("root-token")); err == nil {
+		t.Fatal("expected the certificate of the transit vault not to be trusted without its CA")
+	}
+
+	insecure, err := New(store, server.URL, "transit-token", "unseal-transit", "bank-vaults", &api.TLSConfig{Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := insecure.Set("vault-root", []byte("root-token")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package versioned
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+)
+
+type versioned struct {
+	sync.Mutex
+	store    kv.Service
+	versions int
+}
+
+// New creates a new kv.Service which keeps the last versions previous values of every key in the
+// store, under VersionKey(key, 1) (the latest) to VersionKey(key, versions) (the oldest). Setting
+// the value a key already has doesn't create a new version.
+func New(store kv.Service, versions int) kv.Service {
+	return &versioned{store: store, versions: versions}
+}
+
+// VersionKey returns the key of the previous version of the key, the latest being version 1
+func VersionKey(key string, version int) string {
+	return fmt.Sprintf("%s.v%d", key, version)
+}
+
+func (v *versioned) Set(key string, val []byte) error {
+	v.Lock()
+	defer v.Unlock()
+
+	current, found, err := v.get(key)
+	if err != nil {
+		return err
+	}
+
+	if found && !bytes.Equal(current, val) {
+		err = v.shift(key)
+		if err != nil {
+			return err
+		}
+		err = v.store.Set(VersionKey(key, 1), current)
+		if err != nil {
+			return fmt.Errorf("error keeping the previous version of key '%s': %s", key, err.Error())
+		}
+	}
+
+	return v.store.Set(key, val)
+}
+
+// shift moves the versions of the key one version older, the oldest one is overwritten
+func (v *versioned) shift(key string) error {
+	for version := v.versions - 1; version >= 1; version-- {
+		val, found, err := v.get(VersionKey(key, version))
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		err = v.store.Set(VersionKey(key, version+1), val)
+		if err != nil {
+			return fmt.Errorf("error keeping version %d of key '%s': %s", version+1, key, err.Error())
+		}
+	}
+	return nil
+}
+
+func (v *versioned) get(key string) ([]byte, bool, error) {
+	val, err := v.store.Get(key)
+	if err != nil {
+		if _, ok := err.(*kv.NotFoundError); ok {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+func (v *versioned) Get(key string) ([]byte, error) {
+	return v.store.Get(key)
+}
+
+// Delete deletes the key and its previous versions
+func (v *versioned) Delete(key string) error {
+	v.Lock()
+	defer v.Unlock()
+
+	for version := v.versions; version >= 1; version-- {
+		err := kv.Delete(v.store, VersionKey(key, version))
+		if err != nil {
+			return err
+		}
+	}
+	return kv.Delete(v.store, key)
+}
+
+func (v *versioned) Test(key string) error {
+	return v.store.Test(key)
+}
+
+// List returns the keys of the store, including the keys of the previous versions
+func (v *versioned) List() ([]string, error) {
+	return v.store.List()
+}
+
+// Restore sets the key to its previous version in the versioned store, the replaced value
+// becomes the latest previous version, so the restore can be undone the same way
+func Restore(store kv.Service, key string, version int) error {
+	val, err := store.Get(VersionKey(key, version))
+	if err != nil {
+		return fmt.Errorf("error getting version %d of key '%s': %s", version, key, err.Error())
+	}
+	return store.Set(key, val)
+}
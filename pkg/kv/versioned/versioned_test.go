@@ -0,0 +1,124 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package versioned
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/banzaicloud/bank-vaults/pkg/kv/memory"
+)
+
+func TestVersioned(t *testing.T) {
+	backend := memory.New(nil)
+	store := New(backend, 2)
+
+	for _, val := range []string{"share-1", "share-2", "share-2", "share-3", "share-4"} {
+		if err := store.Set("vault-unseal-0", []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"vault-unseal-0":    "share-4",
+		"vault-unseal-0.v1": "share-3",
+		"vault-unseal-0.v2": "share-2",
+	}
+	if len(backend.Values()) != len(expected) {
+		t.Fatalf("expected only the last 2 versions to be kept: %v", backend.Values())
+	}
+	for key, val := range expected {
+		if string(backend.Values()[key]) != val {
+			t.Fatalf("expected %s to be %s, got %s", key, val, backend.Values()[key])
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	backend := memory.New(nil)
+	store := New(backend, 3)
+
+	if err := store.Set("vault-unseal-0", []byte("good")); err != nil {
+		t.Fatal(err)
+	}
+	// an accidental overwrite of the share
+	if err := store.Set("vault-unseal-0", []byte("bad")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(store, "vault-unseal-0", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := store.Get("vault-unseal-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "good" {
+		t.Fatalf("expected the previous version to be restored, got %s", val)
+	}
+	if string(backend.Values()["vault-unseal-0.v1"]) != "bad" {
+		t.Fatalf("expected the replaced value to be kept, got %s", backend.Values()["vault-unseal-0.v1"])
+	}
+
+	if err := Restore(store, "vault-unseal-0", 3); err == nil {
+		t.Fatal("expected an error restoring a missing version")
+	}
+}
+
+func TestVersionedList(t *testing.T) {
+	backend := memory.New(nil)
+	store := New(backend, 2)
+
+	for _, val := range []string{"share-1", "share-2"} {
+		if err := store.Set("vault-unseal-0", []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set("vault-root", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"vault-root", "vault-unseal-0", VersionKey("vault-unseal-0", 1)}
+	if strings.Join(keys, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected the written keys %v, got %v", expected, keys)
+	}
+}
+
+func TestVersionedDelete(t *testing.T) {
+	backend := memory.New(nil)
+	store := New(backend, 2)
+
+	for _, val := range []string{"share-1", "share-2", "share-3"} {
+		if err := store.Set("vault-unseal-0", []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Set("vault-root", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kv.Delete(store, "vault-unseal-0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.Values()) != 1 || string(backend.Values()["vault-root"]) != "root" {
+		t.Fatalf("expected the key and its versions to be deleted, got %v", backend.Values())
+	}
+}
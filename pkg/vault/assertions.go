@@ -0,0 +1,129 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// The types of the assertions of the "assertions" section
+const (
+	assertionPathExists   = "exists"
+	assertionSecretEngine = "secret_engine"
+	assertionAuthMethod   = "auth_method"
+	assertionPolicy       = "policy"
+)
+
+// configureAssertions evaluates the checks of the "assertions" section after the configuration is
+// applied: a path exists, a secret engine or an auth method is enabled or a policy is present.
+// All assertions are evaluated, the section fails with the list of the failed ones.
+func (v *vault) configureAssertions(config *viper.Viper) error {
+	assertions, err := toNormalizedSliceStringMapE(config.Get("assertions"))
+	if err != nil {
+		return fmt.Errorf("error decoding assertions config: %s", err.Error())
+	}
+	if len(assertions) == 0 {
+		return nil
+	}
+
+	var failures []string
+	for _, assertion := range assertions {
+		assertionType, err := getOrError(assertion, "type")
+		if err != nil {
+			return fmt.Errorf("error getting type for assertion: %s", err.Error())
+		}
+		target, err := assertionTarget(assertionType, assertion)
+		if err != nil {
+			return err
+		}
+
+		err = v.assert(assertionType, target)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		logrus.Infof("assertion %s %s holds", assertionType, target)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d assertions failed: %s", len(failures), len(assertions), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// assertionTarget returns the name of the policy or the path the assertion checks
+func assertionTarget(assertionType string, assertion map[string]interface{}) (string, error) {
+	switch assertionType {
+	case assertionPolicy:
+		name, err := getOrError(assertion, "name")
+		if err != nil {
+			return "", fmt.Errorf("error getting name for %s assertion: %s", assertionType, err.Error())
+		}
+		return name, nil
+	case assertionPathExists, assertionSecretEngine, assertionAuthMethod:
+		path, err := getOrError(assertion, "path")
+		if err != nil {
+			return "", fmt.Errorf("error getting path for %s assertion: %s", assertionType, err.Error())
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("unsupported assertion type %s, supported types: %s, %s, %s, %s",
+		assertionType, assertionPathExists, assertionSecretEngine, assertionAuthMethod, assertionPolicy)
+}
+
+func (v *vault) assert(assertionType, target string) error {
+	switch assertionType {
+	case assertionPathExists:
+		secret, err := v.cl.Logical().Read(target)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", target, err.Error())
+		}
+		if secret == nil {
+			return fmt.Errorf("path %s doesn't exist", target)
+		}
+
+	case assertionSecretEngine:
+		mounts, err := v.cl.Sys().ListMounts()
+		if err != nil {
+			return fmt.Errorf("error listing mounts: %s", err.Error())
+		}
+		if _, ok := mounts[strings.Trim(target, "/")+"/"]; !ok {
+			return fmt.Errorf("secret engine %s isn't enabled", target)
+		}
+
+	case assertionAuthMethod:
+		auths, err := v.cl.Sys().ListAuth()
+		if err != nil {
+			return fmt.Errorf("error listing auth methods: %s", err.Error())
+		}
+		if _, ok := auths[strings.Trim(target, "/")+"/"]; !ok {
+			return fmt.Errorf("auth method %s isn't enabled", target)
+		}
+
+	case assertionPolicy:
+		policy, err := v.cl.Sys().GetPolicy(target)
+		if err != nil {
+			return fmt.Errorf("error reading policy %s: %s", target, err.Error())
+		}
+		if policy == "" {
+			return fmt.Errorf("policy %s isn't present", target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSectionBreakerCooldown is how long an open section breaker skips its section if the
+// cooldown isn't configured
+const defaultSectionBreakerCooldown = 5 * time.Minute
+
+// sectionBreaker is the circuit breaker of a configuration section: after the threshold of
+// consecutive failures it opens and the section is skipped until the cooldown is over, then the
+// section is tried once more, a success closes the breaker and a failure opens it again
+type sectionBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// sectionShortCircuited tells if the breaker of the section is open, with the error reported
+// for the skipped section
+func (v *vault) sectionShortCircuited(section string) (bool, error) {
+	if v.config.SectionBreakerThreshold <= 0 {
+		return false, nil
+	}
+
+	v.breakersLock.Lock()
+	defer v.breakersLock.Unlock()
+
+	breaker := v.breakers[section]
+	if breaker == nil || breaker.failures < v.config.SectionBreakerThreshold || !time.Now().Before(breaker.openUntil) {
+		return false, nil
+	}
+	return true, fmt.Errorf("the circuit breaker of the %s section is open after %d consecutive failures, the section is skipped until %s",
+		section, breaker.failures, breaker.openUntil.Format(time.RFC3339))
+}
+
+// recordSectionResult updates the breaker of the section with the result of applying it
+func (v *vault) recordSectionResult(section string, err error) {
+	if v.config.SectionBreakerThreshold <= 0 {
+		return
+	}
+
+	v.breakersLock.Lock()
+	defer v.breakersLock.Unlock()
+
+	if err == nil {
+		delete(v.breakers, section)
+		return
+	}
+
+	if v.breakers == nil {
+		v.breakers = map[string]*sectionBreaker{}
+	}
+	breaker := v.breakers[section]
+	if breaker == nil {
+		breaker = &sectionBreaker{}
+		v.breakers[section] = breaker
+	}
+	breaker.failures++
+	if breaker.failures >= v.config.SectionBreakerThreshold {
+		cooldown := v.config.SectionBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = defaultSectionBreakerCooldown
+		}
+		breaker.openUntil = time.Now().Add(cooldown)
+		logrus.Warnf("opening the circuit breaker of the %s section after %d consecutive failures for %s", section, breaker.failures, cooldown)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// The kinds of the mounts checked by CheckMounts
+const (
+	MountKindSecret = "secret"
+	MountKindAuth   = "auth"
+)
+
+// MountCheck is the state of a secret engine or an auth method declared in the configuration
+type MountCheck struct {
+	Kind    string
+	Path    string
+	Type    string
+	Enabled bool
+	Healthy bool
+	Message string
+}
+
+// CheckMounts checks that the secret engines and auth methods of the configuration are enabled
+// with the declared type and that their tune endpoint answers, without changing anything
+func (v *vault) CheckMounts(config *viper.Viper) ([]MountCheck, error) {
+	var checks []MountCheck
+
+	err := v.withRootToken(func() error {
+		secretsEngines, err := toNormalizedSliceStringMapE(config.Get("secrets"))
+		if err != nil {
+			return fmt.Errorf("error decoding secrets config: %s", err.Error())
+		}
+		if len(secretsEngines) > 0 {
+			mounts, err := v.cl.Sys().ListMounts()
+			if err != nil {
+				return fmt.Errorf("error reading mounts from vault: %s", err.Error())
+			}
+			enabled := map[string]string{}
+			for path, mount := range mounts {
+				enabled[path] = mount.Type
+			}
+			for _, secretEngine := range secretsEngines {
+				check, err := v.checkMount(MountKindSecret, secretEngine, enabled, "sys/mounts/%s/tune")
+				if err != nil {
+					return err
+				}
+				checks = append(checks, check)
+			}
+		}
+
+		authMethods, err := toNormalizedSliceStringMapE(config.Get("auth"))
+		if err != nil {
+			return fmt.Errorf("error decoding auth config: %s", err.Error())
+		}
+		if len(authMethods) > 0 {
+			auths, err := v.cl.Sys().ListAuth()
+			if err != nil {
+				return fmt.Errorf("error listing auth backends vault: %s", err.Error())
+			}
+			enabled := map[string]string{}
+			for path, auth := range auths {
+				enabled[path] = auth.Type
+			}
+			for _, authMethod := range authMethods {
+				check, err := v.checkMount(MountKindAuth, authMethod, enabled, "sys/auth/%s/tune")
+				if err != nil {
+					return err
+				}
+				checks = append(checks, check)
+			}
+		}
+
+		return nil
+	})
+
+	return checks, err
+}
+
+func (v *vault) checkMount(kind string, mount map[string]interface{}, enabled map[string]string, tunePath string) (MountCheck, error) {
+	path, mountType, err := mountPathAndType(mount)
+	if err != nil {
+		return MountCheck{}, fmt.Errorf("error finding path for %s mount: %s", kind, err.Error())
+	}
+	check := MountCheck{Kind: kind, Path: path, Type: mountType}
+
+	// the mounts of plugins have the type of the plugin
+	expectedType := mountType
+	if pluginName, ok := mount["plugin_name"]; ok && mountType == "plugin" {
+		expectedType = fmt.Sprint(pluginName)
+	}
+
+	enabledType, ok := enabled[path+"/"]
+	switch {
+	case !ok:
+		check.Message = "not enabled"
+		return check, nil
+	case enabledType != expectedType:
+		check.Message = fmt.Sprintf("enabled as %s", enabledType)
+		return check, nil
+	}
+	check.Enabled = true
+
+	tune, err := v.cl.Logical().Read(fmt.Sprintf(tunePath, path))
+	if err != nil {
+		check.Message = err.Error()
+		return check, nil
+	}
+	if tune == nil {
+		check.Message = "the tune endpoint didn't answer"
+		return check, nil
+	}
+	check.Healthy = true
+	return check, nil
+}
@@ -0,0 +1,182 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// StaticRoleRotation is the result of rotating the credentials of a database static role
+type StaticRoleRotation struct {
+	Path string
+	Role string
+	Err  error
+}
+
+// RootRotation is the result of rotating the root credentials of a database connection
+type RootRotation struct {
+	Path       string
+	Connection string
+	Err        error
+}
+
+// databaseCredentialTypes are the types of the credentials the database roles can generate (Vault 1.12+),
+// the credential_config of the role configures the generator of its type (e.g. key_bits or common_name_template)
+var databaseCredentialTypes = map[string]bool{"password": true, "rsa_private_key": true, "client_certificate": true}
+
+// validateDatabaseRole checks the credential_type and the credential_config of a database role
+func validateDatabaseRole(role map[string]interface{}) error {
+	credentialType, err := getOrDefaultString(role, "credential_type")
+	if err != nil {
+		return fmt.Errorf("error getting credential_type: %s", err.Error())
+	}
+	if credentialType != "" && !databaseCredentialTypes[credentialType] {
+		return fmt.Errorf("unknown credential_type %s, it has to be password, rsa_private_key or client_certificate", credentialType)
+	}
+	if _, err := getOrDefaultStringMap(role, "credential_config"); err != nil {
+		return fmt.Errorf("error getting credential_config: %s", err.Error())
+	}
+	return nil
+}
+
+// databaseItems are the items of a block (e.g. "config" or "static-roles") of the
+// configuration of a database secret engine mounted at path
+type databaseItems struct {
+	path  string
+	items []map[string]interface{}
+}
+
+// databaseConfigurationItems returns the items of the block of every database secret engine in the configuration
+func databaseConfigurationItems(config *viper.Viper, block string) ([]databaseItems, error) {
+	secretsEngines := []map[string]interface{}{}
+	err := config.UnmarshalKey("secrets", &secretsEngines)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling vault secrets config: %s", err.Error())
+	}
+
+	var engines []databaseItems
+	for _, secretEngine := range secretsEngines {
+		secretEngineType, err := cast.ToStringE(secretEngine["type"])
+		if err != nil {
+			return nil, fmt.Errorf("error finding type for secret engine: %s", err.Error())
+		}
+		if secretEngineType != "database" {
+			continue
+		}
+
+		path := secretEngineType
+		if pathOverwrite, ok := secretEngine["path"]; ok {
+			path, err = cast.ToStringE(pathOverwrite)
+			if err != nil {
+				return nil, fmt.Errorf("error converting path for secret engine: %s", err.Error())
+			}
+		}
+
+		configuration, err := getOrDefaultStringMap(secretEngine, "configuration")
+		if err != nil {
+			return nil, fmt.Errorf("error getting configuration for secret engine: %s", err.Error())
+		}
+
+		items, err := toNormalizedSliceStringMapE(configuration[block])
+		if err != nil {
+			return nil, fmt.Errorf("error finding %s block for %s: %s", block, path, err.Error())
+		}
+		engines = append(engines, databaseItems{path: path, items: items})
+	}
+	return engines, nil
+}
+
+// RotateDatabaseStaticRoles rotates the credentials of every static role listed under
+// "static-roles" in the configuration of the database secret engines
+func (v *vault) RotateDatabaseStaticRoles(config *viper.Viper) ([]StaticRoleRotation, error) {
+	var rotations []StaticRoleRotation
+
+	err := v.withRootToken(func() error {
+		engines, err := databaseConfigurationItems(config, "static-roles")
+		if err != nil {
+			return err
+		}
+
+		for _, engine := range engines {
+			for _, staticRole := range engine.items {
+				role, err := getOrError(staticRole, "name")
+				if err != nil {
+					return fmt.Errorf("error getting name for static role of %s: %s", engine.path, err.Error())
+				}
+
+				// https://www.vaultproject.io/api/secret/databases/index.html#rotate-static-role-credentials
+				_, err = v.cl.Logical().Write(fmt.Sprintf("%s/rotate-role/%s", engine.path, role), nil)
+				if err != nil {
+					logrus.Errorf("error rotating %s static role of %s: %s", role, engine.path, err.Error())
+				} else {
+					logrus.Infof("rotated %s static role of %s", role, engine.path)
+				}
+				rotations = append(rotations, StaticRoleRotation{Path: engine.path, Role: role, Err: err})
+			}
+		}
+
+		return nil
+	})
+
+	return rotations, err
+}
+
+// RotateDatabaseRoots rotates the root credentials of every connection listed under "config"
+// in the configuration of the database secret engines, only the connections listed in
+// connections (as <path>/<name>) are rotated if it is not empty
+func (v *vault) RotateDatabaseRoots(config *viper.Viper, connections []string) ([]RootRotation, error) {
+	var rotations []RootRotation
+
+	selected := map[string]bool{}
+	for _, connection := range connections {
+		selected[connection] = true
+	}
+
+	err := v.withRootToken(func() error {
+		engines, err := databaseConfigurationItems(config, "config")
+		if err != nil {
+			return err
+		}
+
+		for _, engine := range engines {
+			for _, connectionConfig := range engine.items {
+				connection, err := getOrError(connectionConfig, "name")
+				if err != nil {
+					return fmt.Errorf("error getting name for connection of %s: %s", engine.path, err.Error())
+				}
+				if len(selected) > 0 && !selected[engine.path+"/"+connection] {
+					continue
+				}
+
+				// https://www.vaultproject.io/api/secret/databases/index.html#rotate-root-credentials
+				_, err = v.cl.Logical().Write(fmt.Sprintf("%s/rotate-root/%s", engine.path, connection), nil)
+				if err != nil {
+					logrus.Errorf("error rotating the root credentials of %s connection of %s: %s", connection, engine.path, err.Error())
+				} else {
+					logrus.Infof("rotated the root credentials of %s connection of %s", connection, engine.path)
+				}
+				rotations = append(rotations, RootRotation{Path: engine.path, Connection: connection, Err: err})
+			}
+		}
+
+		return nil
+	})
+
+	return rotations, err
+}
@@ -0,0 +1,126 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// deprecatedAuthMethods are the known deprecated auth method types with the guidance to migrate
+var deprecatedAuthMethods = map[string]string{
+	"app-id": "use the approle auth method instead",
+}
+
+// deprecatedSecretEngines are the known deprecated secret engine types with the guidance to migrate
+var deprecatedSecretEngines = map[string]string{
+	"cassandra":  "use the database secret engine with the cassandra-database-plugin instead",
+	"mongodb":    "use the database secret engine with the mongodb-database-plugin instead",
+	"mssql":      "use the database secret engine with the mssql-database-plugin instead",
+	"mysql":      "use the database secret engine with the mysql-database-plugin instead",
+	"postgresql": "use the database secret engine with the postgresql-database-plugin instead",
+	"ad":         "use the ldap secret engine instead",
+}
+
+// DeprecationError is returned when the configuration uses deprecated Vault features
+// and the run is configured to fail on them
+type DeprecationError struct {
+	Deprecations []string
+}
+
+func (e *DeprecationError) Error() string {
+	return fmt.Sprintf("the configuration uses deprecated features: %s", strings.Join(e.Deprecations, "; "))
+}
+
+// checkDeprecations checks the auth methods and secret engines of the configuration against
+// the known deprecations and the deprecation status reported by the plugin catalog of Vault
+func (v *vault) checkDeprecations(config *viper.Viper) error {
+	var deprecations []string
+
+	authMethods := []map[string]interface{}{}
+	err := config.UnmarshalKey("auth", &authMethods)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling vault auth methods config: %s", err.Error())
+	}
+	for _, authMethod := range authMethods {
+		authMethodType, err := cast.ToStringE(authMethod["type"])
+		if err != nil {
+			return fmt.Errorf("error finding auth method type: %s", err.Error())
+		}
+		deprecations = append(deprecations, v.pluginDeprecations("auth", authMethodType, deprecatedAuthMethods)...)
+	}
+
+	secretEngines := []map[string]interface{}{}
+	err = config.UnmarshalKey("secrets", &secretEngines)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling vault secrets config: %s", err.Error())
+	}
+	for _, secretEngine := range secretEngines {
+		secretEngineType, err := cast.ToStringE(secretEngine["type"])
+		if err != nil {
+			return fmt.Errorf("error finding type for secret engine: %s", err.Error())
+		}
+		deprecations = append(deprecations, v.pluginDeprecations("secret", secretEngineType, deprecatedSecretEngines)...)
+
+		if secretEngineType == "kv" {
+			options, err := getOrDefaultStringMap(secretEngine, "options")
+			if err != nil {
+				return fmt.Errorf("error getting options for secret engine: %s", err.Error())
+			}
+			if version := cast.ToString(options["version"]); version == "" || version == "1" {
+				path, _ := getOrDefaultString(secretEngine, "path")
+				if path == "" {
+					path = secretEngineType
+				}
+				deprecations = append(deprecations, fmt.Sprintf("secret engine %s is KV version 1: set options.version to 2 and upgrade the mount", path))
+			}
+		}
+	}
+
+	if len(deprecations) > 0 {
+		return &DeprecationError{Deprecations: deprecations}
+	}
+	return nil
+}
+
+// pluginDeprecations returns the deprecations of the plugin, the deprecation status is reported
+// by Vault 1.12+ only, so failing to read it from the plugin catalog is not an error
+func (v *vault) pluginDeprecations(pluginType, name string, known map[string]string) []string {
+	kind := "auth method"
+	if pluginType == "secret" {
+		kind = "secret engine"
+	}
+
+	var deprecations []string
+	if guidance, ok := known[name]; ok {
+		deprecations = append(deprecations, fmt.Sprintf("%s type %s is deprecated: %s", kind, name, guidance))
+	}
+
+	secret, err := v.cl.Logical().Read(fmt.Sprintf("sys/plugins/catalog/%s/%s", pluginType, name))
+	if err != nil || secret == nil || secret.Data == nil {
+		return deprecations
+	}
+	status, _ := getOrDefaultString(secret.Data, "deprecation_status")
+	switch status {
+	case "deprecated", "pending removal", "removed":
+		if len(deprecations) == 0 {
+			deprecations = append(deprecations, fmt.Sprintf("%s type %s is reported as %s by vault: migrate to a supported plugin", kind, name, status))
+		}
+	}
+	return deprecations
+}
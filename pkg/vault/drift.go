@@ -0,0 +1,335 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// The resource types checked for configuration drift
+const (
+	DriftPolicy = "policy"
+	DriftMount  = "mount"
+	DriftAuth   = "auth"
+	DriftAudit  = "audit"
+)
+
+// DriftResourceTypes are all the resource types checked for configuration drift
+var DriftResourceTypes = []string{DriftPolicy, DriftMount, DriftAuth, DriftAudit}
+
+// Drift holds the resources per resource type which are missing from Vault
+// or differ from the configuration
+type Drift map[string][]string
+
+// The actions of the planned changes
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanTune   = "tune"
+)
+
+// PlannedChange is a change applying the configuration would make to a resource of Vault
+type PlannedChange struct {
+	ResourceType string
+	Resource     string
+	Action       string
+	// what changes, e.g. "max_lease_ttl: 768h0m0s -> 24h0m0s" when a mount is tuned
+	Details []string
+}
+
+// PlannedSections are the top-level sections of the configuration compared by Plan
+var PlannedSections = []string{"policies", "secrets", "auth", "audit"}
+
+// UnplannedSections returns the top-level sections of the configuration which Plan doesn't
+// compare with Vault, so the plan can't tell whether applying them would change anything
+func UnplannedSections(config *viper.Viper) []string {
+	planned := map[string]bool{}
+	for _, section := range PlannedSections {
+		planned[section] = true
+	}
+
+	seen := map[string]bool{}
+	var sections []string
+	for _, key := range config.AllKeys() {
+		section := strings.SplitN(key, ".", 2)[0]
+		if !planned[section] && !seen[section] {
+			seen[section] = true
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+
+	return sections
+}
+
+// Plan compares the configuration with the state of Vault without changing anything, and
+// returns the policies, secret engines, auth methods and audit devices which would be created,
+// updated or tuned by applying it.
+//
+// The plan is narrower than Configure and doesn't share its code: only the acl policies, the
+// path and type of the mounts, the lease TTLs and options of the secret engines are compared.
+// The configuration of the auth methods and secret engines (roles, config, ...) and the other
+// sections, see UnplannedSections, are not.
+func (v *vault) Plan(config *viper.Viper) ([]PlannedChange, error) {
+	var changes []PlannedChange
+
+	err := v.withRootToken(func() error {
+		for _, plan := range []func(*viper.Viper) ([]PlannedChange, error){
+			v.planPolicies,
+			v.planMounts,
+			v.planAuthMethods,
+			v.planAuditDevices,
+		} {
+			planned, err := plan(config)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, planned...)
+		}
+		return nil
+	})
+
+	return changes, err
+}
+
+// DetectDrift compares the configuration with the state of Vault without changing anything
+func (v *vault) DetectDrift(config *viper.Viper) (Drift, error) {
+	changes, err := v.Plan(config)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := Drift{}
+	for _, change := range changes {
+		drift[change.ResourceType] = append(drift[change.ResourceType], change.Resource)
+	}
+	return drift, nil
+}
+
+func (v *vault) planPolicies(config *viper.Viper) ([]PlannedChange, error) {
+	policies := []map[string]interface{}{}
+	err := config.UnmarshalKey("policies", &policies)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling vault policy config: %s", err.Error())
+	}
+
+	var changes []PlannedChange
+	for _, policy := range policies {
+		name, rules, policyType, err := policyDefinition(policy, configDir(config))
+		if err != nil {
+			return nil, err
+		}
+		// only the acl policies are checked
+		if policyType != "" && policyType != "acl" {
+			continue
+		}
+
+		current, err := v.cl.Sys().GetPolicy(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s policy from vault: %s", name, err.Error())
+		}
+		if current == "" {
+			changes = append(changes, PlannedChange{ResourceType: DriftPolicy, Resource: name, Action: PlanCreate})
+		} else if strings.TrimSpace(current) != strings.TrimSpace(rules) {
+			changes = append(changes, PlannedChange{ResourceType: DriftPolicy, Resource: name, Action: PlanUpdate})
+		}
+	}
+
+	return changes, nil
+}
+
+func (v *vault) planMounts(config *viper.Viper) ([]PlannedChange, error) {
+	secretsEngines := []map[string]interface{}{}
+	err := config.UnmarshalKey("secrets", &secretsEngines)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling vault secrets config: %s", err.Error())
+	}
+	if len(secretsEngines) == 0 {
+		return nil, nil
+	}
+
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return nil, fmt.Errorf("error reading mounts from vault: %s", err.Error())
+	}
+
+	var changes []PlannedChange
+	for _, secretEngine := range secretsEngines {
+		path, secretEngineType, err := mountPathAndType(secretEngine)
+		if err != nil {
+			return nil, fmt.Errorf("error finding path for secret engine: %s", err.Error())
+		}
+		mount, ok := mounts[path+"/"]
+		if !ok {
+			changes = append(changes, PlannedChange{ResourceType: DriftMount, Resource: path, Action: PlanCreate})
+			continue
+		}
+		if mount.Type != secretEngineType {
+			changes = append(changes, PlannedChange{ResourceType: DriftMount, Resource: path, Action: PlanUpdate,
+				Details: []string{fmt.Sprintf("type: %s -> %s", mount.Type, secretEngineType)}})
+			continue
+		}
+
+		config, err := getMountConfigInput(secretEngine)
+		if err != nil {
+			return nil, err
+		}
+		if details := mountTuneChanges(mount, config); len(details) > 0 {
+			changes = append(changes, PlannedChange{ResourceType: DriftMount, Resource: path, Action: PlanTune, Details: details})
+		}
+	}
+
+	return changes, nil
+}
+
+// mountTuneChanges returns the lease TTLs and the options of the mount which differ from the
+// configuration, the settings which aren't configured are left as they are by the tuning
+func mountTuneChanges(mount *api.MountOutput, config api.MountConfigInput) []string {
+	var details []string
+
+	for _, ttl := range []struct {
+		name       string
+		configured string
+		current    int
+	}{
+		{"default_lease_ttl", config.DefaultLeaseTTL, mount.Config.DefaultLeaseTTL},
+		{"max_lease_ttl", config.MaxLeaseTTL, mount.Config.MaxLeaseTTL},
+	} {
+		seconds, ok := ttlSeconds(ttl.configured)
+		if ok && seconds != ttl.current {
+			details = append(details, fmt.Sprintf("%s: %s -> %s", ttl.name,
+				time.Duration(ttl.current)*time.Second, time.Duration(seconds)*time.Second))
+		}
+	}
+
+	var options []string
+	for option := range config.Options {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	for _, option := range options {
+		if value := config.Options[option]; mount.Options[option] != value {
+			details = append(details, fmt.Sprintf("options.%s: %q -> %q", option, mount.Options[option], value))
+		}
+	}
+
+	return details
+}
+
+// ttlSeconds parses a TTL of the configuration, a duration or a number of seconds, it returns
+// false if it isn't set or is left to the system default
+func ttlSeconds(ttl string) (int, bool) {
+	if ttl == "" || ttl == "system" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(ttl); err == nil {
+		return seconds, true
+	}
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, false
+	}
+	return int(duration / time.Second), true
+}
+
+func (v *vault) planAuthMethods(config *viper.Viper) ([]PlannedChange, error) {
+	authMethods := []map[string]interface{}{}
+	err := config.UnmarshalKey("auth", &authMethods)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling vault auth methods config: %s", err.Error())
+	}
+	if len(authMethods) == 0 {
+		return nil, nil
+	}
+
+	auths, err := v.cl.Sys().ListAuth()
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth backends vault: %s", err.Error())
+	}
+
+	var changes []PlannedChange
+	for _, authMethod := range authMethods {
+		path, authMethodType, err := mountPathAndType(authMethod)
+		if err != nil {
+			return nil, fmt.Errorf("error finding path for auth method: %s", err.Error())
+		}
+		if auth, ok := auths[path+"/"]; !ok {
+			changes = append(changes, PlannedChange{ResourceType: DriftAuth, Resource: path, Action: PlanCreate})
+		} else if auth.Type != authMethodType {
+			changes = append(changes, PlannedChange{ResourceType: DriftAuth, Resource: path, Action: PlanUpdate,
+				Details: []string{fmt.Sprintf("type: %s -> %s", auth.Type, authMethodType)}})
+		}
+	}
+
+	return changes, nil
+}
+
+func (v *vault) planAuditDevices(config *viper.Viper) ([]PlannedChange, error) {
+	auditDevices := []map[string]interface{}{}
+	err := config.UnmarshalKey("audit", &auditDevices)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling audit devices config: %s", err.Error())
+	}
+	if len(auditDevices) == 0 {
+		return nil, nil
+	}
+
+	audits, err := v.cl.Sys().ListAudit()
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit mounts from vault: %s", err.Error())
+	}
+
+	var changes []PlannedChange
+	for _, auditDevice := range auditDevices {
+		path, auditDeviceType, err := mountPathAndType(auditDevice)
+		if err != nil {
+			return nil, fmt.Errorf("error finding path for audit device: %s", err.Error())
+		}
+		if audit, ok := audits[path+"/"]; !ok {
+			changes = append(changes, PlannedChange{ResourceType: DriftAudit, Resource: path, Action: PlanCreate})
+		} else if audit.Type != auditDeviceType {
+			changes = append(changes, PlannedChange{ResourceType: DriftAudit, Resource: path, Action: PlanUpdate,
+				Details: []string{fmt.Sprintf("type: %s -> %s", audit.Type, auditDeviceType)}})
+		}
+	}
+
+	return changes, nil
+}
+
+// mountPathAndType returns the path (defaults to the type) and the type of a mount configuration
+func mountPathAndType(mount map[string]interface{}) (string, string, error) {
+	mountType, err := cast.ToStringE(mount["type"])
+	if err != nil {
+		return "", "", fmt.Errorf("error finding type: %s", err.Error())
+	}
+
+	path := mountType
+	if pathOverwrite, ok := mount["path"]; ok {
+		path, err = cast.ToStringE(pathOverwrite)
+		if err != nil {
+			return "", "", fmt.Errorf("error converting path: %s", err.Error())
+		}
+	}
+
+	return strings.Trim(path, "/"), mountType, nil
+}
@@ -0,0 +1,213 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Explain describes in plain English what applying the configuration does, one sentence per
+// configured item in the order of the sections, without connecting to Vault
+func Explain(config *viper.Viper) []string {
+	var sentences []string
+	for _, section := range (&vault{}).configSections() {
+		if !config.IsSet(section.name) {
+			continue
+		}
+		value := normalizeConfigValue(config.Get(section.name))
+		for _, sentence := range explainSection(section.name, value) {
+			sentences = append(sentences, fmt.Sprintf("%s: %s", section.name, sentence))
+		}
+	}
+	return sentences
+}
+
+func explainSection(section string, value interface{}) []string {
+	if _, isList := listSectionRequiredKeys[section]; isList {
+		items, err := toNormalizedSliceStringMapE(value)
+		if err != nil {
+			return []string{fmt.Sprintf("Can't be explained: %s", err.Error())}
+		}
+		var sentences []string
+		for _, item := range items {
+			sentences = append(sentences, explainItem(section, item))
+		}
+		return sentences
+	}
+
+	block := cast.ToStringMap(value)
+	switch section {
+	case "identity":
+		var parts []string
+		for _, kind := range []string{"entities", "groups"} {
+			items, _ := toNormalizedSliceStringMapE(block[kind])
+			if len(items) > 0 {
+				parts = append(parts, fmt.Sprintf("the %s %s", kind, itemNames(items)))
+			}
+		}
+		if externalGroups, _ := externalGroupsFromMappings(cast.ToStringMap(block["external_groups"])); len(externalGroups) > 0 {
+			parts = append(parts, fmt.Sprintf("the external groups %s", itemNames(externalGroups)))
+		}
+		if groupAliases, _ := toNormalizedSliceStringMapE(block["group_aliases"]); len(groupAliases) > 0 {
+			parts = append(parts, fmt.Sprintf("the group aliases %s", itemNames(groupAliases)))
+		}
+		for _, kind := range []string{"oidc", "oidc_provider", "mfa"} {
+			if _, ok := block[kind]; ok {
+				parts = append(parts, fmt.Sprintf("the %s settings", strings.Replace(kind, "_", " ", -1)))
+			}
+		}
+		if len(parts) == 0 {
+			return []string{"Configures the identity secrets engine"}
+		}
+		return []string{fmt.Sprintf("Creates or updates %s", strings.Join(parts, ", "))}
+	case "identityPurge":
+		var parts []string
+		for _, kind := range []string{"entities", "groups"} {
+			if names := cast.ToStringSlice(block[kind]); len(names) > 0 {
+				parts = append(parts, fmt.Sprintf("the %s %s", kind, strings.Join(names, ", ")))
+			}
+		}
+		if len(parts) == 0 {
+			return []string{"Deletes nothing, no entity or group is listed"}
+		}
+		return []string{fmt.Sprintf("Deletes %s (only with --confirm-identity-purge)", strings.Join(parts, " and "))}
+	case "purgeUnmanagedConfig":
+		var types []string
+		for _, resourceType := range []string{"policies", "auth", "secrets", "groups"} {
+			if cast.ToBool(block[resourceType]) {
+				types = append(types, resourceType)
+			}
+		}
+		if len(types) == 0 {
+			return []string{"Removes nothing, no resource type is enabled"}
+		}
+		return []string{fmt.Sprintf("Removes the managed %s which are not in the configuration anymore", strings.Join(types, ", "))}
+	case "wrappingDefaults":
+		return []string{fmt.Sprintf("Sets the response wrapping defaults %s", explainSettings(block))}
+	case "ui":
+		return []string{"Configures the Vault UI (headers, default login methods and custom messages)"}
+	case "controlGroup":
+		return []string{fmt.Sprintf("Sets the control group settings %s (Enterprise only)", explainSettings(block))}
+	case "seal":
+		return []string{fmt.Sprintf("Tracks the seal key %s, rewrapping the seal-wrapped entries when it changes", cast.ToString(block["key_id"]))}
+	}
+	return []string{fmt.Sprintf("Configures %s", section)}
+}
+
+func explainItem(section string, item map[string]interface{}) string {
+	itemType := cast.ToString(item["type"])
+	path := itemType
+	if _, ok := item["path"]; ok {
+		path = cast.ToString(item["path"])
+	}
+	path = strings.TrimSuffix(path, "/") + "/"
+
+	switch section {
+	case "auth":
+		sentence := fmt.Sprintf("Enables the %s auth method at path %s", itemType, path)
+		if roles, _ := toNormalizedSliceStringMapE(item["roles"]); len(roles) > 0 {
+			sentence += fmt.Sprintf(" with the roles %s", itemNames(roles))
+		}
+		return sentence
+	case "policies":
+		policyType := cast.ToString(item["type"])
+		if policyType == "" {
+			policyType = "acl"
+		}
+		return fmt.Sprintf("Writes the %s policy %s", strings.ToUpper(policyType), cast.ToString(item["name"]))
+	case "plugins":
+		return fmt.Sprintf("Registers the %s plugin %s running %s", itemType, cast.ToString(item["plugin_name"]), cast.ToString(item["command"]))
+	case "secrets":
+		if builtinMounts[itemType] && path == itemType+"/" {
+			return fmt.Sprintf("Tunes the built-in %s mount", itemType)
+		}
+		engine := fmt.Sprintf("the %s secret engine", itemType)
+		if itemType == "kv" || itemType == "kv-v2" {
+			version := cast.ToString(cast.ToStringMap(item["options"])["version"])
+			if itemType == "kv-v2" {
+				version = "2"
+			}
+			if version == "" {
+				version = "1"
+			}
+			engine = "KV v" + version
+		}
+		sentence := fmt.Sprintf("Enables %s at path %s", engine, path)
+		if maxVersions, ok := cast.ToStringMap(item["limits"])["max_versions"]; ok {
+			sentence += fmt.Sprintf(" with max %s versions", cast.ToString(maxVersions))
+		}
+		return sentence
+	case "sysConfig":
+		return fmt.Sprintf("Writes %s %s", cast.ToString(item["path"]), explainSettings(cast.ToStringMap(item["data"])))
+	case "mountFilters":
+		paths := cast.ToStringSlice(item["paths"])
+		for _, authPath := range cast.ToStringSlice(item["auth_paths"]) {
+			paths = append(paths, "auth/"+authPath)
+		}
+		return fmt.Sprintf("Sets the %s filter of the performance secondary %s to %s (Enterprise only)", cast.ToString(item["mode"]), cast.ToString(item["secondary_id"]), strings.Join(paths, ", "))
+	case "audit":
+		return fmt.Sprintf("Enables the %s audit device at path %s", itemType, path)
+	case "startupSecrets":
+		return fmt.Sprintf("Writes the %s secret %s", itemType, cast.ToString(item["path"]))
+	case "kvOperations":
+		versions := cast.ToIntSlice(item["versions"])
+		if len(versions) == 0 {
+			return fmt.Sprintf("Applies %s on the latest version of %s", cast.ToString(item["operation"]), cast.ToString(item["path"]))
+		}
+		return fmt.Sprintf("Applies %s on the versions %v of %s", cast.ToString(item["operation"]), versions, cast.ToString(item["path"]))
+	case "verifyAccess":
+		return fmt.Sprintf("Verifies that the policy %s can read %s (only with --verify-access)", cast.ToString(item["policy"]), cast.ToString(item["path"]))
+	case "assertions":
+		switch itemType {
+		case assertionPathExists:
+			return fmt.Sprintf("Asserts that the path %s exists", cast.ToString(item["path"]))
+		case assertionSecretEngine:
+			return fmt.Sprintf("Asserts that the secret engine at path %s is enabled", cast.ToString(item["path"]))
+		case assertionAuthMethod:
+			return fmt.Sprintf("Asserts that the auth method at path %s is enabled", cast.ToString(item["path"]))
+		case assertionPolicy:
+			return fmt.Sprintf("Asserts that the policy %s is present", cast.ToString(item["name"]))
+		}
+	}
+	return fmt.Sprintf("Configures %s", itemType)
+}
+
+// itemNames lists the names of the config blocks
+func itemNames(items []map[string]interface{}) string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, cast.ToString(item["name"]))
+	}
+	return strings.Join(names, ", ")
+}
+
+// explainSettings lists the settings as key=value in the order of the keys
+func explainSettings(settings map[string]interface{}) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, settings[key]))
+	}
+	return "(" + strings.Join(pairs, ", ") + ")"
+}
@@ -0,0 +1,903 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// configureIdentity configures the identity secrets engine from the "identity" section:
+// OIDC identity token keys and roles, the entities using them, the groups and the login MFA
+func (v *vault) configureIdentity(config *viper.Viper) error {
+	if !config.IsSet("identity") {
+		return nil
+	}
+
+	identity, err := cast.ToStringMapE(normalizeConfigValue(config.Get("identity")))
+	if err != nil {
+		return fmt.Errorf("error decoding identity config: %s", err.Error())
+	}
+
+	entities, err := toNormalizedSliceStringMapE(identity["entities"])
+	if err != nil {
+		return fmt.Errorf("error finding entities block for identity: %s", err.Error())
+	}
+
+	// nothing is written until every auth mount referenced by the aliases exists
+	err = v.validateIdentityAliases(config, entities)
+	if err != nil {
+		return err
+	}
+
+	groups, err := toNormalizedSliceStringMapE(identity["groups"])
+	if err != nil {
+		return fmt.Errorf("error finding groups block for identity: %s", err.Error())
+	}
+	externalGroups, err := getOrDefaultStringMap(identity, "external_groups")
+	if err != nil {
+		return fmt.Errorf("error finding external_groups block for identity: %s", err.Error())
+	}
+	mappedGroups, err := externalGroupsFromMappings(externalGroups)
+	if err != nil {
+		return fmt.Errorf("error configuring identity external groups: %s", err.Error())
+	}
+	groups = append(groups, mappedGroups...)
+
+	// the allowlist is checked over every configured group, the policies inherited through
+	// member_group_ids included
+	allowlist, err := getOrDefaultStringMap(identity, "group_policy_allowlist")
+	if err != nil {
+		return fmt.Errorf("error finding group_policy_allowlist block for identity: %s", err.Error())
+	}
+	err = validateGroupPolicyAllowlist(groups, allowlist)
+	if err != nil {
+		return err
+	}
+
+	oidc, err := getOrDefaultStringMap(identity, "oidc")
+	if err != nil {
+		return fmt.Errorf("error finding oidc block for identity: %s", err.Error())
+	}
+	err = v.configureIdentityOIDC(oidc)
+	if err != nil {
+		return fmt.Errorf("error configuring identity oidc: %s", err.Error())
+	}
+
+	err = v.configureIdentityEntities(entities)
+	if err != nil {
+		return fmt.Errorf("error configuring identity entities: %s", err.Error())
+	}
+
+	err = v.configureIdentityGroups(groups)
+	if err != nil {
+		return fmt.Errorf("error configuring identity groups: %s", err.Error())
+	}
+
+	groupAliases, err := toNormalizedSliceStringMapE(identity["group_aliases"])
+	if err != nil {
+		return fmt.Errorf("error finding group_aliases block for identity: %s", err.Error())
+	}
+	err = v.configureIdentityGroupAliases(groupAliases)
+	if err != nil {
+		return fmt.Errorf("error configuring identity group aliases: %s", err.Error())
+	}
+
+	// the assignments refer to the entities and groups by name, so they come after them
+	oidcProvider, err := getOrDefaultStringMap(identity, "oidc_provider")
+	if err != nil {
+		return fmt.Errorf("error finding oidc_provider block for identity: %s", err.Error())
+	}
+	err = v.configureIdentityOIDCProvider(oidcProvider)
+	if err != nil {
+		return fmt.Errorf("error configuring identity oidc provider: %s", err.Error())
+	}
+
+	mfa, err := getOrDefaultStringMap(identity, "mfa")
+	if err != nil {
+		return fmt.Errorf("error finding mfa block for identity: %s", err.Error())
+	}
+	err = v.configureIdentityMFA(mfa)
+	if err != nil {
+		return fmt.Errorf("error configuring identity mfa: %s", err.Error())
+	}
+
+	return nil
+}
+
+// configureIdentityGroupAliases binds the external groups to the groups of an IdP from the
+// group_aliases of the identity section, every alias names the group, the IdP group name and the
+// auth mount. The groups have to exist already, they are configured before the aliases.
+func (v *vault) configureIdentityGroupAliases(aliases []map[string]interface{}) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	accessors, err := v.authMountAccessors()
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		groupName, err := getOrError(alias, "group")
+		if err != nil {
+			return fmt.Errorf("error getting group for group alias: %s", err.Error())
+		}
+		groupID, err := v.groupIDByName(groupName)
+		if err != nil {
+			return err
+		}
+		err = v.configureGroupAlias(groupName, groupID, alias, accessors)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configureIdentityOIDC configures Vault to issue identity tokens, roles can set the
+// "audience" of the tokens (e.g. sts.amazonaws.com) for cloud workload identity federation
+func (v *vault) configureIdentityOIDC(oidc map[string]interface{}) error {
+	// https://www.vaultproject.io/api/secret/identity/tokens.html
+	if oidcConfig, ok := oidc["config"]; ok {
+		oidcConfig, err := cast.ToStringMapE(oidcConfig)
+		if err != nil {
+			return fmt.Errorf("error converting config for oidc: %s", err.Error())
+		}
+		_, err = v.cl.Logical().Write("identity/oidc/config", oidcConfig)
+		if err != nil {
+			return fmt.Errorf("error putting oidc config into vault: %s", err.Error())
+		}
+	}
+
+	keys, err := toNormalizedSliceStringMapE(oidc["keys"])
+	if err != nil {
+		return fmt.Errorf("error finding keys block for oidc: %s", err.Error())
+	}
+	for _, key := range keys {
+		name, err := getOrError(key, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for oidc key: %s", err.Error())
+		}
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/oidc/key/%s", name), key)
+		if err != nil {
+			return fmt.Errorf("error putting %s oidc key into vault: %s", name, err.Error())
+		}
+	}
+
+	roles, err := toNormalizedSliceStringMapE(oidc["roles"])
+	if err != nil {
+		return fmt.Errorf("error finding roles block for oidc: %s", err.Error())
+	}
+	for _, role := range roles {
+		name, err := getOrError(role, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for oidc role: %s", err.Error())
+		}
+
+		data := copyWithout(role, "audience")
+		// the aud claim of the issued tokens is the client_id of the role
+		if audience, ok := role["audience"]; ok {
+			data["client_id"] = audience
+		}
+
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/oidc/role/%s", name), data)
+		if err != nil {
+			return fmt.Errorf("error putting %s oidc role into vault: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// The modes of the custom_metadata of the entity aliases
+const (
+	aliasCustomMetadataReplace = "replace"
+	aliasCustomMetadataMerge   = "merge"
+)
+
+// configureIdentityEntities creates or updates the entities by name, the oidc roles listed
+// in "oidc_roles" are granted to the entity through a generated policy. If one of the "aliases"
+// of an entity (auth mount + name) already belongs to an entity, that entity gets reused instead
+// of creating a duplicate one. The custom_metadata of existing aliases is replaced, or merged with
+// the keys already set in Vault if the custom_metadata_mode of the alias is "merge".
+func (v *vault) configureIdentityEntities(entities []map[string]interface{}) error {
+	// the auth mount accessors are only listed if there are aliases
+	var accessors map[string]string
+
+	for _, entity := range entities {
+		name, err := getOrError(entity, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for entity: %s", err.Error())
+		}
+
+		policies, err := getOrDefaultStringSlice(entity, "policies")
+		if err != nil {
+			return fmt.Errorf("error getting policies for entity %s: %s", name, err.Error())
+		}
+
+		oidcRoles, err := getOrDefaultStringSlice(entity, "oidc_roles")
+		if err != nil {
+			return fmt.Errorf("error getting oidc_roles for entity %s: %s", name, err.Error())
+		}
+		if len(oidcRoles) > 0 {
+			policyName := fmt.Sprintf("identity-token-%s", name)
+			err = v.cl.Sys().PutPolicy(policyName, identityTokenPolicy(oidcRoles))
+			if err != nil {
+				return fmt.Errorf("error putting %s policy into vault: %s", policyName, err.Error())
+			}
+			policies = append(policies, policyName)
+		}
+
+		aliases, err := toNormalizedSliceStringMapE(entity["aliases"])
+		if err != nil {
+			return fmt.Errorf("error finding aliases block for entity %s: %s", name, err.Error())
+		}
+
+		// resolve the entity by its aliases first
+		entityID := ""
+		missingAliases := []map[string]interface{}{}
+		for _, alias := range aliases {
+			aliasName, err := getOrError(alias, "name")
+			if err != nil {
+				return fmt.Errorf("error getting name for alias of entity %s: %s", name, err.Error())
+			}
+			mount, err := getOrError(alias, "mount")
+			if err != nil {
+				return fmt.Errorf("error getting mount for alias %s of entity %s: %s", aliasName, name, err.Error())
+			}
+			if accessors == nil {
+				accessors, err = v.authMountAccessors()
+				if err != nil {
+					return err
+				}
+			}
+			accessor, ok := accessors[strings.TrimSuffix(mount, "/")+"/"]
+			if !ok {
+				return fmt.Errorf("auth mount %s of alias %s of entity %s doesn't exist", mount, aliasName, name)
+			}
+
+			customMetadata, err := getOrDefaultStringMapString(alias, "custom_metadata")
+			if err != nil {
+				return fmt.Errorf("error getting custom_metadata for alias %s of entity %s: %s", aliasName, name, err.Error())
+			}
+			customMetadataMode, err := getOrDefaultString(alias, "custom_metadata_mode")
+			if err != nil {
+				return fmt.Errorf("error getting custom_metadata_mode for alias %s of entity %s: %s", aliasName, name, err.Error())
+			}
+			switch customMetadataMode {
+			case "", aliasCustomMetadataReplace, aliasCustomMetadataMerge:
+			default:
+				return fmt.Errorf("unknown custom_metadata_mode %q for alias %s of entity %s, expected %s or %s", customMetadataMode, aliasName, name, aliasCustomMetadataReplace, aliasCustomMetadataMerge)
+			}
+
+			aliasEntityID, existingAlias, err := v.lookupEntityByAlias(aliasName, accessor)
+			if err != nil {
+				return err
+			}
+			if aliasEntityID == "" {
+				missingAlias := map[string]interface{}{"name": aliasName, "mount_accessor": accessor}
+				if len(customMetadata) > 0 {
+					missingAlias["custom_metadata"] = customMetadata
+				}
+				missingAliases = append(missingAliases, missingAlias)
+				continue
+			}
+			if entityID == "" {
+				entityID = aliasEntityID
+			}
+			if _, ok := alias["custom_metadata"]; ok {
+				err = v.updateEntityAliasCustomMetadata(aliasEntityID, existingAlias, customMetadata, customMetadataMode == aliasCustomMetadataMerge)
+				if err != nil {
+					return fmt.Errorf("error updating alias %s of entity %s: %s", aliasName, name, err.Error())
+				}
+			}
+		}
+
+		data := copyWithout(entity, "oidc_roles", "aliases")
+		data["policies"] = policies
+		if len(v.config.ManagedMetadata) > 0 {
+			data["metadata"], err = v.withManagedMetadata(entity["metadata"])
+			if err != nil {
+				return fmt.Errorf("error getting metadata for entity %s: %s", name, err.Error())
+			}
+		}
+
+		if entityID != "" {
+			logrus.Debugf("entity %s found by alias, updating %s", name, entityID)
+			_, err = v.cl.Logical().Write(fmt.Sprintf("identity/entity/id/%s", entityID), data)
+		} else {
+			// https://www.vaultproject.io/api/secret/identity/entity.html#create-update-entity-by-name
+			_, err = v.cl.Logical().Write(fmt.Sprintf("identity/entity/name/%s", name), copyWithout(data, "name"))
+		}
+		if err != nil {
+			return fmt.Errorf("error putting %s entity into vault: %s", name, err.Error())
+		}
+
+		if len(missingAliases) == 0 {
+			continue
+		}
+
+		if entityID == "" {
+			entityID, err = v.entityIDByName(name)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, alias := range missingAliases {
+			alias["canonical_id"] = entityID
+			_, err = v.cl.Logical().Write("identity/entity-alias", alias)
+			if err != nil {
+				return fmt.Errorf("error creating alias %s for entity %s: %s", alias["name"], name, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// externalGroupsFromMappings returns the external groups of the "external_groups" block, which maps
+// the group names of an IdP (as returned by the auth method at "mount") to Vault policies, every
+// IdP group becomes an external group of the same name with an alias of the IdP group name
+func externalGroupsFromMappings(externalGroups map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(externalGroups) == 0 {
+		return nil, nil
+	}
+
+	mount, err := getOrError(externalGroups, "mount")
+	if err != nil {
+		return nil, fmt.Errorf("error getting mount for external groups: %s", err.Error())
+	}
+	mappings, err := getOrDefaultStringMap(externalGroups, "mappings")
+	if err != nil {
+		return nil, fmt.Errorf("error getting mappings for external groups: %s", err.Error())
+	}
+
+	names := make([]string, 0, len(mappings))
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		policies, err := cast.ToStringSliceE(mappings[name])
+		if err != nil {
+			return nil, fmt.Errorf("error getting policies of external group %s: %s", name, err.Error())
+		}
+		groups = append(groups, map[string]interface{}{
+			"name":     name,
+			"type":     "external",
+			"policies": policies,
+			"alias":    map[string]interface{}{"name": name, "mount": mount},
+		})
+	}
+	return groups, nil
+}
+
+// configureIdentityGroups creates or updates the groups by name, "member_group_ids" lists the
+// names of the member groups, which are created before the groups containing them. External
+// groups can have an "alias" (name and auth mount) binding them to a group of the IdP.
+func (v *vault) configureIdentityGroups(groups []map[string]interface{}) error {
+	ordered, err := orderIdentityGroups(groups)
+	if err != nil {
+		return err
+	}
+
+	groupIDs := map[string]string{}
+	var accessors map[string]string
+
+	for _, group := range ordered {
+		name, _ := getOrError(group, "name")
+
+		memberGroups, err := getOrDefaultStringSlice(group, "member_group_ids")
+		if err != nil {
+			return fmt.Errorf("error getting member_group_ids for group %s: %s", name, err.Error())
+		}
+
+		alias, err := getOrDefaultStringMap(group, "alias")
+		if err != nil {
+			return fmt.Errorf("error getting alias for group %s: %s", name, err.Error())
+		}
+		if len(alias) > 0 && cast.ToString(group["type"]) != "external" {
+			return fmt.Errorf("only external groups can have an alias, group %s isn't external", name)
+		}
+
+		data := copyWithout(group, "name", "alias")
+		if len(v.config.ManagedMetadata) > 0 {
+			data["metadata"], err = v.withManagedMetadata(group["metadata"])
+			if err != nil {
+				return fmt.Errorf("error getting metadata for group %s: %s", name, err.Error())
+			}
+		}
+		if len(memberGroups) > 0 {
+			memberGroupIDs := []string{}
+			for _, memberGroup := range memberGroups {
+				memberGroupID, ok := groupIDs[memberGroup]
+				if !ok {
+					// the member group is not part of the configuration but may exist already
+					memberGroupID, err = v.groupIDByName(memberGroup)
+					if err != nil {
+						return fmt.Errorf("error resolving member group %s of group %s: %s", memberGroup, name, err.Error())
+					}
+				}
+				memberGroupIDs = append(memberGroupIDs, memberGroupID)
+			}
+			data["member_group_ids"] = memberGroupIDs
+		}
+
+		// https://www.vaultproject.io/api/secret/identity/group.html#create-update-group-by-name
+		secret, err := v.cl.Logical().Write(fmt.Sprintf("identity/group/name/%s", name), data)
+		if err != nil {
+			return fmt.Errorf("error putting %s group into vault: %s", name, err.Error())
+		}
+
+		// the ID is only returned when the group gets created
+		groupID := ""
+		if secret != nil && secret.Data != nil {
+			groupID, err = getOrDefaultString(secret.Data, "id")
+			if err != nil {
+				return fmt.Errorf("error getting id of group %s: %s", name, err.Error())
+			}
+		}
+		if groupID == "" {
+			groupID, err = v.groupIDByName(name)
+			if err != nil {
+				return err
+			}
+		}
+		groupIDs[name] = groupID
+
+		if len(alias) > 0 {
+			if accessors == nil {
+				accessors, err = v.authMountAccessors()
+				if err != nil {
+					return err
+				}
+			}
+			err = v.configureGroupAlias(name, groupID, alias, accessors)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// configureGroupAlias creates the alias of an external group, or updates it if its name or mount changed
+func (v *vault) configureGroupAlias(groupName, groupID string, alias map[string]interface{}, accessors map[string]string) error {
+	aliasName, err := getOrError(alias, "name")
+	if err != nil {
+		return fmt.Errorf("error getting name for alias of group %s: %s", groupName, err.Error())
+	}
+	mount, err := getOrError(alias, "mount")
+	if err != nil {
+		return fmt.Errorf("error getting mount for alias %s of group %s: %s", aliasName, groupName, err.Error())
+	}
+	accessor, ok := accessors[strings.TrimSuffix(mount, "/")+"/"]
+	if !ok {
+		return fmt.Errorf("auth mount %s of alias %s of group %s doesn't exist", mount, aliasName, groupName)
+	}
+
+	secret, err := v.cl.Logical().Read(fmt.Sprintf("identity/group/id/%s", groupID))
+	if err != nil {
+		return fmt.Errorf("error reading group %s: %s", groupName, err.Error())
+	}
+	aliasPath := "identity/group-alias"
+	if secret != nil && secret.Data != nil {
+		existing := cast.ToStringMap(secret.Data["alias"])
+		if cast.ToString(existing["name"]) == aliasName && cast.ToString(existing["mount_accessor"]) == accessor {
+			return nil
+		}
+		if existingID := cast.ToString(existing["id"]); existingID != "" {
+			aliasPath = fmt.Sprintf("identity/group-alias/id/%s", existingID)
+		}
+	}
+
+	// https://www.vaultproject.io/api/secret/identity/group-alias.html
+	_, err = v.cl.Logical().Write(aliasPath, map[string]interface{}{
+		"name":           aliasName,
+		"mount_accessor": accessor,
+		"canonical_id":   groupID,
+	})
+	if err != nil {
+		return fmt.Errorf("error writing alias %s of group %s: %s", aliasName, groupName, err.Error())
+	}
+	return nil
+}
+
+// orderIdentityGroups orders the groups so that the member groups come before the groups containing them
+func orderIdentityGroups(groups []map[string]interface{}) ([]map[string]interface{}, error) {
+	byName := map[string]map[string]interface{}{}
+	var names []string
+	for _, group := range groups {
+		name, err := getOrError(group, "name")
+		if err != nil {
+			return nil, fmt.Errorf("error getting name for group: %s", err.Error())
+		}
+		byName[name] = group
+		names = append(names, name)
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	ordered := make([]map[string]interface{}, 0, len(groups))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		group, ok := byName[name]
+		if !ok || state[name] == visited {
+			return nil
+		}
+		if state[name] == visiting {
+			return fmt.Errorf("group %s is a member of itself through member_group_ids", name)
+		}
+		state[name] = visiting
+
+		memberGroups, err := getOrDefaultStringSlice(group, "member_group_ids")
+		if err != nil {
+			return fmt.Errorf("error getting member_group_ids for group %s: %s", name, err.Error())
+		}
+		for _, memberGroup := range memberGroups {
+			if err := visit(memberGroup); err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+		ordered = append(ordered, group)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// validateGroupPolicyAllowlist checks the effective policies of the groups against the
+// "policies" of the allowlist. The policies of a group apply to its member groups too, so the
+// effective policies of a group are its own and the effective policies of every group listing
+// it in member_group_ids. The violations are logged as warnings, or fail the identity section
+// before anything is written if "fail" is set.
+func validateGroupPolicyAllowlist(groups []map[string]interface{}, allowlist map[string]interface{}) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	allowedPolicies, err := getOrDefaultStringSlice(allowlist, "policies")
+	if err != nil {
+		return fmt.Errorf("error getting policies for group_policy_allowlist: %s", err.Error())
+	}
+	fail, err := cast.ToBoolE(allowlist["fail"])
+	if err != nil {
+		return fmt.Errorf("error getting fail for group_policy_allowlist: %s", err.Error())
+	}
+	allowed := map[string]bool{}
+	for _, policy := range allowedPolicies {
+		allowed[policy] = true
+	}
+
+	effective, err := effectiveGroupPolicies(groups)
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	for _, group := range groups {
+		name, _ := getOrError(group, "name")
+		var denied []string
+		for _, policy := range effective[name] {
+			if !allowed[policy] {
+				denied = append(denied, policy)
+			}
+		}
+		if len(denied) > 0 {
+			violations = append(violations, fmt.Sprintf("group %s has policies outside of the allowlist: %s", name, strings.Join(denied, ", ")))
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	if fail {
+		return fmt.Errorf("error validating group_policy_allowlist: %s", strings.Join(violations, "; "))
+	}
+	for _, violation := range violations {
+		logrus.Warnf("%s", violation)
+	}
+	return nil
+}
+
+// effectiveGroupPolicies returns the sorted own and inherited policies of the groups by name,
+// only the groups of the configuration are taken into account
+func effectiveGroupPolicies(groups []map[string]interface{}) (map[string][]string, error) {
+	// ordering the groups detects the membership cycles
+	_, err := orderIdentityGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := map[string][]string{}
+	parents := map[string][]string{}
+	for _, group := range groups {
+		name, _ := getOrError(group, "name")
+		policies[name], err = getOrDefaultStringSlice(group, "policies")
+		if err != nil {
+			return nil, fmt.Errorf("error getting policies for group %s: %s", name, err.Error())
+		}
+		memberGroups, err := getOrDefaultStringSlice(group, "member_group_ids")
+		if err != nil {
+			return nil, fmt.Errorf("error getting member_group_ids for group %s: %s", name, err.Error())
+		}
+		for _, memberGroup := range memberGroups {
+			parents[memberGroup] = append(parents[memberGroup], name)
+		}
+	}
+
+	effective := map[string][]string{}
+	var collect func(name string, seen map[string]bool)
+	collect = func(name string, seen map[string]bool) {
+		for _, policy := range policies[name] {
+			seen[policy] = true
+		}
+		for _, parent := range parents[name] {
+			collect(parent, seen)
+		}
+	}
+	for name := range policies {
+		seen := map[string]bool{}
+		collect(name, seen)
+		for policy := range seen {
+			effective[name] = append(effective[name], policy)
+		}
+		sort.Strings(effective[name])
+	}
+	return effective, nil
+}
+
+// groupIDByName returns the ID of the named group
+func (v *vault) groupIDByName(name string) (string, error) {
+	secret, err := v.cl.Logical().Read(fmt.Sprintf("identity/group/name/%s", name))
+	if err != nil {
+		return "", fmt.Errorf("error reading group %s: %s", name, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("group %s doesn't exist", name)
+	}
+	return getOrError(secret.Data, "id")
+}
+
+// validateIdentityAliases checks that the auth mount of every entity alias exists in Vault,
+// the identity section runs after the auth section, so the mounts configured there are present
+// unless configuring them failed
+func (v *vault) validateIdentityAliases(config *viper.Viper, entities []map[string]interface{}) error {
+	mounts := []string{}
+	referencedBy := map[string][]string{}
+	for _, entity := range entities {
+		name, err := getOrError(entity, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for entity: %s", err.Error())
+		}
+		aliases, err := toNormalizedSliceStringMapE(entity["aliases"])
+		if err != nil {
+			return fmt.Errorf("error finding aliases block for entity %s: %s", name, err.Error())
+		}
+		for _, alias := range aliases {
+			mount, err := getOrError(alias, "mount")
+			if err != nil {
+				return fmt.Errorf("error getting mount for alias of entity %s: %s", name, err.Error())
+			}
+			mount = strings.TrimSuffix(mount, "/") + "/"
+			if _, ok := referencedBy[mount]; !ok {
+				mounts = append(mounts, mount)
+			}
+			referencedBy[mount] = append(referencedBy[mount], name)
+		}
+	}
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	accessors, err := v.authMountAccessors()
+	if err != nil {
+		return err
+	}
+
+	configured := map[string]bool{}
+	authMethods := []map[string]interface{}{}
+	err = config.UnmarshalKey("auth", &authMethods)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling vault auth methods config: %s", err.Error())
+	}
+	for _, authMethod := range authMethods {
+		path := cast.ToString(authMethod["type"])
+		if pathOverwrite, ok := authMethod["path"]; ok {
+			path = cast.ToString(pathOverwrite)
+		}
+		configured[strings.TrimSuffix(path, "/")+"/"] = true
+	}
+
+	missing := []string{}
+	for _, mount := range mounts {
+		if _, ok := accessors[mount]; ok {
+			continue
+		}
+		reason := "it is not configured in the auth section"
+		if configured[mount] {
+			reason = "configuring it in the auth section failed"
+		}
+		missing = append(missing, fmt.Sprintf("%s (referenced by the aliases of %s, %s)", mount, strings.Join(referencedBy[mount], ", "), reason))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("error validating identity aliases, auth mounts don't exist: %s", strings.Join(missing, "; "))
+	}
+
+	return nil
+}
+
+// deleteIdentityAliases deletes the entity and group aliases bound to the auth mount accessor
+func (v *vault) deleteIdentityAliases(accessor string) error {
+	for _, kind := range []string{"entity-alias", "group-alias"} {
+		secret, err := v.cl.Logical().List(fmt.Sprintf("identity/%s/id", kind))
+		if err != nil {
+			return fmt.Errorf("error listing %ses: %s", kind, err.Error())
+		}
+		if secret == nil || secret.Data == nil {
+			continue
+		}
+
+		keyInfo, err := getOrDefaultStringMap(secret.Data, "key_info")
+		if err != nil {
+			return fmt.Errorf("error parsing %ses: %s", kind, err.Error())
+		}
+		for id, info := range keyInfo {
+			alias, err := cast.ToStringMapE(info)
+			if err != nil {
+				return fmt.Errorf("error parsing %s %s: %s", kind, id, err.Error())
+			}
+			if cast.ToString(alias["mount_accessor"]) != accessor {
+				continue
+			}
+
+			logrus.Infof("deleting %s %s (%s) of auth mount accessor %s", kind, alias["name"], id, accessor)
+			_, err = v.cl.Logical().Delete(fmt.Sprintf("identity/%s/id/%s", kind, id))
+			if err != nil {
+				return fmt.Errorf("error deleting %s %s: %s", kind, id, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// authMountAccessors returns the accessors of the auth mounts by path (with trailing slash)
+func (v *vault) authMountAccessors() (map[string]string, error) {
+	auths, err := v.cl.Sys().ListAuth()
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth backends vault: %s", err.Error())
+	}
+
+	accessors := make(map[string]string, len(auths))
+	for path, auth := range auths {
+		accessors[path] = auth.Accessor
+	}
+	return accessors, nil
+}
+
+// lookupEntityByAlias returns the ID of the entity owning the alias, or an empty string,
+// and the alias itself as listed in the aliases of the entity
+func (v *vault) lookupEntityByAlias(aliasName, mountAccessor string) (string, map[string]interface{}, error) {
+	secret, err := v.cl.Logical().Write("identity/lookup/entity", map[string]interface{}{
+		"alias_name":           aliasName,
+		"alias_mount_accessor": mountAccessor,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("error looking up entity by alias %s: %s", aliasName, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return "", nil, nil
+	}
+	entityID, err := getOrDefaultString(secret.Data, "id")
+	if err != nil {
+		return "", nil, err
+	}
+
+	aliases, _ := toNormalizedSliceStringMapE(secret.Data["aliases"])
+	for _, alias := range aliases {
+		if cast.ToString(alias["name"]) == aliasName && cast.ToString(alias["mount_accessor"]) == mountAccessor {
+			return entityID, alias, nil
+		}
+	}
+	return entityID, nil, nil
+}
+
+// updateEntityAliasCustomMetadata sets the custom_metadata of an existing entity alias, in merge mode
+// the keys only present in Vault are kept, otherwise the custom_metadata is replaced. Nothing is
+// written if the custom_metadata is already the expected one.
+func (v *vault) updateEntityAliasCustomMetadata(entityID string, alias map[string]interface{}, customMetadata map[string]string, merge bool) error {
+	aliasID := cast.ToString(alias["id"])
+	if aliasID == "" {
+		return fmt.Errorf("the ID of the alias wasn't returned by the entity lookup")
+	}
+
+	existing := cast.ToStringMapString(alias["custom_metadata"])
+	expected := map[string]string{}
+	if merge {
+		for key, value := range existing {
+			expected[key] = value
+		}
+	}
+	for key, value := range customMetadata {
+		expected[key] = value
+	}
+	if reflect.DeepEqual(existing, expected) {
+		return nil
+	}
+
+	// https://www.vaultproject.io/api/secret/identity/entity-alias.html#update-entity-alias-by-id
+	_, err := v.cl.Logical().Write(fmt.Sprintf("identity/entity-alias/id/%s", aliasID), map[string]interface{}{
+		"name":            alias["name"],
+		"mount_accessor":  alias["mount_accessor"],
+		"canonical_id":    entityID,
+		"custom_metadata": expected,
+	})
+	return err
+}
+
+// entityIDByName returns the ID of the named entity
+func (v *vault) entityIDByName(name string) (string, error) {
+	secret, err := v.cl.Logical().Read(fmt.Sprintf("identity/entity/name/%s", name))
+	if err != nil {
+		return "", fmt.Errorf("error reading entity %s: %s", name, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("entity %s doesn't exist", name)
+	}
+	return getOrError(secret.Data, "id")
+}
+
+// identityTokenPolicy grants the right to generate identity tokens with the given oidc roles
+func identityTokenPolicy(roles []string) string {
+	var rules []string
+	for _, role := range roles {
+		rules = append(rules, fmt.Sprintf("path \"identity/oidc/token/%s\" {\n  capabilities = [\"read\"]\n}", role))
+	}
+	return strings.Join(rules, "\n")
+}
+
+// copyWithout returns a shallow copy of m without the given keys
+func copyWithout(m map[string]interface{}, keys ...string) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	for _, key := range keys {
+		delete(result, key)
+	}
+	return result
+}
@@ -0,0 +1,128 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// configureIdentityPurge deletes the entities and groups listed by name in the "identityPurge"
+// section (e.g. for offboarding), after the identity section is applied. Without the purge being
+// confirmed in the Config only the identities which would be deleted are logged.
+func (v *vault) configureIdentityPurge(config *viper.Viper) error {
+	if !config.IsSet("identityPurge") {
+		return nil
+	}
+
+	purge, err := cast.ToStringMapE(normalizeConfigValue(config.Get("identityPurge")))
+	if err != nil {
+		return fmt.Errorf("error decoding identityPurge config: %s", err.Error())
+	}
+
+	entities, err := getOrDefaultStringSlice(purge, "entities")
+	if err != nil {
+		return fmt.Errorf("error getting entities for identityPurge: %s", err.Error())
+	}
+	groups, err := getOrDefaultStringSlice(purge, "groups")
+	if err != nil {
+		return fmt.Errorf("error getting groups for identityPurge: %s", err.Error())
+	}
+
+	// purging an identity which is configured as well would recreate and delete it on every run
+	configured, err := configuredIdentityNames(config)
+	if err != nil {
+		return err
+	}
+	for _, name := range entities {
+		if configured["entities"][name] {
+			return fmt.Errorf("entity %s is both configured and purged", name)
+		}
+	}
+	for _, name := range groups {
+		if configured["groups"][name] {
+			return fmt.Errorf("group %s is both configured and purged", name)
+		}
+	}
+
+	for _, name := range entities {
+		err = v.purgeIdentity("entity", name)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range groups {
+		err = v.purgeIdentity("group", name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeIdentity deletes the named entity or group by its ID, it is a no-op if it doesn't exist
+func (v *vault) purgeIdentity(kind, name string) error {
+	secret, err := v.cl.Logical().Read(fmt.Sprintf("identity/%s/name/%s", kind, name))
+	if err != nil {
+		return fmt.Errorf("error reading %s %s: %s", kind, name, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		logrus.Debugf("%s %s doesn't exist, nothing to purge", kind, name)
+		return nil
+	}
+	id, err := getOrError(secret.Data, "id")
+	if err != nil {
+		return fmt.Errorf("error getting id of %s %s: %s", kind, name, err.Error())
+	}
+
+	if !v.config.ConfirmIdentityPurge {
+		logrus.Warnf("%s %s (%s) would be purged, the purge has to be confirmed", kind, name, id)
+		return nil
+	}
+
+	logrus.Infof("purging %s %s (%s)", kind, name, id)
+	_, err = v.cl.Logical().Delete(fmt.Sprintf("identity/%s/id/%s", kind, id))
+	if err != nil {
+		return fmt.Errorf("error deleting %s %s: %s", kind, name, err.Error())
+	}
+	return nil
+}
+
+// configuredIdentityNames returns the names of the entities and groups of the identity section
+func configuredIdentityNames(config *viper.Viper) (map[string]map[string]bool, error) {
+	names := map[string]map[string]bool{"entities": {}, "groups": {}}
+	if !config.IsSet("identity") {
+		return names, nil
+	}
+
+	identity, err := cast.ToStringMapE(normalizeConfigValue(config.Get("identity")))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding identity config: %s", err.Error())
+	}
+	for kind := range names {
+		items, err := toNormalizedSliceStringMapE(identity[kind])
+		if err != nil {
+			return nil, fmt.Errorf("error finding %s block for identity: %s", kind, err.Error())
+		}
+		for _, item := range items {
+			names[kind][cast.ToString(item["name"])] = true
+		}
+	}
+	return names, nil
+}
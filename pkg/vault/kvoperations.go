@@ -0,0 +1,82 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// The operations of the "kvOperations" section
+const (
+	kvOperationDelete   = "delete"
+	kvOperationUndelete = "undelete"
+	kvOperationDestroy  = "destroy"
+)
+
+// configureKVOperations applies the operations of the "kvOperations" section on the versions of KV
+// version 2 secrets after the startup secrets are written: delete (soft, the latest version without
+// versions), undelete and destroy (permanently). The operations are idempotent in Vault.
+func (v *vault) configureKVOperations(config *viper.Viper) error {
+	operations, err := toNormalizedSliceStringMapE(config.Get("kvOperations"))
+	if err != nil {
+		return fmt.Errorf("error decoding kv operations config: %s", err.Error())
+	}
+
+	for _, operation := range operations {
+		operationType, err := getOrError(operation, "operation")
+		if err != nil {
+			return fmt.Errorf("error getting operation for kv operation: %s", err.Error())
+		}
+		path, err := getOrError(operation, "path")
+		if err != nil {
+			return fmt.Errorf("error getting path for %s kv operation: %s", operationType, err.Error())
+		}
+		versions, err := cast.ToIntSliceE(operation["versions"])
+		if _, ok := operation["versions"]; ok && err != nil {
+			return fmt.Errorf("error converting versions of %s kv operation on %s: %s", operationType, path, err.Error())
+		}
+
+		switch operationType {
+		case kvOperationDelete, kvOperationUndelete, kvOperationDestroy:
+		default:
+			return fmt.Errorf("unsupported kv operation %s on %s, supported operations: %s, %s, %s",
+				operationType, path, kvOperationDelete, kvOperationUndelete, kvOperationDestroy)
+		}
+		if len(versions) == 0 && operationType != kvOperationDelete {
+			return fmt.Errorf("the %s kv operation on %s needs the versions", operationType, path)
+		}
+
+		mountPath, key, err := v.kvV2MountAndKey(path, operationType)
+		if err != nil {
+			return fmt.Errorf("error applying %s kv operation on %s: %s", operationType, path, err.Error())
+		}
+
+		// https://www.vaultproject.io/api-docs/secret/kv/kv-v2#delete-latest-version-of-secret
+		if len(versions) == 0 {
+			_, err = v.cl.Logical().Delete(path)
+		} else {
+			_, err = v.cl.Logical().Write(mountPath+operationType+"/"+key, map[string]interface{}{"versions": versions})
+		}
+		if err != nil {
+			return fmt.Errorf("error applying %s kv operation on %s: %s", operationType, path, err.Error())
+		}
+		logrus.Infof("applied %s kv operation on %s %v", operationType, path, versions)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// mountLimit is a size or entry limit of a mount and where it is applied
+type mountLimit struct {
+	// the secret engine types supporting the limit, any if empty
+	engines []string
+	// the config path of the limit, relative to the mount or absolute if it starts with sys/
+	path func(mount string) string
+	// the field of the config path holding the limit
+	field string
+	// the extra fields of the config path
+	extra func(mount string) map[string]interface{}
+	// validates the value of the limit, any non-negative value is valid if nil
+	validate func(value int) error
+	// the new value is only applied by Vault after the mount is reloaded
+	reload bool
+}
+
+// mountLimits are the limits which can be set in the "limits" block of a secret engine: max_versions
+// of kv version 2 (written to <path>/config), cache_size of transit (written to <path>/cache-config)
+// and the max_leases lease count quota of any engine (Enterprise, written to sys/quotas/lease-count).
+// The maximum request size is a setting of the listener (max_request_size), not of the mounts.
+var mountLimits = map[string]mountLimit{
+	"max_versions": {
+		engines: []string{"kv", "kv-v2"},
+		path:    func(mount string) string { return mount + "/config" },
+		field:   "max_versions",
+	},
+	"cache_size": {
+		engines: []string{"transit"},
+		path:    func(mount string) string { return mount + "/cache-config" },
+		field:   "size",
+		validate: func(value int) error {
+			if value != 0 && value < 10 {
+				return fmt.Errorf("the cache_size of transit has to be 0 (unlimited) or at least 10, got %d", value)
+			}
+			return nil
+		},
+		reload: true,
+	},
+	"max_leases": {
+		path:  func(mount string) string { return "sys/quotas/lease-count/" + strings.Replace(mount, "/", "-", -1) },
+		field: "max_leases",
+		extra: func(mount string) map[string]interface{} { return map[string]interface{}{"path": mount + "/"} },
+	},
+}
+
+// configureMountLimits applies the "limits" block of a secret engine through the config paths of the limits,
+// every limit is validated before writing any, the limits which are set already aren't written again.
+// The "cache_size" of a transit secret engine can be set next to its keys too, as a limit.
+func (v *vault) configureMountLimits(path, secretEngineType string, secretEngine map[string]interface{}) error {
+	configured, err := getOrDefaultStringMap(secretEngine, "limits")
+	if err != nil {
+		return fmt.Errorf("error getting limits for secret engine: %s", err.Error())
+	}
+	limits := map[string]interface{}{}
+	for name, value := range configured {
+		limits[name] = value
+	}
+	if cacheSize, ok := secretEngine["cache_size"]; ok && secretEngineType == "transit" {
+		if _, ok := limits["cache_size"]; ok {
+			return fmt.Errorf("cache_size is set both in the limits and next to the keys of %s", path)
+		}
+		limits["cache_size"] = cacheSize
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := map[string]int{}
+	for _, name := range names {
+		limit, ok := mountLimits[name]
+		if !ok {
+			return fmt.Errorf("unknown limit %s, the supported limits are max_versions, cache_size and max_leases", name)
+		}
+		supported := len(limit.engines) == 0
+		for _, engine := range limit.engines {
+			supported = supported || engine == secretEngineType
+		}
+		if !supported {
+			return fmt.Errorf("limit %s is not supported by the %s secret engine, only by %s", name, secretEngineType, strings.Join(limit.engines, ", "))
+		}
+		if name == "max_versions" && secretEngineType == "kv" {
+			options, err := getOrDefaultStringMap(secretEngine, "options")
+			if err != nil {
+				return fmt.Errorf("error getting options for secret engine: %s", err.Error())
+			}
+			if cast.ToString(options["version"]) != "2" {
+				return fmt.Errorf("limit %s is only supported by version 2 of the kv secret engine", name)
+			}
+		}
+		value, err := cast.ToIntE(limits[name])
+		if err != nil || value < 0 {
+			return fmt.Errorf("error converting limit %s, it has to be a non-negative integer: %v", name, limits[name])
+		}
+		if limit.validate != nil {
+			if err := limit.validate(value); err != nil {
+				return err
+			}
+		}
+		values[name] = value
+	}
+
+	for _, name := range names {
+		limit := mountLimits[name]
+		configPath := limit.path(path)
+
+		secret, err := v.cl.Logical().Read(configPath)
+		if err != nil {
+			return fmt.Errorf("error reading limit %s from %s: %s", name, configPath, err.Error())
+		}
+		if secret != nil && secret.Data != nil {
+			// the numbers are decoded as json.Number
+			if existing, err := cast.ToIntE(fmt.Sprint(secret.Data[limit.field])); err == nil && existing == values[name] {
+				continue
+			}
+		}
+
+		data := map[string]interface{}{limit.field: values[name]}
+		if limit.extra != nil {
+			for field, value := range limit.extra(path) {
+				data[field] = value
+			}
+		}
+		if limit.reload {
+			logrus.Infof("setting the limit %s of %s to %d, it is applied after the mount is reloaded", name, path, values[name])
+		}
+		_, err = v.cl.Logical().Write(configPath, data)
+		if err != nil {
+			return fmt.Errorf("error writing limit %s to %s: %s", name, configPath, err.Error())
+		}
+	}
+
+	return nil
+}
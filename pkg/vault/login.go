@@ -0,0 +1,349 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+// AuthMethodKubernetes logs in to Vault with the service account token of the pod
+const AuthMethodKubernetes = "kubernetes"
+
+// AuthMethodAppRole logs in to Vault with a role-id and a (response-wrapped) secret-id
+const AuthMethodAppRole = "approle"
+
+// tokenExpiryMargin is how long before its expiry a cached login token is renewed by logging in again
+const tokenExpiryMargin = 10 * time.Second
+
+// withLoginToken sets the cached login token of the configured auth method on the client for
+// the duration of fn, logging in if there is no token or it is about to expire. The requests
+// denied with the cached token (e.g. it got revoked) are retried by the LoginRetryTransport.
+func (v *vault) withLoginToken(fn func() error) error {
+	token, err := v.freshLoginToken("")
+	if err != nil {
+		return err
+	}
+
+	v.cl.SetToken(token)
+	defer v.cl.SetToken("")
+
+	return fn()
+}
+
+// loginTokenExpiring returns true if the cached login token expires within the margin, the
+// tokens without a lease duration (e.g. a periodic token without a TTL) don't expire
+func (v *vault) loginTokenExpiring() bool {
+	return !v.loginTokenExpiry.IsZero() && time.Now().Add(tokenExpiryMargin).After(v.loginTokenExpiry)
+}
+
+// freshLoginToken returns the cached login token, it is renewed (or replaced by logging in again)
+// if there is none, it is about to expire or it is the denied one. There is a single login in
+// flight, the concurrent callers (e.g. the workers of a section) wait for it and get its token.
+func (v *vault) freshLoginToken(denied string) (string, error) {
+	v.loginLock.Lock()
+	defer v.loginLock.Unlock()
+
+	for v.loginInFlight != nil {
+		inFlight := v.loginInFlight
+		v.loginLock.Unlock()
+		<-inFlight
+		v.loginLock.Lock()
+	}
+	if v.loginToken != "" && v.loginToken != denied && !v.loginTokenExpiring() {
+		return v.loginToken, nil
+	}
+
+	inFlight := make(chan struct{})
+	v.loginInFlight = inFlight
+	defer func() {
+		v.loginInFlight = nil
+		close(inFlight)
+	}()
+
+	token := v.loginToken
+	renewable := v.loginTokenRenewable && token != denied
+	v.loginLock.Unlock()
+
+	var auth *api.SecretAuth
+	renewed := false
+	if renewable {
+		auth, renewed = v.renewLoginToken(token)
+	}
+	var err error
+	if !renewed {
+		if denied != "" {
+			logrus.Info("the cached vault token has been denied, logging in again...")
+		}
+		auth, err = v.login()
+	}
+
+	v.loginLock.Lock()
+	if !renewed && token != "" {
+		if v.replacedLoginTokens == nil {
+			v.replacedLoginTokens = map[string]bool{}
+		}
+		v.replacedLoginTokens[token] = true
+	}
+	if err != nil {
+		v.loginToken = ""
+		return "", err
+	}
+
+	if !renewed {
+		v.loginToken = auth.ClientToken
+	}
+	v.loginTokenRenewable = auth.Renewable
+	v.loginTokenExpiry = time.Time{}
+	if auth.LeaseDuration > 0 {
+		v.loginTokenExpiry = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second)
+	}
+	return v.loginToken, nil
+}
+
+// reloginDenied logs in again if the denied token is the cached login token and sets the new
+// token on the client, a token already replaced (e.g. by the login of a concurrent worker) is
+// not logged in again, the current token is returned. It returns false if the request can't be
+// retried.
+func (v *vault) reloginDenied(token string) (string, bool) {
+	v.loginLock.Lock()
+	cached := token != "" && (token == v.loginToken || v.replacedLoginTokens[token])
+	v.loginLock.Unlock()
+	if !cached {
+		return "", false
+	}
+
+	newToken, err := v.freshLoginToken(token)
+	if err != nil {
+		logrus.Errorf("error logging in again: %s", err.Error())
+		return "", false
+	}
+	v.cl.SetToken(newToken)
+	return newToken, true
+}
+
+// LoginRetryTransport retries the requests Vault denied with the cached token of the auth method
+// of a vault helper (as an http.RoundTripper) once, with the token of a new login, the other
+// requests of the run aren't repeated. The helpers created by New with it in their Config are
+// registered, so the clients of multiple Vault clusters can share it.
+type LoginRetryTransport struct {
+	next http.RoundTripper
+
+	mu      sync.Mutex
+	helpers []*vault
+}
+
+// NewLoginRetryTransport returns the LoginRetryTransport sending the requests with next
+func NewLoginRetryTransport(next http.RoundTripper) *LoginRetryTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &LoginRetryTransport{next: next}
+}
+
+func (t *LoginRetryTransport) register(v *vault) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.helpers = append(t.helpers, v)
+}
+
+func (t *LoginRetryTransport) relogin(token string) (string, bool) {
+	t.mu.Lock()
+	helpers := append([]*vault(nil), t.helpers...)
+	t.mu.Unlock()
+
+	for _, v := range helpers {
+		if newToken, ok := v.reloginDenied(token); ok {
+			return newToken, true
+		}
+	}
+	return "", false
+}
+
+// RoundTrip sends the request, and once more with a new token if it was denied with a login token
+func (t *LoginRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := req.Header.Get("X-Vault-Token")
+	if token == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	// the body is sent twice if the request is retried
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+
+	newToken, ok := t.relogin(token)
+	if !ok {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.WithContext(req.Context())
+	retry.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		retry.Header[key] = append([]string(nil), values...)
+	}
+	retry.Header.Set("X-Vault-Token", newToken)
+	if body != nil {
+		retry.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return t.next.RoundTrip(retry)
+}
+
+// login authenticates with the configured auth method and returns the token with its lease
+func (v *vault) login() (*api.SecretAuth, error) {
+	var data map[string]interface{}
+	switch v.config.AuthMethod {
+	case AuthMethodKubernetes:
+		jwtFile := v.config.AuthJWTFile
+		if jwtFile == "" {
+			jwtFile = serviceAccountFile
+		}
+		jwt, err := ioutil.ReadFile(jwtFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading service account token: %s", err.Error())
+		}
+		data = map[string]interface{}{"jwt": strings.TrimSpace(string(jwt)), "role": v.config.AuthRole}
+	case AuthMethodAppRole:
+		secretID, err := v.appRoleSecretID()
+		if err != nil {
+			return nil, err
+		}
+		data = map[string]interface{}{"role_id": v.config.AppRoleRoleID, "secret_id": secretID}
+	default:
+		return nil, fmt.Errorf("unsupported auth method %s", v.config.AuthMethod)
+	}
+
+	path := v.config.AuthPath
+	if path == "" {
+		path = v.config.AuthMethod
+	}
+
+	// logging in doesn't need a token
+	secret, err := v.writeWithToken("", fmt.Sprintf("auth/%s/login", path), data)
+	if err != nil {
+		return nil, fmt.Errorf("error logging in with the %s auth method: %s", v.config.AuthMethod, err.Error())
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("error logging in with the %s auth method: no token returned", v.config.AuthMethod)
+	}
+
+	role := v.config.AuthRole
+	if v.config.AuthMethod == AuthMethodAppRole {
+		role = v.config.AppRoleRoleID
+	}
+	logrus.Infof("logged in to vault with the %s auth method as role %s", v.config.AuthMethod, role)
+
+	return secret.Auth, nil
+}
+
+// renewLoginToken renews the login token, it returns false if the token has to be
+// replaced by logging in again (e.g. it reached its max TTL)
+func (v *vault) renewLoginToken(token string) (*api.SecretAuth, bool) {
+	secret, err := v.writeWithToken(token, "auth/token/renew-self", map[string]interface{}{"increment": 0})
+	if err != nil {
+		logrus.Infof("error renewing the vault token, logging in again: %s", err.Error())
+		return nil, false
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, false
+	}
+
+	// the token can't be renewed beyond its max TTL
+	ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second
+	if ttl <= tokenExpiryMargin {
+		return nil, false
+	}
+
+	logrus.Debugf("renewed the vault token of the %s auth method for %s", v.config.AuthMethod, ttl)
+	return secret.Auth, true
+}
+
+// appRoleSecretID reads the secret-id of the AppRole from its file, a response-wrapped
+// secret-id is unwrapped once and kept in memory, since the wrapping token is single use
+func (v *vault) appRoleSecretID() (string, error) {
+	if v.appRoleUnwrappedSecretID != "" {
+		return v.appRoleUnwrappedSecretID, nil
+	}
+
+	content, err := ioutil.ReadFile(v.config.AppRoleSecretIDFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading the approle secret-id: %s", err.Error())
+	}
+	secretID := strings.TrimSpace(string(content))
+
+	if !v.config.AppRoleSecretIDWrapped {
+		return secretID, nil
+	}
+
+	// the wrapping token authenticates the unwrap request itself
+	secret, err := v.writeWithToken(secretID, "sys/wrapping/unwrap", nil)
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping the approle secret-id: %s", err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("error unwrapping the approle secret-id: no secret-id returned")
+	}
+	unwrapped, ok := secret.Data["secret_id"].(string)
+	if !ok || unwrapped == "" {
+		return "", fmt.Errorf("error unwrapping the approle secret-id: no secret-id returned")
+	}
+
+	v.appRoleUnwrappedSecretID = unwrapped
+	return unwrapped, nil
+}
+
+// writeWithToken writes to the path with the token instead of the token of the client, which is
+// shared by the concurrent requests of a configuration run
+func (v *vault) writeWithToken(token, path string, data map[string]interface{}) (*api.Secret, error) {
+	r := v.cl.NewRequest("PUT", "/v1/"+path)
+	r.ClientToken = token
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	resp, err := v.cl.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return api.ParseSecret(resp.Body)
+}
+
+func isPermissionDeniedError(err error) bool {
+	return strings.Contains(err.Error(), "Code: 403")
+}
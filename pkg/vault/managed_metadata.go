@@ -0,0 +1,56 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+)
+
+// managedMetadataString renders the managed metadata stamp as sorted key=value pairs,
+// e.g. for the descriptions of the mounts
+func (v *vault) managedMetadataString() string {
+	if len(v.config.ManagedMetadata) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(v.config.ManagedMetadata))
+	for key, value := range v.config.ManagedMetadata {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// withManagedMetadata merges the managed metadata stamp into the metadata of a resource
+// (e.g. of an entity or a group), the keys set in the configuration take precedence
+func (v *vault) withManagedMetadata(metadata interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for key, value := range v.config.ManagedMetadata {
+		result[key] = value
+	}
+	if metadata != nil {
+		configured, err := cast.ToStringMapE(metadata)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range configured {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,231 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// configureIdentityMFA configures the TOTP login MFA methods of the "mfa" block of identity by
+// their name, the TOTP secrets of the listed "entities" are generated for the enrollment.
+// The login enforcements bind the methods to the logins they select.
+func (v *vault) configureIdentityMFA(mfa map[string]interface{}) error {
+	methods, err := toNormalizedSliceStringMapE(mfa["totp"])
+	if err != nil {
+		return fmt.Errorf("error finding totp block for mfa: %s", err.Error())
+	}
+	enforcements, err := toNormalizedSliceStringMapE(mfa["login_enforcements"])
+	if err != nil {
+		return fmt.Errorf("error finding login_enforcements block for mfa: %s", err.Error())
+	}
+	if len(methods) == 0 && len(enforcements) == 0 {
+		return nil
+	}
+
+	// https://www.vaultproject.io/api-docs/secret/identity/mfa/totp
+	existingMethods := map[string]string{}
+	secret, err := v.cl.Logical().List("identity/mfa/method/totp")
+	if err != nil {
+		return fmt.Errorf("error listing totp mfa methods: %s", err.Error())
+	}
+	if secret != nil && secret.Data != nil {
+		keyInfo, err := getOrDefaultStringMap(secret.Data, "key_info")
+		if err != nil {
+			return fmt.Errorf("error parsing totp mfa methods: %s", err.Error())
+		}
+		for id, info := range keyInfo {
+			existingMethods[cast.ToString(cast.ToStringMap(info)["method_name"])] = id
+		}
+	}
+
+	for _, method := range methods {
+		name, err := getOrError(method, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for totp mfa method: %s", err.Error())
+		}
+		storeEnrollment, err := getOrDefaultBool(method, "store_enrollment")
+		if err != nil {
+			return fmt.Errorf("error getting store_enrollment for totp mfa method %s: %s", name, err.Error())
+		}
+		entities, err := getOrDefaultStringSlice(method, "entities")
+		if err != nil {
+			return fmt.Errorf("error getting entities for totp mfa method %s: %s", name, err.Error())
+		}
+
+		data := copyWithout(method, "name", "entities", "store_enrollment")
+		data["method_name"] = name
+
+		methodID, exists := existingMethods[name]
+		if exists {
+			_, err = v.cl.Logical().Write(fmt.Sprintf("identity/mfa/method/totp/%s", methodID), data)
+			if err != nil {
+				return fmt.Errorf("error updating totp mfa method %s: %s", name, err.Error())
+			}
+		} else {
+			secret, err := v.cl.Logical().Write("identity/mfa/method/totp", data)
+			if err != nil {
+				return fmt.Errorf("error creating totp mfa method %s: %s", name, err.Error())
+			}
+			if secret == nil || secret.Data == nil {
+				return fmt.Errorf("error creating totp mfa method %s: no method_id in the response", name)
+			}
+			methodID = cast.ToString(secret.Data["method_id"])
+			existingMethods[name] = methodID
+		}
+
+		for _, entity := range entities {
+			err = v.generateTOTPSecret(name, methodID, entity, storeEnrollment)
+			if err != nil {
+				return fmt.Errorf("error generating totp secret of entity %s for mfa method %s: %s", entity, name, err.Error())
+			}
+		}
+	}
+
+	return v.configureMFALoginEnforcements(enforcements, existingMethods)
+}
+
+// mfaLoginEnforcementSelectors are the fields of a login enforcement selecting the logins it applies to
+var mfaLoginEnforcementSelectors = []string{"auth_method_accessors", "auth_method_types", "identity_group_ids", "identity_entity_ids"}
+
+// configureMFALoginEnforcements configures the login enforcements by name, the MFA methods can be
+// referenced by name (mfa_methods) besides their ID, the auth methods by path (auth_methods) besides
+// their accessor. An enforcement has to select the logins with at least one of the selectors.
+func (v *vault) configureMFALoginEnforcements(enforcements []map[string]interface{}, methodIDs map[string]string) error {
+	// the auth mount accessors are only listed if they are referenced by path
+	var accessors map[string]string
+
+	for _, enforcement := range enforcements {
+		name, err := getOrError(enforcement, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for mfa login enforcement: %s", err.Error())
+		}
+
+		ids, err := getOrDefaultStringSlice(enforcement, "mfa_method_ids")
+		if err != nil {
+			return fmt.Errorf("error getting mfa_method_ids for mfa login enforcement %s: %s", name, err.Error())
+		}
+		methodNames, err := getOrDefaultStringSlice(enforcement, "mfa_methods")
+		if err != nil {
+			return fmt.Errorf("error getting mfa_methods for mfa login enforcement %s: %s", name, err.Error())
+		}
+		for _, methodName := range methodNames {
+			id, ok := methodIDs[methodName]
+			if !ok {
+				return fmt.Errorf("mfa login enforcement %s references unknown mfa method %s", name, methodName)
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("mfa login enforcement %s has no mfa methods", name)
+		}
+
+		authAccessors, err := getOrDefaultStringSlice(enforcement, "auth_method_accessors")
+		if err != nil {
+			return fmt.Errorf("error getting auth_method_accessors for mfa login enforcement %s: %s", name, err.Error())
+		}
+		authPaths, err := getOrDefaultStringSlice(enforcement, "auth_methods")
+		if err != nil {
+			return fmt.Errorf("error getting auth_methods for mfa login enforcement %s: %s", name, err.Error())
+		}
+		for _, path := range authPaths {
+			if accessors == nil {
+				accessors, err = v.authMountAccessors()
+				if err != nil {
+					return fmt.Errorf("error listing auth mount accessors: %s", err.Error())
+				}
+			}
+			accessor, ok := accessors[strings.TrimSuffix(path, "/")+"/"]
+			if !ok {
+				return fmt.Errorf("mfa login enforcement %s references unknown auth method %s", name, path)
+			}
+			authAccessors = append(authAccessors, accessor)
+		}
+
+		data := copyWithout(enforcement, "name", "mfa_methods", "auth_methods")
+		data["mfa_method_ids"] = ids
+		if len(authAccessors) > 0 {
+			data["auth_method_accessors"] = authAccessors
+		}
+
+		selected := false
+		for _, selector := range mfaLoginEnforcementSelectors {
+			values, err := getOrDefaultStringSlice(data, selector)
+			if err != nil {
+				return fmt.Errorf("error getting %s for mfa login enforcement %s: %s", selector, name, err.Error())
+			}
+			selected = selected || len(values) > 0
+		}
+		if !selected {
+			return fmt.Errorf("mfa login enforcement %s has to set at least one of %s", name, strings.Join(mfaLoginEnforcementSelectors, ", "))
+		}
+
+		// https://www.vaultproject.io/api-docs/secret/identity/mfa/login-enforcement
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/mfa/login-enforcement/%s", name), data)
+		if err != nil {
+			return fmt.Errorf("error configuring mfa login enforcement %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// generateTOTPSecret generates the TOTP secret of an entity, Vault doesn't generate a new one if the
+// entity already has a secret for the method. The otpauth URL and the QR code (base64 PNG) can be
+// stored in the key store for distribution, as JSON under vault-mfa-totp-<method>-<entity>.
+func (v *vault) generateTOTPSecret(methodName, methodID, entityName string, storeEnrollment bool) error {
+	entity, err := v.cl.Logical().Read(fmt.Sprintf("identity/entity/name/%s", entityName))
+	if err != nil {
+		return fmt.Errorf("error reading entity: %s", err.Error())
+	}
+	if entity == nil || entity.Data == nil {
+		return fmt.Errorf("entity doesn't exist")
+	}
+
+	secret, err := v.cl.Logical().Write("identity/mfa/method/totp/admin-generate", map[string]interface{}{
+		"method_id": methodID,
+		"entity_id": entity.Data["id"],
+	})
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Data == nil || secret.Data["url"] == nil {
+		logrus.Debugf("entity %s already has a totp secret for mfa method %s", entityName, methodName)
+		return nil
+	}
+
+	logrus.Infof("generated the totp secret of entity %s for mfa method %s", entityName, methodName)
+	if !storeEnrollment {
+		return nil
+	}
+
+	enrollment, err := json.Marshal(map[string]interface{}{
+		"url":     secret.Data["url"],
+		"barcode": secret.Data["barcode"],
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding totp enrollment: %s", err.Error())
+	}
+	key := fmt.Sprintf("vault-mfa-totp-%s-%s", methodName, entityName)
+	err = v.keyStore.Set(key, enrollment)
+	if err != nil {
+		return fmt.Errorf("error storing totp enrollment '%s': %s", key, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+// SealTypeShamir is the seal type of the Shamir unseal key shares
+const SealTypeShamir = "shamir"
+
+// MigrateSeal performs the migrate unseal flow of a Vault which was restarted with the new seal
+// and the old seal marked as disabled: from Shamir to an auto-unseal seal type the stored unseal
+// keys are sent, and they are stored as the recovery keys afterwards, the other way around the
+// stored recovery keys are sent and stored as the unseal keys afterwards.
+func (v *vault) MigrateSeal(sealType string) error {
+	defer runtime.GC()
+
+	status, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return fmt.Errorf("error checking seal status: %s", err.Error())
+	}
+	if !status.Sealed {
+		return fmt.Errorf("vault is unsealed, it has to be restarted with the new seal to migrate")
+	}
+	if !status.Migration {
+		return fmt.Errorf("vault is not in seal migration mode, the old seal has to be configured with disabled = \"true\"")
+	}
+	if status.Type != sealType {
+		logrus.Warnf("vault reports the seal type %s instead of %s", status.Type, sealType)
+	}
+
+	sourceKey, targetKey := v.unsealKeyForID, v.recoveryKeyForID
+	if sealType == SealTypeShamir {
+		sourceKey, targetKey = v.recoveryKeyForID, v.unsealKeyForID
+	}
+
+	// every share is read before anything is sent, they are all stored under the new names afterwards
+	// the shares are stored as read, they are only decoded to be sent
+	var shares, decodedShares [][]byte
+	for i := 0; i < v.config.SecretShares; i++ {
+		key := sourceKey(i)
+		share, err := v.storeForKey(key).Get(key)
+		if err != nil {
+			if len(shares) >= v.config.SecretThreshold {
+				logrus.Warnf("unable to get key '%s', only %d shares can be migrated: %s", key, len(shares), err.Error())
+				break
+			}
+			return fmt.Errorf("unable to get key '%s': %s", key, err.Error())
+		}
+		decoded, err := v.decodeShare(key, share)
+		if err != nil {
+			return fmt.Errorf("unable to decode key '%s': %s", key, err.Error())
+		}
+		shares = append(shares, share)
+		decodedShares = append(decodedShares, decoded)
+	}
+
+	for i, share := range decodedShares {
+		logrus.Debugf("sending migrate unseal request to vault...")
+		resp, err := v.cl.Sys().UnsealWithOptions(&api.UnsealOpts{Key: string(share), Migrate: true})
+		if err != nil {
+			return fmt.Errorf("fail to send migrate unseal request to vault: %s", err.Error())
+		}
+		if !resp.Sealed {
+			break
+		}
+		if resp.Progress == 0 {
+			return fmt.Errorf("failed to migrate the seal of vault, progress reset to 0 at key '%s'", sourceKey(i))
+		}
+		if i == len(shares)-1 {
+			return fmt.Errorf("failed to migrate the seal of vault, still sealed after %d keys", len(shares))
+		}
+	}
+
+	logrus.Infof("migrated the seal of vault to %s", sealType)
+
+	for i, share := range shares {
+		key := targetKey(i)
+		if err := v.storeForKey(key).Set(key, share); err != nil {
+			return fmt.Errorf("error storing key '%s' after the seal migration: %s", key, err.Error())
+		}
+		logrus.WithField("key", key).Info("key stored in key store after the seal migration")
+	}
+
+	return nil
+}
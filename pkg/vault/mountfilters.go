@@ -0,0 +1,113 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// configureMountFilters writes the "mountFilters" entries, the performance replication paths
+// filters of the secondaries, on the primary. The paths are the secret engine mounts and the
+// auth_paths the auth method mounts (sent as auth/<path>), allowed or denied depending on the
+// mode. Replication needs Vault Enterprise, the section is skipped on other editions.
+func (v *vault) configureMountFilters(config *viper.Viper) error {
+	if !config.IsSet("mountFilters") {
+		return nil
+	}
+
+	filters, err := toNormalizedSliceStringMapE(config.Get("mountFilters"))
+	if err != nil {
+		return fmt.Errorf("error decoding mountFilters: %s", err.Error())
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+
+	enterprise, err := v.enterprise()
+	if err != nil {
+		return err
+	}
+	if !enterprise {
+		logrus.Warnf("skipping the mount filters, they need Vault Enterprise")
+		return nil
+	}
+
+	for _, filter := range filters {
+		secondaryID, err := getOrError(filter, "secondary_id")
+		if err != nil {
+			return fmt.Errorf("error getting secondary_id for mount filter: %s", err.Error())
+		}
+		mode, err := getOrDefaultString(filter, "mode")
+		if err != nil {
+			return fmt.Errorf("error getting mode for mount filter of %s: %s", secondaryID, err.Error())
+		}
+		if mode != "allow" && mode != "deny" {
+			return fmt.Errorf("error validating mode for mount filter of %s: it has to be allow or deny", secondaryID)
+		}
+		paths, err := mountFilterPaths(filter)
+		if err != nil {
+			return fmt.Errorf("error getting paths for mount filter of %s: %s", secondaryID, err.Error())
+		}
+
+		// https://developer.hashicorp.com/vault/api-docs/system/replication/replication-performance#create-paths-filter
+		filterPath := "sys/replication/performance/primary/paths-filter/" + secondaryID
+		current, err := v.cl.Logical().Read(filterPath)
+		if err != nil {
+			return fmt.Errorf("error reading mount filter of %s: %s", secondaryID, err.Error())
+		}
+		if current != nil && current.Data != nil && cast.ToString(current.Data["mode"]) == mode &&
+			sameKeys(normalizeMountFilterPaths(cast.ToStringSlice(current.Data["paths"])), paths) {
+			continue
+		}
+
+		_, err = v.cl.Logical().Write(filterPath, map[string]interface{}{"mode": mode, "paths": paths})
+		if err != nil {
+			return fmt.Errorf("error writing mount filter of %s: %s", secondaryID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// mountFilterPaths returns the secret engine and the auth method mounts of the filter as Vault
+// expects them in the paths filter
+func mountFilterPaths(filter map[string]interface{}) ([]string, error) {
+	paths, err := getOrDefaultStringSlice(filter, "paths")
+	if err != nil {
+		return nil, err
+	}
+	authPaths, err := getOrDefaultStringSlice(filter, "auth_paths")
+	if err != nil {
+		return nil, fmt.Errorf("error getting auth_paths: %s", err.Error())
+	}
+	for _, authPath := range authPaths {
+		paths = append(paths, "auth/"+strings.Trim(authPath, "/"))
+	}
+	return normalizeMountFilterPaths(paths), nil
+}
+
+// normalizeMountFilterPaths trims the slashes of the mount paths, so e.g. secret and secret/ are the same
+func normalizeMountFilterPaths(paths []string) []string {
+	normalized := make([]string, 0, len(paths))
+	for _, path := range paths {
+		normalized = append(normalized, strings.Trim(path, "/"))
+	}
+	return normalized
+}
@@ -0,0 +1,65 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// namespaceSections are the sections which can be applied inside of a namespace,
+// the others (e.g. plugins and audit devices) are only available in the root namespace
+var namespaceSections = []string{"auth", "policies", "secrets", "identity", "startupSecrets"}
+
+// ValidateInNamespace applies the configuration in a temporary (Enterprise) namespace, to catch
+// the configuration errors without touching the configured Vault. The namespace is deleted afterwards,
+// so it has to be a new one, an existing namespace is never used (nor deleted).
+func (v *vault) ValidateInNamespace(config *viper.Viper, namespace string) error {
+	return v.withRootToken(func() error {
+		// https://www.vaultproject.io/api/system/namespaces.html
+		existing, err := v.cl.Logical().Read(fmt.Sprintf("sys/namespaces/%s", namespace))
+		if err != nil {
+			return fmt.Errorf("error reading validation namespace %s: %s", namespace, err.Error())
+		}
+		if existing != nil {
+			return fmt.Errorf("validation namespace %s already exists, it would be deleted after the validation", namespace)
+		}
+
+		_, err = v.cl.Logical().Write(fmt.Sprintf("sys/namespaces/%s", namespace), nil)
+		if err != nil {
+			return fmt.Errorf("error creating validation namespace %s: %s", namespace, err.Error())
+		}
+		defer func() {
+			_, err := v.cl.Logical().Delete(fmt.Sprintf("sys/namespaces/%s", namespace))
+			if err != nil {
+				logrus.Errorf("error deleting validation namespace %s: %s", namespace, err.Error())
+			}
+		}()
+
+		cl, err := v.cl.Clone()
+		if err != nil {
+			return fmt.Errorf("error creating client for validation namespace %s: %s", namespace, err.Error())
+		}
+		cl.SetToken(v.cl.Token())
+		cl.SetNamespace(namespace)
+
+		scratch := &vault{keyStore: v.keyStore, cl: cl, config: v.config}
+
+		logrus.Infof("validating the configuration in namespace %s", namespace)
+		return scratch.configure(config, namespaceSections)
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+)
+
+// configureIdentityOIDCProvider configures Vault as an OIDC provider from the "oidc_provider" block
+// of identity: the scopes, the assignments (with the "entities" and "groups" resolved by name),
+// the clients bound to the assignments and the providers (with the "allowed_clients" resolved by name)
+func (v *vault) configureIdentityOIDCProvider(provider map[string]interface{}) error {
+	// https://www.vaultproject.io/api-docs/secret/identity/oidc-provider
+	scopes, err := toNormalizedSliceStringMapE(provider["scopes"])
+	if err != nil {
+		return fmt.Errorf("error finding scopes block for oidc provider: %s", err.Error())
+	}
+	for _, scope := range scopes {
+		name, err := getOrError(scope, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for oidc scope: %s", err.Error())
+		}
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/oidc/scope/%s", name), copyWithout(scope, "name"))
+		if err != nil {
+			return fmt.Errorf("error putting %s oidc scope into vault: %s", name, err.Error())
+		}
+	}
+
+	assignments, err := toNormalizedSliceStringMapE(provider["assignments"])
+	if err != nil {
+		return fmt.Errorf("error finding assignments block for oidc provider: %s", err.Error())
+	}
+	for _, assignment := range assignments {
+		name, err := getOrError(assignment, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for oidc assignment: %s", err.Error())
+		}
+
+		entityIDs, err := getOrDefaultStringSlice(assignment, "entity_ids")
+		if err != nil {
+			return fmt.Errorf("error getting entity_ids for oidc assignment %s: %s", name, err.Error())
+		}
+		entities, err := getOrDefaultStringSlice(assignment, "entities")
+		if err != nil {
+			return fmt.Errorf("error getting entities for oidc assignment %s: %s", name, err.Error())
+		}
+		for _, entity := range entities {
+			entityID, err := v.entityIDByName(entity)
+			if err != nil {
+				return fmt.Errorf("error resolving entity %s of oidc assignment %s: %s", entity, name, err.Error())
+			}
+			entityIDs = append(entityIDs, entityID)
+		}
+
+		groupIDs, err := getOrDefaultStringSlice(assignment, "group_ids")
+		if err != nil {
+			return fmt.Errorf("error getting group_ids for oidc assignment %s: %s", name, err.Error())
+		}
+		groups, err := getOrDefaultStringSlice(assignment, "groups")
+		if err != nil {
+			return fmt.Errorf("error getting groups for oidc assignment %s: %s", name, err.Error())
+		}
+		for _, group := range groups {
+			groupID, err := v.groupIDByName(group)
+			if err != nil {
+				return fmt.Errorf("error resolving group %s of oidc assignment %s: %s", group, name, err.Error())
+			}
+			groupIDs = append(groupIDs, groupID)
+		}
+
+		data := map[string]interface{}{
+			"entity_ids": entityIDs,
+			"group_ids":  groupIDs,
+		}
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/oidc/assignment/%s", name), data)
+		if err != nil {
+			return fmt.Errorf("error putting %s oidc assignment into vault: %s", name, err.Error())
+		}
+	}
+
+	clients, err := toNormalizedSliceStringMapE(provider["clients"])
+	if err != nil {
+		return fmt.Errorf("error finding clients block for oidc provider: %s", err.Error())
+	}
+	for _, client := range clients {
+		name, err := getOrError(client, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for oidc client: %s", err.Error())
+		}
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/oidc/client/%s", name), copyWithout(client, "name"))
+		if err != nil {
+			return fmt.Errorf("error putting %s oidc client into vault: %s", name, err.Error())
+		}
+	}
+
+	providers, err := toNormalizedSliceStringMapE(provider["providers"])
+	if err != nil {
+		return fmt.Errorf("error finding providers block for oidc provider: %s", err.Error())
+	}
+	for _, provider := range providers {
+		name, err := getOrError(provider, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for oidc provider: %s", err.Error())
+		}
+
+		allowedClients, err := getOrDefaultStringSlice(provider, "allowed_clients")
+		if err != nil {
+			return fmt.Errorf("error getting allowed_clients for oidc provider %s: %s", name, err.Error())
+		}
+
+		data := copyWithout(provider, "name", "allowed_clients")
+		if len(allowedClients) > 0 {
+			allowedClientIDs, err := getOrDefaultStringSlice(provider, "allowed_client_ids")
+			if err != nil {
+				return fmt.Errorf("error getting allowed_client_ids for oidc provider %s: %s", name, err.Error())
+			}
+			for _, allowedClient := range allowedClients {
+				clientID, err := v.oidcClientIDByName(allowedClient)
+				if err != nil {
+					return fmt.Errorf("error resolving client %s of oidc provider %s: %s", allowedClient, name, err.Error())
+				}
+				allowedClientIDs = append(allowedClientIDs, clientID)
+			}
+			data["allowed_client_ids"] = allowedClientIDs
+		}
+
+		_, err = v.cl.Logical().Write(fmt.Sprintf("identity/oidc/provider/%s", name), data)
+		if err != nil {
+			return fmt.Errorf("error putting %s oidc provider into vault: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// oidcClientIDByName returns the generated client_id of the named oidc client
+func (v *vault) oidcClientIDByName(name string) (string, error) {
+	secret, err := v.cl.Logical().Read(fmt.Sprintf("identity/oidc/client/%s", name))
+	if err != nil {
+		return "", fmt.Errorf("error reading oidc client %s: %s", name, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("oidc client %s doesn't exist", name)
+	}
+	return getOrError(secret.Data, "client_id")
+}
@@ -15,20 +15,27 @@
 package vault
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/banzaicloud/bank-vaults/pkg/kv"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/helper/consts"
-	json "github.com/json-iterator/go"
 	"github.com/mitchellh/mapstructure"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cast"
@@ -38,6 +45,14 @@ import (
 // DefaultConfigFile is the name of the default config file
 const DefaultConfigFile = "vault-config.yml"
 
+// DefaultMountDescriptionTemplate is the default template of the descriptions of the mounts
+// managed by bank-vaults, it keeps the human readable description and appends the managed marker
+const DefaultMountDescriptionTemplate = "{{ .Description }} [{{ .Marker }}]"
+
+// managedMarker is embedded into the description of every mount created or tuned by bank-vaults,
+// prune only removes mounts carrying this marker
+const managedMarker = "managed-by:bank-vaults"
+
 // secretEngineConfigNoNeedName holds the secret engine types where
 // the name shouldn't be part of the config path
 var secretEngineConfigNoNeedName = map[string]bool{
@@ -46,6 +61,7 @@ var secretEngineConfigNoNeedName = map[string]bool{
 	"azure":    true,
 	"gcp":      true,
 	"gcpkms":   true,
+	"kmip":     true,
 	"kv":       true,
 }
 
@@ -60,6 +76,93 @@ type Config struct {
 	InitRootToken string
 	// should the root token be stored in the keyStore
 	StoreRootToken bool
+
+	// template of the mount descriptions, it is rendered with .Description, .Path, .Type, .Marker and .Metadata
+	MountDescriptionTemplate string
+	// metadata stamped on the managed resources for inventory tooling (e.g. the source of the
+	// configuration): in the descriptions of the mounts, the metadata of the entities and groups
+	// and the custom_metadata of the KV version 2 startup secrets
+	ManagedMetadata map[string]string
+	// should the managed secret engines which are not present in the configuration be unmounted
+	PruneSecrets bool
+	// should the built-in default policy be allowed to be overwritten from the configuration
+	AllowDefaultPolicyEdit bool
+	// should the ACL policies be staged under temporary names first and swapped in all-or-nothing
+	AtomicPolicies bool
+	// should the ACL templating directives of the policies be validated before they are written
+	ValidatePolicyTemplates bool
+	// should the configuration be refused if it uses deprecated auth methods or secret engines
+	FailOnDeprecated bool
+	// delete the identities listed in the identityPurge section, otherwise they are only logged
+	ConfirmIdentityPurge bool
+	// read the paths of the verifyAccess section with tokens of the policies, otherwise they are skipped
+	VerifyAccess bool
+	// apply the audit devices before the startup secrets (so their writes are audited) instead of last
+	EarlyAudit bool
+	// apply the sections after a failed one nevertheless, otherwise they are skipped, since they may
+	// depend on it (e.g. the roles of the policies of a failed policies section)
+	ContinueOnSectionFailure bool
+	// log in with this auth method (kubernetes or approle) instead of using the root token from the key store
+	AuthMethod string
+	// the role, the mount path (defaults to the auth method) and the JWT file (defaults to
+	// the service account token of the pod) of the auth method
+	AuthRole    string
+	AuthPath    string
+	AuthJWTFile string
+	// the transport of the client, it retries the requests denied with the cached token of the
+	// auth method once, with the token of a new login
+	LoginRetryTransport *LoginRetryTransport
+	// the role-id and the file of the secret-id of the approle auth method, the secret-id
+	// is unwrapped first if it is a response-wrapping token
+	AppRoleRoleID          string
+	AppRoleSecretIDFile    string
+	AppRoleSecretIDWrapped bool
+	// use this token instead of the root token from the key store (or logging in)
+	Token string
+	// revoke the root token from the key store after the first successful configuration, it is
+	// replaced by a periodic token of the policy the configuration requires
+	AutoRevokeRootToken bool
+	// the period of the token replacing the root token, it is renewed whenever it is used
+	ConfigurerTokenPeriod time.Duration
+	// the rules of all the configurations applied with the token replacing the root token (e.g. of
+	// every config file), they are granted by its policy besides the ones of the first configuration
+	ConfigurerPolicy PolicyRules
+	// should the managed auth methods which are not present in the configuration be disabled
+	PruneAuth bool
+	// should the leases of a mount be revoked when it is disabled by the pruning
+	RevokeLeasesOnDisable bool
+	// maximum number of Vault API calls per second during the configuration (0 means unlimited)
+	ConfigureRateLimit float64
+	// traces the configuration runs and their sections if set
+	Tracer Tracer
+	// the configuration runs stop waiting (e.g. before retrying a write) when it is cancelled
+	Context context.Context
+	// the key stores of specific unseal key shares by share index, e.g. so that no single
+	// backend holds a threshold of them, the other keys are stored in the key store
+	ShareStores map[int]kv.Service
+	// transforms the stored unseal (and recovery) key shares before they are submitted to Vault,
+	// the shares are submitted as stored if it is nil
+	ShareDecoder ShareDecoder
+	// after this many consecutive failures of a configuration section its circuit breaker opens and
+	// the section is skipped for the cooldown, so it doesn't use up the retries (0 disables the breakers)
+	SectionBreakerThreshold int
+	SectionBreakerCooldown  time.Duration
+	// how many independent items of a section (the policies and the roles of the auth methods) are
+	// applied concurrently, one after the other if it isn't set
+	Concurrency int
+	// how many times the idempotent writes failing with a transient error (e.g. a 502 of a load
+	// balancer) are retried, the first retry waits WriteRetryInterval, then twice as long every time
+	WriteRetries       int
+	WriteRetryInterval time.Duration
+}
+
+// mountDescriptionData is the data context of the mount description template
+type mountDescriptionData struct {
+	Description string
+	Path        string
+	Type        string
+	Marker      string
+	Metadata    string
 }
 
 // vault is an implementation of the Vault interface that will perform actions
@@ -68,6 +171,28 @@ type vault struct {
 	keyStore kv.Service
 	cl       *api.Client
 	config   *Config
+
+	// the cached token of the auth method if it is configured
+	loginToken          string
+	loginTokenExpiry    time.Time
+	loginTokenRenewable bool
+	// the login tokens replaced by a new login, the requests denied with them are retried with the new token
+	replacedLoginTokens map[string]bool
+	// closed when the login in flight is done, the concurrent callers wait for it instead of logging in too
+	loginInFlight chan struct{}
+	loginLock     sync.Mutex
+	// the unwrapped secret-id of the approle auth method, only used by the login in flight
+	appRoleUnwrappedSecretID string
+
+	// the circuit breakers of the failing configuration sections by section name
+	breakers     map[string]*sectionBreaker
+	breakersLock sync.Mutex
+
+	// the token is set on the client for the duration of a configuration run, the secrets read
+	// from other goroutines (e.g. by the config templates) wait for the run to finish
+	runLock sync.Mutex
+	// the context of the configuration run in flight, it carries the span of the run
+	runCtx context.Context
 }
 
 // Interface check
@@ -82,7 +207,22 @@ type Vault interface {
 	Unseal() error
 	Leader() (bool, error)
 	Configure(config *viper.Viper) error
+	ConfigureSections(config *viper.Viper, sections []string) error
 	StepDownActive(string) error
+	RewrapSealWrap(pollPeriod time.Duration) error
+	MigrateSeal(sealType string) error
+	RotateDatabaseStaticRoles(config *viper.Viper) ([]StaticRoleRotation, error)
+	RotateDatabaseRoots(config *viper.Viper, connections []string) ([]RootRotation, error)
+	DetectDrift(config *viper.Viper) (Drift, error)
+	Plan(config *viper.Viper) ([]PlannedChange, error)
+	CheckMounts(config *viper.Viper) ([]MountCheck, error)
+	ValidateInNamespace(config *viper.Viper, namespace string) error
+	RegenerateRoot(store bool) (string, error)
+	ReadSecret(path string) (map[string]interface{}, error)
+	RaftJoin(leaderAPIAddr string) error
+	RaftPeers() ([]RaftPeer, error)
+	RaftRemovePeer(nodeID string) error
+	RaftSnapshot(w io.Writer) error
 }
 
 // New returns a new vault Vault, or an error.
@@ -92,11 +232,35 @@ func New(k kv.Service, cl *api.Client, config Config) (Vault, error) {
 		return nil, errors.New("the secret threshold can't be bigger than the shares")
 	}
 
-	return &vault{
+	switch config.AuthMethod {
+	case "":
+	case AuthMethodKubernetes:
+		if config.AuthRole == "" {
+			return nil, fmt.Errorf("the role of the %s auth method has to be set", config.AuthMethod)
+		}
+	case AuthMethodAppRole:
+		if config.AppRoleRoleID == "" || config.AppRoleSecretIDFile == "" {
+			return nil, fmt.Errorf("the role-id and the secret-id file of the %s auth method have to be set", config.AuthMethod)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported auth method %s, only %s and %s are supported", config.AuthMethod, AuthMethodKubernetes, AuthMethodAppRole)
+	}
+
+	for i := range config.ShareStores {
+		if i < 0 || i >= config.SecretShares {
+			return nil, fmt.Errorf("the share index %d of a share store is out of range (secret shares: %d)", i, config.SecretShares)
+		}
+	}
+
+	v := &vault{
 		keyStore: k,
 		cl:       cl,
 		config:   &config,
-	}, nil
+	}
+	if config.AuthMethod != "" && config.LoginRetryTransport != nil {
+		config.LoginRetryTransport.register(v)
+	}
+	return v, nil
 }
 
 func (v *vault) Sealed() (bool, error) {
@@ -111,15 +275,25 @@ func (v *vault) Active() (bool, error) {
 	req := v.cl.NewRequest("GET", "/v1/sys/health")
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
+	// the api client returns an error for the status codes of the standby nodes as well,
+	// so the status code of the response is checked before the error
 	resp, err := v.cl.RawRequestWithContext(ctx, req)
-	if err != nil {
+	if resp == nil {
+		if err == nil {
+			err = errors.New("no response")
+		}
 		return false, fmt.Errorf("error checking status: %s", err.Error())
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
 		return true, nil
+	case http.StatusTooManyRequests, 473:
+		// 429 is returned by standby, 473 by performance standby nodes
+		return false, nil
+	default:
+		return false, fmt.Errorf("error unexpected status code: %d", resp.StatusCode)
 	}
-	return false, fmt.Errorf("error unexpected status code: %d", resp.StatusCode)
 }
 
 func (v *vault) Leader() (bool, error) {
@@ -140,12 +314,18 @@ func (v *vault) Unseal() error {
 		keyID := v.unsealKeyForID(i)
 
 		logrus.Debugf("retrieving key from kms service...")
-		k, err := v.keyStore.Get(keyID)
+		k, err := v.storeForKey(keyID).Get(keyID)
 
 		if err != nil {
 			return fmt.Errorf("unable to get key '%s': %s", keyID, err.Error())
 		}
 
+		k, err = v.decodeShare(keyID, k)
+
+		if err != nil {
+			return fmt.Errorf("unable to decode key '%s': %s", keyID, err.Error())
+		}
+
 		logrus.Debugf("sending unseal request to vault...")
 		resp, err := v.cl.Sys().Unseal(string(k))
 
@@ -166,8 +346,19 @@ func (v *vault) Unseal() error {
 	}
 }
 
+// storeForKey returns the key store holding the key, the unseal (and recovery) key shares
+// may be distributed to other stores with ShareStores
+func (v *vault) storeForKey(key string) kv.Service {
+	for i, store := range v.config.ShareStores {
+		if key == v.unsealKeyForID(i) || key == v.recoveryKeyForID(i) {
+			return store
+		}
+	}
+	return v.keyStore
+}
+
 func (v *vault) keyStoreNotFound(key string) (bool, error) {
-	_, err := v.keyStore.Get(key)
+	_, err := v.storeForKey(key).Get(key)
 	if _, ok := err.(*kv.NotFoundError); ok {
 		return true, nil
 	}
@@ -177,7 +368,7 @@ func (v *vault) keyStoreNotFound(key string) (bool, error) {
 func (v *vault) keyStoreSet(key string, val []byte) error {
 	notFound, err := v.keyStoreNotFound(key)
 	if notFound {
-		return v.keyStore.Set(key, val)
+		return v.storeForKey(key).Set(key, val)
 	} else if err == nil {
 		return fmt.Errorf("error setting key '%s': it already exists", key)
 	} else {
@@ -192,24 +383,36 @@ func (v *vault) Init() error {
 		return fmt.Errorf("error testing if vault is initialized: %s", err.Error())
 	}
 	if initialized {
-		logrus.Info("vault is already initialized")
-		return nil
+		return v.resumeInit()
 	}
 
 	logrus.Info("initializing vault")
 
-	// test backend first
+	err = v.checkShareEncoder()
+	if err != nil {
+		return fmt.Errorf("error before init: %s", err.Error())
+	}
+
+	// test backends first
 	err = v.keyStore.Test(v.testKey())
 	if err != nil {
 		return fmt.Errorf("error testing keystore before init: %s", err.Error())
 	}
+	for i, store := range v.config.ShareStores {
+		err = store.Test(v.testKey())
+		if err != nil {
+			return fmt.Errorf("error testing keystore of share %d before init: %s", i, err.Error())
+		}
+	}
 
 	// test for an existing keys
 	keys := []string{
 		v.rootTokenKey(),
+		v.initProgressKey(),
+		v.initProgressRootTokenKey(),
 	}
 
-	// add unseal keys
+	// add unseal keys and their init progress
 	for i := 0; i <= v.config.SecretShares; i++ {
 		keys = append(keys, v.unsealKeyForID(i))
 	}
@@ -223,6 +426,16 @@ func (v *vault) Init() error {
 			return fmt.Errorf("error before init: keystore value for '%s' already exists", key)
 		}
 	}
+	for i := 0; i <= v.config.SecretShares; i++ {
+		key := v.initProgressShareKey(i)
+		_, err := v.storeForKey(v.unsealKeyForID(i)).Get(key)
+		if _, ok := err.(*kv.NotFoundError); !ok {
+			if err == nil {
+				return fmt.Errorf("error before init: keystore value for '%s' already exists", key)
+			}
+			return fmt.Errorf("error before init: checking key '%s' failed: %s", key, err.Error())
+		}
+	}
 
 	resp, err := v.cl.Sys().Init(&api.InitRequest{
 		SecretShares:      v.config.SecretShares,
@@ -235,10 +448,112 @@ func (v *vault) Init() error {
 		return fmt.Errorf("error initializing vault: %s", err.Error())
 	}
 
-	for i, k := range resp.Keys {
+	// the keys are returned only once, save every share in the store of the share and the root
+	// token in the key store first, so a restart can finish storing them if the init is interrupted
+	progress := &initProgress{Shares: len(resp.Keys)}
+	err = v.saveInitProgress(progress)
+	if err != nil {
+		logrus.Errorf("error storing init progress, the init can't be resumed if it is interrupted: %s", err.Error())
+	}
+	for i, share := range resp.Keys {
+		err = v.storeForKey(v.unsealKeyForID(i)).Set(v.initProgressShareKey(i), []byte(share))
+		if err != nil {
+			logrus.Errorf("error storing init progress of share %d, the init can't be resumed if it is interrupted: %s", i, err.Error())
+		}
+	}
+	if v.initNeedsRootToken() {
+		err = v.keyStore.Set(v.initProgressRootTokenKey(), []byte(resp.RootToken))
+		if err != nil {
+			logrus.Errorf("error storing init progress of the root token, the init can't be resumed if it is interrupted: %s", err.Error())
+		}
+	}
+
+	return v.finishInit(progress, resp.Keys, resp.RootToken)
+}
+
+// initProgress is the state of an interrupted init, the keys returned by sys/init are kept in
+// the init progress keys of their own stores until they are stored, it is deleted afterwards
+type initProgress struct {
+	Shares           int  `json:"shares"`
+	RootTokenCreated bool `json:"root_token_created,omitempty"`
+}
+
+func (v *vault) saveInitProgress(progress *initProgress) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return v.keyStore.Set(v.initProgressKey(), data)
+}
+
+// initNeedsRootToken tells if the root token returned by sys/init is used after storing the
+// unseal keys, to store it or to create the predefined root token with it
+func (v *vault) initNeedsRootToken() bool {
+	return v.config.StoreRootToken || v.config.InitRootToken != ""
+}
+
+// resumeInit finishes storing the keys of an interrupted init (e.g. the pod was restarted
+// after sys/init), instead of giving up on the already initialized Vault
+func (v *vault) resumeInit() error {
+	data, err := v.keyStore.Get(v.initProgressKey())
+	if _, ok := err.(*kv.NotFoundError); ok {
+		logrus.Info("vault is already initialized")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading init progress: %s", err.Error())
+	}
+
+	var progress initProgress
+	err = json.Unmarshal(data, &progress)
+	if err != nil {
+		return fmt.Errorf("error decoding init progress: %s", err.Error())
+	}
+
+	logrus.Warn("vault is already initialized, but storing its keys was interrupted, resuming the init")
+
+	shares := make([]string, progress.Shares)
+	for i := range shares {
+		keyID := v.unsealKeyForID(i)
+		share, err := v.storeForKey(keyID).Get(v.initProgressShareKey(i))
+		if _, ok := err.(*kv.NotFoundError); ok {
+			// stored before the interruption, or lost if it wasn't
+			if _, err := v.storeForKey(keyID).Get(keyID); err != nil {
+				return fmt.Errorf("error resuming init: unseal key '%s' wasn't stored before the interruption: %s", keyID, err.Error())
+			}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error reading init progress of share %d: %s", i, err.Error())
+		}
+		shares[i] = string(share)
+	}
+
+	var rootToken string
+	if v.initNeedsRootToken() {
+		token, err := v.keyStore.Get(v.initProgressRootTokenKey())
+		if _, ok := err.(*kv.NotFoundError); !ok && err != nil {
+			return fmt.Errorf("error reading init progress of the root token: %s", err.Error())
+		}
+		rootToken = string(token)
+	}
+
+	return v.finishInit(&progress, shares, rootToken)
+}
+
+// finishInit stores the unseal keys and the root token returned by sys/init, the keys which
+// were stored before an interruption are skipped (their shares are empty), and deletes the
+// init progress keys
+func (v *vault) finishInit(progress *initProgress, shares []string, rootToken string) error {
+	for i, k := range shares {
+		if k == "" {
+			continue
+		}
 		keyID := v.unsealKeyForID(i)
-		err := v.keyStoreSet(keyID, []byte(k))
+		share, err := v.encodeShare(keyID, []byte(k))
+		if err != nil {
+			return fmt.Errorf("error encoding unseal key '%s': %s", keyID, err.Error())
+		}
 
+		err = v.storeInitKey(keyID, share)
 		if err != nil {
 			return fmt.Errorf("error storing unseal key '%s': %s", keyID, err.Error())
 		}
@@ -246,10 +561,12 @@ func (v *vault) Init() error {
 		logrus.WithField("key", keyID).Info("unseal key stored in key store")
 	}
 
-	rootToken := resp.RootToken
-
 	// this sets up a predefined root token
-	if v.config.InitRootToken != "" {
+	if v.config.InitRootToken != "" && !progress.RootTokenCreated {
+		if rootToken == "" {
+			return fmt.Errorf("error resuming init: the root token wasn't stored before the interruption, the predefined root token can't be created")
+		}
+
 		logrus.Info("setting up init root token, waiting for vault to be unsealed")
 
 		count := 0
@@ -270,7 +587,7 @@ func (v *vault) Init() error {
 		}
 
 		// use temporary token
-		v.cl.SetToken(resp.RootToken)
+		v.cl.SetToken(rootToken)
 
 		// setup root token with provided key
 		_, err := v.cl.Auth().Token().CreateOrphan(&api.TokenCreateRequest{
@@ -280,31 +597,77 @@ func (v *vault) Init() error {
 			NoParent:    true,
 		})
 		if err != nil {
-			return fmt.Errorf("unable to setup requested root token, (temporary root token: '%s'): %s", resp.RootToken, err)
+			return fmt.Errorf("unable to setup requested root token, (temporary root token: '%s'): %s", rootToken, err)
 		}
 
 		// revoke the temporary token
-		err = v.cl.Auth().Token().RevokeSelf(resp.RootToken)
+		err = v.cl.Auth().Token().RevokeSelf(rootToken)
 		if err != nil {
 			return fmt.Errorf("unable to revoke temporary root token: %s", err.Error())
 		}
 
+		progress.RootTokenCreated = true
+		err = v.saveInitProgress(progress)
+		if err != nil {
+			logrus.Errorf("error storing init progress: %s", err.Error())
+		}
+	}
+	if v.config.InitRootToken != "" {
 		rootToken = v.config.InitRootToken
 	}
 
 	if v.config.StoreRootToken {
 		rootTokenKey := v.rootTokenKey()
-		if err = v.keyStoreSet(rootTokenKey, []byte(resp.RootToken)); err != nil {
-			return fmt.Errorf("error storing root token '%s' in key'%s'", rootToken, rootTokenKey)
+		if rootToken == "" {
+			// stored before the interruption, or lost if it wasn't
+			if _, err := v.keyStore.Get(rootTokenKey); err != nil {
+				return fmt.Errorf("error resuming init: the root token wasn't stored before the interruption: %s", err.Error())
+			}
+		} else if err := v.storeInitKey(rootTokenKey, []byte(rootToken)); err != nil {
+			return fmt.Errorf("error storing root token in key '%s': %s", rootTokenKey, err.Error())
 		}
 		logrus.WithField("key", rootTokenKey).Info("root token stored in key store")
 	} else if v.config.InitRootToken == "" {
-		logrus.WithField("root-token", resp.RootToken).Warnf("won't store root token in key store, this token grants full privileges to vault, so keep this secret")
+		logrus.WithField("root-token", rootToken).Warnf("won't store root token in key store, this token grants full privileges to vault, so keep this secret")
+	}
+
+	// the progress itself is deleted last, so a failed deletion is retried by the next init
+	for i := range shares {
+		err := v.deleteInitProgressKey(v.storeForKey(v.unsealKeyForID(i)), v.initProgressShareKey(i))
+		if err != nil {
+			return err
+		}
+	}
+	if err := v.deleteInitProgressKey(v.keyStore, v.initProgressRootTokenKey()); err != nil {
+		return err
 	}
+	return v.deleteInitProgressKey(v.keyStore, v.initProgressKey())
+}
 
+// deleteInitProgressKey deletes the init progress key (and its versions) if it exists
+func (v *vault) deleteInitProgressKey(store kv.Service, key string) error {
+	_, err := store.Get(key)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading init progress key '%s': %s", key, err.Error())
+	}
+	err = kv.Delete(store, key)
+	if err != nil {
+		return fmt.Errorf("error deleting init progress key '%s': %s", key, err.Error())
+	}
 	return nil
 }
 
+// storeInitKey stores a key of the init, unless the same value was stored before an interruption
+func (v *vault) storeInitKey(key string, value []byte) error {
+	existing, err := v.storeForKey(key).Get(key)
+	if err == nil && bytes.Equal(existing, value) {
+		return nil
+	}
+	return v.keyStoreSet(key, value)
+}
+
 func (v *vault) StepDownActive(address string) error {
 	logrus.Debugf("retrieving key from kms service...")
 
@@ -329,65 +692,344 @@ func (v *vault) StepDownActive(address string) error {
 }
 
 func (v *vault) Configure(config *viper.Viper) error {
-	logrus.Debugf("retrieving key from kms service...")
+	return v.ConfigureSections(config, nil)
+}
 
-	rootToken, err := v.keyStore.Get(v.rootTokenKey())
-	if err != nil {
-		return fmt.Errorf("unable to get key '%s': %s", v.rootTokenKey(), err.Error())
+// ConfigureSections applies only the given sections of the configuration (all of them if nil),
+// e.g. to retry the failed sections of a previous run
+func (v *vault) ConfigureSections(config *viper.Viper, sections []string) error {
+	if v.config.ConfigureRateLimit > 0 {
+		// a token bucket of size 1, so the API calls are spaced evenly
+		v.cl.SetLimiter(v.config.ConfigureRateLimit, 1)
+	}
+
+	v.runLock.Lock()
+	defer v.runLock.Unlock()
+
+	ctx, span := v.startSpan(v.baseContext(), "configure", map[string]string{"config.file": config.ConfigFileUsed()})
+	v.runCtx = ctx
+	defer func() { v.runCtx = nil }()
+	defer v.traceRequests(ctx)()
+	err := v.withRootToken(func() error {
+		err := v.configure(config, sections)
+		if err != nil || !v.config.AutoRevokeRootToken {
+			return err
+		}
+		return v.replaceRootToken(config)
+	})
+	span.End(err)
+	return err
+}
+
+// baseContext is the context the configuration runs start from
+func (v *vault) baseContext() context.Context {
+	if v.config.Context != nil {
+		return v.config.Context
 	}
+	return context.Background()
+}
 
-	v.cl.SetToken(string(rootToken))
+// runContext is the context of the configuration run in flight, the base context outside of the runs
+func (v *vault) runContext() context.Context {
+	if v.runCtx != nil {
+		return v.runCtx
+	}
+	return v.baseContext()
+}
 
-	// Clear the token and GC it
-	defer runtime.GC()
-	defer v.cl.SetToken("")
-	defer func() { rootToken = nil }()
+// ConfigurationError is returned when some sections of the configuration failed to apply, the
+// sections after the first failed one are skipped unless ContinueOnSectionFailure is set
+type ConfigurationError struct {
+	FailedSections []string
+	Errors         map[string]error
+	// the failed sections which were skipped because their circuit breaker is open
+	ShortCircuited []string
+	// the sections which weren't applied because a section before them failed
+	SkippedSections []string
+}
 
-	err = v.configureAuthMethods(config)
-	if err != nil {
-		return fmt.Errorf("error configuring auth methods for vault: %s", err.Error())
+func (e *ConfigurationError) Error() string {
+	var errs []string
+	for _, section := range e.FailedSections {
+		errs = append(errs, e.Errors[section].Error())
 	}
+	if len(e.SkippedSections) > 0 {
+		errs = append(errs, fmt.Sprintf("skipped the sections %s after the failure", strings.Join(e.SkippedSections, ", ")))
+	}
+	return strings.Join(errs, "; ")
+}
 
-	err = v.configurePolicies(config)
-	if err != nil {
-		return fmt.Errorf("error configuring policies for vault: %s", err.Error())
+// UnappliedSections returns the failed and the skipped sections, which a retry has to apply
+func (e *ConfigurationError) UnappliedSections() []string {
+	return append(append([]string(nil), e.FailedSections...), e.SkippedSections...)
+}
+
+// RetriableSections returns the failed sections which are worth retrying, the ones whose
+// circuit breaker is open would be skipped again
+func (e *ConfigurationError) RetriableSections() []string {
+	shortCircuited := map[string]bool{}
+	for _, section := range e.ShortCircuited {
+		shortCircuited[section] = true
+	}
+	var sections []string
+	for _, section := range e.FailedSections {
+		if !shortCircuited[section] {
+			sections = append(sections, section)
+		}
 	}
+	return sections
+}
 
-	err = v.configurePlugins(config)
-	if err != nil {
-		return fmt.Errorf("error configuring plugins for vault: %s", err.Error())
+type configSection struct {
+	name        string
+	description string
+	configure   func(config *viper.Viper) error
+}
+
+// configSections returns the sections of the configuration in the order they are applied, the
+// audit devices are applied last, so a failing audit device doesn't keep the rest of the configuration
+// from being applied, unless they are applied early (before the startup secrets are written)
+func (v *vault) configSections() []configSection {
+	audit := configSection{"audit", "configuring audit devices for", v.configureAuditDevices}
+
+	sections := []configSection{
+		{"auth", "configuring auth methods for", v.configureAuthMethods},
+		{"policies", "configuring policies for", v.configurePolicies},
+		{"plugins", "configuring plugins for", v.configurePlugins},
+		{"secrets", "configuring secret engines for", v.configureSecretEngines},
+		{"identity", "configuring identity for", v.configureIdentity},
+		{"identityPurge", "purging identities from", v.configureIdentityPurge},
+		{"purgeUnmanagedConfig", "purging unmanaged config from", v.configurePurgeUnmanagedConfig},
+		{"wrappingDefaults", "configuring wrapping defaults for", v.configureWrappingDefaults},
+		{"ui", "configuring the ui of", v.configureUI},
+		{"sysConfig", "writing sys config to", v.configureSysConfig},
+		{"controlGroup", "configuring control groups for", v.configureControlGroup},
+		{"mountFilters", "configuring mount filters for", v.configureMountFilters},
+		{"seal", "configuring the seal of", v.configureSeal},
+	}
+	if v.config != nil && v.config.EarlyAudit {
+		sections = append(sections, audit)
+	}
+	sections = append(sections,
+		configSection{"startupSecrets", "writing startup secrets to", v.configureStartupSecrets},
+		configSection{"kvOperations", "applying kv operations to", v.configureKVOperations},
+		configSection{"verifyAccess", "verifying access to", v.configureVerifyAccess},
+	)
+	if v.config == nil || !v.config.EarlyAudit {
+		sections = append(sections, audit)
+	}
+	// the assertions check the applied configuration
+	return append(sections, configSection{"assertions", "evaluating the assertions of", v.configureAssertions})
+}
+
+func (v *vault) configure(config *viper.Viper, sections []string) error {
+	selected := map[string]bool{}
+	for _, section := range sections {
+		selected[section] = true
+	}
+
+	if v.config.FailOnDeprecated {
+		if err := v.checkDeprecations(config); err != nil {
+			return err
+		}
+	}
+
+	configErr := &ConfigurationError{Errors: map[string]error{}}
+
+	for _, section := range v.configSections() {
+		if sections != nil && !selected[section.name] {
+			continue
+		}
+
+		if len(configErr.FailedSections) > 0 && !v.config.ContinueOnSectionFailure {
+			configErr.SkippedSections = append(configErr.SkippedSections, section.name)
+			continue
+		}
+
+		if open, err := v.sectionShortCircuited(section.name); open {
+			logrus.Error(err.Error())
+			configErr.FailedSections = append(configErr.FailedSections, section.name)
+			configErr.ShortCircuited = append(configErr.ShortCircuited, section.name)
+			configErr.Errors[section.name] = err
+			continue
+		}
+
+		ctx, span := v.startSpan(v.runContext(), "configure "+section.name, map[string]string{"section": section.name})
+		restore := v.traceRequests(ctx)
+		err := section.configure(config)
+		restore()
+		span.End(err)
+		v.recordSectionResult(section.name, err)
+		if err != nil {
+			err = fmt.Errorf("error %s vault: %s", section.description, err.Error())
+			logrus.Error(err.Error())
+			configErr.FailedSections = append(configErr.FailedSections, section.name)
+			configErr.Errors[section.name] = err
+		}
+	}
+
+	if len(configErr.FailedSections) > 0 {
+		if len(configErr.SkippedSections) > 0 {
+			logrus.Errorf("skipped the sections %v after the failure", configErr.SkippedSections)
+		}
+		return configErr
 	}
 
-	err = v.configureSecretEngines(config)
+	return nil
+}
+
+// RewrapSealWrap triggers the rewrap of all seal-wrapped entries (sys/sealwrap/rewrap),
+// this is needed after enabling seal wrapping on existing mounts, and polls until it is finished.
+func (v *vault) RewrapSealWrap(pollPeriod time.Duration) error {
+	return v.withRootToken(func() error {
+		return v.rewrapSealWrap(pollPeriod)
+	})
+}
+
+func (v *vault) rewrapSealWrap(pollPeriod time.Duration) error {
+	logrus.Info("triggering seal wrap rewrap")
+
+	_, err := v.cl.Logical().Write("sys/sealwrap/rewrap", nil)
 	if err != nil {
-		return fmt.Errorf("error configuring secret engines for vault: %s", err.Error())
+		return fmt.Errorf("error triggering seal wrap rewrap: %s", err.Error())
 	}
 
-	err = v.configureAuditDevices(config)
+	for {
+		running, err := v.sealWrapRewrapRunning()
+		if err != nil {
+			return err
+		}
+		if !running {
+			logrus.Info("seal wrap rewrap finished")
+			return nil
+		}
+
+		logrus.Infof("seal wrap rewrap is still running, waiting %s before checking again...", pollPeriod)
+		time.Sleep(pollPeriod)
+	}
+}
+
+func (v *vault) sealWrapRewrapRunning() (bool, error) {
+	secret, err := v.cl.Logical().Read("sys/sealwrap/rewrap")
 	if err != nil {
-		return fmt.Errorf("error configuring audit devices for vault: %s", err.Error())
+		return false, fmt.Errorf("error reading seal wrap rewrap status: %s", err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return false, nil
+	}
+	running, err := getOrDefaultBool(secret.Data, "is_running")
+	if err != nil {
+		return false, fmt.Errorf("error parsing seal wrap rewrap status: %s", err.Error())
+	}
+	return running, nil
+}
+
+// withRootToken retrieves the root token from the key store and sets it on the client
+// for the duration of fn, or the token of the auth method if it is configured.
+func (v *vault) withRootToken(fn func() error) error {
+	if v.config.Token != "" {
+		v.cl.SetToken(v.config.Token)
+		defer v.cl.SetToken("")
+		return fn()
+	}
+
+	if v.config.AuthMethod != "" {
+		return v.withLoginToken(fn)
 	}
 
-	err = v.configureStartupSecrets(config)
+	if v.config.AutoRevokeRootToken {
+		return v.withRootOrConfigurerToken(fn)
+	}
+
+	logrus.Debugf("retrieving key from kms service...")
+
+	rootToken, err := v.keyStore.Get(v.rootTokenKey())
 	if err != nil {
-		return fmt.Errorf("error writing startup secrets tor vault: %s", err.Error())
+		return fmt.Errorf("unable to get key '%s': %s", v.rootTokenKey(), err.Error())
 	}
 
-	return err
+	v.cl.SetToken(string(rootToken))
+
+	// Clear the token and GC it
+	defer runtime.GC()
+	defer v.cl.SetToken("")
+	defer func() { rootToken = nil }()
+
+	return fn()
+}
+
+// StoredKeys returns the names of the keys (the root token and the unseal keys)
+// bank-vaults stores in the key store with the given configuration
+func StoredKeys(config Config) []string {
+	v := vault{config: &config}
+	keys := []string{v.rootTokenKey()}
+	for i := 0; i < config.SecretShares; i++ {
+		keys = append(keys, v.unsealKeyForID(i))
+	}
+	return keys
+}
+
+// StoreForKey returns the key store holding the key with the given configuration,
+// which is the given store unless the key is an unseal key share assigned to a ShareStore
+func StoreForKey(store kv.Service, config Config, key string) kv.Service {
+	v := vault{keyStore: store, config: &config}
+	return v.storeForKey(key)
 }
 
 func (*vault) unsealKeyForID(i int) string {
 	return fmt.Sprint("vault-unseal-", i)
 }
 
+func (*vault) recoveryKeyForID(i int) string {
+	return fmt.Sprint("vault-recovery-", i)
+}
+
 func (*vault) rootTokenKey() string {
 	return fmt.Sprint("vault-root")
 }
 
+func (*vault) initProgressKey() string {
+	return fmt.Sprint("vault-init-progress")
+}
+
+// initProgressShareKey is the key of the share before it is stored as its unseal key, in the
+// store of the share
+func (v *vault) initProgressShareKey(i int) string {
+	return fmt.Sprint(v.initProgressKey(), "-", i)
+}
+
+func (v *vault) initProgressRootTokenKey() string {
+	return fmt.Sprint(v.initProgressKey(), "-root")
+}
+
 func (*vault) testKey() string {
 	return fmt.Sprint("vault-test")
 }
 
+func (*vault) sealKeyIDKey() string {
+	return fmt.Sprint("vault-seal-key-id")
+}
+
+func (*vault) configurerTokenKey() string {
+	return fmt.Sprint("vault-configurer-token")
+}
+
+func (*vault) managedPoliciesKey() string {
+	return fmt.Sprint("vault-managed-policies")
+}
+
+func (*vault) managedGroupsKey() string {
+	return fmt.Sprint("vault-managed-groups")
+}
+
+func (*vault) managedAuthKey() string {
+	return fmt.Sprint("vault-managed-auth")
+}
+
+func (*vault) managedSecretsKey() string {
+	return fmt.Sprint("vault-managed-secrets")
+}
+
 func (v *vault) kubernetesAuthConfigDefault() (map[string]interface{}, error) {
 	kubernetesCACert, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
 	if err != nil {
@@ -440,7 +1082,6 @@ func (v *vault) configureAuthMethods(config *viper.Viper) error {
 				return fmt.Errorf("error converting path for auth method: %s", err.Error())
 			}
 		}
-
 		description := fmt.Sprintf("%s backend", authMethodType)
 		if descriptionOverwrite, ok := authMethod["description"]; ok {
 			description, err = cast.ToStringE(descriptionOverwrite)
@@ -461,6 +1102,11 @@ func (v *vault) configureAuthMethods(config *viper.Viper) error {
 		if !exists {
 			logrus.Debugf("enabling %s auth backend in vault...", authMethodType)
 
+			description, err = v.mountDescription(description, path, authMethodType)
+			if err != nil {
+				return fmt.Errorf("error rendering description for auth method: %s", err.Error())
+			}
+
 			// https://www.vaultproject.io/api/system/auth.html
 			options := api.EnableAuthOptions{
 				Type:        authMethodType,
@@ -600,8 +1246,18 @@ func (v *vault) configureAuthMethods(config *viper.Viper) error {
 			if err != nil {
 				return fmt.Errorf("error configuring approle auth for vault: %s", err.Error())
 			}
-		case "jwt":
-			config, err := cast.ToStringMapE(authMethod["config"])
+		case "token":
+			roles, err := cast.ToSliceE(authMethod["roles"])
+			if err != nil {
+				return fmt.Errorf("error finding roles block for token: %s", err.Error())
+			}
+			err = v.configureTokenRoles(roles)
+			if err != nil {
+				return fmt.Errorf("error configuring token roles for vault: %s", err.Error())
+			}
+		case "jwt", "oidc":
+			// normalized, as the nested blocks (e.g. provider_config, bound_claims) are passed through
+			config, err := cast.ToStringMapE(normalizeConfigValue(authMethod["config"]))
 			if err != nil {
 				return fmt.Errorf("error finding config block for jwt: %s", err.Error())
 			}
@@ -609,7 +1265,7 @@ func (v *vault) configureAuthMethods(config *viper.Viper) error {
 			if err != nil {
 				return fmt.Errorf("error configuring jwt auth on path %s for vault: %s", path, err.Error())
 			}
-			roles, err := cast.ToSliceE(authMethod["roles"])
+			roles, err := cast.ToSliceE(normalizeConfigValue(authMethod["roles"]))
 			if err != nil {
 				return fmt.Errorf("error finding roles block for jwt: %s", err.Error())
 			}
@@ -620,49 +1276,312 @@ func (v *vault) configureAuthMethods(config *viper.Viper) error {
 		}
 	}
 
-	return nil
-}
-
-func (v *vault) configurePolicies(config *viper.Viper) error {
-	policies := []map[string]string{}
-	err := config.UnmarshalKey("policies", &policies)
+	// the paths are stored even without pruning, so the auth methods of this config file are
+	// kept when another config file is pruned
+	managedPaths, err := v.managedMountPaths(config, "auth", v.managedAuthKey())
 	if err != nil {
-		return fmt.Errorf("error unmarshalling vault policy config: %s", err.Error())
+		return err
 	}
-
-	for _, policy := range policies {
-		err := v.cl.Sys().PutPolicy(policy["name"], policy["rules"])
-
-		if err != nil {
-			return fmt.Errorf("error putting %s policy into vault: %s", policy["name"], err.Error())
-		}
+	if v.config.PruneAuth {
+		return v.pruneAuthMethods(existingAuths, managedPaths)
 	}
 
 	return nil
 }
 
-func (v *vault) configureKubernetesRoles(path string, roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role, err := cast.ToStringMapE(roleInterface)
-		if err != nil {
-			return fmt.Errorf("error converting role for kubernetes: %s", err.Error())
+// pruneAuthMethods disables the auth methods which were enabled by bank-vaults (their description
+// carries the managed marker) but are not present in managedPaths anymore, which holds the paths of
+// every config file, the entity and group aliases bound to their accessors are deleted first, so
+// they don't become orphaned
+func (v *vault) pruneAuthMethods(existingAuths map[string]*api.AuthMount, managedPaths map[string]bool) error {
+	for path, auth := range existingAuths {
+		if managedPaths[path] || !isManagedDescription(auth.Description) {
+			continue
 		}
 
-		_, err = v.cl.Logical().Write(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
-
+		logrus.Infof("disabling auth method %s which is not present in the configuration anymore", path)
+		err := v.deleteIdentityAliases(auth.Accessor)
 		if err != nil {
-			return fmt.Errorf("error putting %s kubernetes role into vault: %s", role["name"], err.Error())
+			return fmt.Errorf("error deleting the identity aliases of auth method %s: %s", path, err.Error())
+		}
+		err = v.revokeLeasePrefix("auth/" + path)
+		if err != nil {
+			return err
+		}
+		err = v.cl.Sys().DisableAuth(path)
+		if err != nil {
+			return fmt.Errorf("error disabling auth method %s: %s", path, err.Error())
 		}
 	}
+
 	return nil
 }
 
-func (v *vault) configureGithubConfig(path string, config map[string]interface{}) error {
-	// https://www.vaultproject.io/api/auth/github/index.html
-	_, err := v.cl.Logical().Write(fmt.Sprintf("auth/%s/config", path), config)
-
+func (v *vault) configurePolicies(config *viper.Viper) error {
+	policies := []map[string]interface{}{}
+	err := config.UnmarshalKey("policies", &policies)
 	if err != nil {
-		return fmt.Errorf("error putting %s github config into vault: %s", config, err.Error())
+		return fmt.Errorf("error unmarshalling vault policy config: %s", err.Error())
+	}
+
+	baseDir := configDir(config)
+	if v.config.AtomicPolicies {
+		return v.configurePoliciesAtomically(policies, baseDir)
+	}
+
+	// the policies are independent of each other, a failed one doesn't stop the others
+	return v.forEachItem(len(policies), func(i int) error {
+		policy := policies[i]
+		name, rules, policyType, err := policyDefinition(policy, baseDir)
+		if err != nil {
+			return err
+		}
+
+		switch policyType {
+		case "", "acl":
+			err = v.checkACLPolicy(name, rules)
+			if err != nil {
+				return err
+			}
+
+			err = v.retryIdempotent("putting "+name+" policy", func(cl *api.Client) error {
+				return cl.Sys().PutPolicy(name, rules)
+			})
+		case "rgp", "egp":
+			err = v.retryIdempotent("putting "+name+" policy", func(cl *api.Client) error {
+				return v.putSentinelPolicy(cl, policyType, name, rules, policy)
+			})
+		default:
+			return fmt.Errorf("unknown type %s of policy %s", policyType, name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error putting %s policy into vault: %s", name, err.Error())
+		}
+		return nil
+	})
+}
+
+// configDir returns the directory of the config file, the relative paths of the configuration
+// are relative to it, inline configurations use the working directory
+func configDir(config *viper.Viper) string {
+	if configFile := config.ConfigFileUsed(); configFile != "" {
+		return filepath.Dir(configFile)
+	}
+	return "."
+}
+
+// policyDefinition returns the policy, its rules are either inlined or read from the file
+// given by file, relative paths are relative to baseDir (the directory of the config file)
+func policyDefinition(policy map[string]interface{}, baseDir string) (name, rules, policyType string, err error) {
+	name, err = getOrError(policy, "name")
+	if err != nil {
+		return "", "", "", fmt.Errorf("error getting name for policy: %s", err.Error())
+	}
+	rules, err = getOrDefaultString(policy, "rules")
+	if err != nil {
+		return "", "", "", fmt.Errorf("error getting rules for policy %s: %s", name, err.Error())
+	}
+	file, err := getOrDefaultString(policy, "file")
+	if err != nil {
+		return "", "", "", fmt.Errorf("error getting file for policy %s: %s", name, err.Error())
+	}
+	if file != "" {
+		if rules != "" {
+			return "", "", "", fmt.Errorf("policy %s can't set both rules and file", name)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(baseDir, file)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", "", fmt.Errorf("error reading the rules of policy %s: %s", name, err.Error())
+		}
+		rules = string(content)
+	}
+	policyType, err = getOrDefaultString(policy, "type")
+	if err != nil {
+		return "", "", "", fmt.Errorf("error getting type for policy %s: %s", name, err.Error())
+	}
+	return name, rules, policyType, nil
+}
+
+func (v *vault) checkACLPolicy(name, rules string) error {
+	if v.config.ValidatePolicyTemplates {
+		err := validatePolicyTemplates(name, rules)
+		if err != nil {
+			return err
+		}
+	}
+	return v.checkACLPolicyName(name)
+}
+
+func (v *vault) checkACLPolicyName(name string) error {
+	switch name {
+	case "root":
+		return errors.New("the root policy can't be modified")
+	case "default":
+		if !v.config.AllowDefaultPolicyEdit {
+			return errors.New("modifying the default policy has to be allowed explicitly")
+		}
+		logrus.Warn("overwriting the default policy")
+	}
+	return nil
+}
+
+// stagingPolicyPrefix is prepended to the names of the staged policies in the atomic mode
+const stagingPolicyPrefix = "bank-vaults-staging-"
+
+// configurePoliciesAtomically writes the ACL policies all-or-nothing: they are staged under temporary
+// names first (so Vault parses them) and read back, then the real policies are overwritten, and restored
+// (or deleted if they didn't exist) if one of them fails. The staged policies are deleted in every case.
+// The Sentinel policies are written afterwards, as they are enforced as soon as they are written.
+func (v *vault) configurePoliciesAtomically(policies []map[string]interface{}, baseDir string) error {
+	type aclPolicy struct {
+		name, rules string
+	}
+	var aclPolicies []aclPolicy
+	var sentinelPolicies []map[string]interface{}
+
+	for _, policy := range policies {
+		name, rules, policyType, err := policyDefinition(policy, baseDir)
+		if err != nil {
+			return err
+		}
+		switch policyType {
+		case "", "acl":
+			err = v.checkACLPolicy(name, rules)
+			if err != nil {
+				return err
+			}
+			aclPolicies = append(aclPolicies, aclPolicy{name: name, rules: rules})
+		case "rgp", "egp":
+			sentinelPolicies = append(sentinelPolicies, policy)
+		default:
+			return fmt.Errorf("unknown type %s of policy %s", policyType, name)
+		}
+	}
+
+	var staged []string
+	defer func() {
+		for _, name := range staged {
+			if err := v.cl.Sys().DeletePolicy(name); err != nil {
+				logrus.Errorf("error deleting staged policy %s: %s", name, err.Error())
+			}
+		}
+	}()
+
+	for _, policy := range aclPolicies {
+		stagedName := stagingPolicyPrefix + policy.name
+		staged = append(staged, stagedName)
+		err := v.cl.Sys().PutPolicy(stagedName, policy.rules)
+		if err != nil {
+			return fmt.Errorf("error staging %s policy, no policy was changed: %s", policy.name, err.Error())
+		}
+		rules, err := v.cl.Sys().GetPolicy(stagedName)
+		if err != nil {
+			return fmt.Errorf("error verifying staged %s policy, no policy was changed: %s", policy.name, err.Error())
+		}
+		if rules == "" {
+			return fmt.Errorf("error verifying staged %s policy, no policy was changed: it wasn't stored", policy.name)
+		}
+	}
+
+	originals := map[string]string{}
+	for _, policy := range aclPolicies {
+		original, err := v.cl.Sys().GetPolicy(policy.name)
+		if err != nil {
+			return fmt.Errorf("error reading %s policy, no policy was changed: %s", policy.name, err.Error())
+		}
+		originals[policy.name] = original
+	}
+
+	for i, policy := range aclPolicies {
+		err := v.cl.Sys().PutPolicy(policy.name, policy.rules)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("error putting %s policy into vault: %s", policy.name, err.Error())
+
+		for _, swapped := range aclPolicies[:i] {
+			var rollbackErr error
+			if original := originals[swapped.name]; original != "" {
+				rollbackErr = v.cl.Sys().PutPolicy(swapped.name, original)
+			} else {
+				rollbackErr = v.cl.Sys().DeletePolicy(swapped.name)
+			}
+			if rollbackErr != nil {
+				logrus.Errorf("error rolling back %s policy: %s", swapped.name, rollbackErr.Error())
+			}
+		}
+		return err
+	}
+
+	for _, policy := range sentinelPolicies {
+		name, rules, policyType, _ := policyDefinition(policy, baseDir)
+		err := v.putSentinelPolicy(v.cl, policyType, name, rules, policy)
+		if err != nil {
+			return fmt.Errorf("error putting %s policy into vault: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// putSentinelPolicy writes a role (rgp) or endpoint (egp) governing policy (Enterprise) with the client
+// https://www.vaultproject.io/api/system/policies.html#create-update-rgp-policy
+func (v *vault) putSentinelPolicy(cl *api.Client, policyType, name, rules string, policy map[string]interface{}) error {
+	enforcementLevel, err := getOrError(policy, "enforcement_level")
+	if err != nil {
+		return err
+	}
+	switch enforcementLevel {
+	case "advisory", "soft-mandatory", "hard-mandatory":
+	default:
+		return fmt.Errorf("invalid enforcement_level %s", enforcementLevel)
+	}
+
+	data := map[string]interface{}{
+		"policy":            rules,
+		"enforcement_level": enforcementLevel,
+	}
+
+	if policyType == "egp" {
+		paths, err := getOrDefaultStringSlice(policy, "paths")
+		if err != nil {
+			return fmt.Errorf("error getting paths: %s", err.Error())
+		}
+		if len(paths) == 0 {
+			return errors.New("egp policies need at least one path")
+		}
+		data["paths"] = paths
+	}
+
+	_, err = cl.Logical().Write(fmt.Sprintf("sys/policies/%s/%s", policyType, name), data)
+	return err
+}
+
+func (v *vault) configureKubernetesRoles(path string, roles []interface{}) error {
+	return v.forEachItem(len(roles), func(i int) error {
+		role, err := cast.ToStringMapE(roles[i])
+		if err != nil {
+			return fmt.Errorf("error converting role for kubernetes: %s", err.Error())
+		}
+
+		err = v.writeIdempotent(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
+		if err != nil {
+			return fmt.Errorf("error putting %s kubernetes role into vault: %s", role["name"], err.Error())
+		}
+		return nil
+	})
+}
+
+func (v *vault) configureGithubConfig(path string, config map[string]interface{}) error {
+	// https://www.vaultproject.io/api/auth/github/index.html
+	_, err := v.cl.Logical().Write(fmt.Sprintf("auth/%s/config", path), config)
+
+	if err != nil {
+		return fmt.Errorf("error putting %s github config into vault: %s", config, err.Error())
 	}
 	return nil
 }
@@ -694,18 +1613,18 @@ func (v *vault) configureAwsConfig(path string, config map[string]interface{}) e
 }
 
 func (v *vault) configureAwsRoles(path string, roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role, err := cast.ToStringMapE(roleInterface)
+	return v.forEachItem(len(roles), func(i int) error {
+		role, err := cast.ToStringMapE(roles[i])
 		if err != nil {
 			return fmt.Errorf("error converting roles for aws: %s", err.Error())
 		}
 
-		_, err = v.cl.Logical().Write(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
+		err = v.writeIdempotent(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 		if err != nil {
 			return fmt.Errorf("error putting %s aws role into vault: %s", role["name"], err.Error())
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func (v *vault) configureAWSCrossAccountRoles(path string, crossAccountRoles []interface{}) error {
@@ -746,33 +1665,33 @@ func (v *vault) configureJwtConfig(path string, config map[string]interface{}) e
 }
 
 func (v *vault) configureGcpRoles(path string, roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role, err := cast.ToStringMapE(roleInterface)
+	return v.forEachItem(len(roles), func(i int) error {
+		role, err := cast.ToStringMapE(roles[i])
 		if err != nil {
 			return fmt.Errorf("error converting roles for gcp: %s", err.Error())
 		}
-		_, err = v.cl.Logical().Write(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 
+		err = v.writeIdempotent(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 		if err != nil {
 			return fmt.Errorf("error putting %s gcp role into vault: %s", role["name"], err.Error())
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func (v *vault) configureJwtRoles(path string, roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role, err := cast.ToStringMapE(roleInterface)
+	return v.forEachItem(len(roles), func(i int) error {
+		role, err := cast.ToStringMapE(roles[i])
 		if err != nil {
 			return fmt.Errorf("error converting roles for jwt: %s", err.Error())
 		}
-		_, err = v.cl.Logical().Write(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 
+		err = v.writeIdempotent(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 		if err != nil {
 			return fmt.Errorf("error putting %s jwt role into vault: %s", role["name"], err.Error())
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func (v *vault) configureLdapConfig(path string, config map[string]interface{}) error {
@@ -786,18 +1705,38 @@ func (v *vault) configureLdapConfig(path string, config map[string]interface{})
 }
 
 func (v *vault) configureApproleRoles(path string, roles []interface{}) error {
-	for _, roleInterface := range roles {
-		role, err := cast.ToStringMapE(roleInterface)
+	return v.forEachItem(len(roles), func(i int) error {
+		role, err := cast.ToStringMapE(roles[i])
 		if err != nil {
 			return fmt.Errorf("error converting role for approle: %s", err.Error())
 		}
-		_, err = v.cl.Logical().Write(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 
+		err = v.writeIdempotent(fmt.Sprintf("auth/%s/role/%s", path, role["name"]), role)
 		if err != nil {
 			return fmt.Errorf("error putting %s approle role into vault: %s", role["name"], err.Error())
 		}
-	}
-	return nil
+		return nil
+	})
+}
+
+// configureTokenRoles configures the roles of the built-in token auth method, which is always mounted on auth/token
+func (v *vault) configureTokenRoles(roles []interface{}) error {
+	return v.forEachItem(len(roles), func(i int) error {
+		role, err := cast.ToStringMapE(roles[i])
+		if err != nil {
+			return fmt.Errorf("error converting role for token: %s", err.Error())
+		}
+		name, err := getOrError(role, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for token role: %s", err.Error())
+		}
+		// https://www.vaultproject.io/api/auth/token/index.html#create-update-token-role
+		err = v.writeIdempotent(fmt.Sprintf("auth/token/roles/%s", name), copyWithout(role, "name"))
+		if err != nil {
+			return fmt.Errorf("error putting %s token role into vault: %s", name, err.Error())
+		}
+		return nil
+	})
 }
 
 func (v *vault) configureLdapMappings(path string, mappingType string, mappings map[string]interface{}) error {
@@ -850,6 +1789,11 @@ func (v *vault) configurePlugins(config *viper.Viper) error {
 			return fmt.Errorf("error parsing type for plugin: %s", err.Error())
 		}
 
+		env, err := pluginEnv(plugin)
+		if err != nil {
+			return fmt.Errorf("error getting env for plugin %s: %s", pluginName, err.Error())
+		}
+
 		input := api.RegisterPluginInput{
 			Name:    pluginName,
 			Command: command,
@@ -858,17 +1802,59 @@ func (v *vault) configurePlugins(config *viper.Viper) error {
 		}
 		logrus.Infof("Registering plugin with input: %#v\n", input)
 
-		err = v.cl.Sys().RegisterPlugin(&input)
+		if len(env) > 0 {
+			// the env is not part of the register API types of this client version
+			logrus.Infof("Registering plugin %s with the env variables: %s", pluginName, strings.Join(pluginEnvNames(env), ", "))
+			_, err = v.cl.Logical().Write(fmt.Sprintf("sys/plugins/catalog/%s/%s", pluginType, pluginName), map[string]interface{}{
+				"command": command,
+				"sha256":  sha256,
+				"env":     env,
+			})
+		} else {
+			err = v.cl.Sys().RegisterPlugin(&input)
+		}
 		if err != nil {
 			return fmt.Errorf("error registering plugin %s in vault", err.Error())
 		}
 
-		logrus.Infoln("registered", plugin)
+		logrus.Infoln("registered plugin", pluginName)
 	}
 
 	return nil
 }
 
+// pluginEnv returns the env variables of the plugin, they are configured as a list of
+// KEY=VALUE strings (not as a map, since the config keys are lowercased)
+func pluginEnv(plugin map[string]interface{}) ([]string, error) {
+	raw, ok := plugin["env"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	list, err := cast.ToSliceE(raw)
+	if err != nil {
+		return nil, fmt.Errorf("env has to be a list of KEY=VALUE strings: %s", err.Error())
+	}
+	env := []string{}
+	for _, item := range list {
+		pair := cast.ToString(item)
+		if !strings.Contains(pair, "=") || strings.HasPrefix(pair, "=") {
+			return nil, fmt.Errorf("env variable '%s' is not in KEY=VALUE form", pair)
+		}
+		env = append(env, pair)
+	}
+	return env, nil
+}
+
+// pluginEnvNames returns the names of the env variables, so the values are not logged
+func pluginEnvNames(env []string) []string {
+	names := []string{}
+	for _, pair := range env {
+		names = append(names, strings.SplitN(pair, "=", 2)[0])
+	}
+	return names
+}
+
 func (v *vault) configureSecretEngines(config *viper.Viper) error {
 	secretsEngines := []map[string]interface{}{}
 	err := config.UnmarshalKey("secrets", &secretsEngines)
@@ -890,16 +1876,34 @@ func (v *vault) configureSecretEngines(config *viper.Viper) error {
 			}
 		}
 
+		if builtinMounts[secretEngineType] && path == secretEngineType {
+			err = v.tuneBuiltinMount(path, secretEngine)
+			if err != nil {
+				return fmt.Errorf("error tuning %s in vault: %s", path, err.Error())
+			}
+			continue
+		}
+
+		description, err := getOrDefaultString(secretEngine, "description")
+		if err != nil {
+			return fmt.Errorf("error getting description for secret engine: %s", err.Error())
+		}
+		description, err = v.mountDescription(description, path, secretEngineType)
+		if err != nil {
+			return fmt.Errorf("error rendering description for secret engine: %s", err.Error())
+		}
+
+		pluginVersion, err := getOrDefaultString(secretEngine, "plugin_version")
+		if err != nil {
+			return fmt.Errorf("error getting plugin_version for secret engine: %s", err.Error())
+		}
+
 		mounts, err := v.cl.Sys().ListMounts()
 		if err != nil {
 			return fmt.Errorf("error reading mounts from vault: %s", err.Error())
 		}
 		logrus.Infof("Already existing mounts: %#v\n", mounts)
 		if mounts[path+"/"] == nil {
-			description, err := getOrDefaultString(secretEngine, "description")
-			if err != nil {
-				return fmt.Errorf("error getting description for secret engine: %s", err.Error())
-			}
 			pluginName, err := getOrDefaultString(secretEngine, "plugin_name")
 			if err != nil {
 				return fmt.Errorf("error getting plugin_name for secret engine: %s", err.Error())
@@ -933,16 +1937,43 @@ func (v *vault) configureSecretEngines(config *viper.Viper) error {
 
 			logrus.Infoln("mounted", secretEngineType, "to", path)
 
+			extra, err := getMountTuneExtras(secretEngine)
+			if err != nil {
+				return err
+			}
+			if len(extra) > 0 {
+				// e.g. allowed_managed_keys can't be sent in the mount request of this client version
+				err = v.tuneMountWithPluginVersion(path, config, "", extra)
+				if err != nil {
+					return fmt.Errorf("error tuning %s in vault: %s", path, err.Error())
+				}
+			}
+
+			if pluginVersion != "" {
+				err = v.upgradePluginVersion(path, pluginVersion)
+				if err != nil {
+					return fmt.Errorf("error upgrading plugin of %s: %s", path, err.Error())
+				}
+			}
 		} else {
 			logrus.Infof("Tuning already existing mount: %s/\n", path)
 			config, err := getMountConfigInput(secretEngine)
 			if err != nil {
 				return err
 			}
-			err = v.cl.Sys().TuneMount(path, config)
+			config.Description = &description
+			extra, err := getMountTuneExtras(secretEngine)
+			if err != nil {
+				return err
+			}
+			err = v.tuneMountWithPluginVersion(path, config, pluginVersion, extra)
 			if err != nil {
 				return fmt.Errorf("error tuning %s in vault: %s", path, err.Error())
 			}
+			err = v.reconcileAuditNonHMACKeys(path, config)
+			if err != nil {
+				return fmt.Errorf("error tuning audit keys of %s in vault: %s", path, err.Error())
+			}
 		}
 
 		// Configuration of the Secret Engine in a very generic manner, YAML config file should have the proper format
@@ -950,6 +1981,12 @@ func (v *vault) configureSecretEngines(config *viper.Viper) error {
 		if err != nil {
 			return fmt.Errorf("error getting configuration for secret engine: %s", err.Error())
 		}
+		if secretEngineType == "pki" {
+			configuration, err = v.pkiConfiguration(path, configuration)
+			if err != nil {
+				return fmt.Errorf("error checking pki configuration for %s: %s", path, err.Error())
+			}
+		}
 		for configOption, configData := range configuration {
 			configData, err := cast.ToSliceE(configData)
 			if err != nil {
@@ -976,6 +2013,13 @@ func (v *vault) configureSecretEngines(config *viper.Viper) error {
 					}
 				}
 
+				if secretEngineType == "database" && configOption == "roles" {
+					err = validateDatabaseRole(subConfigData)
+					if err != nil {
+						return fmt.Errorf("error validating database role %v of %s: %s", name, path, err.Error())
+					}
+				}
+
 				var configPath string
 				if name != nil {
 					configPath = fmt.Sprintf("%s/%s/%s", path, configOption, name)
@@ -993,71 +2037,734 @@ func (v *vault) configureSecretEngines(config *viper.Viper) error {
 				}
 			}
 		}
-	}
-
-	return nil
-}
-
-func (v *vault) configureAuditDevices(config *viper.Viper) error {
-	auditDevices := []map[string]interface{}{}
-	err := config.UnmarshalKey("audit", &auditDevices)
-	if err != nil {
-		return fmt.Errorf("error unmarshalling audit devices config: %s", err.Error())
-	}
 
-	for _, auditDevice := range auditDevices {
-		auditDeviceType, err := cast.ToStringE(auditDevice["type"])
+		err = v.configureMountLimits(path, secretEngineType, secretEngine)
 		if err != nil {
-			return fmt.Errorf("error finding type for audit device: %s", err.Error())
+			return fmt.Errorf("error configuring limits of %s: %s", path, err.Error())
 		}
 
-		path := auditDeviceType
-		if pathOverwrite, ok := auditDevice["path"]; ok {
-			path, err = cast.ToStringE(pathOverwrite)
+		if secretEngineType == "pki" {
+			err = v.configurePKIIntermediate(path, secretEngine)
 			if err != nil {
-				return fmt.Errorf("error converting path for audit device: %s", err.Error())
+				return fmt.Errorf("error configuring pki intermediate for %s: %s", path, err.Error())
 			}
-		}
-
-		mounts, err := v.cl.Sys().ListAudit()
-		if err != nil {
-			return fmt.Errorf("error reading audit mounts from vault: %s", err.Error())
-		}
-
-		logrus.Infof("Already existing audit devices: %#v\n", mounts)
-
-		if mounts[path+"/"] == nil {
-			var options api.EnableAuditOptions
-			err = mapstructure.Decode(auditDevice, &options)
+			err = v.configurePKIExternalPolicy(path, secretEngine)
 			if err != nil {
-				return fmt.Errorf("error parsing audit options: %s", err.Error())
+				return fmt.Errorf("error configuring pki external policy for %s: %s", path, err.Error())
 			}
-			logrus.Infof("Enabling audit device with options: %#v\n", options)
-			err = v.cl.Sys().EnableAuditWithOptions(path, &options)
+			err = v.configurePKIIssuers(path, secretEngine)
 			if err != nil {
-				return fmt.Errorf("error enabling audit device %s in vault: %s", path, err.Error())
+				return fmt.Errorf("error configuring pki issuers for %s: %s", path, err.Error())
 			}
+		}
 
-			logrus.Infoln("mounted audit device", auditDeviceType, "to", path)
+		if secretEngineType == "kmip" {
+			err = v.configureKMIPScopes(path, secretEngine)
+			if err != nil {
+				return fmt.Errorf("error configuring kmip scopes for %s: %s", path, err.Error())
+			}
+		}
 
-		} else {
-			logrus.Infof("audit device is already mounted: %s/\n", path)
+		if secretEngineType == "transit" {
+			err = v.configureTransitKeys(path, secretEngine)
+			if err != nil {
+				return fmt.Errorf("error configuring transit keys for %s: %s", path, err.Error())
+			}
 		}
 	}
 
+	// the paths are stored even without pruning, so the mounts of this config file are kept
+	// when another config file is pruned
+	managedPaths, err := v.managedMountPaths(config, "secrets", v.managedSecretsKey())
+	if err != nil {
+		return err
+	}
+	if v.config.PruneSecrets {
+		return v.pruneSecretEngines(managedPaths)
+	}
+
 	return nil
 }
 
-func (v *vault) configureStartupSecrets(config *viper.Viper) error {
-	raw := config.Get("startupSecrets")
-	startupSecrets, err := toSliceStringMapE(raw)
+// configureKMIPScopes creates the missing scopes of a KMIP secret engine (Enterprise) and
+// writes their roles, the "operations" list of a role is translated to operation_<name> flags
+func (v *vault) configureKMIPScopes(path string, secretEngine map[string]interface{}) error {
+	scopes, err := toNormalizedSliceStringMapE(secretEngine["scopes"])
 	if err != nil {
-		return fmt.Errorf("error decoding data for startup secrets: %s", err.Error())
+		return fmt.Errorf("error finding scopes block for kmip: %s", err.Error())
 	}
-	for _, startupSecret := range startupSecrets {
-		startupSecretType, err := cast.ToStringE(startupSecret["type"])
+	if len(scopes) == 0 {
+		return nil
+	}
+
+	// https://www.vaultproject.io/docs/secrets/kmip/index.html
+	existingScopes := map[string]bool{}
+	secret, err := v.cl.Logical().List(fmt.Sprintf("%s/scope", path))
+	if err != nil {
+		return fmt.Errorf("error listing kmip scopes: %s", err.Error())
+	}
+	if secret != nil && secret.Data != nil {
+		keys, err := getOrDefaultStringSlice(secret.Data, "keys")
 		if err != nil {
-			return fmt.Errorf("error finding type for startup secret: %s", err.Error())
+			return fmt.Errorf("error parsing kmip scopes: %s", err.Error())
+		}
+		for _, key := range keys {
+			existingScopes[key] = true
+		}
+	}
+
+	for _, scope := range scopes {
+		scopeName, err := getOrError(scope, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for kmip scope: %s", err.Error())
+		}
+
+		if !existingScopes[scopeName] {
+			_, err = v.cl.Logical().Write(fmt.Sprintf("%s/scope/%s", path, scopeName), nil)
+			if err != nil {
+				return fmt.Errorf("error creating kmip scope %s: %s", scopeName, err.Error())
+			}
+		}
+
+		roles, err := toNormalizedSliceStringMapE(scope["roles"])
+		if err != nil {
+			return fmt.Errorf("error finding roles block for kmip scope %s: %s", scopeName, err.Error())
+		}
+		for _, role := range roles {
+			roleName, err := getOrError(role, "name")
+			if err != nil {
+				return fmt.Errorf("error getting name for kmip role: %s", err.Error())
+			}
+
+			operations, err := getOrDefaultStringSlice(role, "operations")
+			if err != nil {
+				return fmt.Errorf("error getting operations for kmip role %s: %s", roleName, err.Error())
+			}
+
+			data := copyWithout(role, "name", "operations")
+			for _, operation := range operations {
+				data["operation_"+operation] = true
+			}
+
+			_, err = v.cl.Logical().Write(fmt.Sprintf("%s/scope/%s/role/%s", path, scopeName, roleName), data)
+			if err != nil {
+				return fmt.Errorf("error putting %s kmip role into vault: %s", roleName, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// reconcileAuditNonHMACKeys tunes the audit_non_hmac_request_keys and audit_non_hmac_response_keys
+// of an existing mount if they differ from the configured ones, the tune request of the client
+// omits the empty lists, so the removed keys wouldn't be cleared otherwise
+func (v *vault) reconcileAuditNonHMACKeys(path string, config api.MountConfigInput) error {
+	tune, err := v.cl.Logical().Read(fmt.Sprintf("sys/mounts/%s/tune", path))
+	if err != nil {
+		return fmt.Errorf("error reading mount tune: %s", err.Error())
+	}
+	var current map[string]interface{}
+	if tune != nil {
+		current = tune.Data
+	}
+
+	if sameKeys(cast.ToStringSlice(current["audit_non_hmac_request_keys"]), config.AuditNonHMACRequestKeys) &&
+		sameKeys(cast.ToStringSlice(current["audit_non_hmac_response_keys"]), config.AuditNonHMACResponseKeys) {
+		return nil
+	}
+
+	logrus.Infof("tuning the audit non-HMAC keys of %s", path)
+	_, err = v.cl.Logical().Write(fmt.Sprintf("sys/mounts/%s/tune", path), map[string]interface{}{
+		"audit_non_hmac_request_keys":  append([]string{}, config.AuditNonHMACRequestKeys...),
+		"audit_non_hmac_response_keys": append([]string{}, config.AuditNonHMACResponseKeys...),
+	})
+	return err
+}
+
+// sameKeys returns true if the two lists hold the same keys in any order
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, key := range a {
+		counts[key]++
+	}
+	for _, key := range b {
+		counts[key]--
+		if counts[key] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// upgradePluginVersion pins the plugin backing the mount to pluginVersion if it runs another version:
+// the mount is tuned to the new version first, then its backend is reloaded to start it
+func (v *vault) upgradePluginVersion(path, pluginVersion string) error {
+	outdated, err := v.pluginVersionOutdated(path, pluginVersion)
+	if err != nil || !outdated {
+		return err
+	}
+
+	logrus.Infof("upgrading the plugin of %s to version %s", path, pluginVersion)
+	_, err = v.cl.Logical().Write(fmt.Sprintf("sys/mounts/%s/tune", path), map[string]interface{}{"plugin_version": pluginVersion})
+	if err != nil {
+		return fmt.Errorf("error tuning plugin_version: %s", err.Error())
+	}
+	return v.reloadPluginBackend(path)
+}
+
+// tuneMountWithPluginVersion tunes the config of an existing mount, and its plugin_version in the
+// same request if the mount runs another version, reloading its backend afterwards. This way e.g.
+// listing_visibility and plugin_version are reconciled together on every run. The extra fields
+// (e.g. allowed_managed_keys) are sent along with the config.
+func (v *vault) tuneMountWithPluginVersion(path string, config api.MountConfigInput, pluginVersion string, extra map[string]interface{}) error {
+	outdated := false
+	if pluginVersion != "" {
+		var err error
+		outdated, err = v.pluginVersionOutdated(path, pluginVersion)
+		if err != nil {
+			return err
+		}
+	}
+	if !outdated && len(extra) == 0 {
+		return v.cl.Sys().TuneMount(path, config)
+	}
+
+	// the plugin_version and the extra fields are not part of the tune API types of this client version
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error encoding mount tune: %s", err.Error())
+	}
+	data := map[string]interface{}{}
+	err = json.Unmarshal(encoded, &data)
+	if err != nil {
+		return fmt.Errorf("error encoding mount tune: %s", err.Error())
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+	if !outdated {
+		_, err = v.cl.Logical().Write(fmt.Sprintf("sys/mounts/%s/tune", path), data)
+		return err
+	}
+	data["plugin_version"] = pluginVersion
+
+	logrus.Infof("upgrading the plugin of %s to version %s", path, pluginVersion)
+	_, err = v.cl.Logical().Write(fmt.Sprintf("sys/mounts/%s/tune", path), data)
+	if err != nil {
+		return err
+	}
+	return v.reloadPluginBackend(path)
+}
+
+// getMountTuneExtras returns the fields of the config block of the secret engine which
+// the tune API types of this client version don't know about
+func getMountTuneExtras(secretEngine map[string]interface{}) (map[string]interface{}, error) {
+	config, err := getOrDefaultStringMap(secretEngine, "config")
+	if err != nil {
+		return nil, fmt.Errorf("error getting config for secret engine: %s", err.Error())
+	}
+	extra := map[string]interface{}{}
+	if value, ok := config["allowed_managed_keys"]; ok {
+		// managed keys are named in Vault Enterprise, a single key can be given as a string
+		if name, ok := value.(string); ok {
+			value = strings.Split(name, ",")
+		}
+		keys, err := cast.ToStringSliceE(value)
+		if err != nil {
+			return nil, fmt.Errorf("error getting allowed_managed_keys for secret engine: %s", err.Error())
+		}
+		extra["allowed_managed_keys"] = keys
+	}
+	return extra, nil
+}
+
+// pluginVersionOutdated tells if the plugin backing the mount runs another version than pluginVersion
+func (v *vault) pluginVersionOutdated(path, pluginVersion string) (bool, error) {
+	tune, err := v.cl.Logical().Read(fmt.Sprintf("sys/mounts/%s/tune", path))
+	if err != nil {
+		return false, fmt.Errorf("error reading mount tune: %s", err.Error())
+	}
+	return tune == nil || tune.Data == nil || cast.ToString(tune.Data["plugin_version"]) != pluginVersion, nil
+}
+
+func (v *vault) reloadPluginBackend(path string) error {
+	// https://www.vaultproject.io/api/system/plugins-reload-backend.html
+	_, err := v.cl.Logical().Write("sys/plugins/reload/backend", map[string]interface{}{"mounts": []string{path}})
+	if err != nil {
+		return fmt.Errorf("error reloading plugin backend: %s", err.Error())
+	}
+	return nil
+}
+
+// revokeLeasePrefix revokes all the leases under prefix before its mount is disabled,
+// if RevokeLeasesOnDisable is set, so that no dangling leases are left behind
+func (v *vault) revokeLeasePrefix(prefix string) error {
+	if !v.config.RevokeLeasesOnDisable {
+		return nil
+	}
+
+	logrus.Infof("revoking the leases of %s", prefix)
+	_, err := v.cl.Logical().Write(fmt.Sprintf("sys/leases/revoke-prefix/%s", strings.TrimSuffix(prefix, "/")), nil)
+	if err != nil {
+		return fmt.Errorf("error revoking the leases of %s: %s", prefix, err.Error())
+	}
+	return nil
+}
+
+// pruneSecretEngines unmounts the secret engines which were mounted by bank-vaults (their
+// description carries the managed marker) but are not present in managedPaths anymore, which
+// holds the paths of every config file
+func (v *vault) pruneSecretEngines(managedPaths map[string]bool) error {
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("error reading mounts from vault: %s", err.Error())
+	}
+
+	for path, mount := range mounts {
+		if managedPaths[path] || !isManagedDescription(mount.Description) {
+			continue
+		}
+
+		logrus.Infof("unmounting secret engine %s which is not present in the configuration anymore", path)
+		err = v.revokeLeasePrefix(path)
+		if err != nil {
+			return err
+		}
+		err = v.cl.Sys().Unmount(path)
+		if err != nil {
+			return fmt.Errorf("error unmounting %s from vault: %s", path, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// mountDescription renders the description of a managed mount, the managed marker (and the
+// managed metadata if any) is always part of the result, even if the template omits it
+func (v *vault) mountDescription(description, path, mountType string) (string, error) {
+	metadata := v.managedMetadataString()
+
+	descriptionTemplate := v.config.MountDescriptionTemplate
+	if descriptionTemplate == "" {
+		descriptionTemplate = DefaultMountDescriptionTemplate
+	}
+
+	tpl, err := template.New("description").Parse(descriptionTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = tpl.Execute(buffer, mountDescriptionData{
+		Description: description,
+		Path:        path,
+		Type:        mountType,
+		Marker:      managedMarker,
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	result := strings.TrimSpace(buffer.String())
+	if !isManagedDescription(result) {
+		result = strings.TrimSpace(fmt.Sprintf("%s [%s]", result, managedMarker))
+	}
+	if metadata != "" && !strings.Contains(result, metadata) {
+		result = fmt.Sprintf("%s [%s]", result, metadata)
+	}
+
+	return result, nil
+}
+
+func isManagedDescription(description string) bool {
+	return strings.Contains(description, managedMarker)
+}
+
+func (v *vault) configureAuditDevices(config *viper.Viper) error {
+	auditDevices := []map[string]interface{}{}
+	err := config.UnmarshalKey("audit", &auditDevices)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling audit devices config: %s", err.Error())
+	}
+
+	excludedMounts, err := auditExcludedMounts(config)
+	if err != nil {
+		return err
+	}
+
+	err = checkAuditFallback(auditDevices)
+	if err != nil {
+		return err
+	}
+
+	var auditFilterSupported *bool
+
+	for _, auditDevice := range auditDevices {
+		auditDeviceType, err := cast.ToStringE(auditDevice["type"])
+		if err != nil {
+			return fmt.Errorf("error finding type for audit device: %s", err.Error())
+		}
+
+		path := auditDeviceType
+		if pathOverwrite, ok := auditDevice["path"]; ok {
+			path, err = cast.ToStringE(pathOverwrite)
+			if err != nil {
+				return fmt.Errorf("error converting path for audit device: %s", err.Error())
+			}
+		}
+
+		mounts, err := v.cl.Sys().ListAudit()
+		if err != nil {
+			return fmt.Errorf("error reading audit mounts from vault: %s", err.Error())
+		}
+
+		logrus.Infof("Already existing audit devices: %#v\n", mounts)
+
+		if mounts[path+"/"] == nil {
+			var options api.EnableAuditOptions
+			err = mapstructure.Decode(copyWithout(auditDevice, "options"), &options)
+			if err != nil {
+				return fmt.Errorf("error parsing audit options: %s", err.Error())
+			}
+			options.Options, err = auditDeviceOptions(auditDevice["options"])
+			if err != nil {
+				return fmt.Errorf("error parsing options of audit device %s: %s", path, err.Error())
+			}
+			if auditDeviceType == "socket" {
+				err = validateSocketAuditOptions(options.Options)
+				if err != nil {
+					return fmt.Errorf("error validating socket audit device %s: %s", path, err.Error())
+				}
+			}
+			if fallback, _ := strconv.ParseBool(options.Options["fallback"]); fallback {
+				// the fallback audit device needs Vault Enterprise 1.16+
+				supported, err := enterpriseAtLeast(v.cl, 1, 16)
+				if err != nil {
+					return err
+				}
+				if !supported {
+					logrus.Warnf("fallback audit devices need Vault Enterprise 1.16+, enabling %s as a regular audit device", path)
+					delete(options.Options, "fallback")
+				}
+			}
+			if len(excludedMounts) > 0 {
+				if auditFilterSupported == nil {
+					supported, err := v.enterprise()
+					if err != nil {
+						return err
+					}
+					auditFilterSupported = &supported
+				}
+				if *auditFilterSupported {
+					if options.Options == nil {
+						options.Options = map[string]string{}
+					}
+					options.Options["filter"] = auditExclusionFilter(options.Options["filter"], excludedMounts)
+				} else {
+					logrus.Warnf("audit filters need Vault Enterprise, not excluding %v from audit device %s", excludedMounts, path)
+				}
+			}
+			logrus.Infof("Enabling audit device with options: %#v\n", options)
+			err = v.cl.Sys().EnableAuditWithOptions(path, &options)
+			if err != nil {
+				return fmt.Errorf("error enabling audit device %s in vault: %s", path, err.Error())
+			}
+
+			logrus.Infoln("mounted audit device", auditDeviceType, "to", path)
+
+		} else {
+			logrus.Infof("audit device is already mounted: %s/\n", path)
+		}
+	}
+
+	return nil
+}
+
+// builtinMounts are the types of the secret engines Vault mounts itself at the path of their type,
+// they can't be mounted, unmounted or marked as managed, only their config can be tuned
+var builtinMounts = map[string]bool{
+	"cubbyhole": true,
+	"identity":  true,
+}
+
+// tuneBuiltinMount tunes the config (e.g. the lease TTLs) of a built-in mount, the description is
+// only changed if it is set. Vault versions which refuse to tune the built-in mounts are skipped.
+func (v *vault) tuneBuiltinMount(path string, secretEngine map[string]interface{}) error {
+	config, err := getMountConfigInput(secretEngine)
+	if err != nil {
+		return err
+	}
+	description, err := getOrDefaultString(secretEngine, "description")
+	if err != nil {
+		return fmt.Errorf("error getting description for secret engine: %s", err.Error())
+	}
+	if description != "" {
+		config.Description = &description
+	}
+
+	logrus.Infof("tuning the built-in mount: %s/", path)
+	err = v.cl.Sys().TuneMount(path, config)
+	if err != nil {
+		if isBadRequestError(err) {
+			logrus.Warnf("vault doesn't support tuning the built-in %s mount, skipping it: %s", path, err.Error())
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// wrappingDefaultsTuneKeys maps the supported wrappingDefaults to the cubbyhole mount tune
+// parameters, response-wrapping tokens store the wrapped responses in their cubbyhole
+var wrappingDefaultsTuneKeys = map[string]string{
+	"default_ttl": "default_lease_ttl",
+	"max_ttl":     "max_lease_ttl",
+}
+
+// configureWrappingDefaults applies the "wrappingDefaults" by tuning the cubbyhole mount,
+// the wrap TTL itself is always requested by the clients, so unsupported settings are skipped
+func (v *vault) configureWrappingDefaults(config *viper.Viper) error {
+	if !config.IsSet("wrappingDefaults") {
+		return nil
+	}
+
+	wrappingDefaults, err := cast.ToStringMapE(normalizeConfigValue(config.Get("wrappingDefaults")))
+	if err != nil {
+		return fmt.Errorf("error decoding wrappingDefaults config: %s", err.Error())
+	}
+
+	tune := map[string]interface{}{}
+	for key, value := range wrappingDefaults {
+		tuneKey, ok := wrappingDefaultsTuneKeys[key]
+		if !ok {
+			logrus.Warnf("wrapping default %s is not supported by vault, skipping it", key)
+			continue
+		}
+		tune[tuneKey] = value
+	}
+
+	if len(tune) == 0 {
+		return nil
+	}
+
+	// https://www.vaultproject.io/api/system/mounts.html#tune-mount-configuration
+	_, err = v.cl.Logical().Write("sys/mounts/cubbyhole/tune", tune)
+	if err != nil {
+		if isBadRequestError(err) {
+			logrus.Warnf("vault doesn't support tuning the wrapping defaults, skipping them: %s", err.Error())
+			return nil
+		}
+		return fmt.Errorf("error tuning the cubbyhole mount: %s", err.Error())
+	}
+
+	return nil
+}
+
+// auditExcludedMounts returns the mount points of the secret engines and auth methods
+// which have "audit_excluded: true" set, these are filtered out of the audit devices
+func auditExcludedMounts(config *viper.Viper) ([]string, error) {
+	var excludedMounts []string
+
+	for _, section := range []string{"auth", "secrets"} {
+		mounts := []map[string]interface{}{}
+		err := config.UnmarshalKey(section, &mounts)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling vault %s config: %s", section, err.Error())
+		}
+
+		for _, mount := range mounts {
+			excluded, err := getOrDefaultBool(mount, "audit_excluded")
+			if err != nil {
+				return nil, fmt.Errorf("error getting audit_excluded for %s: %s", section, err.Error())
+			}
+			if !excluded {
+				continue
+			}
+
+			path, err := getOrDefaultString(mount, "path")
+			if err != nil {
+				return nil, fmt.Errorf("error getting path for %s: %s", section, err.Error())
+			}
+			if path == "" {
+				path, err = getOrError(mount, "type")
+				if err != nil {
+					return nil, fmt.Errorf("error getting type for %s: %s", section, err.Error())
+				}
+			}
+
+			mountPoint := strings.Trim(path, "/") + "/"
+			if section == "auth" {
+				mountPoint = "auth/" + mountPoint
+			}
+			excludedMounts = append(excludedMounts, mountPoint)
+		}
+	}
+
+	return excludedMounts, nil
+}
+
+// auditExclusionFilter extends the audit device filter expression (Vault Enterprise)
+// so that requests to the excluded mount points are not audited
+func auditExclusionFilter(filter string, excludedMounts []string) string {
+	var conditions []string
+	for _, mountPoint := range excludedMounts {
+		conditions = append(conditions, fmt.Sprintf("mount_point != %q", mountPoint))
+	}
+	exclusion := strings.Join(conditions, " and ")
+
+	if filter == "" {
+		return exclusion
+	}
+	return fmt.Sprintf("(%s) and %s", filter, exclusion)
+}
+
+// The editions of Vault returned by Edition
+const (
+	EditionCE         = "ce"
+	EditionEnterprise = "enterprise"
+)
+
+// Edition detects the edition of the Vault server (ce or enterprise) from the version reported
+// by sys/health, which is available even if Vault is sealed or not initialized yet
+func Edition(cl *api.Client) (string, error) {
+	enterprise, err := enterpriseAtLeast(cl, 0, 0)
+	if err != nil {
+		return "", err
+	}
+	if enterprise {
+		return EditionEnterprise, nil
+	}
+	return EditionCE, nil
+}
+
+// enterpriseAtLeast returns true if the Vault server is an Enterprise version, at least major.minor,
+// the version is the one reported by sys/health
+func enterpriseAtLeast(cl *api.Client, major, minor int) (bool, error) {
+	health, err := cl.Sys().Health()
+	if err != nil {
+		return false, fmt.Errorf("error checking health: %s", err.Error())
+	}
+	return strings.Contains(health.Version, "+ent") && versionAtLeast(health.Version, major, minor), nil
+}
+
+// enterprise returns true if the Vault server is an Enterprise version
+func (v *vault) enterprise() (bool, error) {
+	return enterpriseAtLeast(v.cl, 0, 0)
+}
+
+// auditDeviceOptions converts the options of an audit device to strings, as the API expects them
+// (e.g. log_raw: true), and validates the common options of the audit devices
+func auditDeviceOptions(rawOptions interface{}) (map[string]string, error) {
+	if rawOptions == nil {
+		return nil, nil
+	}
+	rawOptionsMap, err := cast.ToStringMapE(rawOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]string, len(rawOptionsMap))
+	for key, value := range rawOptionsMap {
+		options[key], err = cast.ToStringE(value)
+		if err != nil {
+			return nil, fmt.Errorf("error converting option %s: %s", key, err.Error())
+		}
+	}
+
+	// https://www.vaultproject.io/docs/audit/index.html#common-configuration-options
+	for _, key := range []string{"log_raw", "hmac_accessor", "elide_list_responses", "fallback"} {
+		if value, ok := options[key]; ok {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid %s option %s, should be true or false", key, value)
+			}
+		}
+	}
+	switch format := options["format"]; format {
+	case "", "json", "jsonx":
+	default:
+		return nil, fmt.Errorf("unsupported format %s, should be json or jsonx", format)
+	}
+
+	return options, nil
+}
+
+// checkAuditFallback checks that at most one audit device is the fallback device (Enterprise 1.16+),
+// which receives the entries not matching the filter of any other device
+func checkAuditFallback(auditDevices []map[string]interface{}) error {
+	var fallbacks []string
+	for _, auditDevice := range auditDevices {
+		options, err := cast.ToStringMapE(auditDevice["options"])
+		if err != nil {
+			continue
+		}
+		if fallback, _ := cast.ToBoolE(options["fallback"]); fallback {
+			path := cast.ToString(auditDevice["path"])
+			if path == "" {
+				path = cast.ToString(auditDevice["type"])
+			}
+			fallbacks = append(fallbacks, path)
+		}
+	}
+	if len(fallbacks) > 1 {
+		return fmt.Errorf("only one audit device can be the fallback device, got %s", strings.Join(fallbacks, ", "))
+	}
+	return nil
+}
+
+// validateSocketAuditOptions checks the options of a socket audit device, the address
+// has to be a host:port of a tcp (default) or udp endpoint (e.g. a log collector sidecar),
+// the format json (default) or jsonx and the write_timeout (after which Vault reconnects) a duration
+func validateSocketAuditOptions(options map[string]string) error {
+	socketType := options["socket_type"]
+	switch socketType {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("unsupported socket_type %s, should be tcp or udp", socketType)
+	}
+
+	format := options["format"]
+	switch format {
+	case "", "json", "jsonx":
+	default:
+		return fmt.Errorf("unsupported format %s, should be json or jsonx", format)
+	}
+
+	if writeTimeout, ok := options["write_timeout"]; ok {
+		if _, err := time.ParseDuration(writeTimeout); err != nil {
+			return fmt.Errorf("invalid write_timeout %s: %s", writeTimeout, err.Error())
+		}
+	}
+
+	address, ok := options["address"]
+	if !ok || address == "" {
+		return fmt.Errorf("address option is required")
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %s", address, err.Error())
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port in address %s", address)
+	}
+
+	return nil
+}
+
+func (v *vault) configureStartupSecrets(config *viper.Viper) error {
+	startupSecrets, err := toNormalizedSliceStringMapE(config.Get("startupSecrets"))
+	if err != nil {
+		return fmt.Errorf("error decoding data for startup secrets: %s", err.Error())
+	}
+	for _, startupSecret := range startupSecrets {
+		startupSecretType, err := cast.ToStringE(startupSecret["type"])
+		if err != nil {
+			return fmt.Errorf("error finding type for startup secret: %s", err.Error())
 		}
 
 		switch startupSecretType {
@@ -1077,6 +2784,28 @@ func (v *vault) configureStartupSecrets(config *viper.Viper) error {
 				return fmt.Errorf("error writing data for startup secret '%s': %s", path, err.Error())
 			}
 
+			customMetadata, err := getOrDefaultStringMap(startupSecret, "custom_metadata")
+			if err != nil {
+				return fmt.Errorf("error getting custom_metadata for startup secret '%s': %s", path, err.Error())
+			}
+			if len(v.config.ManagedMetadata) > 0 {
+				// without a custom_metadata the stamp is only written to KV version 2 secrets
+				if _, ok := startupSecret["custom_metadata"]; ok {
+					customMetadata, err = v.withManagedMetadata(customMetadata)
+				} else if _, _, kvErr := v.kvV2MountAndKey(path, "custom_metadata"); kvErr == nil {
+					customMetadata, err = v.withManagedMetadata(nil)
+				}
+				if err != nil {
+					return fmt.Errorf("error stamping custom_metadata of startup secret '%s': %s", path, err.Error())
+				}
+			}
+			if len(customMetadata) > 0 {
+				err = v.writeKVCustomMetadata(path, customMetadata)
+				if err != nil {
+					return fmt.Errorf("error writing custom_metadata for startup secret '%s': %s", path, err.Error())
+				}
+			}
+
 		default:
 			return errors.New("other startup secret type than 'kv' is not supported yet")
 		}
@@ -1084,14 +2813,97 @@ func (v *vault) configureStartupSecrets(config *viper.Viper) error {
 	return nil
 }
 
-// toSliceStringMapE casts []map[string]interface{} preserving nested types
-func toSliceStringMapE(o interface{}) ([]map[string]interface{}, error) {
-	data, err := json.Marshal(o)
+// writeKVCustomMetadata writes the custom_metadata of a KV version 2 secret given by its data path
+// (<mount>/data/<key>) through the metadata endpoint (<mount>/metadata/<key>)
+func (v *vault) writeKVCustomMetadata(dataPath string, customMetadata map[string]interface{}) error {
+	mountPath, key, err := v.kvV2MountAndKey(dataPath, "custom_metadata")
+	if err != nil {
+		return err
+	}
+
+	metadata, err := cast.ToStringMapStringE(customMetadata)
+	if err != nil {
+		return fmt.Errorf("error converting custom_metadata, the values have to be strings: %s", err.Error())
+	}
+
+	// https://www.vaultproject.io/api-docs/secret/kv/kv-v2#create-update-metadata
+	metadataPath := mountPath + "metadata/" + key
+	_, err = v.cl.Logical().Write(metadataPath, map[string]interface{}{"custom_metadata": metadata})
+	return err
+}
+
+// kvV2MountAndKey splits the data path (<mount>/data/<key>) of a KV version 2 secret into the
+// mount path (with a trailing slash) and the key, feature names what needs KV version 2
+func (v *vault) kvV2MountAndKey(dataPath, feature string) (string, string, error) {
+	mounts, err := v.cl.Sys().ListMounts()
+	if err != nil {
+		return "", "", fmt.Errorf("error reading mounts from vault: %s", err.Error())
+	}
+
+	// the longest mount path containing the secret
+	mountPath := ""
+	for path, mount := range mounts {
+		if strings.HasPrefix(dataPath, path) && len(path) > len(mountPath) && mount.Type == "kv" {
+			mountPath = path
+		}
+	}
+	if mountPath == "" || mounts[mountPath].Options["version"] != "2" {
+		return "", "", fmt.Errorf("%s is only supported by the secrets of KV version 2 mounts", feature)
+	}
+
+	key := strings.TrimPrefix(dataPath, mountPath)
+	if !strings.HasPrefix(key, "data/") {
+		return "", "", fmt.Errorf("the path of a KV version 2 secret has to be %sdata/<key>", mountPath)
+	}
+	return mountPath, strings.TrimPrefix(key, "data/"), nil
+}
+
+// normalizeConfigValue converts the map[interface{}]interface{} values created by the YAML parser
+// recursively to map[string]interface{}, so they can be sent to Vault as JSON
+func normalizeConfigValue(o interface{}) interface{} {
+	switch value := o.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[cast.ToString(k)] = normalizeConfigValue(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[k] = normalizeConfigValue(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(value))
+		for i, v := range value {
+			s[i] = normalizeConfigValue(v)
+		}
+		return s
+	default:
+		return o
+	}
+}
+
+// toNormalizedSliceStringMapE casts a list of config blocks to []map[string]interface{}
+// with normalized nested values
+func toNormalizedSliceStringMapE(o interface{}) ([]map[string]interface{}, error) {
+	if o == nil {
+		return []map[string]interface{}{}, nil
+	}
+	slice, err := cast.ToSliceE(normalizeConfigValue(o))
 	if err != nil {
 		return nil, err
 	}
-	var sm []map[string]interface{}
-	return sm, json.Unmarshal(data, &sm)
+	result := make([]map[string]interface{}, 0, len(slice))
+	for _, item := range slice {
+		m, err := cast.ToStringMapE(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, nil
 }
 
 func getOrDefaultBool(m map[string]interface{}, key string) (bool, error) {
@@ -1118,6 +2930,14 @@ func getOrDefaultStringMapString(m map[string]interface{}, key string) (map[stri
 	return map[string]string{}, nil
 }
 
+func getOrDefaultStringSlice(m map[string]interface{}, key string) ([]string, error) {
+	value := m[key]
+	if value != nil {
+		return cast.ToStringSliceE(value)
+	}
+	return []string{}, nil
+}
+
 func getOrDefaultStringMap(m map[string]interface{}, key string) (map[string]interface{}, error) {
 	value := m[key]
 	if value != nil {
@@ -1138,14 +2958,23 @@ func isOverwriteProhibitedError(err error) bool {
 	return strings.Contains(err.Error(), "delete them before reconfiguring")
 }
 
+func isBadRequestError(err error) bool {
+	return strings.Contains(err.Error(), "Code: 400")
+}
+
 func getMountConfigInput(secretEngine map[string]interface{}) (api.MountConfigInput, error) {
 	var mountConfigInput api.MountConfigInput
 
-	config, err := getOrDefaultStringMapString(secretEngine, "config")
+	config, err := getOrDefaultStringMap(secretEngine, "config")
 	if err != nil {
 		return mountConfigInput, fmt.Errorf("error getting config for secret engine: %s", err.Error())
 	}
-	err = mapstructure.Decode(config, &mountConfigInput)
+	// weakly typed, so e.g. force_no_cache: true and max_lease_ttl: 3600 are both accepted
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{WeaklyTypedInput: true, Result: &mountConfigInput})
+	if err != nil {
+		return mountConfigInput, fmt.Errorf("error creating config decoder for secret engine: %s", err.Error())
+	}
+	err = decoder.Decode(config)
 	if err != nil {
 		return mountConfigInput, fmt.Errorf("error parsing config for secret engine: %s", err.Error())
 	}
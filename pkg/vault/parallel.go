@@ -0,0 +1,168 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultWriteRetryInterval is the wait before the first retry of a failed write, doubled after every retry
+const DefaultWriteRetryInterval = time.Second
+
+// ItemErrors are the errors of the items of a section applied one by one, every item is applied
+// even if some of them failed. The errors are in the order of the items.
+type ItemErrors []error
+
+func (e ItemErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// forEachItem applies the independent items of a section (e.g. the policies) with at most
+// Concurrency workers, one after the other if it isn't set. The failed items don't stop the
+// others, their errors are returned together as ItemErrors.
+func (v *vault) forEachItem(count int, apply func(i int) error) error {
+	errs := make([]error, count)
+
+	workers := v.config.Concurrency
+	if workers > count {
+		workers = count
+	}
+	if workers <= 1 {
+		for i := 0; i < count; i++ {
+			errs[i] = apply(i)
+		}
+	} else {
+		items := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range items {
+					errs[i] = apply(i)
+				}
+			}()
+		}
+		for i := 0; i < count; i++ {
+			items <- i
+		}
+		close(items)
+		wg.Wait()
+	}
+
+	var failed ItemErrors
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// statusCodePattern matches the status code in the errors of the Vault API client
+var statusCodePattern = regexp.MustCompile(`Code: (\d+)\.`)
+
+// isTransientError tells if a request may succeed when it is sent again: the server errors
+// (except 501 Not Implemented) and the errors of connecting to Vault are transient
+func isTransientError(err error) bool {
+	if _, ok := err.(*url.Error); ok {
+		return true
+	}
+	match := statusCodePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return false
+	}
+	code, _ := strconv.Atoi(match[1])
+	return code >= 500 && code != 501
+}
+
+// retryIdempotent sends an idempotent request (writing the same data to the same path again has
+// the same result) up to WriteRetries more times if it failed with a transient error, waiting
+// WriteRetryInterval first and twice as long after every retry. The waiting stops when the
+// context of the run is cancelled (e.g. on shutdown), the last error is returned then. The request
+// is sent with a client which doesn't retry by itself, so the writes aren't retried twice.
+func (v *vault) retryIdempotent(description string, request func(cl *api.Client) error) error {
+	interval := v.config.WriteRetryInterval
+	if interval <= 0 {
+		interval = DefaultWriteRetryInterval
+	}
+
+	cl := v.cl
+	if v.config.WriteRetries > 0 {
+		var err error
+		cl, err = v.nonRetryingClient()
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx := v.runContext()
+	err := request(cl)
+	for retry := 1; err != nil && retry <= v.config.WriteRetries && isTransientError(err); retry++ {
+		logrus.Warnf("error %s, retrying in %s (%d/%d): %s", description, interval, retry, v.config.WriteRetries, err.Error())
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return err
+		}
+		interval *= 2
+		err = request(cl)
+	}
+	return err
+}
+
+// nonRetryingClient returns a copy of the client with its token and headers, which doesn't retry
+// the requests failing with a server error, the other requests keep the retries of the client
+// (e.g. VAULT_MAX_RETRIES)
+func (v *vault) nonRetryingClient() (*api.Client, error) {
+	// the clone has the address of the config, not the one set on the client
+	cl, err := v.cl.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %s", err.Error())
+	}
+	err = cl.SetAddress(v.cl.Address())
+	if err != nil {
+		return nil, fmt.Errorf("error creating client: %s", err.Error())
+	}
+	cl.SetHeaders(v.cl.Headers())
+	cl.SetToken(v.cl.Token())
+	cl.SetMaxRetries(0)
+	return cl, nil
+}
+
+// writeIdempotent writes the data to the path with the retries of retryIdempotent, the path
+// has to be overwritten with the same data on every run (e.g. the role of an auth method)
+func (v *vault) writeIdempotent(path string, data map[string]interface{}) error {
+	return v.retryIdempotent("writing "+path, func(cl *api.Client) error {
+		_, err := cl.Logical().Write(path, data)
+		return err
+	})
+}
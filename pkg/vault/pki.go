@@ -0,0 +1,329 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// configurePKIIntermediate runs a PKI secret engine as an intermediate CA of an external (offline) root,
+// configured in its "intermediate" block. Until a signed certificate is provided (inline in
+// "signed_certificate" or in "signed_certificate_file"), a CSR is generated with the "generate" parameters
+// and written to "csr_file", it isn't generated again while that file exists. The signed certificate is
+// imported with intermediate/set-signed if it isn't the CA certificate of the engine already.
+func (v *vault) configurePKIIntermediate(path string, secretEngine map[string]interface{}) error {
+	intermediate, err := getOrDefaultStringMap(secretEngine, "intermediate")
+	if err != nil {
+		return fmt.Errorf("error finding intermediate block for pki: %s", err.Error())
+	}
+	if len(intermediate) == 0 {
+		return nil
+	}
+
+	signedCertificate, err := getOrDefaultString(intermediate, "signed_certificate")
+	if err != nil {
+		return fmt.Errorf("error getting signed_certificate for pki intermediate: %s", err.Error())
+	}
+	signedCertificateFile, err := getOrDefaultString(intermediate, "signed_certificate_file")
+	if err != nil {
+		return fmt.Errorf("error getting signed_certificate_file for pki intermediate: %s", err.Error())
+	}
+	if signedCertificate == "" && signedCertificateFile != "" {
+		certificate, err := ioutil.ReadFile(signedCertificateFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading signed certificate of pki intermediate: %s", err.Error())
+		}
+		signedCertificate = string(certificate)
+	}
+
+	if strings.TrimSpace(signedCertificate) != "" {
+		// https://www.vaultproject.io/api/secret/pki/index.html#read-ca-certificate
+		ca, err := v.cl.Logical().Read(fmt.Sprintf("%s/cert/ca", path))
+		if err != nil {
+			return fmt.Errorf("error reading ca certificate of %s: %s", path, err.Error())
+		}
+		if ca != nil && ca.Data != nil && strings.TrimSpace(cast.ToString(ca.Data["certificate"])) == strings.TrimSpace(signedCertificate) {
+			logrus.Debugf("the signed intermediate certificate of %s is already imported", path)
+			return nil
+		}
+
+		logrus.Infof("importing the signed intermediate certificate of %s", path)
+		_, err = v.cl.Logical().Write(fmt.Sprintf("%s/intermediate/set-signed", path), map[string]interface{}{"certificate": signedCertificate})
+		if err != nil {
+			return fmt.Errorf("error importing signed intermediate certificate of %s: %s", path, err.Error())
+		}
+		return nil
+	}
+
+	csrFile, err := getOrDefaultString(intermediate, "csr_file")
+	if err != nil {
+		return fmt.Errorf("error getting csr_file for pki intermediate: %s", err.Error())
+	}
+	if csrFile != "" {
+		if _, err := os.Stat(csrFile); err == nil {
+			logrus.Infof("the intermediate CSR of %s is waiting to be signed: %s", path, csrFile)
+			return nil
+		}
+	}
+
+	generate, err := getOrDefaultStringMap(intermediate, "generate")
+	if err != nil {
+		return fmt.Errorf("error finding generate block for pki intermediate: %s", err.Error())
+	}
+	keyType := "internal"
+	if generateType, ok := generate["type"]; ok {
+		keyType = cast.ToString(generateType)
+	}
+
+	// https://www.vaultproject.io/api/secret/pki/index.html#generate-intermediate
+	secret, err := v.cl.Logical().Write(fmt.Sprintf("%s/intermediate/generate/%s", path, keyType), copyWithout(generate, "type"))
+	if err != nil {
+		return fmt.Errorf("error generating intermediate CSR of %s: %s", path, err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("error generating intermediate CSR of %s: empty response", path)
+	}
+	csr := cast.ToString(secret.Data["csr"])
+
+	if csrFile == "" {
+		logrus.Infof("generated the intermediate CSR of %s, sign it with the root CA:\n%s", path, csr)
+		return nil
+	}
+
+	err = ioutil.WriteFile(csrFile, []byte(csr), 0644)
+	if err != nil {
+		return fmt.Errorf("error writing intermediate CSR of %s: %s", path, err.Error())
+	}
+	logrus.Infof("generated the intermediate CSR of %s, sign it with the root CA: %s", path, csrFile)
+
+	return nil
+}
+
+// pkiMetadataRoleFields are the certificate metadata options of the PKI roles, they are only
+// sent to Vault versions supporting the certificate metadata
+var pkiMetadataRoleFields = []string{"no_store_metadata"}
+
+// pkiIssuancePoliciesSupported returns true if Vault supports the certificate metadata and the
+// certificate issuance external policy service (CIEPS) of PKI, which need Vault Enterprise 1.16+
+func (v *vault) pkiIssuancePoliciesSupported() (bool, error) {
+	return enterpriseAtLeast(v.cl, 1, 16)
+}
+
+// versionAtLeast returns true if the major.minor(.patch)(+suffix) version is at least major.minor
+func versionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	versionMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	versionMinor, err := strconv.Atoi(strings.SplitN(parts[1], "+", 2)[0])
+	if err != nil {
+		return false
+	}
+	return versionMajor > major || (versionMajor == major && versionMinor >= minor)
+}
+
+// pkiConfiguration leaves the certificate metadata options out of the roles of the configuration
+// of a PKI secret engine if Vault doesn't support them, so older versions can be configured as well
+func (v *vault) pkiConfiguration(path string, configuration map[string]interface{}) (map[string]interface{}, error) {
+	roles, err := toNormalizedSliceStringMapE(configuration["roles"])
+	if err != nil {
+		return nil, fmt.Errorf("error finding roles block for pki: %s", err.Error())
+	}
+
+	usesMetadata := false
+	for _, role := range roles {
+		for _, field := range pkiMetadataRoleFields {
+			if _, ok := role[field]; ok {
+				usesMetadata = true
+			}
+		}
+	}
+	if !usesMetadata {
+		return configuration, nil
+	}
+
+	supported, err := v.pkiIssuancePoliciesSupported()
+	if err != nil {
+		return nil, err
+	}
+	if supported {
+		return configuration, nil
+	}
+
+	supportedRoles := make([]interface{}, 0, len(roles))
+	for _, role := range roles {
+		supportedRole := copyWithout(role, pkiMetadataRoleFields...)
+		if len(supportedRole) != len(role) {
+			logrus.Warnf("skipping the certificate metadata options of role %v of %s, they need Vault Enterprise 1.16+", role["name"], path)
+		}
+		supportedRoles = append(supportedRoles, supportedRole)
+	}
+	supportedConfiguration := copyWithout(configuration)
+	supportedConfiguration["roles"] = supportedRoles
+	return supportedConfiguration, nil
+}
+
+// configurePKIExternalPolicy configures the certificate issuance external policy service (CIEPS)
+// of a PKI secret engine from its "external_policy" block, it is skipped if Vault doesn't support it
+func (v *vault) configurePKIExternalPolicy(path string, secretEngine map[string]interface{}) error {
+	externalPolicy, err := getOrDefaultStringMap(secretEngine, "external_policy")
+	if err != nil {
+		return fmt.Errorf("error finding external_policy block for pki: %s", err.Error())
+	}
+	if len(externalPolicy) == 0 {
+		return nil
+	}
+
+	supported, err := v.pkiIssuancePoliciesSupported()
+	if err != nil {
+		return err
+	}
+	if !supported {
+		logrus.Warnf("skipping the external issuance policy of %s, it needs Vault Enterprise 1.16+", path)
+		return nil
+	}
+
+	// https://developer.hashicorp.com/vault/api-docs/secret/pki/issuance#configure-cieps
+	_, err = v.cl.Logical().Write(fmt.Sprintf("%s/config/external-policy", path), externalPolicy)
+	if err != nil {
+		return fmt.Errorf("error configuring external issuance policy of %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// pkiIssuerUsages are the usages an issuer can be restricted to, e.g. an issuer only signing the CRLs
+var pkiIssuerUsages = map[string]bool{
+	"read-only":            true,
+	"issuing-certificates": true,
+	"crl-signing":          true,
+	"ocsp-signing":         true,
+}
+
+// pkiIssuerFields are the parameters of an issuer which are kept when it is updated,
+// the update endpoint resets the parameters which aren't sent to their defaults
+var pkiIssuerFields = []string{
+	"issuer_name",
+	"leaf_not_after_behavior",
+	"manual_chain",
+	"usage",
+	"revocation_signature_algorithm",
+	"issuing_certificates",
+	"crl_distribution_points",
+	"ocsp_servers",
+	"enable_aia_url_templating",
+}
+
+// configurePKIIssuers updates the issuers (multi-issuer PKI, Vault 1.11+) of a PKI secret engine listed
+// in its "issuers" block by issuer reference ("name", an issuer name, ID or "default"), e.g. their
+// usage and revocation_signature_algorithm to delegate the CRL signing to another issuer. The issuers
+// have to exist already, the parameters not in the block are left untouched.
+func (v *vault) configurePKIIssuers(path string, secretEngine map[string]interface{}) error {
+	issuers, err := toNormalizedSliceStringMapE(secretEngine["issuers"])
+	if err != nil {
+		return fmt.Errorf("error finding issuers block for pki: %s", err.Error())
+	}
+
+	for _, issuer := range issuers {
+		ref, err := getOrError(issuer, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for pki issuer: %s", err.Error())
+		}
+
+		expected := copyWithout(issuer, "name")
+		if usage, ok := expected["usage"]; ok {
+			usages, err := pkiIssuerUsage(usage)
+			if err != nil {
+				return fmt.Errorf("error getting usage for pki issuer %s: %s", ref, err.Error())
+			}
+			expected["usage"] = usages
+		}
+
+		issuerPath := fmt.Sprintf("%s/issuer/%s", path, ref)
+		// https://developer.hashicorp.com/vault/api-docs/secret/pki#read-issuer
+		secret, err := v.cl.Logical().Read(issuerPath)
+		if err != nil {
+			return fmt.Errorf("error reading pki issuer %s: %s", ref, err.Error())
+		}
+		if secret == nil || secret.Data == nil {
+			return fmt.Errorf("pki issuer %s doesn't exist", ref)
+		}
+
+		data := map[string]interface{}{}
+		for _, field := range pkiIssuerFields {
+			if value, ok := secret.Data[field]; ok {
+				data[field] = value
+			}
+		}
+		if usage, ok := data["usage"]; ok {
+			data["usage"], _ = pkiIssuerUsage(usage)
+		}
+
+		changed := false
+		for key, value := range expected {
+			if fmt.Sprint(data[key]) != fmt.Sprint(value) {
+				changed = true
+			}
+			data[key] = value
+		}
+		if !changed {
+			logrus.Debugf("pki issuer %s of %s is up to date", ref, path)
+			continue
+		}
+
+		// https://developer.hashicorp.com/vault/api-docs/secret/pki#update-issuer
+		_, err = v.cl.Logical().Write(issuerPath, data)
+		if err != nil {
+			return fmt.Errorf("error updating pki issuer %s: %s", ref, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// pkiIssuerUsage returns the usage of an issuer (a list or a comma separated string) the way Vault
+// returns it, comma separated in a stable order
+func pkiIssuerUsage(value interface{}) (string, error) {
+	var usages []string
+	if usage, ok := value.(string); ok {
+		usages = strings.Split(usage, ",")
+	} else {
+		var err error
+		usages, err = cast.ToStringSliceE(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for i, usage := range usages {
+		usages[i] = strings.TrimSpace(usage)
+		if !pkiIssuerUsages[usages[i]] {
+			return "", fmt.Errorf("unknown issuer usage %q", usages[i])
+		}
+	}
+	sort.Strings(usages)
+	return strings.Join(usages, ","), nil
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// policyTemplateParameters are the ACL templating parameters known by Vault, the
+// <placeholders> match a single non-empty path segment
+var policyTemplateParameters = compilePolicyTemplateParameters(
+	"identity.entity.id",
+	"identity.entity.name",
+	"identity.entity.metadata.<key>",
+	"identity.entity.aliases.<mount accessor>.id",
+	"identity.entity.aliases.<mount accessor>.name",
+	"identity.entity.aliases.<mount accessor>.metadata.<key>",
+	"identity.entity.aliases.<mount accessor>.custom_metadata.<key>",
+	"identity.groups.ids.<group id>.name",
+	"identity.groups.ids.<group id>.metadata.<key>",
+	"identity.groups.names.<group name>.id",
+	"identity.groups.names.<group name>.metadata.<key>",
+)
+
+func compilePolicyTemplateParameters(parameters ...string) []*regexp.Regexp {
+	placeholder := regexp.MustCompile(`<[^>]+>`)
+	var compiled []*regexp.Regexp
+	for _, parameter := range parameters {
+		pattern := placeholder.ReplaceAllString(regexp.QuoteMeta(parameter), `[^.{}\s]+`)
+		compiled = append(compiled, regexp.MustCompile("^"+pattern+"$"))
+	}
+	return compiled
+}
+
+// validatePolicyTemplates checks the templating directives of the ACL policy rules client side,
+// because Vault accepts malformed ones and the policy silently doesn't match anything
+func validatePolicyTemplates(name, rules string) error {
+	for rest := rules; ; {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			return nil
+		}
+		rest = rest[start:]
+
+		// a stray }} is left alone, it can close nested HCL blocks as well
+		end := strings.Index(rest, "}}")
+		if end < 0 || strings.Contains(rest[2:end], "{{") {
+			return fmt.Errorf("malformed template in policy %s: unclosed %q", name, firstLine(rest))
+		}
+		directive := rest[:end+2]
+		if !isPolicyTemplateParameter(strings.TrimSpace(directive[2 : len(directive)-2])) {
+			return fmt.Errorf("malformed template in policy %s: unknown parameter %q", name, directive)
+		}
+		rest = rest[end+2:]
+	}
+}
+
+func isPolicyTemplateParameter(parameter string) bool {
+	for _, known := range policyTemplateParameters {
+		if known.MatchString(parameter) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
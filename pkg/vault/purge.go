@@ -0,0 +1,316 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// purgeUnmanagedTypes are the resource types which can be enabled in the "purgeUnmanagedConfig" section
+var purgeUnmanagedTypes = map[string]bool{
+	"policies": true,
+	"auth":     true,
+	"secrets":  true,
+	"groups":   true,
+}
+
+// configurePurgeUnmanagedConfig removes the resources of the types enabled in the
+// "purgeUnmanagedConfig" section which were managed by bank-vaults but aren't in the
+// configuration anymore. The auth methods and secret engines are recognized by the managed
+// marker of their description (like with --prune-auth and --prune-secrets). Policies and groups
+// have no such marker, so the names configured by the previous run are kept in the kv store:
+// the ones configured before the purge got enabled are never removed. The names are kept per
+// config file, so the resources of the other config files are never removed either. The kv store
+// is the only storage of bank-vaults besides Vault, and the names have to survive the root token
+// and Vault itself being replaced (like the seal key id and the configurer token).
+func (v *vault) configurePurgeUnmanagedConfig(config *viper.Viper) error {
+	if !config.IsSet("purgeUnmanagedConfig") {
+		return nil
+	}
+
+	purge, err := cast.ToStringMapE(normalizeConfigValue(config.Get("purgeUnmanagedConfig")))
+	if err != nil {
+		return fmt.Errorf("error decoding purgeUnmanagedConfig config: %s", err.Error())
+	}
+
+	enabled := map[string]bool{}
+	for resourceType, value := range purge {
+		if !purgeUnmanagedTypes[resourceType] {
+			return fmt.Errorf("unknown resource type %s in purgeUnmanagedConfig", resourceType)
+		}
+		enabled[resourceType], err = cast.ToBoolE(value)
+		if err != nil {
+			return fmt.Errorf("error getting %s for purgeUnmanagedConfig: %s", resourceType, err.Error())
+		}
+	}
+
+	if enabled["policies"] {
+		err = v.purgeUnmanagedPolicies(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	if enabled["auth"] {
+		paths, err := v.managedMountPaths(config, "auth", v.managedAuthKey())
+		if err != nil {
+			return err
+		}
+		auths, err := v.cl.Sys().ListAuth()
+		if err != nil {
+			return fmt.Errorf("error listing auth backends vault: %s", err.Error())
+		}
+		err = v.pruneAuthMethods(auths, paths)
+		if err != nil {
+			return err
+		}
+	}
+
+	if enabled["secrets"] {
+		paths, err := v.managedMountPaths(config, "secrets", v.managedSecretsKey())
+		if err != nil {
+			return err
+		}
+		err = v.pruneSecretEngines(paths)
+		if err != nil {
+			return err
+		}
+	}
+
+	if enabled["groups"] {
+		err = v.purgeUnmanagedGroups(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeUnmanagedPolicies deletes the policies configured by the previous run which aren't
+// configured anymore, the names are kept as type/name, e.g. acl/allow_secrets
+func (v *vault) purgeUnmanagedPolicies(config *viper.Viper) error {
+	policies := []map[string]interface{}{}
+	err := config.UnmarshalKey("policies", &policies)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling vault policy config: %s", err.Error())
+	}
+
+	configured := map[string]bool{}
+	for _, policy := range policies {
+		name, err := getOrError(policy, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for policy: %s", err.Error())
+		}
+		policyType := cast.ToString(policy["type"])
+		if policyType == "" {
+			policyType = "acl"
+		}
+		configured[policyType+"/"+name] = true
+	}
+
+	configFile := config.ConfigFileUsed()
+	managed, err := v.managedNames(v.managedPoliciesKey(), configFile)
+	if err != nil {
+		return err
+	}
+	others := otherManagedNames(managed, configFile)
+	for _, policy := range managed[configFile] {
+		if configured[policy] || others[policy] {
+			continue
+		}
+		policyType, name := policyTypeAndName(policy)
+		// the built-in policies can't be deleted
+		if policyType == "acl" && (name == "default" || name == "root") {
+			continue
+		}
+
+		logrus.Infof("deleting %s policy %s which is not present in the configuration anymore", policyType, name)
+		_, err = v.cl.Logical().Delete(fmt.Sprintf("sys/policies/%s/%s", policyType, name))
+		if err != nil {
+			return fmt.Errorf("error deleting %s policy %s: %s", policyType, name, err.Error())
+		}
+	}
+
+	return v.storeManagedNames(v.managedPoliciesKey(), managed, configFile, configured)
+}
+
+// purgeUnmanagedGroups deletes the identity groups configured by the previous run (including the
+// external groups of the mappings) which aren't configured anymore
+func (v *vault) purgeUnmanagedGroups(config *viper.Viper) error {
+	names, err := configuredIdentityNames(config)
+	if err != nil {
+		return err
+	}
+	configured := names["groups"]
+
+	if config.IsSet("identity") {
+		identity, err := cast.ToStringMapE(normalizeConfigValue(config.Get("identity")))
+		if err != nil {
+			return fmt.Errorf("error decoding identity config: %s", err.Error())
+		}
+		mappedGroups, err := externalGroupsFromMappings(cast.ToStringMap(identity["external_groups"]))
+		if err != nil {
+			return fmt.Errorf("error configuring identity external groups: %s", err.Error())
+		}
+		for _, group := range mappedGroups {
+			configured[cast.ToString(group["name"])] = true
+		}
+	}
+
+	configFile := config.ConfigFileUsed()
+	managed, err := v.managedNames(v.managedGroupsKey(), configFile)
+	if err != nil {
+		return err
+	}
+	others := otherManagedNames(managed, configFile)
+	for _, name := range managed[configFile] {
+		if configured[name] || others[name] {
+			continue
+		}
+
+		logrus.Infof("deleting group %s which is not present in the configuration anymore", name)
+		// https://www.vaultproject.io/api/secret/identity/group.html#delete-group-by-name
+		_, err = v.cl.Logical().Delete(fmt.Sprintf("identity/group/name/%s", name))
+		if err != nil {
+			return fmt.Errorf("error deleting group %s: %s", name, err.Error())
+		}
+	}
+
+	return v.storeManagedNames(v.managedGroupsKey(), managed, configFile, configured)
+}
+
+// configuredMountPaths returns the mount paths (with a trailing slash, as Vault lists them) of
+// the auth methods or secret engines of the configuration
+func configuredMountPaths(config *viper.Viper, section string) (map[string]bool, error) {
+	mounts := []map[string]interface{}{}
+	err := config.UnmarshalKey(section, &mounts)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling vault %s config: %s", section, err.Error())
+	}
+
+	paths := map[string]bool{}
+	for _, mount := range mounts {
+		path, _, err := mountPathAndType(mount)
+		if err != nil {
+			return nil, fmt.Errorf("error finding path for %s: %s", section, err.Error())
+		}
+		paths[path+"/"] = true
+	}
+	return paths, nil
+}
+
+func policyTypeAndName(policy string) (string, string) {
+	parts := strings.SplitN(policy, "/", 2)
+	if len(parts) == 1 {
+		return "acl", parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+// managedMountPaths returns the mount paths of the section configured by the config file or by
+// the other config files, and stores the ones of the config file
+func (v *vault) managedMountPaths(config *viper.Viper, section, key string) (map[string]bool, error) {
+	paths, err := configuredMountPaths(config, section)
+	if err != nil {
+		return nil, err
+	}
+
+	configFile := config.ConfigFileUsed()
+	managed, err := v.managedNames(key, configFile)
+	if err != nil {
+		return nil, err
+	}
+	err = v.storeManagedNames(key, managed, configFile, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := otherManagedNames(managed, configFile)
+	for path := range paths {
+		kept[path] = true
+	}
+	return kept, nil
+}
+
+// managedNames returns the names stored under the key by the previous run of every config file,
+// none if there was no run yet. The names stored by a single config file before they were kept
+// per config file are the names of this config file.
+func (v *vault) managedNames(key, configFile string) (map[string][]string, error) {
+	data, err := v.keyStore.Get(key)
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return map[string][]string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", key, err.Error())
+	}
+
+	managed := map[string][]string{}
+	err = json.Unmarshal(data, &managed)
+	if err != nil {
+		var names []string
+		if json.Unmarshal(data, &names) != nil {
+			return nil, fmt.Errorf("error decoding key '%s': %s", key, err.Error())
+		}
+		managed = map[string][]string{configFile: names}
+	}
+	return managed, nil
+}
+
+// otherManagedNames returns the names managed by the other config files
+func otherManagedNames(managed map[string][]string, configFile string) map[string]bool {
+	others := map[string]bool{}
+	for otherConfigFile, names := range managed {
+		if otherConfigFile == configFile {
+			continue
+		}
+		for _, name := range names {
+			others[name] = true
+		}
+	}
+	return others
+}
+
+// storeManagedNames stores the names of the config file together with the names of the other config files
+func (v *vault) storeManagedNames(key string, managed map[string][]string, configFile string, names map[string]bool) error {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	stored := map[string][]string{}
+	for otherConfigFile, otherNames := range managed {
+		stored[otherConfigFile] = otherNames
+	}
+	stored[configFile] = sorted
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	err = v.keyStore.Set(key, data)
+	if err != nil {
+		return fmt.Errorf("error storing key '%s': %s", key, err.Error())
+	}
+	return nil
+}
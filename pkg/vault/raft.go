@@ -0,0 +1,144 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// RaftPeer is a server of the Integrated Storage (raft) cluster
+type RaftPeer struct {
+	NodeID  string
+	Address string
+	Leader  bool
+	Voter   bool
+}
+
+// RaftJoin joins the uninitialized Vault to the raft cluster of the Vault at the leader API
+// address (e.g. https://vault-0.vault:8200), it has to be unsealed with the keys of the cluster
+// afterwards. An initialized Vault is already a member of a cluster, it isn't joined again.
+func (v *vault) RaftJoin(leaderAPIAddr string) error {
+	initialized, err := v.cl.Sys().InitStatus()
+	if err != nil {
+		return fmt.Errorf("error testing if vault is initialized: %s", err.Error())
+	}
+	if initialized {
+		return nil
+	}
+
+	logrus.Infof("joining the raft cluster of %s", leaderAPIAddr)
+
+	// the response isn't a secret, the joined flag is at the top level
+	req := v.cl.NewRequest("PUT", "/v1/sys/storage/raft/join")
+	err = req.SetJSONBody(map[string]interface{}{"leader_api_addr": leaderAPIAddr})
+	if err != nil {
+		return fmt.Errorf("error encoding the raft join request: %s", err.Error())
+	}
+	resp, err := v.cl.RawRequestWithContext(context.Background(), req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error joining the raft cluster of %s: %s", leaderAPIAddr, err.Error())
+	}
+
+	var result struct {
+		Joined bool `json:"joined"`
+	}
+	err = resp.DecodeJSON(&result)
+	if err != nil {
+		return fmt.Errorf("error decoding the raft join response: %s", err.Error())
+	}
+	if !result.Joined {
+		return fmt.Errorf("error joining the raft cluster of %s: the node hasn't joined", leaderAPIAddr)
+	}
+	return nil
+}
+
+// RaftPeers returns the servers of the raft cluster
+func (v *vault) RaftPeers() ([]RaftPeer, error) {
+	var peers []RaftPeer
+	err := v.withRootToken(func() error {
+		secret, err := v.cl.Logical().Read("sys/storage/raft/configuration")
+		if err != nil {
+			return fmt.Errorf("error reading the raft configuration: %s", err.Error())
+		}
+		if secret == nil || secret.Data == nil {
+			return fmt.Errorf("error reading the raft configuration: no configuration returned")
+		}
+
+		config, err := cast.ToStringMapE(secret.Data["config"])
+		if err != nil {
+			return fmt.Errorf("error decoding the raft configuration: %s", err.Error())
+		}
+		servers, err := toNormalizedSliceStringMapE(config["servers"])
+		if err != nil {
+			return fmt.Errorf("error decoding the raft servers: %s", err.Error())
+		}
+		for _, server := range servers {
+			peers = append(peers, RaftPeer{
+				NodeID:  cast.ToString(server["node_id"]),
+				Address: cast.ToString(server["address"]),
+				Leader:  cast.ToBool(server["leader"]),
+				Voter:   cast.ToBool(server["voter"]),
+			})
+		}
+		return nil
+	})
+	return peers, err
+}
+
+// RaftRemovePeer removes the server from the raft cluster, e.g. once its pod has been deleted
+func (v *vault) RaftRemovePeer(nodeID string) error {
+	return v.withRootToken(func() error {
+		_, err := v.cl.Logical().Write("sys/storage/raft/remove-peer", map[string]interface{}{
+			"server_id": nodeID,
+		})
+		if err != nil {
+			return fmt.Errorf("error removing raft peer %s: %s", nodeID, err.Error())
+		}
+		return nil
+	})
+}
+
+// RaftSnapshot writes a snapshot of the raft storage to w, the data in it is encrypted by the
+// barrier of Vault, so it can only be restored with the unseal keys of the cluster
+func (v *vault) RaftSnapshot(w io.Writer) error {
+	return v.withRootToken(func() error {
+		req := v.cl.NewRequest("GET", "/v1/sys/storage/raft/snapshot")
+		resp, err := v.cl.RawRequestWithContext(context.Background(), req)
+		if resp != nil {
+			defer resp.Body.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("error taking raft snapshot: %s", err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error taking raft snapshot: unexpected status code %d", resp.StatusCode)
+		}
+
+		_, err = io.Copy(w, resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading raft snapshot: %s", err.Error())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,278 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// policyCapabilities are the ACL capabilities in the order they are printed
+var policyCapabilities = []string{"create", "read", "update", "delete", "list", "sudo"}
+
+// PolicyRules are the capabilities needed per Vault API path
+type PolicyRules map[string]map[string]bool
+
+func (r PolicyRules) add(path string, capabilities ...string) {
+	if r[path] == nil {
+		r[path] = map[string]bool{}
+	}
+	for _, capability := range capabilities {
+		r[path][capability] = true
+	}
+}
+
+// merge adds the capabilities of the other rules
+func (r PolicyRules) merge(other PolicyRules) {
+	for path, capabilities := range other {
+		for capability, ok := range capabilities {
+			if ok {
+				r.add(path, capability)
+			}
+		}
+	}
+}
+
+// parsePolicyRules parses the path rules of an ACL policy, e.g. of the policy rendered by HCL
+func parsePolicyRules(policy string) (PolicyRules, error) {
+	var parsed struct {
+		Path map[string]struct {
+			Capabilities []string `hcl:"capabilities"`
+		} `hcl:"path"`
+	}
+	err := hcl.Decode(&parsed, policy)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing policy: %s", err.Error())
+	}
+
+	rules := PolicyRules{}
+	for path, rule := range parsed.Path {
+		rules.add(path, rule.Capabilities...)
+	}
+	return rules, nil
+}
+
+// HCL renders the rules as an ACL policy, with the paths in a stable order
+func (r PolicyRules) HCL() string {
+	paths := make([]string, 0, len(r))
+	for path := range r {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	buffer := bytes.NewBuffer(nil)
+	for i, path := range paths {
+		var capabilities []string
+		for _, capability := range policyCapabilities {
+			if r[path][capability] {
+				capabilities = append(capabilities, fmt.Sprintf("%q", capability))
+			}
+		}
+		if i > 0 {
+			buffer.WriteString("\n")
+		}
+		fmt.Fprintf(buffer, "path %q {\n  capabilities = [%s]\n}\n", path, strings.Join(capabilities, ", "))
+	}
+	return buffer.String()
+}
+
+// RequiredPolicy returns the ACL rules a token needs to apply the configuration with the
+// given options (e.g. pruning), without connecting to Vault. The rules of several configurations
+// can be merged into the same PolicyRules.
+func RequiredPolicy(config *viper.Viper, options Config, rules PolicyRules) PolicyRules {
+	if rules == nil {
+		rules = PolicyRules{}
+	}
+
+	// the edition and the version are detected through the health endpoint
+	rules.add("sys/health", "read")
+
+	for _, section := range (&vault{}).configSections() {
+		if !config.IsSet(section.name) {
+			continue
+		}
+		value := normalizeConfigValue(config.Get(section.name))
+		items, _ := toNormalizedSliceStringMapE(value)
+		block := cast.ToStringMap(value)
+
+		switch section.name {
+		case "auth":
+			rules.add("sys/auth", "read")
+			for _, item := range items {
+				mountPath, _, err := mountPathAndType(item)
+				if err != nil {
+					continue
+				}
+				rules.add("sys/auth/"+mountPath, "create", "read", "update", "sudo")
+				rules.add("sys/auth/"+mountPath+"/tune", "read", "update")
+				rules.add("auth/"+mountPath+"/*", "create", "read", "update", "delete", "list")
+			}
+			if options.PruneAuth {
+				rules.add("sys/auth/*", "delete", "sudo")
+				rules.add("identity/entity-alias/id/*", "read", "delete", "list")
+				rules.add("identity/group-alias/id/*", "read", "delete", "list")
+			}
+		case "policies":
+			for _, item := range items {
+				policyType := cast.ToString(item["type"])
+				if policyType == "" {
+					policyType = "acl"
+				}
+				rules.add(fmt.Sprintf("sys/policies/%s/%s", policyType, cast.ToString(item["name"])), "create", "read", "update")
+				// the created policies are deleted on a rollback
+				if options.AtomicPolicies && policyType == "acl" {
+					rules.add("sys/policies/acl/"+cast.ToString(item["name"]), "delete")
+				}
+			}
+			if options.AtomicPolicies {
+				rules.add("sys/policies/acl/"+stagingPolicyPrefix+"*", "create", "read", "update", "delete")
+			}
+		case "plugins":
+			for _, item := range items {
+				rules.add(fmt.Sprintf("sys/plugins/catalog/%s/%s", cast.ToString(item["type"]), cast.ToString(item["plugin_name"])), "create", "read", "update", "sudo")
+			}
+		case "secrets":
+			rules.add("sys/mounts", "read")
+			for _, item := range items {
+				mountPath, mountType, err := mountPathAndType(item)
+				if err != nil {
+					continue
+				}
+				if builtinMounts[mountType] && mountPath == mountType {
+					rules.add("sys/mounts/"+mountPath+"/tune", "update")
+					continue
+				}
+				rules.add("sys/mounts/"+mountPath, "create", "read", "update")
+				rules.add("sys/mounts/"+mountPath+"/tune", "read", "update")
+				rules.add(mountPath+"/*", "create", "read", "update", "list")
+				if _, ok := item["plugin_version"]; ok {
+					rules.add("sys/plugins/reload/backend", "update", "sudo")
+				}
+				// the lease count quota is outside of the mount
+				if _, ok := cast.ToStringMap(item["limits"])["max_leases"]; ok {
+					rules.add(mountLimits["max_leases"].path(mountPath), "create", "read", "update")
+				}
+			}
+			if options.PruneSecrets {
+				rules.add("sys/mounts/*", "delete")
+			}
+		case "identity":
+			rules.add("sys/auth", "read")
+			rules.add("identity/*", "create", "read", "update", "list")
+			// the identity token policies of the entities with oidc_roles
+			rules.add("sys/policies/acl/identity-token-*", "create", "read", "update")
+		case "identityPurge":
+			if options.ConfirmIdentityPurge {
+				rules.add("identity/entity/id/*", "read", "delete", "list")
+				rules.add("identity/group/id/*", "read", "delete", "list")
+			}
+		case "purgeUnmanagedConfig":
+			if cast.ToBool(block["policies"]) {
+				rules.add("sys/policies/*", "delete")
+			}
+			if cast.ToBool(block["auth"]) {
+				rules.add("sys/auth", "read")
+				rules.add("sys/auth/*", "delete", "sudo")
+				rules.add("identity/entity-alias/id/*", "read", "delete", "list")
+				rules.add("identity/group-alias/id/*", "read", "delete", "list")
+			}
+			if cast.ToBool(block["secrets"]) {
+				rules.add("sys/mounts", "read")
+				rules.add("sys/mounts/*", "delete")
+			}
+			if cast.ToBool(block["groups"]) {
+				rules.add("identity/group/name/*", "delete")
+			}
+		case "wrappingDefaults":
+			rules.add("sys/mounts/cubbyhole/tune", "update")
+		case "ui":
+			rules.add("sys/config/ui/*", "create", "read", "update", "list", "sudo")
+		case "sysConfig":
+			for _, item := range items {
+				rules.add(path.Clean(strings.TrimPrefix(cast.ToString(item["path"]), "/")), "create", "update", "sudo")
+			}
+		case "controlGroup":
+			rules.add("sys/config/control-group", "create", "update", "sudo")
+		case "mountFilters":
+			for _, item := range items {
+				rules.add("sys/replication/performance/primary/paths-filter/"+cast.ToString(item["secondary_id"]), "create", "read", "update", "sudo")
+			}
+		case "seal":
+			if _, ok := block["key_id"]; ok {
+				rules.add("sys/sealwrap/rewrap", "read", "update")
+			}
+		case "audit":
+			rules.add("sys/audit", "read", "sudo")
+			for _, item := range items {
+				mountPath, _, err := mountPathAndType(item)
+				if err != nil {
+					continue
+				}
+				rules.add("sys/audit/"+mountPath, "create", "update", "sudo")
+			}
+		case "startupSecrets":
+			for _, item := range items {
+				secretPath := cast.ToString(item["path"])
+				rules.add(secretPath, "create", "update")
+				if _, ok := item["custom_metadata"]; ok {
+					rules.add("sys/mounts", "read")
+					rules.add(strings.Replace(secretPath, "/data/", "/metadata/", 1), "create", "update")
+				}
+			}
+		case "kvOperations":
+			rules.add("sys/mounts", "read")
+			for _, item := range items {
+				dataPath := cast.ToString(item["path"])
+				if len(cast.ToIntSlice(item["versions"])) == 0 {
+					rules.add(dataPath, "delete")
+					continue
+				}
+				operation := cast.ToString(item["operation"])
+				rules.add(strings.Replace(dataPath, "/data/", "/"+operation+"/", 1), "update")
+			}
+		case "verifyAccess":
+			if options.VerifyAccess {
+				rules.add("auth/token/create-orphan", "create", "update", "sudo")
+				rules.add("auth/token/revoke", "update")
+			}
+		case "assertions":
+			for _, item := range items {
+				switch cast.ToString(item["type"]) {
+				case assertionPathExists:
+					rules.add(cast.ToString(item["path"]), "read")
+				case assertionSecretEngine:
+					rules.add("sys/mounts", "read")
+				case assertionAuthMethod:
+					rules.add("sys/auth", "read")
+				case assertionPolicy:
+					rules.add("sys/policies/acl/"+cast.ToString(item["name"]), "read")
+				}
+			}
+		}
+	}
+
+	if options.RevokeLeasesOnDisable && (options.PruneAuth || options.PruneSecrets) {
+		rules.add("sys/leases/revoke-prefix/*", "update", "sudo")
+	}
+
+	return rules
+}
@@ -0,0 +1,282 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"encoding/base64"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/base62"
+	"github.com/hashicorp/vault/helper/xor"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// ConfigurerPolicyName is the name of the policy of the token which replaces the root token
+// when it is revoked automatically
+const ConfigurerPolicyName = "bank-vaults-configurer"
+
+// DefaultConfigurerTokenPeriod is the default period of the token which replaces the root token
+const DefaultConfigurerTokenPeriod = 720 * time.Hour
+
+// withRootOrConfigurerToken sets the stored root token on the client for the duration of fn if
+// it is still valid (e.g. it has been regenerated), otherwise the stored token which replaced it.
+// The periodic configurer token is renewed first, so it doesn't expire between the runs.
+func (v *vault) withRootOrConfigurerToken(fn func() error) error {
+	logrus.Debugf("retrieving key from kms service...")
+
+	token, err := v.keyStore.Get(v.rootTokenKey())
+	if _, ok := err.(*kv.NotFoundError); err != nil && !ok {
+		return fmt.Errorf("unable to get key '%s': %s", v.rootTokenKey(), err.Error())
+	}
+
+	// Clear the token and GC it
+	defer runtime.GC()
+	defer v.cl.SetToken("")
+	defer func() { token = nil }()
+
+	if token != nil {
+		v.cl.SetToken(string(token))
+		_, err := v.cl.Auth().Token().LookupSelf()
+		if err == nil {
+			return fn()
+		}
+		// only a rejected token is replaced, e.g. not if Vault isn't reachable
+		if !isPermissionDeniedError(err) {
+			return fmt.Errorf("error looking up the root token: %s", err.Error())
+		}
+		logrus.Debugf("the stored root token is not valid anymore, using the configurer token")
+	}
+
+	token, err = v.keyStore.Get(v.configurerTokenKey())
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return fmt.Errorf("the root token is not valid and there is no configurer token in key '%s', generate a new root token with regenerate-root", v.configurerTokenKey())
+	} else if err != nil {
+		return fmt.Errorf("unable to get key '%s': %s", v.configurerTokenKey(), err.Error())
+	}
+
+	v.cl.SetToken(string(token))
+	if _, err := v.cl.Auth().Token().RenewSelf(0); err != nil {
+		logrus.Warnf("error renewing the configurer token: %s", err.Error())
+	}
+
+	return fn()
+}
+
+// usingRootToken returns true if the client token is the root token of the key store, so it
+// can be revoked: the tokens of the auth methods and the ones given with Token are never revoked
+func (v *vault) usingRootToken() (bool, error) {
+	if v.config.Token != "" || v.config.AuthMethod != "" {
+		return false, nil
+	}
+
+	secret, err := v.cl.Auth().Token().LookupSelf()
+	if err != nil {
+		return false, fmt.Errorf("error looking up the vault token: %s", err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return false, nil
+	}
+	for _, policy := range cast.ToStringSlice(secret.Data["policies"]) {
+		if policy == "root" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// replaceRootToken replaces the root token with a periodic orphan token of the policy the
+// configurations require (see RequiredPolicy), stores it in the key store, revokes the root token
+// and deletes it from the key store. The root token is kept if anything fails before the new token
+// is stored. The policy grants the rules of the configuration, of ConfigurerPolicy and of the
+// previous policy, so a root token from regenerate-root doesn't narrow it to one config file. A
+// configuration requiring more than the policy allows needs a new root token from regenerate-root,
+// the policy is updated for it by the next run.
+func (v *vault) replaceRootToken(config *viper.Viper) error {
+	root, err := v.usingRootToken()
+	if err != nil || !root {
+		return err
+	}
+
+	rules := RequiredPolicy(config, *v.config, PolicyRules{})
+	rules.merge(v.config.ConfigurerPolicy)
+
+	previousPolicy, err := v.cl.Sys().GetPolicy(ConfigurerPolicyName)
+	if err != nil {
+		return fmt.Errorf("error getting %s policy from vault: %s", ConfigurerPolicyName, err.Error())
+	}
+	if previousPolicy != "" {
+		previousRules, err := parsePolicyRules(previousPolicy)
+		if err != nil {
+			return fmt.Errorf("error parsing %s policy: %s", ConfigurerPolicyName, err.Error())
+		}
+		rules.merge(previousRules)
+	}
+
+	err = v.cl.Sys().PutPolicy(ConfigurerPolicyName, rules.HCL())
+	if err != nil {
+		return fmt.Errorf("error putting %s policy into vault: %s", ConfigurerPolicyName, err.Error())
+	}
+
+	period := v.config.ConfigurerTokenPeriod
+	if period <= 0 {
+		period = DefaultConfigurerTokenPeriod
+	}
+
+	// an orphan token, the children of the root token are revoked together with it
+	secret, err := v.cl.Auth().Token().CreateOrphan(&api.TokenCreateRequest{
+		Policies:    []string{ConfigurerPolicyName},
+		Period:      period.String(),
+		DisplayName: ConfigurerPolicyName,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating the configurer token: %s", err.Error())
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return fmt.Errorf("error creating the configurer token: no token returned")
+	}
+
+	previous, err := v.storedConfigurerToken()
+	if err != nil {
+		return err
+	}
+
+	err = v.keyStore.Set(v.configurerTokenKey(), []byte(secret.Auth.ClientToken))
+	if err != nil {
+		return fmt.Errorf("error storing the configurer token in key '%s': %s", v.configurerTokenKey(), err.Error())
+	}
+	logrus.WithField("key", v.configurerTokenKey()).Infof("token of the %s policy stored in key store", ConfigurerPolicyName)
+
+	// the token replacing the previous root token, e.g. before regenerate-root
+	if previous != nil {
+		if err := v.cl.Auth().Token().RevokeTree(string(previous)); err != nil {
+			logrus.Warnf("error revoking the previous configurer token: %s", err.Error())
+		}
+	}
+
+	err = v.cl.Auth().Token().RevokeSelf("")
+	if err != nil {
+		return fmt.Errorf("error revoking the root token: %s", err.Error())
+	}
+	logrus.Info("revoked the root token, regenerate-root generates a new one with the unseal keys")
+
+	err = kv.Delete(v.keyStore, v.rootTokenKey())
+	if err != nil {
+		logrus.Warnf("error deleting the revoked root token from key '%s': %s", v.rootTokenKey(), err.Error())
+	}
+
+	return nil
+}
+
+// RegenerateRoot generates a new root token with the generate-root flow, using the stored unseal
+// keys (or the recovery keys of an auto-unseal seal). An attempt which is already in progress is
+// cancelled, its one-time password is unknown. The new root token is stored in the key store if
+// store is true, it is returned in any case.
+func (v *vault) RegenerateRoot(store bool) (string, error) {
+	defer runtime.GC()
+
+	sealStatus, err := v.cl.Sys().SealStatus()
+	if err != nil {
+		return "", fmt.Errorf("error checking seal status: %s", err.Error())
+	}
+	if sealStatus.Sealed {
+		return "", fmt.Errorf("vault is sealed, it has to be unsealed to generate a root token")
+	}
+	keyForID := v.unsealKeyForID
+	if sealStatus.RecoverySeal {
+		keyForID = v.recoveryKeyForID
+	}
+
+	status, err := v.cl.Sys().GenerateRootStatus()
+	if err != nil {
+		return "", fmt.Errorf("error getting root generation status: %s", err.Error())
+	}
+	if status.OTPLength == 0 {
+		return "", fmt.Errorf("vault doesn't support generating a root token with a one-time password, it should be at least 1.0")
+	}
+	if status.Started {
+		logrus.Warn("cancelling the root generation in progress")
+		if err := v.cl.Sys().GenerateRootCancel(); err != nil {
+			return "", fmt.Errorf("error cancelling root generation: %s", err.Error())
+		}
+	}
+
+	otp, err := base62.Random(status.OTPLength, true)
+	if err != nil {
+		return "", fmt.Errorf("error generating one-time password: %s", err.Error())
+	}
+
+	status, err = v.cl.Sys().GenerateRootInit(otp, "")
+	if err != nil {
+		return "", fmt.Errorf("error starting root generation: %s", err.Error())
+	}
+
+	for i := 0; !status.Complete; i++ {
+		key := keyForID(i)
+		share, err := v.storeForKey(key).Get(key)
+		if err != nil {
+			v.cl.Sys().GenerateRootCancel()
+			return "", fmt.Errorf("unable to get key '%s': %s", key, err.Error())
+		}
+		share, err = v.decodeShare(key, share)
+		if err != nil {
+			v.cl.Sys().GenerateRootCancel()
+			return "", fmt.Errorf("unable to decode key '%s': %s", key, err.Error())
+		}
+
+		logrus.Debugf("sending root generation request to vault...")
+		status, err = v.cl.Sys().GenerateRootUpdate(string(share), status.Nonce)
+		if err != nil {
+			v.cl.Sys().GenerateRootCancel()
+			return "", fmt.Errorf("fail to send root generation request to vault: %s", err.Error())
+		}
+	}
+
+	encoded, err := base64.RawStdEncoding.DecodeString(status.EncodedToken)
+	if err != nil {
+		return "", fmt.Errorf("error decoding the generated root token: %s", err.Error())
+	}
+	token, err := xor.XORBytes(encoded, []byte(otp))
+	if err != nil {
+		return "", fmt.Errorf("error decoding the generated root token: %s", err.Error())
+	}
+	logrus.Info("generated a new root token")
+
+	if store {
+		err = v.keyStore.Set(v.rootTokenKey(), token)
+		if err != nil {
+			return "", fmt.Errorf("error storing root token in key '%s': %s", v.rootTokenKey(), err.Error())
+		}
+		logrus.WithField("key", v.rootTokenKey()).Info("root token stored in key store")
+	}
+
+	return string(token), nil
+}
+
+// storedConfigurerToken returns the token which replaced the root token, nil if there is none
+func (v *vault) storedConfigurerToken() ([]byte, error) {
+	token, err := v.keyStore.Get(v.configurerTokenKey())
+	if _, ok := err.(*kv.NotFoundError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to get key '%s': %s", v.configurerTokenKey(), err.Error())
+	}
+	return token, nil
+}
@@ -0,0 +1,292 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configSchema is the typed form of the configuration, its fields are the keys the sections read.
+// The values are interface{} since they are converted leniently by the sections, the free-form
+// blocks (e.g. the config of an auth method, or the identity section) are passed on to Vault.
+type configSchema struct {
+	Auth                 []authSchema          `yaml:"auth"`
+	Policies             []policySchema        `yaml:"policies"`
+	Plugins              []pluginSchema        `yaml:"plugins"`
+	Secrets              []secretSchema        `yaml:"secrets"`
+	Identity             interface{}           `yaml:"identity"`
+	IdentityPurge        interface{}           `yaml:"identityPurge"`
+	PurgeUnmanagedConfig interface{}           `yaml:"purgeUnmanagedConfig"`
+	WrappingDefaults     interface{}           `yaml:"wrappingDefaults"`
+	UI                   interface{}           `yaml:"ui"`
+	SysConfig            []sysConfigSchema     `yaml:"sysConfig"`
+	ControlGroup         interface{}           `yaml:"controlGroup"`
+	MountFilters         []mountFilterSchema   `yaml:"mountFilters"`
+	Seal                 interface{}           `yaml:"seal"`
+	StartupSecrets       []startupSecretSchema `yaml:"startupSecrets"`
+	KVOperations         []kvOperationSchema   `yaml:"kvOperations"`
+	VerifyAccess         []verifyAccessSchema  `yaml:"verifyAccess"`
+	Audit                []auditSchema         `yaml:"audit"`
+	Assertions           []assertionSchema     `yaml:"assertions"`
+}
+
+type authSchema struct {
+	Type             interface{} `yaml:"type"`
+	Path             interface{} `yaml:"path"`
+	Description      interface{} `yaml:"description"`
+	Config           interface{} `yaml:"config"`
+	Roles            interface{} `yaml:"roles"`
+	Map              interface{} `yaml:"map"`
+	CrossAccountRole interface{} `yaml:"crossaccountrole"`
+	Groups           interface{} `yaml:"groups"`
+	Users            interface{} `yaml:"users"`
+	AuditExcluded    interface{} `yaml:"audit_excluded"`
+}
+
+type policySchema struct {
+	Name             interface{} `yaml:"name"`
+	Rules            interface{} `yaml:"rules"`
+	File             interface{} `yaml:"file"`
+	Type             interface{} `yaml:"type"`
+	EnforcementLevel interface{} `yaml:"enforcement_level"`
+	Paths            interface{} `yaml:"paths"`
+}
+
+type pluginSchema struct {
+	PluginName interface{} `yaml:"plugin_name"`
+	Command    interface{} `yaml:"command"`
+	SHA256     interface{} `yaml:"sha256"`
+	Type       interface{} `yaml:"type"`
+	Env        interface{} `yaml:"env"`
+}
+
+type secretSchema struct {
+	Type           interface{} `yaml:"type"`
+	Path           interface{} `yaml:"path"`
+	Description    interface{} `yaml:"description"`
+	PluginName     interface{} `yaml:"plugin_name"`
+	PluginVersion  interface{} `yaml:"plugin_version"`
+	Local          interface{} `yaml:"local"`
+	SealWrap       interface{} `yaml:"seal_wrap"`
+	Options        interface{} `yaml:"options"`
+	Config         interface{} `yaml:"config"`
+	Configuration  interface{} `yaml:"configuration"`
+	Limits         interface{} `yaml:"limits"`
+	Intermediate   interface{} `yaml:"intermediate"`
+	ExternalPolicy interface{} `yaml:"external_policy"`
+	Issuers        interface{} `yaml:"issuers"`
+	Keys           interface{} `yaml:"keys"`
+	CacheSize      interface{} `yaml:"cache_size"`
+	Scopes         interface{} `yaml:"scopes"`
+	AuditExcluded  interface{} `yaml:"audit_excluded"`
+}
+
+type sysConfigSchema struct {
+	Path interface{} `yaml:"path"`
+	Data interface{} `yaml:"data"`
+}
+
+type mountFilterSchema struct {
+	SecondaryID interface{} `yaml:"secondary_id"`
+	Mode        interface{} `yaml:"mode"`
+	Paths       interface{} `yaml:"paths"`
+	AuthPaths   interface{} `yaml:"auth_paths"`
+}
+
+type startupSecretSchema struct {
+	Type           interface{} `yaml:"type"`
+	Path           interface{} `yaml:"path"`
+	Data           interface{} `yaml:"data"`
+	CustomMetadata interface{} `yaml:"custom_metadata"`
+}
+
+type kvOperationSchema struct {
+	Operation interface{} `yaml:"operation"`
+	Path      interface{} `yaml:"path"`
+	Versions  interface{} `yaml:"versions"`
+}
+
+type verifyAccessSchema struct {
+	Policy interface{} `yaml:"policy"`
+	Path   interface{} `yaml:"path"`
+}
+
+type auditSchema struct {
+	Type        interface{} `yaml:"type"`
+	Path        interface{} `yaml:"path"`
+	Description interface{} `yaml:"description"`
+	Options     interface{} `yaml:"options"`
+	Local       interface{} `yaml:"local"`
+}
+
+type assertionSchema struct {
+	Type   interface{} `yaml:"type"`
+	Name   interface{} `yaml:"name"`
+	Path   interface{} `yaml:"path"`
+	Policy interface{} `yaml:"policy"`
+}
+
+// schemaListSections are the sections of the schema types of the list items
+var schemaListSections = map[string]string{
+	"authSchema":          "auth",
+	"policySchema":        "policies",
+	"pluginSchema":        "plugins",
+	"secretSchema":        "secrets",
+	"sysConfigSchema":     "sysConfig",
+	"mountFilterSchema":   "mountFilters",
+	"startupSecretSchema": "startupSecrets",
+	"kvOperationSchema":   "kvOperations",
+	"verifyAccessSchema":  "verifyAccess",
+	"auditSchema":         "audit",
+	"assertionSchema":     "assertions",
+}
+
+// schemaProblem replaces the Go types in an error of the YAML decoder with the sections, e.g.
+// "line 3: field polices not found in type vault.configSchema" becomes
+// "line 3: unknown top-level key 'polices'"
+func schemaProblem(problem string) string {
+	if i := strings.Index(problem, "field "); i >= 0 && strings.Contains(problem, " not found in type ") {
+		field := strings.SplitN(problem[i+len("field "):], " not found in type ", 2)
+		typeName := strings.TrimPrefix(field[1], "vault.")
+		if section, ok := schemaListSections[typeName]; ok {
+			return fmt.Sprintf("%sunknown key '%s' in the %s section", problem[:i], field[0], section)
+		}
+		return fmt.Sprintf("%sunknown top-level key '%s'", problem[:i], field[0])
+	}
+	for typeName, section := range schemaListSections {
+		problem = strings.Replace(problem, "[]vault."+typeName, section+" list", -1)
+		problem = strings.Replace(problem, "vault."+typeName, section+" item", -1)
+	}
+	return strings.Replace(problem, "vault.configSchema", "the configuration", -1)
+}
+
+// SchemaError lists the problems of a configuration not matching the schema, with their lines
+type SchemaError struct {
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// rawSection keeps the decoder of a section, so it can be decoded later into its typed field
+type rawSection struct {
+	unmarshal func(interface{}) error
+}
+
+func (r *rawSection) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	r.unmarshal = unmarshal
+	return nil
+}
+
+// caseVariantSections decodes the sections whose keys differ only in case from the schema (e.g.
+// Policies) into their typed fields, viper lowercases the top-level keys, so those are read too
+type caseVariantSections struct {
+	// the keys of the configuration by the field names of configSchema
+	fields map[string]string
+}
+
+func (s *caseVariantSections) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	sections := map[string]rawSection{}
+	if err := unmarshal(&sections); err != nil {
+		return err
+	}
+
+	fields := make([]string, 0, len(s.fields))
+	for field := range s.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	schema := reflect.ValueOf(&configSchema{}).Elem()
+	var problems []string
+	for _, field := range fields {
+		err := sections[s.fields[field]].unmarshal(schema.FieldByName(field).Addr().Interface())
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			problems = append(problems, typeErr.Errors...)
+		}
+	}
+	if len(problems) > 0 {
+		return &yaml.TypeError{Errors: problems}
+	}
+	return nil
+}
+
+// schemaField returns the field name of configSchema with the YAML key, compared case-insensitively
+func schemaField(key string) (string, bool) {
+	schemaType := reflect.TypeOf(configSchema{})
+	for i := 0; i < schemaType.NumField(); i++ {
+		field := schemaType.Field(i)
+		if strings.EqualFold(field.Tag.Get("yaml"), key) {
+			return field.Name, true
+		}
+	}
+	return "", false
+}
+
+// ValidateConfigSchema decodes the rendered YAML/JSON configuration into the typed sections
+// before anything is applied: unknown top-level keys (e.g. a misspelled section name) and unknown
+// keys of the auth, secrets, policies etc. items are reported with their lines as a *SchemaError,
+// instead of being ignored silently. The top-level keys are case-insensitive, like with viper.
+// Only the keys of the schema are checked: the free-form blocks (e.g. the config of an auth
+// method) and the map sections (e.g. identity, ui or seal) aren't, neither are syntax errors,
+// those are left to the reader of the configuration.
+func ValidateConfigSchema(data []byte) error {
+	err := yaml.UnmarshalStrict(data, &configSchema{})
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return nil
+	}
+
+	problems := make([]string, 0, len(typeErr.Errors))
+	caseVariants := &caseVariantSections{fields: map[string]string{}}
+	for _, problem := range typeErr.Errors {
+		if key, ok := unknownTopLevelKey(problem); ok {
+			if field, ok := schemaField(key); ok {
+				caseVariants.fields[field] = key
+				continue
+			}
+		}
+		problems = append(problems, schemaProblem(problem))
+	}
+
+	if len(caseVariants.fields) > 0 {
+		err = yaml.UnmarshalStrict(data, caseVariants)
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			for _, problem := range typeErr.Errors {
+				problems = append(problems, schemaProblem(problem))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &SchemaError{Problems: problems}
+}
+
+// unknownTopLevelKey returns the key of a "field ... not found in type vault.configSchema" error
+func unknownTopLevelKey(problem string) (string, bool) {
+	i := strings.Index(problem, "field ")
+	if i < 0 || !strings.HasSuffix(problem, " not found in type vault.configSchema") {
+		return "", false
+	}
+	return strings.TrimSuffix(problem[i+len("field "):], " not found in type vault.configSchema"), true
+}
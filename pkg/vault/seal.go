@@ -0,0 +1,78 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/banzaicloud/bank-vaults/pkg/kv"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// configureSeal tracks the KMS key referenced in the auto-unseal seal stanza of Vault ("seal.key_id"),
+// and when it changes compared to the last applied one (stored in the key store), it rewraps the
+// seal-wrapped entries with the new key. Vault itself picks up the new key on restart or SIGHUP.
+func (v *vault) configureSeal(config *viper.Viper) error {
+	if !config.IsSet("seal") {
+		return nil
+	}
+
+	seal := cast.ToStringMap(config.Get("seal"))
+
+	keyID, err := getOrError(seal, "key_id")
+	if err != nil {
+		return fmt.Errorf("error getting key_id for seal: %s", err.Error())
+	}
+
+	pollPeriod := 5 * time.Second
+	if _, ok := seal["rewrap_poll_period"]; ok {
+		pollPeriod, err = cast.ToDurationE(seal["rewrap_poll_period"])
+		if err != nil {
+			return fmt.Errorf("error getting rewrap_poll_period for seal: %s", err.Error())
+		}
+	}
+
+	previousKeyID, err := v.keyStore.Get(v.sealKeyIDKey())
+	if _, ok := err.(*kv.NotFoundError); ok {
+		logrus.Infof("seal key %s seen for the first time, nothing to rewrap", keyID)
+		return v.storeSealKeyID(keyID)
+	} else if err != nil {
+		return fmt.Errorf("unable to get key '%s': %s", v.sealKeyIDKey(), err.Error())
+	}
+
+	if string(previousKeyID) == keyID {
+		return nil
+	}
+
+	logrus.Infof("seal key changed from %s to %s, rewrapping the seal-wrapped entries", previousKeyID, keyID)
+
+	err = v.rewrapSealWrap(pollPeriod)
+	if err != nil {
+		return err
+	}
+
+	return v.storeSealKeyID(keyID)
+}
+
+func (v *vault) storeSealKeyID(keyID string) error {
+	err := v.keyStore.Set(v.sealKeyIDKey(), []byte(keyID))
+	if err != nil {
+		return fmt.Errorf("error storing key '%s': %s", v.sealKeyIDKey(), err.Error())
+	}
+	return nil
+}
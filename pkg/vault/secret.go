@@ -0,0 +1,47 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+)
+
+// ReadSecret reads the data of the secret at the path with the token of the configuration runs
+// (e.g. for the vault function of the config templates), the data of a KV version 2 secret
+// (secret/data/...) is returned without its metadata
+func (v *vault) ReadSecret(path string) (map[string]interface{}, error) {
+	v.runLock.Lock()
+	defer v.runLock.Unlock()
+
+	var data map[string]interface{}
+	err := v.withRootToken(func() error {
+		secret, err := v.cl.Logical().Read(path)
+		if err != nil {
+			return fmt.Errorf("error reading secret %s: %s", path, err.Error())
+		}
+		if secret == nil || secret.Data == nil {
+			return fmt.Errorf("secret %s not found", path)
+		}
+
+		data = secret.Data
+		if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+			if _, ok := secret.Data["metadata"]; ok {
+				data = nested
+			}
+		}
+		return nil
+	})
+	return data, err
+}
@@ -0,0 +1,167 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ShareDecoder transforms the value of an unseal (or recovery) key share read from the key store
+// into the share Vault expects, e.g. if the shares are split again with another scheme before
+// they are stored, see Config.ShareDecoder
+type ShareDecoder interface {
+	Decode(keyID string, value []byte) ([]byte, error)
+}
+
+// ShareDecoderFunc is a function implementing ShareDecoder
+type ShareDecoderFunc func(keyID string, value []byte) ([]byte, error)
+
+// Decode calls the function
+func (f ShareDecoderFunc) Decode(keyID string, value []byte) ([]byte, error) {
+	return f(keyID, value)
+}
+
+// ShareEncoder is the inverse of a ShareDecoder, it transforms the shares returned by sys/init into
+// the values stored in the key store, so the share decoder of the same name can decode them on unseal
+type ShareEncoder interface {
+	Encode(keyID string, share []byte) ([]byte, error)
+}
+
+// ShareCodec is a share decoder with its encoder, the decoders have to be registered as a ShareCodec
+// to initialize Vault with them
+type ShareCodec struct {
+	DecodeFunc ShareDecoderFunc
+	EncodeFunc func(keyID string, share []byte) ([]byte, error)
+}
+
+// Decode calls DecodeFunc
+func (c ShareCodec) Decode(keyID string, value []byte) ([]byte, error) {
+	return c.DecodeFunc(keyID, value)
+}
+
+// Encode calls EncodeFunc
+func (c ShareCodec) Encode(keyID string, share []byte) ([]byte, error) {
+	return c.EncodeFunc(keyID, share)
+}
+
+// The names of the built-in share decoders
+const (
+	ShareDecoderRaw    = "raw"
+	ShareDecoderBase64 = "base64"
+)
+
+var (
+	shareDecodersMu sync.Mutex
+	shareDecoders   = map[string]ShareDecoder{
+		// the shares are stored as submitted to Vault
+		ShareDecoderRaw: ShareCodec{
+			DecodeFunc: func(_ string, value []byte) ([]byte, error) {
+				return value, nil
+			},
+			EncodeFunc: func(_ string, share []byte) ([]byte, error) {
+				return share, nil
+			},
+		},
+		// the shares are stored base64 encoded once more
+		ShareDecoderBase64: ShareCodec{
+			DecodeFunc: func(keyID string, value []byte) ([]byte, error) {
+				decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(value)))
+				if err != nil {
+					return nil, fmt.Errorf("error decoding base64 share '%s': %s", keyID, err.Error())
+				}
+				return decoded, nil
+			},
+			EncodeFunc: func(_ string, share []byte) ([]byte, error) {
+				return []byte(base64.StdEncoding.EncodeToString(share)), nil
+			},
+		},
+	}
+)
+
+// RegisterShareDecoder makes a custom share decoder available by name (e.g. for --share-decoder)
+func RegisterShareDecoder(name string, decoder ShareDecoder) {
+	shareDecodersMu.Lock()
+	defer shareDecodersMu.Unlock()
+	shareDecoders[name] = decoder
+}
+
+// ShareDecoderByName returns the registered share decoder, an empty name is the raw decoder
+func ShareDecoderByName(name string) (ShareDecoder, error) {
+	if name == "" {
+		name = ShareDecoderRaw
+	}
+
+	shareDecodersMu.Lock()
+	defer shareDecodersMu.Unlock()
+	decoder, ok := shareDecoders[name]
+	if !ok {
+		var names []string
+		for name := range shareDecoders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown share decoder '%s', expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return decoder, nil
+}
+
+// decodeShare decodes the key share with the configured share decoder
+func (v *vault) decodeShare(keyID string, value []byte) ([]byte, error) {
+	if v.config.ShareDecoder == nil {
+		return value, nil
+	}
+	return v.config.ShareDecoder.Decode(keyID, value)
+}
+
+// checkShareEncoder tells if the shares returned by sys/init can be stored, so they can be decoded by
+// the configured share decoder, it is checked before the init since the shares are returned only once
+func (v *vault) checkShareEncoder() error {
+	if v.config.ShareDecoder == nil {
+		return nil
+	}
+	if _, ok := v.config.ShareDecoder.(ShareEncoder); !ok {
+		return fmt.Errorf("the share decoder can't encode the key shares, register it as a ShareCodec to initialize vault with it")
+	}
+	return nil
+}
+
+// encodeShare encodes the key share with the encoder of the configured share decoder, and checks
+// that the encoded share decodes to the share
+func (v *vault) encodeShare(keyID string, share []byte) ([]byte, error) {
+	if v.config.ShareDecoder == nil {
+		return share, nil
+	}
+	if err := v.checkShareEncoder(); err != nil {
+		return nil, err
+	}
+
+	encoded, err := v.config.ShareDecoder.(ShareEncoder).Encode(keyID, share)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := v.config.ShareDecoder.Decode(keyID, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the encoded share '%s': %s", keyID, err.Error())
+	}
+	if !bytes.Equal(decoded, share) {
+		return nil, fmt.Errorf("the encoded share '%s' doesn't decode to the share", keyID)
+	}
+	return encoded, nil
+}
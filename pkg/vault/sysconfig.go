@@ -0,0 +1,98 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// configureSysConfig writes the "sysConfig" entries as they are, it is an escape hatch for
+// the sys/ settings without first-class support. The paths are validated before writing any.
+func (v *vault) configureSysConfig(config *viper.Viper) error {
+	if !config.IsSet("sysConfig") {
+		return nil
+	}
+
+	entries, err := toNormalizedSliceStringMapE(config.Get("sysConfig"))
+	if err != nil {
+		return fmt.Errorf("error decoding sysConfig: %s", err.Error())
+	}
+
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entryPath, err := getOrError(entry, "path")
+		if err != nil {
+			return fmt.Errorf("error getting path for sysConfig entry: %s", err.Error())
+		}
+		// cleaned, so e.g. sys/../secret can't escape sys/
+		cleanPath := path.Clean(strings.TrimPrefix(entryPath, "/"))
+		if !strings.HasPrefix(cleanPath, "sys/") {
+			return fmt.Errorf("error validating sysConfig path %s: it has to start with sys/", entryPath)
+		}
+		paths = append(paths, cleanPath)
+	}
+
+	for i, entry := range entries {
+		data, err := getOrDefaultStringMap(entry, "data")
+		if err != nil {
+			return fmt.Errorf("error getting data for sysConfig path %s: %s", paths[i], err.Error())
+		}
+		_, err = v.cl.Logical().Write(paths[i], data)
+		if err != nil {
+			return fmt.Errorf("error writing sysConfig path %s: %s", paths[i], err.Error())
+		}
+	}
+
+	return nil
+}
+
+// configureControlGroup writes the "controlGroup" settings (e.g. max_ttl, the maximum TTL of the
+// control group wrapping tokens) to sys/config/control-group. Control groups need Vault Enterprise,
+// the section is skipped on other editions.
+func (v *vault) configureControlGroup(config *viper.Viper) error {
+	if !config.IsSet("controlGroup") {
+		return nil
+	}
+
+	controlGroup, err := cast.ToStringMapE(normalizeConfigValue(config.Get("controlGroup")))
+	if err != nil {
+		return fmt.Errorf("error decoding controlGroup config: %s", err.Error())
+	}
+	if len(controlGroup) == 0 {
+		return nil
+	}
+
+	enterprise, err := v.enterprise()
+	if err != nil {
+		return err
+	}
+	if !enterprise {
+		logrus.Warnf("skipping the control group config, it needs Vault Enterprise")
+		return nil
+	}
+
+	// https://developer.hashicorp.com/vault/api-docs/system/config-control-group#configure-control-group-settings
+	_, err = v.cl.Logical().Write("sys/config/control-group", controlGroup)
+	if err != nil {
+		return fmt.Errorf("error writing control group config: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer starts the spans of the configuration runs, see Config.Tracer
+type Tracer interface {
+	// StartSpan starts a span which is the child of the span of the context (a new trace
+	// without one), the returned context carries the new span
+	StartSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+	// Inject adds the span of the context to the headers of the Vault API calls, so the calls
+	// made while it is in flight are its children (e.g. the W3C traceparent header)
+	Inject(ctx context.Context, headers http.Header)
+}
+
+// Span is a traced operation, it is ended with the error of the operation (or nil)
+type Span interface {
+	End(err error)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+func (v *vault) startSpan(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	if v.config.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return v.config.Tracer.StartSpan(ctx, name, attributes)
+}
+
+// traceRequests makes the span of the context the parent of the API calls of the client, the
+// items of a section applied concurrently share the span of the section, the returned function
+// restores the headers of the client
+func (v *vault) traceRequests(ctx context.Context) func() {
+	if v.config.Tracer == nil {
+		return func() {}
+	}
+	headers := v.cl.Headers()
+	traced := v.cl.Headers()
+	if traced == nil {
+		traced = http.Header{}
+	}
+	v.config.Tracer.Inject(ctx, traced)
+	v.cl.SetHeaders(traced)
+	return func() { v.cl.SetHeaders(headers) }
+}
@@ -0,0 +1,152 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// transitKeyConfigFields are the fields of a transit key which can be changed after creating it,
+// through <path>/keys/<name>/config, the others are only used when the key is created
+var transitKeyConfigFields = []string{
+	"auto_rotate_period",
+	"deletion_allowed",
+	"exportable",
+	"allow_plaintext_backup",
+	"min_decryption_version",
+	"min_encryption_version",
+}
+
+// defaultTransitKeyType is the type of the transit keys created without a type
+const defaultTransitKeyType = "aes256-gcm96"
+
+// transitKeyUsages are the operations supported by the transit key types, hmac is supported by
+// every type, managed keys are checked by Vault as their capabilities depend on the backing key
+var transitKeyUsages = map[string]map[string]bool{
+	"aes128-gcm96":      {"encrypt": true, "decrypt": true, "hmac": true},
+	"aes256-gcm96":      {"encrypt": true, "decrypt": true, "hmac": true},
+	"chacha20-poly1305": {"encrypt": true, "decrypt": true, "hmac": true},
+	"ed25519":           {"sign": true, "verify": true, "hmac": true},
+	"ecdsa-p256":        {"sign": true, "verify": true, "hmac": true},
+	"ecdsa-p384":        {"sign": true, "verify": true, "hmac": true},
+	"ecdsa-p521":        {"sign": true, "verify": true, "hmac": true},
+	"rsa-2048":          {"encrypt": true, "decrypt": true, "sign": true, "verify": true, "hmac": true},
+	"rsa-3072":          {"encrypt": true, "decrypt": true, "sign": true, "verify": true, "hmac": true},
+	"rsa-4096":          {"encrypt": true, "decrypt": true, "sign": true, "verify": true, "hmac": true},
+	"hmac":              {"hmac": true},
+}
+
+// checkTransitKeyUsages checks the usages a transit key is declared for (e.g. [sign, verify] for
+// JWT signing) against its type, the usages are only checked by bank-vaults, not sent to Vault
+func checkTransitKeyUsages(name, keyType string, key map[string]interface{}) error {
+	if _, ok := key["usages"]; !ok {
+		return nil
+	}
+	usages, err := cast.ToStringSliceE(key["usages"])
+	if err != nil {
+		return fmt.Errorf("error converting usages of transit key %s: %s", name, err.Error())
+	}
+	if keyType == "managed_key" {
+		return nil
+	}
+	supported, ok := transitKeyUsages[keyType]
+	if !ok {
+		return fmt.Errorf("unknown type %s of transit key %s", keyType, name)
+	}
+	for _, usage := range usages {
+		if !supported[usage] {
+			return fmt.Errorf("transit key %s of type %s doesn't support %s", name, keyType, usage)
+		}
+	}
+	return nil
+}
+
+// configureTransitKeys creates the missing keys of the "keys" block of a transit secret engine,
+// and reconciles their config (e.g. auto_rotate_period) on every run. A key backed by a managed
+// key (Enterprise, e.g. in an HSM) references it with managed_key_name, its type is managed_key.
+func (v *vault) configureTransitKeys(path string, secretEngine map[string]interface{}) error {
+	keys, err := toNormalizedSliceStringMapE(secretEngine["keys"])
+	if err != nil {
+		return fmt.Errorf("error finding keys block for transit: %s", err.Error())
+	}
+
+	for _, key := range keys {
+		name, err := getOrError(key, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for transit key: %s", err.Error())
+		}
+
+		keyType := cast.ToString(key["type"])
+		if _, ok := key["managed_key_name"]; ok {
+			if keyType == "" {
+				keyType = "managed_key"
+			} else if keyType != "managed_key" {
+				return fmt.Errorf("transit key %s references a managed key, its type has to be managed_key instead of %s", name, keyType)
+			}
+		}
+
+		// https://www.vaultproject.io/api/secret/transit/index.html
+		keyPath := fmt.Sprintf("%s/keys/%s", path, name)
+		existing, err := v.cl.Logical().Read(keyPath)
+		if err != nil {
+			return fmt.Errorf("error reading transit key %s: %s", name, err.Error())
+		}
+
+		effectiveType := keyType
+		if effectiveType == "" && existing != nil && existing.Data != nil {
+			effectiveType = cast.ToString(existing.Data["type"])
+		}
+		if effectiveType == "" {
+			effectiveType = defaultTransitKeyType
+		}
+		err = checkTransitKeyUsages(name, effectiveType, key)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil || existing.Data == nil {
+			data := copyWithout(key, "name", "usages")
+			if keyType != "" {
+				data["type"] = keyType
+			}
+			logrus.Infof("creating transit key %s", name)
+			_, err = v.cl.Logical().Write(keyPath, data)
+			if err != nil {
+				return fmt.Errorf("error creating transit key %s: %s", name, err.Error())
+			}
+		} else if existingType := cast.ToString(existing.Data["type"]); keyType != "" && existingType != keyType {
+			return fmt.Errorf("the type of transit key %s can't be changed from %s to %s", name, existingType, keyType)
+		}
+
+		config := map[string]interface{}{}
+		for _, field := range transitKeyConfigFields {
+			if value, ok := key[field]; ok {
+				config[field] = value
+			}
+		}
+		if len(config) == 0 {
+			continue
+		}
+		_, err = v.cl.Logical().Write(keyPath+"/config", config)
+		if err != nil {
+			return fmt.Errorf("error configuring transit key %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// configureUI configures the Vault UI from the "ui" section: custom response headers and the
+// default login methods, the blocks under "namespaces" are applied in their (Enterprise) namespace
+func (v *vault) configureUI(config *viper.Viper) error {
+	if !config.IsSet("ui") {
+		return nil
+	}
+
+	ui, err := cast.ToStringMapE(normalizeConfigValue(config.Get("ui")))
+	if err != nil {
+		return fmt.Errorf("error decoding ui config: %s", err.Error())
+	}
+
+	err = v.configureUIInNamespace("", ui)
+	if err != nil {
+		return err
+	}
+
+	// a list, since viper lowercases the map keys and the namespaces are case sensitive
+	namespaces, err := toNormalizedSliceStringMapE(ui["namespaces"])
+	if err != nil {
+		return fmt.Errorf("error finding namespaces block for ui: %s", err.Error())
+	}
+
+	for _, namespaceUI := range namespaces {
+		namespace, err := getOrError(namespaceUI, "namespace")
+		if err != nil {
+			return fmt.Errorf("error getting namespace for ui: %s", err.Error())
+		}
+		err = v.configureUIInNamespace(namespace, namespaceUI)
+		if err != nil {
+			return fmt.Errorf("error configuring ui in namespace %s: %s", namespace, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (v *vault) configureUIInNamespace(namespace string, ui map[string]interface{}) error {
+	// https://www.vaultproject.io/api/system/config-ui.html
+	headers, err := getOrDefaultStringMap(ui, "headers")
+	if err != nil {
+		return fmt.Errorf("error finding headers block for ui: %s", err.Error())
+	}
+	for header, values := range headers {
+		values, err := cast.ToStringSliceE(values)
+		if err != nil {
+			return fmt.Errorf("error converting values of ui header %s: %s", header, err.Error())
+		}
+		err = v.writeInNamespace(namespace, path.Join("sys/config/ui/headers", header), map[string]interface{}{"values": values})
+		if err != nil {
+			return fmt.Errorf("error putting ui header %s into vault: %s", header, err.Error())
+		}
+	}
+
+	// https://developer.hashicorp.com/vault/api-docs/system/config-ui-login-default-auth
+	defaultAuths, err := toNormalizedSliceStringMapE(ui["default_auth"])
+	if err != nil {
+		return fmt.Errorf("error finding default_auth block for ui: %s", err.Error())
+	}
+	for _, defaultAuth := range defaultAuths {
+		name, err := getOrError(defaultAuth, "name")
+		if err != nil {
+			return fmt.Errorf("error getting name for ui default auth: %s", err.Error())
+		}
+		err = v.writeInNamespace(namespace, path.Join("sys/config/ui/login/default-auth", name), copyWithout(defaultAuth, "name"))
+		if err != nil {
+			return fmt.Errorf("error putting ui default auth %s into vault: %s", name, err.Error())
+		}
+	}
+
+	err = v.configureUICustomMessages(namespace, ui)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// configureUICustomMessages creates or updates the "custom_messages" of the ui by their title:
+// the banners and modals shown in the UI (Enterprise, Vault 1.16+, skipped on other editions). The
+// logo and the styling of the UI can't be set through the API, the custom messages are the branding
+// it supports. The message is given as plain text and sent base64 encoded.
+func (v *vault) configureUICustomMessages(namespace string, ui map[string]interface{}) error {
+	messages, err := toNormalizedSliceStringMapE(ui["custom_messages"])
+	if err != nil {
+		return fmt.Errorf("error finding custom_messages block for ui: %s", err.Error())
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	enterprise, err := v.enterprise()
+	if err != nil {
+		return err
+	}
+	if !enterprise {
+		logrus.Warnf("skipping the ui custom messages, they need Vault Enterprise")
+		return nil
+	}
+
+	// https://developer.hashicorp.com/vault/api-docs/system/config-ui-custom-messages
+	existing, err := v.requestInNamespace(namespace, "GET", "sys/config/ui/custom-messages", url.Values{"list": {"true"}}, nil)
+	if err != nil {
+		return fmt.Errorf("error listing ui custom messages: %s", err.Error())
+	}
+	ids := map[string]string{}
+	if existing != nil {
+		for id, info := range cast.ToStringMap(existing.Data["key_info"]) {
+			ids[cast.ToString(cast.ToStringMap(info)["title"])] = id
+		}
+	}
+
+	for _, message := range messages {
+		title, err := getOrError(message, "title")
+		if err != nil {
+			return fmt.Errorf("error getting title for ui custom message: %s", err.Error())
+		}
+		text, err := getOrError(message, "message")
+		if err != nil {
+			return fmt.Errorf("error getting message for ui custom message %s: %s", title, err.Error())
+		}
+		data := copyWithout(message)
+		data["message"] = base64.StdEncoding.EncodeToString([]byte(text))
+
+		messagePath := "sys/config/ui/custom-messages"
+		if id, ok := ids[title]; ok {
+			messagePath = path.Join(messagePath, id)
+		}
+		_, err = v.requestInNamespace(namespace, "POST", messagePath, nil, data)
+		if err != nil {
+			return fmt.Errorf("error putting ui custom message %s into vault: %s", title, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// requestInNamespace sends a request to the path in the namespace, or in the namespace of the
+// client if empty, it returns the secret of the response, nil if there is none
+func (v *vault) requestInNamespace(namespace, method, path string, params url.Values, data map[string]interface{}) (*api.Secret, error) {
+	r := v.cl.NewRequest(method, "/v1/"+path)
+	if namespace != "" {
+		if r.Headers == nil {
+			r.Headers = http.Header{}
+		}
+		r.Headers.Set(consts.NamespaceHeaderName, namespace)
+	}
+	for key, values := range params {
+		r.Params[key] = values
+	}
+	if data != nil {
+		if err := r.SetJSONBody(data); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := v.cl.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	return api.ParseSecret(resp.Body)
+}
+
+// writeInNamespace writes the data to the path in the namespace, or in the namespace of the client if empty
+func (v *vault) writeInNamespace(namespace, path string, data map[string]interface{}) error {
+	if namespace == "" {
+		_, err := v.cl.Logical().Write(path, data)
+		return err
+	}
+
+	r := v.cl.NewRequest("PUT", "/v1/"+path)
+	if r.Headers == nil {
+		r.Headers = http.Header{}
+	}
+	r.Headers.Set(consts.NamespaceHeaderName, namespace)
+	if err := r.SetJSONBody(data); err != nil {
+		return err
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := v.cl.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
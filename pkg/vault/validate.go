@@ -0,0 +1,104 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// listSectionRequiredKeys are the keys every item of the list sections has to set
+var listSectionRequiredKeys = map[string][]string{
+	"auth":           {"type"},
+	"policies":       {"name"},
+	"plugins":        {"plugin_name", "command", "sha256", "type"},
+	"secrets":        {"type"},
+	"sysConfig":      {"path"},
+	"mountFilters":   {"secondary_id", "mode"},
+	"audit":          {"type"},
+	"startupSecrets": {"type"},
+	"kvOperations":   {"operation", "path"},
+	"verifyAccess":   {"policy", "path"},
+	"assertions":     {"type"},
+}
+
+// ValidateConfig checks the structure of the configuration without connecting to Vault: the
+// top-level keys have to be known sections, the list sections have to be lists of maps setting
+// the required keys and the other sections have to be maps. It returns the problems found.
+func ValidateConfig(config *viper.Viper) []string {
+	// the top-level keys are lowercased by viper
+	sections := map[string]string{}
+	for _, section := range (&vault{}).configSections() {
+		sections[strings.ToLower(section.name)] = section.name
+	}
+
+	keys := config.AllKeys()
+	topLevel := map[string]bool{}
+	for _, key := range keys {
+		topLevel[strings.SplitN(key, ".", 2)[0]] = true
+	}
+
+	var problems []string
+
+	unknown := []string{}
+	for key := range topLevel {
+		if _, ok := sections[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("unknown top-level key '%s'", key))
+	}
+
+	for _, section := range (&vault{}).configSections() {
+		if !config.IsSet(section.name) {
+			continue
+		}
+		value := normalizeConfigValue(config.Get(section.name))
+
+		requiredKeys, isList := listSectionRequiredKeys[section.name]
+		if !isList {
+			if _, err := cast.ToStringMapE(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s has to be a map", section.name))
+			}
+			continue
+		}
+
+		items, err := cast.ToSliceE(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s has to be a list", section.name))
+			continue
+		}
+		for i, item := range items {
+			entry, err := cast.ToStringMapE(item)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d] has to be a map", section.name, i))
+				continue
+			}
+			for _, key := range requiredKeys {
+				if value, ok := entry[key]; !ok || cast.ToString(value) == "" {
+					problems = append(problems, fmt.Sprintf("%s[%d] has to set %s", section.name, i, key))
+				}
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,112 @@
+// Copyright © 2019 Banzai Cloud
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// verifyAccessTokenTTL is the TTL of the tokens created for the access verification
+const verifyAccessTokenTTL = "1m"
+
+// configureVerifyAccess checks the {policy, path} assertions of the "verifyAccess" section after the
+// startup secrets are seeded: a short-lived token is created with only the policy and the path is read
+// with it, the section fails if the read is denied or finds nothing. Without the verification enabled
+// in the Config the assertions are skipped.
+func (v *vault) configureVerifyAccess(config *viper.Viper) error {
+	assertions, err := toNormalizedSliceStringMapE(config.Get("verifyAccess"))
+	if err != nil {
+		return fmt.Errorf("error decoding verifyAccess config: %s", err.Error())
+	}
+	if len(assertions) == 0 {
+		return nil
+	}
+
+	if !v.config.VerifyAccess {
+		logrus.Infof("skipping the verification of %d access assertions, it is not enabled", len(assertions))
+		return nil
+	}
+
+	var failures []string
+	for _, assertion := range assertions {
+		policy, err := getOrError(assertion, "policy")
+		if err != nil {
+			return fmt.Errorf("error getting policy for access assertion: %s", err.Error())
+		}
+		path, err := getOrError(assertion, "path")
+		if err != nil {
+			return fmt.Errorf("error getting path for access assertion of policy %s: %s", policy, err.Error())
+		}
+
+		err = v.verifyAccess(policy, path)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("policy %s can't read %s: %s", policy, path, err.Error()))
+			continue
+		}
+		logrus.Infof("verified that policy %s can read %s", policy, path)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("access verification failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// verifyAccess reads the path with a short-lived orphan token having only the policy, the token is revoked afterwards
+func (v *vault) verifyAccess(policy, path string) error {
+	token, err := v.cl.Auth().Token().CreateOrphan(&api.TokenCreateRequest{
+		Policies:        []string{policy},
+		TTL:             verifyAccessTokenTTL,
+		NoDefaultPolicy: true,
+		DisplayName:     "bank-vaults-verify-access",
+	})
+	if err != nil {
+		return fmt.Errorf("error creating token: %s", err.Error())
+	}
+	if token == nil || token.Auth == nil {
+		return fmt.Errorf("error creating token: no auth in the response")
+	}
+	defer func() {
+		if err := v.cl.Auth().Token().RevokeTree(token.Auth.ClientToken); err != nil {
+			logrus.Warnf("error revoking the access verification token of policy %s: %s", policy, err.Error())
+		}
+	}()
+
+	// the clone has the address of the config, not the one set on the client
+	cl, err := v.cl.Clone()
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err.Error())
+	}
+	err = cl.SetAddress(v.cl.Address())
+	if err != nil {
+		return fmt.Errorf("error creating client: %s", err.Error())
+	}
+	cl.SetHeaders(v.cl.Headers())
+	cl.SetToken(token.Auth.ClientToken)
+
+	secret, err := cl.Logical().Read(path)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("nothing found")
+	}
+	return nil
+}